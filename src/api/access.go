@@ -0,0 +1,120 @@
+package api
+
+import (
+	"strconv"
+
+	"github.com/Dataman-Cloud/crane/src/plugins/auth"
+	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
+	"github.com/Dataman-Cloud/crane/src/utils/httpresponse"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/context"
+)
+
+// the permission of the account on the service, everyone has read write without the account feature,
+// admins have it on every service, the others have the permission their groups got on it
+func (api *Api) servicePermission(ctx *gin.Context, serviceId string) (auth.Permission, error) {
+	account, ok := ctx.Get("account")
+	if !ok {
+		return auth.PermReadWrite, nil
+	}
+
+	acc, _ := account.(auth.Account)
+	if api.GetConfig() != nil && api.GetConfig().AccountIsAdmin(acc.Email) {
+		return auth.PermAdmin, nil
+	}
+
+	service, err := api.GetDockerClient().InspectServiceWithRaw(serviceId)
+	if err != nil {
+		return auth.Permission{}, err
+	}
+
+	var groups []auth.Group
+	if g, ok := ctx.Get("groups"); ok {
+		groups, _ = g.([]auth.Group)
+	}
+
+	permission, ok := auth.GroupsPermission(service.Spec.Labels, groups)
+	if !ok {
+		return auth.Permission{}, cranerror.NewError(CodeContainerPermissionDenied, "no permission on the service "+serviceId)
+	}
+
+	return permission, nil
+}
+
+// the permission of the account on the container, the one on the service of the container,
+// only admins access the containers out of services
+func (api *Api) containerPermission(ctx *gin.Context, craneContext context.Context, containerId string) (auth.Permission, error) {
+	account, ok := ctx.Get("account")
+	if !ok {
+		return auth.PermReadWrite, nil
+	}
+
+	acc, _ := account.(auth.Account)
+	if api.GetConfig() != nil && api.GetConfig().AccountIsAdmin(acc.Email) {
+		return auth.PermAdmin, nil
+	}
+
+	container, err := api.GetDockerClient().InspectContainer(craneContext, containerId)
+	if err != nil {
+		return auth.Permission{}, err
+	}
+
+	var serviceId string
+	if container.Config != nil {
+		serviceId = container.Config.Labels[labelServiceId]
+	}
+	if serviceId == "" {
+		return auth.Permission{}, cranerror.NewError(CodeContainerPermissionDenied, "only admins access containers out of services")
+	}
+
+	return api.servicePermission(ctx, serviceId)
+}
+
+func permissionAtLeast(permission, required auth.Permission) bool {
+	return permission.Normalize().Perm >= required.Normalize().Perm
+}
+
+// aborts unless the account has at least the required permission on the container of the path
+func (api *Api) AuthorizeContainerAccess(required auth.Permission) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		craneContext, _ := ctx.Get("craneContext")
+		permission, err := api.containerPermission(ctx, craneContext.(context.Context), ctx.Param("container_id"))
+		if err == nil && !permissionAtLeast(permission, required) {
+			err = cranerror.NewError(CodeContainerPermissionDenied, "permission "+required.Display+" on the container required")
+		}
+		if err != nil {
+			httpresponse.Error(ctx, err)
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// aborts unless the account has at least the required permission on the service of the path
+func (api *Api) AuthorizeServicePermission(required auth.Permission) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		permission, err := api.servicePermission(ctx, ctx.Param("service_id"))
+		if err == nil && !permissionAtLeast(permission, required) {
+			err = cranerror.NewError(CodeContainerPermissionDenied, "permission "+required.Display+" on the service required")
+		}
+		if err != nil {
+			httpresponse.Error(ctx, err)
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// runs authorizeAdmin on the requests with the query true only
+func authorizeAdminOnQuery(query string, authorizeAdmin gin.HandlerFunc) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if value, _ := strconv.ParseBool(ctx.Query(query)); value {
+			authorizeAdmin(ctx)
+			return
+		}
+		ctx.Next()
+	}
+}
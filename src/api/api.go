@@ -1,19 +1,48 @@
 package api
 
 import (
+	"sync"
+
 	"github.com/Dataman-Cloud/crane/src/dockerclient"
+	"github.com/Dataman-Cloud/crane/src/plugins/apiplugin"
+	"github.com/Dataman-Cloud/crane/src/plugins/registry"
+	containertty "github.com/Dataman-Cloud/crane/src/plugins/tty"
 	"github.com/Dataman-Cloud/crane/src/utils/config"
 )
 
 type Api struct {
 	Client *dockerclient.CraneDockerClient
 	Config *config.Config
+	// nil if the terminal sessions are not recorded
+	TerminalRecordings *containertty.RecordingStore
+
+	liveTerminalsOnce sync.Once
+	liveTerminalsHub  *containertty.LiveSessions
 }
 
 func (api *Api) GetDockerClient() *dockerclient.CraneDockerClient {
 	return api.Client
 }
 
+func (api *Api) liveTerminals() *containertty.LiveSessions {
+	api.liveTerminalsOnce.Do(func() {
+		api.liveTerminalsHub = containertty.NewLiveSessions()
+	})
+
+	return api.liveTerminalsHub
+}
+
+// the registry plugin, nil if it is not enabled
+func (api *Api) registryPlugin() *registry.Registry {
+	if plugin, ok := apiplugin.ApiPlugins[apiplugin.Registry]; ok {
+		if registryPlugin, ok := plugin.Instance.(*registry.Registry); ok {
+			return registryPlugin
+		}
+	}
+
+	return nil
+}
+
 func (api *Api) GetConfig() *config.Config {
 	return api.Config
 }
@@ -2,6 +2,10 @@ package api
 
 import (
 	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path"
 	"strconv"
 	"strings"
 
@@ -23,6 +27,23 @@ type ContainerRequest struct {
 	Width  int    `json:"Width"`
 }
 
+// ContainerCommitRequest names the image a container is committed into,
+// Repository is the repository in the registry namespace of the account
+type ContainerCommitRequest struct {
+	Repository string `json:"Repository"`
+	Tag        string `json:"Tag"`
+	Message    string `json:"Message"`
+	Author     string `json:"Author"`
+}
+
+// ContainerCommitResult is the image a container is committed and pushed as
+type ContainerCommitResult struct {
+	ImageID    string `json:"ImageID"`
+	Repository string `json:"Repository"`
+	Tag        string `json:"Tag"`
+	Digest     string `json:"Digest,omitempty"`
+}
+
 const (
 	CONTAINER_KILL = "kill"
 	CONTAINER_RM   = "rm"
@@ -38,6 +59,10 @@ const (
 	CodePatchContainerMethodUndefined  = "400-11003"
 	CodeDeleteContainerParamError      = "400-11004"
 	CodeDeleteContainerMethodUndefined = "400-11005"
+	CodeExecContainerParamError        = "400-11012"
+	CodeContainerFilesParamError       = "400-11016"
+	CodeCommitContainerParamError      = "400-11017"
+	CodeContainerPermissionDenied      = "403-11018"
 )
 
 func (api *Api) InspectContainer(ctx *gin.Context) {
@@ -155,6 +180,69 @@ func (api *Api) PatchContainer(ctx *gin.Context) {
 	return
 }
 
+// commit the container into an image of the registry namespace of the account and push it with a credential of the registry,
+// the image committed, the push progress and the digest pushed are streamed back as server sent events
+func (api *Api) CommitContainer(ctx *gin.Context) {
+	var commitRequest ContainerCommitRequest
+	if err := ctx.BindJSON(&commitRequest); err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeCommitContainerParamError, err.Error()))
+		return
+	}
+	if commitRequest.Repository == "" || strings.Contains(commitRequest.Repository, "/") {
+		httpresponse.Error(ctx, cranerror.NewError(CodeCommitContainerParamError, "repository required without namespace"))
+		return
+	}
+	if commitRequest.Tag == "" {
+		commitRequest.Tag = "latest"
+	}
+	// checked before the commit, docker only rejects the name once the container is committed
+	if err := dockerclient.ValidateImageReference(commitRequest.Repository, commitRequest.Tag); err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeCommitContainerParamError, err.Error()))
+		return
+	}
+
+	acc, pushAuth, err := api.registryPushAuth(ctx, commitRequest.Repository)
+	if err != nil {
+		httpresponse.Error(ctx, err)
+		return
+	}
+	if commitRequest.Author == "" {
+		commitRequest.Author = acc.Email
+	}
+
+	craneContext, _ := ctx.Get("craneContext")
+	cId := ctx.Param("container_id")
+	result := ContainerCommitResult{
+		Repository: pushAuth.ServerAddress + "/" + pushAuth.Username + "/" + commitRequest.Repository,
+		Tag:        commitRequest.Tag,
+	}
+	image, err := api.GetDockerClient().CommitContainer(craneContext.(context.Context), docker.CommitContainerOptions{
+		Container:  cId,
+		Repository: result.Repository,
+		Tag:        result.Tag,
+		Message:    commitRequest.Message,
+		Author:     commitRequest.Author,
+	})
+	if err != nil {
+		log.Errorf("commit container %s got error: %s", cId, err.Error())
+		httpresponse.Error(ctx, err)
+		return
+	}
+	result.ImageID = image.ID
+
+	ctx.Writer.Header().Set("Cache-Control", "no-cache")
+	httpresponse.SSEventOk(ctx, dockerclient.SSETypeImageCommit, result)
+
+	result.Digest, err = api.streamPushImage(ctx, craneContext.(context.Context), result.Repository, result.Tag, pushAuth)
+	if err != nil {
+		log.Errorf("push image %s:%s of container %s got error: %s", result.Repository, result.Tag, cId, err.Error())
+		httpresponse.SSEventError(ctx, dockerclient.SSETypeImagePushEnd, err)
+		return
+	}
+
+	httpresponse.SSEventOk(ctx, dockerclient.SSETypeImagePushEnd, result)
+}
+
 func (api *Api) DeleteContainer(ctx *gin.Context) {
 	craneContext, _ := ctx.Get("craneContext")
 	var containerRequest ContainerRequest
@@ -201,6 +289,141 @@ func (api *Api) DiffContainer(ctx *gin.Context) {
 	return
 }
 
+// download the files added or modified in the container as one archive, query format is tar (default) or zip
+func (api *Api) DownloadContainerChanges(ctx *gin.Context) {
+	format, err := archiveFormat(ctx)
+	if err != nil {
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	craneContext, _ := ctx.Get("craneContext")
+	cId := ctx.Param("container_id")
+	archive, err := api.GetDockerClient().ArchiveContainerChanges(craneContext.(context.Context), cId)
+	if err != nil {
+		log.Errorf("Archive changes of container %s got error: %s", cId, err.Error())
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	serveArchive(ctx, archive, cId+"-changes", format)
+}
+
+// list a directory of the container, query path defaults to /
+func (api *Api) ListContainerFiles(ctx *gin.Context) {
+	craneContext, _ := ctx.Get("craneContext")
+	cId := ctx.Param("container_id")
+	files, err := api.GetDockerClient().ListContainerDir(craneContext.(context.Context), cId, ctx.DefaultQuery("path", "/"))
+	if err != nil {
+		log.Errorf("List files of container %s got error: %s", cId, err.Error())
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	httpresponse.Ok(ctx, files)
+	return
+}
+
+// download the files or directories of the query path (repeatable) as an archive,
+// query format is tar (default) or zip
+func (api *Api) DownloadContainerFiles(ctx *gin.Context) {
+	format, err := archiveFormat(ctx)
+	if err != nil {
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	paths := ctx.Request.URL.Query()["path"]
+	if len(paths) == 0 {
+		httpresponse.Error(ctx, cranerror.NewError(CodeContainerFilesParamError, "path required"))
+		return
+	}
+
+	craneContext, _ := ctx.Get("craneContext")
+	cId := ctx.Param("container_id")
+	archive, err := api.GetDockerClient().ArchiveContainerPaths(craneContext.(context.Context), cId, paths, len(paths) > 1)
+	if err != nil {
+		log.Errorf("Archive files of container %s got error: %s", cId, err.Error())
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	name := path.Base(paths[0])
+	if len(paths) > 1 || name == "/" {
+		name = cId + "-files"
+	}
+	serveArchive(ctx, archive, name, format)
+}
+
+// upload the multipart file into the directory of the query path of the container
+func (api *Api) UploadContainerFile(ctx *gin.Context) {
+	maxSize := api.GetDockerClient().ContainerFileMaxSize()
+	// room for the multipart headers
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxSize+1<<20)
+
+	file, header, err := ctx.Request.FormFile("file")
+	if err != nil {
+		log.Error("Parse uploaded file got error: ", err)
+		httpresponse.Error(ctx, cranerror.NewError(CodeContainerFilesParamError, err.Error()))
+		return
+	}
+	defer file.Close()
+
+	size, err := file.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeContainerFilesParamError, err.Error()))
+		return
+	}
+
+	craneContext, _ := ctx.Get("craneContext")
+	cId := ctx.Param("container_id")
+	dir := ctx.Query("path")
+	if err := api.GetDockerClient().UploadContainerFile(craneContext.(context.Context), cId, dir, header.Filename, size, file); err != nil {
+		log.Errorf("Upload file to container %s got error: %s", cId, err.Error())
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	httpresponse.Ok(ctx, path.Join(dir, path.Base(header.Filename)))
+	return
+}
+
+func archiveFormat(ctx *gin.Context) (string, error) {
+	format := ctx.DefaultQuery("format", dockerclient.ArchiveFormatTar)
+	if format != dockerclient.ArchiveFormatTar && format != dockerclient.ArchiveFormatZip {
+		return "", cranerror.NewError(CodeContainerFilesParamError, "format must be tar or zip")
+	}
+
+	return format, nil
+}
+
+// send the tar temp file in format and remove it
+func serveArchive(ctx *gin.Context, archive *os.File, name, format string) {
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	w := ctx.Writer
+	w.Header().Set("Content-Disposition", "attachment; filename="+name+"."+format)
+	if format == dockerclient.ArchiveFormatZip {
+		w.Header().Set("Content-Type", "application/zip")
+		w.WriteHeader(http.StatusOK)
+		if err := dockerclient.TarToZip(archive, w); err != nil {
+			log.Errorf("Convert archive %s to zip got error: %s", name, err.Error())
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	if info, err := archive.Stat(); err == nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	}
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, archive)
+}
+
 func (api *Api) LogsContainer(ctx *gin.Context) {
 	craneContext, _ := ctx.Get("craneContext")
 	message := make(chan string)
@@ -267,3 +490,25 @@ func (api *Api) StatsContainer(ctx *gin.Context) {
 		}
 	}
 }
+
+// run a command in the container and wait for its stdout, stderr and exit code
+func (api *Api) ExecContainer(ctx *gin.Context) {
+	var opts dockerclient.ExecOptions
+	if err := ctx.BindJSON(&opts); err != nil {
+		log.Error("Parse param of exec container got error: ", err)
+		httpresponse.Error(ctx, cranerror.NewError(CodeExecContainerParamError, err.Error()))
+		return
+	}
+
+	craneContext, _ := ctx.Get("craneContext")
+	cId := ctx.Param("container_id")
+	result, err := api.GetDockerClient().ExecContainer(craneContext.(context.Context), cId, opts)
+	if err != nil {
+		log.Errorf("Exec in container %s got error: %s", cId, err.Error())
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	httpresponse.Ok(ctx, result)
+	return
+}
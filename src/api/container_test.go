@@ -0,0 +1,30 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Dataman-Cloud/crane/src/dockerclient"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommitContainerInvalidRepository(t *testing.T) {
+	api := &Api{
+		Client: &dockerclient.CraneDockerClient{},
+	}
+	router := api.ApiRouter()
+
+	for _, body := range []string{
+		`{"Repository": "Web"}`,
+		`{"Repository": "web-"}`,
+		`{"Repository": "web", "Tag": "-v1"}`,
+	} {
+		req, _ := http.NewRequest("POST", "/api/v1/nodes/node1/containers/container1/commit", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+}
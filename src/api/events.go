@@ -0,0 +1,233 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Dataman-Cloud/crane/src/dockerclient"
+	"github.com/Dataman-Cloud/crane/src/plugins/auth"
+	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
+	"github.com/Dataman-Cloud/crane/src/utils/httpresponse"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/engine-api/types"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/manucorporat/sse"
+)
+
+const (
+	//Events error code
+	CodeEventsParamError = "400-11901"
+
+	SSETypeEvent = "crane-event"
+
+	// comment sent on idle streams so proxies do not close them
+	eventsHeartbeatInterval = 15 * time.Second
+)
+
+var eventsUpgrader = &websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// the filter of the query type, namespace and node limited to the scope of the account,
+// and the last event id of the Last-Event-ID header or the query last_event_id
+func (api *Api) eventSubscription(ctx *gin.Context) (dockerclient.EventFilter, uint64, error) {
+	filter := dockerclient.EventFilter{
+		Types:      splitQuery(ctx.Query("type")),
+		Namespaces: splitQuery(ctx.Query("namespace")),
+		Nodes:      splitQuery(ctx.Query("node")),
+	}
+
+	var lastEventId uint64
+	if id := ctx.Request.Header.Get("Last-Event-ID"); id != "" || ctx.Query("last_event_id") != "" {
+		if id == "" {
+			id = ctx.Query("last_event_id")
+		}
+
+		var err error
+		if lastEventId, err = strconv.ParseUint(id, 10, 64); err != nil {
+			return filter, 0, cranerror.NewError(CodeEventsParamError, "invalid last event id")
+		}
+	}
+
+	scope, err := api.eventScope(ctx)
+	if err != nil {
+		return filter, 0, err
+	}
+	filter.Scope = scope
+
+	return filter, lastEventId, nil
+}
+
+// the services the groups of the account have a permission on and their namespaces,
+// nil for admins and without the account feature, the scope is the one at subscription time
+func (api *Api) eventScope(ctx *gin.Context) (*dockerclient.EventScope, error) {
+	account, ok := ctx.Get("account")
+	if !ok {
+		return nil, nil
+	}
+
+	acc, _ := account.(auth.Account)
+	if api.GetConfig() != nil && api.GetConfig().AccountIsAdmin(acc.Email) {
+		return nil, nil
+	}
+
+	var groups []auth.Group
+	if g, ok := ctx.Get("groups"); ok {
+		groups, _ = g.([]auth.Group)
+	}
+
+	services, err := api.GetDockerClient().ListServiceSpec(types.ServiceListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	scope := &dockerclient.EventScope{Services: make(map[string]bool), Namespaces: make(map[string]bool)}
+	for _, service := range services {
+		if _, ok := auth.GroupsPermission(service.Spec.Labels, groups); !ok {
+			continue
+		}
+
+		scope.Services[service.ID] = true
+		if namespace := service.Spec.Labels[dockerclient.LabelNamespace]; namespace != "" {
+			scope.Namespaces[namespace] = true
+		}
+	}
+
+	return scope, nil
+}
+
+// stream events as server sent events, query type, namespace and node filter the events,
+// a client reconnecting with Last-Event-ID gets the events it missed first,
+// the accounts other than admins only get the events of the services they have a permission on
+func (api *Api) Events(ctx *gin.Context) {
+	filter, lastEventId, err := api.eventSubscription(ctx)
+	if err != nil {
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	missed, events := api.GetDockerClient().SubscribeEvents(filter, lastEventId)
+	defer api.GetDockerClient().UnsubscribeEvents(events)
+
+	w := ctx.Writer
+	w.Header().Set("Cache-Control", "no-cache")
+	for _, event := range missed {
+		renderEvent(ctx, event)
+	}
+	w.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	clientGone := w.CloseNotify()
+	for {
+		select {
+		case <-clientGone:
+			return
+		case event := <-events:
+			renderEvent(ctx, event)
+			w.Flush()
+		case <-heartbeat.C:
+			w.Write([]byte(":\n\n"))
+			w.Flush()
+		}
+	}
+}
+
+// stream the events of Events over a websocket, each event is a text message,
+// the missed events are asked with the query last_event_id
+func (api *Api) EventsWebSocket(ctx *gin.Context) {
+	filter, lastEventId, err := api.eventSubscription(ctx)
+	if err != nil {
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	conn, err := eventsUpgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if err != nil {
+		log.Error("Upgrade websocket connect got error: ", err)
+		return
+	}
+	defer conn.Close()
+
+	missed, events := api.GetDockerClient().SubscribeEvents(filter, lastEventId)
+	defer api.GetDockerClient().UnsubscribeEvents(events)
+
+	// the messages of the client are discarded, reading them notices the close
+	clientGone := make(chan struct{})
+	go func() {
+		defer close(clientGone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, event := range missed {
+		if err := conn.WriteJSON(gin.H{"code": httpresponse.CodeOk, "data": event}); err != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(eventsHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-clientGone:
+			return
+		case event := <-events:
+			if err := conn.WriteJSON(gin.H{"code": httpresponse.CodeOk, "data": event}); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventsHeartbeatInterval)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func renderEvent(ctx *gin.Context, event dockerclient.CraneEvent) {
+	ctx.Render(-1, sse.Event{
+		Id:    strconv.FormatUint(event.ID, 10),
+		Event: SSETypeEvent,
+		Data:  gin.H{"code": httpresponse.CodeOk, "data": event},
+	})
+}
+
+// publish an operation made through crane, the account is known if the account feature is enabled
+func (api *Api) publishCraneEvent(ctx *gin.Context, eventType, action, actorId, namespace string) {
+	event := dockerclient.CraneEvent{
+		Type:      eventType,
+		Action:    action,
+		ActorID:   actorId,
+		Namespace: namespace,
+	}
+
+	if account, ok := ctx.Get("account"); ok {
+		if acc, ok := account.(auth.Account); ok {
+			event.Account = acc.Email
+		}
+	}
+
+	api.GetDockerClient().PublishEvent(event)
+}
+
+func splitQuery(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
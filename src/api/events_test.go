@@ -0,0 +1,103 @@
+package api
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Dataman-Cloud/crane/src/dockerclient"
+	"github.com/Dataman-Cloud/crane/src/plugins/auth"
+	"github.com/Dataman-Cloud/crane/src/utils/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventsResume(t *testing.T) {
+	api := &Api{
+		Client: &dockerclient.CraneDockerClient{},
+	}
+	server := httptest.NewServer(api.ApiRouter())
+	defer server.Close()
+
+	api.Client.PublishEvent(dockerclient.CraneEvent{Type: dockerclient.EventTypeStack, Namespace: "blog"})
+	api.Client.PublishEvent(dockerclient.CraneEvent{Type: "container", Namespace: "blog"})
+	api.Client.PublishEvent(dockerclient.CraneEvent{Type: dockerclient.EventTypeStack, Namespace: "shop"})
+	history, events := api.Client.SubscribeEvents(dockerclient.EventFilter{}, 1)
+	api.Client.UnsubscribeEvents(events)
+
+	req, _ := http.NewRequest("GET", server.URL+"/api/v1/events?type=stack", nil)
+	req.Header.Set("Last-Event-ID", strconv.FormatUint(history[0].ID, 10))
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "id:") {
+			assert.Equal(t, "id:"+strconv.FormatUint(history[2].ID, 10), scanner.Text())
+			break
+		}
+	}
+
+	req, _ = http.NewRequest("GET", server.URL+"/api/v1/events?last_event_id=foo", nil)
+	resp, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestEventsWebSocket(t *testing.T) {
+	api := &Api{
+		Client: &dockerclient.CraneDockerClient{},
+	}
+	server := httptest.NewServer(api.ApiRouter())
+	defer server.Close()
+
+	api.Client.PublishEvent(dockerclient.CraneEvent{Type: dockerclient.EventTypeStack, Namespace: "blog"})
+	api.Client.PublishEvent(dockerclient.CraneEvent{Type: dockerclient.EventTypeStack, Namespace: "shop"})
+	history, events := api.Client.SubscribeEvents(dockerclient.EventFilter{}, 1)
+	api.Client.UnsubscribeEvents(events)
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/v1/events/ws?last_event_id=" + strconv.FormatUint(history[0].ID-1, 10)
+	conn, _, err := websocket.DefaultDialer.Dial(url+"&namespace=shop", nil)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	var message struct {
+		Data dockerclient.CraneEvent `json:"data"`
+	}
+	assert.Nil(t, conn.ReadJSON(&message))
+	assert.Equal(t, history[1].ID, message.Data.ID)
+	assert.Equal(t, "shop", message.Data.Namespace)
+
+	api.Client.PublishEvent(dockerclient.CraneEvent{Type: dockerclient.EventTypeStack, Namespace: "blog"})
+	api.Client.PublishEvent(dockerclient.CraneEvent{Type: dockerclient.EventTypeStack, Namespace: "shop", Action: "remove"})
+	assert.Nil(t, conn.ReadJSON(&message))
+	assert.Equal(t, "remove", message.Data.Action)
+}
+
+func TestEventScopeOfAdmin(t *testing.T) {
+	api := &Api{
+		Client: &dockerclient.CraneDockerClient{},
+		Config: &config.Config{AccountEmailDefault: "admin@admin.com"},
+	}
+	ctx := &gin.Context{}
+
+	scope, err := api.eventScope(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, scope)
+
+	ctx.Set("account", auth.Account{Email: "admin@admin.com"})
+	scope, err = api.eventScope(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, scope)
+}
+
+func TestSplitQuery(t *testing.T) {
+	assert.Nil(t, splitQuery(""))
+	assert.Equal(t, []string{"service", "task"}, splitQuery("service, task,"))
+}
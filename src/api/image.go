@@ -1,9 +1,20 @@
 package api
 
 import (
+	"archive/tar"
+	"bytes"
 	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Dataman-Cloud/crane/src/dockerclient"
+	"github.com/Dataman-Cloud/crane/src/plugins/auth"
+	"github.com/Dataman-Cloud/crane/src/plugins/registry"
+	rauth "github.com/Dataman-Cloud/crane/src/plugins/registryauth"
 	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
 	"github.com/Dataman-Cloud/crane/src/utils/httpresponse"
 
@@ -15,9 +26,28 @@ import (
 
 const (
 	//Image error code
-	CodeListImageParamError = "400-11101"
+	CodeListImageParamError   = "400-11101"
+	CodePullImageParamError   = "400-11106"
+	CodeBuildImageParamError  = "400-11107"
+	CodeImageRegistryMissing  = "503-11108"
+	CodeImageAccountRequired  = "401-11109"
+	CodeImageBuildRecordError = "503-11110"
+
+	// MB of a build context if not configured
+	DefaultImageBuildContextMaxSize = 500
+	// bytes of a build form kept in memory, the rest is written to temp files
+	imageBuildFormMemory = 32 << 20
 )
 
+// ImagePullRequest names the image pulled as repository[:tag][@digest],
+// RegistryAuth is the name of the stored registry auth it is pulled with if any,
+// Constraints choose the nodes of a pre-pull as the placement constraints of a service
+type ImagePullRequest struct {
+	Image        string   `json:"Image"`
+	RegistryAuth string   `json:"RegistryAuth"`
+	Constraints  []string `json:"Constraints"`
+}
+
 func (api *Api) ListImages(ctx *gin.Context) {
 	craneContext, _ := ctx.Get("craneContext")
 	all, err := strconv.ParseBool(ctx.DefaultQuery("all", "false"))
@@ -102,3 +132,379 @@ func (api *Api) RemoveImage(ctx *gin.Context) {
 	httpresponse.Ok(ctx, "success")
 	return
 }
+
+// pull an image on the node, the progress of each layer is streamed back as server sent events
+func (api *Api) PullImage(ctx *gin.Context) {
+	pullRequest, opts, auth, err := imagePullOptions(ctx)
+	if err != nil {
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	craneContext, _ := ctx.Get("craneContext")
+	pullContext, cancel := context.WithCancel(craneContext.(context.Context))
+	defer cancel()
+	opts.Context = pullContext
+
+	progress := make(chan dockerclient.ImageProgress)
+	pulled := make(chan error, 1)
+	go func() {
+		err := api.GetDockerClient().PullImage(pullContext, opts, auth, progress)
+		close(progress)
+		pulled <- err
+	}()
+
+	ctx.Writer.Header().Set("Cache-Control", "no-cache")
+	gone := streamImageProgress(ctx, dockerclient.SSETypeImagePull, progress, cancel)
+
+	err = <-pulled
+	if gone {
+		return
+	}
+	if err != nil {
+		log.Errorf("pull image %s on node %s got error: %s", pullRequest.Image, ctx.Param("node_id"), err.Error())
+		httpresponse.SSEventError(ctx, dockerclient.SSETypeImagePullEnd, err)
+		return
+	}
+
+	httpresponse.SSEventOk(ctx, dockerclient.SSETypeImagePullEnd, dockerclient.NodePullResult{NodeID: ctx.Param("node_id")})
+}
+
+// pull an image on every ready node matching the constraints to warm it before a deploy,
+// the progress of each layer of each node is streamed back as server sent events, then the result of each node
+func (api *Api) PrePullImage(ctx *gin.Context) {
+	pullRequest, opts, auth, err := imagePullOptions(ctx)
+	if err != nil {
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	nodes, err := api.GetDockerClient().ReadyNodesMatching(pullRequest.Constraints)
+	if err != nil {
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	craneContext, _ := ctx.Get("craneContext")
+	pullContext, cancel := context.WithCancel(craneContext.(context.Context))
+	defer cancel()
+	opts.Context = pullContext
+
+	progress := make(chan dockerclient.NodeImageProgress)
+	pulled := make(chan []dockerclient.NodePullResult, 1)
+	go func() {
+		results := api.GetDockerClient().PrePullImage(pullContext, opts, auth, nodes, progress)
+		close(progress)
+		pulled <- results
+	}()
+
+	ctx.Writer.Header().Set("Cache-Control", "no-cache")
+	stream := newProgressStream(ctx, dockerclient.SSETypeImagePull, cancel)
+	for message := range progress {
+		stream.send(message)
+	}
+
+	results := <-pulled
+	if stream.gone {
+		return
+	}
+	httpresponse.SSEventOk(ctx, dockerclient.SSETypeImagePullEnd, results)
+}
+
+// the options and the auth of the pull request, the auth is the stored registry auth it names if any
+func imagePullOptions(ctx *gin.Context) (ImagePullRequest, docker.PullImageOptions, docker.AuthConfiguration, error) {
+	var pullRequest ImagePullRequest
+	var pullAuth docker.AuthConfiguration
+	if err := ctx.BindJSON(&pullRequest); err != nil {
+		return pullRequest, docker.PullImageOptions{}, pullAuth, cranerror.NewError(CodePullImageParamError, err.Error())
+	}
+
+	repository, tag := docker.ParseRepositoryTag(pullRequest.Image)
+	if i := strings.Index(pullRequest.Image, "@"); i >= 0 {
+		tag = pullRequest.Image[i+1:]
+	}
+	if tag == "" {
+		tag = "latest"
+	}
+	opts := docker.PullImageOptions{Repository: repository, Tag: tag}
+	if err := dockerclient.ValidateImageReference(repository, tag); err != nil {
+		return pullRequest, opts, pullAuth, err
+	}
+
+	if pullRequest.RegistryAuth != "" {
+		if rauth.DbClient == nil {
+			return pullRequest, opts, pullAuth, cranerror.NewError(CodePullImageParamError, "registry auth not enabled")
+		}
+
+		var acc auth.Account
+		if account, ok := ctx.Get("account"); ok {
+			acc, _ = account.(auth.Account)
+		}
+		if acc.ID == 0 {
+			return pullRequest, opts, pullAuth, cranerror.NewError(CodePullImageParamError, "account required to use a registry auth")
+		}
+
+		authInfo, err := rauth.GetOfAccount(pullRequest.RegistryAuth, acc.ID)
+		if err != nil {
+			return pullRequest, opts, pullAuth, cranerror.NewError(CodePullImageParamError, "registry auth not found: "+pullRequest.RegistryAuth)
+		}
+		pullAuth = dockerclient.RegistryAuthConfiguration(authInfo)
+	}
+
+	return pullRequest, opts, pullAuth, nil
+}
+
+// build an image on the node from the uploaded build context: a tar, gzip accepted, as the file context or a Dockerfile alone as the file dockerfile.
+// Query repository and tag name the image in the registry namespace of the account, dockerfile is the path of the Dockerfile in the context,
+// buildargs is a json object, nocache and pull are the options of docker build and push=true pushes the image once built.
+// The build log and the push progress are streamed back as server sent events, the build is recorded by the registry
+func (api *Api) BuildImage(ctx *gin.Context) {
+	repository := ctx.Query("repository")
+	if repository == "" || strings.Contains(repository, "/") {
+		httpresponse.Error(ctx, cranerror.NewError(CodeBuildImageParamError, "repository required without namespace"))
+		return
+	}
+	tag := ctx.DefaultQuery("tag", "latest")
+
+	opts := docker.BuildImageOptions{RmTmpContainer: true}
+	var push bool
+	for name, value := range map[string]*bool{"push": &push, "nocache": &opts.NoCache, "pull": &opts.Pull} {
+		var err error
+		if *value, err = strconv.ParseBool(ctx.DefaultQuery(name, "false")); err != nil {
+			httpresponse.Error(ctx, cranerror.NewError(CodeBuildImageParamError, "invalid "+name+": "+err.Error()))
+			return
+		}
+	}
+
+	if ctx.Query("buildargs") != "" {
+		var buildArgs map[string]string
+		if err := json.Unmarshal([]byte(ctx.Query("buildargs")), &buildArgs); err != nil {
+			httpresponse.Error(ctx, cranerror.NewError(CodeBuildImageParamError, "invalid buildargs: "+err.Error()))
+			return
+		}
+		for name, value := range buildArgs {
+			opts.BuildArgs = append(opts.BuildArgs, docker.BuildArg{Name: name, Value: value})
+		}
+	}
+
+	acc, pushAuth, err := api.registryPushAuth(ctx, repository)
+	if err != nil {
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	maxSize := int64(DefaultImageBuildContextMaxSize)
+	if api.GetConfig().ImageBuildContextMaxSize > 0 {
+		maxSize = int64(api.GetConfig().ImageBuildContextMaxSize)
+	}
+	// room for the multipart headers
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxSize<<20+1<<20)
+	buildContext, dockerfile, err := imageBuildContext(ctx)
+	if err != nil {
+		httpresponse.Error(ctx, err)
+		return
+	}
+	defer buildContext.Close()
+	opts.InputStream = buildContext
+	opts.Dockerfile = dockerfile
+
+	build := &registry.ImageBuild{
+		AccountEmail: acc.Email,
+		NodeID:       ctx.Param("node_id"),
+		Namespace:    pushAuth.Username,
+		Image:        repository,
+		Tag:          tag,
+		Push:         push,
+		Status:       registry.ImageBuildStatusBuilding,
+	}
+	registryPlugin := api.registryPlugin()
+	if err := registryPlugin.SaveImageBuild(build); err != nil {
+		log.Error("Save image build got error: ", err)
+		httpresponse.Error(ctx, cranerror.NewError(CodeImageBuildRecordError, err.Error()))
+		return
+	}
+
+	ctx.Writer.Header().Set("Cache-Control", "no-cache")
+	httpresponse.SSEventOk(ctx, dockerclient.SSETypeImageBuild, build)
+
+	craneContext, _ := ctx.Get("craneContext")
+	name := pushAuth.ServerAddress + "/" + pushAuth.Username + "/" + repository
+	opts.Name = name + ":" + tag
+	startedAt := time.Now()
+	err = api.streamBuildImage(ctx, craneContext.(context.Context), opts)
+	if err == nil {
+		var image *docker.Image
+		if image, err = api.GetDockerClient().InspectImage(craneContext.(context.Context), opts.Name); err == nil {
+			build.ImageID = image.ID
+		}
+	}
+	if err == nil && push {
+		build.Status = registry.ImageBuildStatusPushing
+		if err := registryPlugin.SaveImageBuild(build); err != nil {
+			log.Errorf("save image build %d got error: %s", build.ID, err.Error())
+		}
+		build.Digest, err = api.streamPushImage(ctx, craneContext.(context.Context), name, tag, pushAuth)
+	}
+
+	build.Duration = time.Since(startedAt).Seconds()
+	build.Status = registry.ImageBuildStatusSucceeded
+	if err != nil {
+		build.Status = registry.ImageBuildStatusFailed
+		build.Error = err.Error()
+	}
+	if err := registryPlugin.SaveImageBuild(build); err != nil {
+		log.Errorf("save image build %d got error: %s", build.ID, err.Error())
+	}
+
+	if err != nil {
+		log.Errorf("build image %s on node %s got error: %s", opts.Name, build.NodeID, err.Error())
+		httpresponse.SSEventError(ctx, dockerclient.SSETypeImageBuildEnd, err)
+		return
+	}
+
+	httpresponse.SSEventOk(ctx, dockerclient.SSETypeImageBuildEnd, build)
+}
+
+// the tar build context of the request and the path of its Dockerfile,
+// the file context is the context, or else the file dockerfile is the only file of the context
+func imageBuildContext(ctx *gin.Context) (io.ReadCloser, string, error) {
+	if err := ctx.Request.ParseMultipartForm(imageBuildFormMemory); err != nil {
+		return nil, "", cranerror.NewError(CodeBuildImageParamError, err.Error())
+	}
+
+	if file, _, err := ctx.Request.FormFile("context"); err == nil {
+		return file, ctx.Query("dockerfile"), nil
+	}
+
+	file, _, err := ctx.Request.FormFile("dockerfile")
+	if err != nil {
+		return nil, "", cranerror.NewError(CodeBuildImageParamError, "build context or dockerfile required")
+	}
+	defer file.Close()
+
+	dockerfile, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, "", cranerror.NewError(CodeBuildImageParamError, err.Error())
+	}
+
+	var buf bytes.Buffer
+	tarWriter := tar.NewWriter(&buf)
+	err = tarWriter.WriteHeader(&tar.Header{Name: "Dockerfile", Mode: 0644, Size: int64(len(dockerfile)), ModTime: time.Now()})
+	if err == nil {
+		_, err = tarWriter.Write(dockerfile)
+	}
+	if err == nil {
+		err = tarWriter.Close()
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	return ioutil.NopCloser(&buf), "Dockerfile", nil
+}
+
+// the account of the request and the auth it pushes the repository of its registry namespace with
+func (api *Api) registryPushAuth(ctx *gin.Context, repository string) (auth.Account, docker.AuthConfiguration, error) {
+	var acc auth.Account
+	registryPlugin := api.registryPlugin()
+	if registryPlugin == nil {
+		return acc, docker.AuthConfiguration{}, cranerror.NewError(CodeImageRegistryMissing, "registry not enabled")
+	}
+
+	if account, ok := ctx.Get("account"); ok {
+		acc, _ = account.(auth.Account)
+	}
+	if acc.Email == "" {
+		return acc, docker.AuthConfiguration{}, cranerror.NewError(CodeImageAccountRequired, "account required to push to the registry")
+	}
+
+	namespace, password, err := registryPlugin.PushCredential(acc, repository)
+	if err != nil {
+		return acc, docker.AuthConfiguration{}, err
+	}
+
+	return acc, docker.AuthConfiguration{Username: namespace, Password: password, ServerAddress: api.GetConfig().RegistryImageHost()}, nil
+}
+
+// build the image with its log streamed back as server sent events
+func (api *Api) streamBuildImage(ctx *gin.Context, craneContext context.Context, opts docker.BuildImageOptions) error {
+	buildContext, cancel := context.WithCancel(craneContext)
+	defer cancel()
+	opts.Context = buildContext
+
+	progress := make(chan dockerclient.ImageProgress)
+	built := make(chan error, 1)
+	go func() {
+		err := api.GetDockerClient().BuildImage(buildContext, opts, progress)
+		close(progress)
+		built <- err
+	}()
+
+	streamImageProgress(ctx, dockerclient.SSETypeImageBuildLog, progress, cancel)
+	return <-built
+}
+
+// push the image with its progress streamed back as server sent events, the digest pushed is returned
+func (api *Api) streamPushImage(ctx *gin.Context, craneContext context.Context, repository, tag string, pushAuth docker.AuthConfiguration) (string, error) {
+	pushContext, cancel := context.WithCancel(craneContext)
+	defer cancel()
+
+	var digest string
+	progress := make(chan dockerclient.ImageProgress)
+	pushed := make(chan error, 1)
+	go func() {
+		var err error
+		digest, err = api.GetDockerClient().PushImage(pushContext,
+			docker.PushImageOptions{Name: repository, Tag: tag, Context: pushContext}, pushAuth, progress)
+		close(progress)
+		pushed <- err
+	}()
+
+	streamImageProgress(ctx, dockerclient.SSETypeImagePush, progress, cancel)
+	return digest, <-pushed
+}
+
+// send the progress messages as server sent events of eventType until progress is closed,
+// returns whether the client is gone
+func streamImageProgress(ctx *gin.Context, eventType string, progress <-chan dockerclient.ImageProgress, cancel context.CancelFunc) bool {
+	stream := newProgressStream(ctx, eventType, cancel)
+	for message := range progress {
+		stream.send(message)
+	}
+	return stream.gone
+}
+
+// progressStream sends progress messages as server sent events, once the client is gone
+// the operation is canceled by cancel and the rest of its progress is dropped
+type progressStream struct {
+	ctx        *gin.Context
+	eventType  string
+	cancel     context.CancelFunc
+	clientGone <-chan bool
+	gone       bool
+}
+
+// the close notification fires once, the streams after it of the request know the client is gone from the context
+func newProgressStream(ctx *gin.Context, eventType string, cancel context.CancelFunc) *progressStream {
+	stream := &progressStream{ctx: ctx, eventType: eventType, cancel: cancel, clientGone: ctx.Writer.CloseNotify()}
+	if _, gone := ctx.Get("clientGone"); gone {
+		stream.gone = true
+		cancel()
+	}
+	return stream
+}
+
+func (stream *progressStream) send(message interface{}) {
+	if stream.gone {
+		return
+	}
+
+	select {
+	case <-stream.clientGone:
+		stream.gone = true
+		stream.ctx.Set("clientGone", true)
+		stream.cancel()
+	default:
+		httpresponse.SSEventOk(stream.ctx, stream.eventType, message)
+	}
+}
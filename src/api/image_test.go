@@ -0,0 +1,112 @@
+package api
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Dataman-Cloud/crane/src/dockerclient"
+	"github.com/Dataman-Cloud/crane/src/plugins/auth"
+	rauth "github.com/Dataman-Cloud/crane/src/plugins/registryauth"
+	"github.com/Dataman-Cloud/crane/src/utils/db"
+
+	docker "github.com/Dataman-Cloud/go-dockerclient"
+	"github.com/erikstmartin/go-testdb"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// a recorder whose client is gone after the first write, the close notification fires once
+type closeNotifyRecorder struct {
+	*httptest.ResponseRecorder
+	closed chan bool
+}
+
+func (r *closeNotifyRecorder) Write(b []byte) (int, error) {
+	return r.WriteString(string(b))
+}
+
+func (r *closeNotifyRecorder) WriteString(s string) (int, error) {
+	if r.Body.Len() == 0 {
+		r.closed <- true
+	}
+	return r.ResponseRecorder.WriteString(s)
+}
+
+func (r *closeNotifyRecorder) CloseNotify() <-chan bool {
+	return r.closed
+}
+
+func TestStreamImageProgressClientGone(t *testing.T) {
+	w := &closeNotifyRecorder{ResponseRecorder: httptest.NewRecorder(), closed: make(chan bool, 1)}
+	canceled := 0
+	var gone, pushGone bool
+
+	router := gin.New()
+	router.GET("/", func(ctx *gin.Context) {
+		progress := make(chan dockerclient.ImageProgress, 4)
+		progress <- dockerclient.ImageProgress{Status: "first"}
+		for i := 0; i < 3; i++ {
+			progress <- dockerclient.ImageProgress{Status: "after"}
+		}
+		close(progress)
+		gone = streamImageProgress(ctx, dockerclient.SSETypeImagePull, progress, func() { canceled++ })
+
+		// the next stream of the request knows the client is gone
+		push := make(chan dockerclient.ImageProgress, 1)
+		push <- dockerclient.ImageProgress{Status: "push"}
+		close(push)
+		pushGone = streamImageProgress(ctx, dockerclient.SSETypeImagePush, push, func() { canceled++ })
+	})
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	router.ServeHTTP(w, req)
+
+	assert.True(t, gone)
+	assert.True(t, pushGone)
+	assert.Equal(t, 2, canceled)
+	assert.Contains(t, w.Body.String(), "first")
+	assert.False(t, strings.Contains(w.Body.String(), "after"))
+	assert.False(t, strings.Contains(w.Body.String(), "push"))
+}
+
+func TestImagePullOptionsRegistryAuthOfAccount(t *testing.T) {
+	var queryArgs []driver.Value
+	testdb.SetQueryWithArgsFunc(func(query string, args []driver.Value) (driver.Rows, error) {
+		queryArgs = args
+		if len(args) == 2 && args[1] == int64(7) {
+			return testdb.RowsFromCSVString([]string{"id", "name", "username", "password", "account_id"}, "1,private,robot,secret,7"), nil
+		}
+		return testdb.RowsFromCSVString([]string{"id"}, ""), nil
+	})
+	defer testdb.SetQueryWithArgsFunc(nil)
+
+	dbClient, _ := db.NewDB("testdb", "")
+	previous := rauth.DbClient
+	rauth.DbClient = dbClient
+	defer func() { rauth.DbClient = previous }()
+
+	pullOptions := func(account *auth.Account) (docker.AuthConfiguration, error) {
+		req, _ := http.NewRequest("POST", "/api/v1/images/pull", strings.NewReader(`{"Image": "shop/web:v1", "RegistryAuth": "private"}`))
+		ctx := &gin.Context{Request: req}
+		if account != nil {
+			ctx.Set("account", *account)
+		}
+		_, _, pullAuth, err := imagePullOptions(ctx)
+		return pullAuth, err
+	}
+
+	_, err := pullOptions(nil)
+	assert.NotNil(t, err)
+
+	pullAuth, err := pullOptions(&auth.Account{ID: 7})
+	assert.Nil(t, err)
+	assert.Equal(t, "robot", pullAuth.Username)
+	assert.Equal(t, []driver.Value{"private", int64(7)}, queryArgs)
+
+	// the auth of the same name of another account
+	_, err = pullOptions(&auth.Account{ID: 8})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,9 @@
+package middlewares
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+func AuthorizeAdmin(ctx *gin.Context) {
+	ctx.Next() // do nothing with account feature
+}
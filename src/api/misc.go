@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"runtime"
 
+	"github.com/Dataman-Cloud/crane/src/dockerclient"
 	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
 	"github.com/Dataman-Cloud/crane/src/utils/httpresponse"
 	"github.com/Dataman-Cloud/crane/src/version"
@@ -21,11 +22,13 @@ const (
 )
 
 type CraneConfigResponse struct {
-	Version      string      `json:"Version"`
-	BuildTime    string      `json:"Build"`
-	FeatureFlags []string    `json:"FeatureFlags"`
-	SwarmInfo    swarm.Swarm `json:"SwarmInfo"`
-	NumGoroutine int
+	Version          string                               `json:"Version"`
+	BuildTime        string                               `json:"Build"`
+	FeatureFlags     []string                             `json:"FeatureFlags"`
+	SwarmInfo        swarm.Swarm                          `json:"SwarmInfo"`
+	ManagerEndpoint  string                               `json:"ManagerEndpoint"`
+	ManagerEndpoints []dockerclient.ManagerEndpointStatus `json:"ManagerEndpoints"`
+	NumGoroutine     int
 }
 
 type RouteInfo struct {
@@ -39,6 +42,8 @@ func (api *Api) CraneConfig(ctx *gin.Context) {
 	config.BuildTime = version.BuildTime
 	config.FeatureFlags = api.GetConfig().FeatureFlags
 	config.NumGoroutine = runtime.NumGoroutine()
+	config.ManagerEndpoint = api.GetDockerClient().ManagerEndpoint()
+	config.ManagerEndpoints = api.GetDockerClient().ManagerEndpoints()
 
 	var err error
 	config.SwarmInfo, err = api.GetDockerClient().InspectSwarm()
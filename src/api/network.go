@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"strconv"
 
 	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
 	"github.com/Dataman-Cloud/crane/src/utils/httpresponse"
@@ -18,6 +19,7 @@ const (
 	CodeCreateNetworkParamError   = "400-11203"
 	CodeInspectNetworkParamError  = "400-11204"
 	CodeListNetworkParamError     = "400-11205"
+	CodeRemoveNetworkParamError   = "400-11214"
 )
 
 type ConnectNetworkRequest struct {
@@ -82,6 +84,19 @@ func (api *Api) CreateNetwork(ctx *gin.Context) {
 	return
 }
 
+// the subnets allocated in the network pools with the free space left, and the subnets outside of the pools
+func (api *Api) NetworkPoolUsage(ctx *gin.Context) {
+	report, err := api.GetDockerClient().NetworkPoolUsage()
+	if err != nil {
+		log.Error("network pool usage got error: ", err)
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	httpresponse.Ok(ctx, report)
+	return
+}
+
 func (api *Api) InspectNetwork(ctx *gin.Context) {
 	network, err := api.GetDockerClient().InspectNetwork(ctx.Param("network_id"))
 	if err != nil {
@@ -117,8 +132,15 @@ func (api *Api) ListNetworks(ctx *gin.Context) {
 	return
 }
 
+// a network used by services or containers is not removed unless query force=true detaches them first
 func (api *Api) RemoveNetwork(ctx *gin.Context) {
-	if err := api.GetDockerClient().RemoveNetwork(ctx.Param("network_id")); err != nil {
+	force, err := strconv.ParseBool(ctx.DefaultQuery("force", "false"))
+	if err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRemoveNetworkParamError, "invalid force"))
+		return
+	}
+
+	if err := api.GetDockerClient().RemoveNetwork(ctx.Param("network_id"), force); err != nil {
 		httpresponse.Error(ctx, err)
 		return
 	}
@@ -127,6 +149,38 @@ func (api *Api) RemoveNetwork(ctx *gin.Context) {
 	return
 }
 
+// the services and the containers attached to the network
+func (api *Api) NetworkUsage(ctx *gin.Context) {
+	usage, err := api.GetDockerClient().NetworkUsage(ctx.Param("network_id"))
+	if err != nil {
+		log.Error("network usage got error: ", err)
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	httpresponse.Ok(ctx, usage)
+	return
+}
+
+// remove the networks of the stacks removed, query dry_run=true only reports them
+func (api *Api) CleanupNetworks(ctx *gin.Context) {
+	dryRun, err := strconv.ParseBool(ctx.DefaultQuery("dry_run", "false"))
+	if err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRemoveNetworkParamError, "invalid dry_run"))
+		return
+	}
+
+	report, err := api.GetDockerClient().CleanupNetworks(dryRun)
+	if err != nil {
+		log.Error("cleanup networks got error: ", err)
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	httpresponse.Ok(ctx, report)
+	return
+}
+
 func (api *Api) ConnectNodeNetwork(ctx *gin.Context) {
 	craneContext, _ := ctx.Get("craneContext")
 	var connectNetworkRequest ConnectNetworkRequest
@@ -153,3 +153,8 @@ func (api *Api) Info(ctx *gin.Context) {
 	httpresponse.Ok(ctx, info)
 	return
 }
+
+func (api *Api) NodeClientStats(ctx *gin.Context) {
+	httpresponse.Ok(ctx, api.GetDockerClient().NodeClientStats())
+	return
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"strconv"
+
+	"github.com/Dataman-Cloud/crane/src/dockerclient"
+	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
+	"github.com/Dataman-Cloud/crane/src/utils/httpresponse"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/context"
+)
+
+const (
+	//Prune error code
+	CodePruneParamError = "400-12103"
+)
+
+// report what is reclaimable on the ready nodes without removing anything,
+// query kind and node_id (comma separated) choose the kinds and the nodes, all of them if not set
+func (api *Api) PruneReport(ctx *gin.Context) {
+	craneContext, _ := ctx.Get("craneContext")
+	report, err := api.GetDockerClient().PruneNodes(craneContext.(context.Context), dockerclient.PruneOptions{
+		Kinds:   splitQuery(ctx.Query("kind")),
+		NodeIDs: splitQuery(ctx.Query("node_id")),
+	}, true)
+	if err != nil {
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	httpresponse.Ok(ctx, report)
+	return
+}
+
+// remove what is reclaimable on the nodes, the kinds and the nodes of the body are all of them if not set,
+// query dry_run=true only reports it
+func (api *Api) Prune(ctx *gin.Context) {
+	var opts dockerclient.PruneOptions
+	if ctx.Request.ContentLength != 0 {
+		if err := ctx.BindJSON(&opts); err != nil {
+			httpresponse.Error(ctx, cranerror.NewError(CodePruneParamError, err.Error()))
+			return
+		}
+	}
+
+	dryRun, err := strconv.ParseBool(ctx.DefaultQuery("dry_run", "false"))
+	if err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodePruneParamError, "invalid dry_run"))
+		return
+	}
+
+	craneContext, _ := ctx.Get("craneContext")
+	report, err := api.GetDockerClient().PruneNodes(craneContext.(context.Context), opts, dryRun)
+	if err != nil {
+		log.Error("Prune got error: ", err)
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	if !dryRun {
+		api.publishCraneEvent(ctx, dockerclient.EventTypePrune, "prune", "", "")
+	}
+	httpresponse.Ok(ctx, report)
+	return
+}
+
+// the interval and the kinds of the scheduled prunes with the report of the last one
+func (api *Api) PruneSchedule(ctx *gin.Context) {
+	httpresponse.Ok(ctx, api.GetDockerClient().PruneSchedule())
+	return
+}
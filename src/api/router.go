@@ -7,6 +7,7 @@ import (
 	"github.com/Dataman-Cloud/crane/src/plugins/apiplugin"
 	"github.com/Dataman-Cloud/crane/src/plugins/auth"
 	authApi "github.com/Dataman-Cloud/crane/src/plugins/auth/api"
+	"github.com/Dataman-Cloud/crane/src/plugins/registry"
 	"github.com/Dataman-Cloud/crane/src/plugins/search"
 	"github.com/Dataman-Cloud/crane/src/utils/log"
 
@@ -18,6 +19,7 @@ func (api *Api) ApiRouter() *gin.Engine {
 	router := gin.New()
 	Authorization := middlewares.Authorization
 	AuthorizeServiceAccess := middlewares.AuthorizeServiceAccess
+	AuthorizeAdmin := middlewares.AuthorizeAdmin
 
 	router.Use(log.Ginrus(logrus.StandardLogger(), time.RFC3339, true), gin.Recovery())
 	router.Use(middlewares.OptionHandler())
@@ -29,17 +31,19 @@ func (api *Api) ApiRouter() *gin.Engine {
 
 	v1 := router.Group("/api/v1", Authorization, middlewares.ListIntercept())
 	{
+		v1.GET("/swarm/cache", api.SwarmCacheStatus)
+
 		v1.GET("/nodes", api.ListNodes)
 		v1.POST("/nodes", api.CreateNode)
 		v1.GET("/nodes/:node_id", api.InspectNode)
 		v1.GET("/nodes/:node_id/info", api.Info)
 		v1.PATCH("/nodes/:node_id", api.UpdateNode)
 		v1.DELETE("/nodes/:node_id", api.RemoveNode)
+		v1.GET("/node_clients", api.NodeClientStats)
 		// Going to delegate to /nodes/:id
 		// v1.GET("/nodes/manager_info", api.ManagerInfo)
 
 		// Containers
-		v1.GET("/nodes/:node_id/containers/:container_id/terminal", api.ConnectContainer)
 		v1.GET("/nodes/:node_id/containers", api.ListContainers)
 		v1.GET("/nodes/:node_id/containers/:container_id", api.InspectContainer)
 		v1.GET("/nodes/:node_id/containers/:container_id/diff", api.DiffContainer)
@@ -67,9 +71,12 @@ func (api *Api) ApiRouter() *gin.Engine {
 
 		v1.POST("/networks", api.CreateNetwork)
 		v1.GET("/networks", api.ListNetworks)
-		v1.DELETE("/networks/:network_id", api.RemoveNetwork)
 		v1.GET("/networks/:network_id", api.InspectNetwork)
 		v1.PATCH("/networks/:network_id", api.ConnectNetwork)
+		v1.GET("/networks/:network_id/usage", api.NetworkUsage)
+		v1.GET("/ipam", api.NetworkPoolUsage)
+
+		v1.GET("/topology", api.Topology)
 
 		v1.POST("/stacks", api.CreateStack)
 		v1.GET("/stacks", api.ListStack)
@@ -91,6 +98,7 @@ func (api *Api) ApiRouter() *gin.Engine {
 		if ok {
 			accountApi.CraneDockerClient = api.Client
 			Authorization = accountApi.Authorization
+			AuthorizeAdmin = accountApi.AuthorizeAdmin
 			accountApi.ApiRegister(router, middlewares.ListIntercept())
 		}
 	}
@@ -105,12 +113,108 @@ func (api *Api) ApiRouter() *gin.Engine {
 					searchApi.ApiRegister(router, Authorization, middlewares.ListIntercept())
 				}
 			case apiplugin.Account:
+			case apiplugin.Registry:
+				registryApi, ok := plugin.Instance.(*registry.Registry)
+				if ok {
+					registryApi.CraneDockerClient = api.Client
+					registryApi.ApiRegister(router, Authorization, middlewares.ListIntercept())
+					registryApi.MaintenanceApiRegister(router, Authorization, AuthorizeAdmin)
+					// the namespace robots are created by the owners of the namespaces
+					if plugin, ok := apiplugin.ApiPlugins[apiplugin.Account]; ok {
+						if accountApi, ok := plugin.Instance.(*authApi.AccountApi); ok {
+							accountApi.RegistryNamespace = registryApi.RegistryNamespaceForAccount
+						}
+					}
+					if registryApi.RetentionInterval > 0 {
+						go registryApi.WatchRetention(registryApi.RetentionInterval, nil)
+					}
+				}
 			default:
 				plugin.Instance.ApiRegister(router, Authorization, middlewares.ListIntercept())
 			}
 		}
 	}
 
+	// the account of a terminal decides its permission and is recorded, only admins manage the sessions
+	terminals := router.Group("/api/v1", Authorization)
+	{
+		terminals.GET("/nodes/:node_id/containers/:container_id/terminal", api.ConnectContainer)
+		terminals.GET("/terminals", AuthorizeAdmin, api.ListLiveTerminals)
+		terminals.DELETE("/terminals/:session_id", AuthorizeAdmin, api.EndLiveTerminal)
+		terminals.GET("/terminal_sessions", AuthorizeAdmin, api.ListTerminalSessions)
+		terminals.GET("/terminal_sessions/:session_id", AuthorizeAdmin, api.InspectTerminalSession)
+		terminals.GET("/terminal_sessions/:session_id/cast", AuthorizeAdmin, api.DownloadTerminalSession)
+		terminals.GET("/terminal_sessions/:session_id/replay", AuthorizeAdmin, api.ReplayTerminalSession)
+		terminals.DELETE("/terminal_sessions/:session_id", AuthorizeAdmin, api.RemoveTerminalSession)
+	}
+
+	// running commands in the containers needs read write on their services, as the terminals do
+	exec := router.Group("/api/v1", Authorization)
+	{
+		exec.POST("/nodes/:node_id/containers/:container_id/exec", api.AuthorizeContainerAccess(auth.PermReadWrite), api.ExecContainer)
+		exec.POST("/stacks/:namespace/services/:service_id/exec", api.AuthorizeServicePermission(auth.PermReadWrite), api.ExecService)
+	}
+
+	// the files of the containers are read with read only and written with read write on their services
+	files := router.Group("/api/v1/nodes/:node_id/containers/:container_id", Authorization)
+	{
+		files.GET("/files", api.AuthorizeContainerAccess(auth.PermReadOnly), api.ListContainerFiles)
+		files.GET("/files/archive", api.AuthorizeContainerAccess(auth.PermReadOnly), api.DownloadContainerFiles)
+		files.GET("/diff/archive", api.AuthorizeContainerAccess(auth.PermReadOnly), api.DownloadContainerChanges)
+		files.PUT("/files", api.AuthorizeContainerAccess(auth.PermReadWrite), api.UploadContainerFile)
+	}
+
+	// the images committed or built are pushed to the registry namespace of the account,
+	// the containers committed need read only on their services as their files do,
+	// the images are pulled with the registry auths of the account
+	images := router.Group("/api/v1", Authorization)
+	{
+		images.POST("/nodes/:node_id/images/pull", api.PullImage)
+		images.POST("/images/pull", api.PrePullImage)
+		images.POST("/nodes/:node_id/containers/:container_id/commit", api.AuthorizeContainerAccess(auth.PermReadOnly), api.CommitContainer)
+		images.POST("/nodes/:node_id/images/build", api.BuildImage)
+	}
+
+	// the accounts other than admins only get the events of the services they have a permission on
+	events := router.Group("/api/v1/events", Authorization)
+	{
+		events.GET("", api.Events)
+		events.GET("/ws", api.EventsWebSocket)
+	}
+
+	// only admins detach the services from a network they remove or remove the unused networks
+	networks := router.Group("/api/v1/networks", Authorization)
+	{
+		networks.DELETE("/:network_id", authorizeAdminOnQuery("force", AuthorizeAdmin), api.RemoveNetwork)
+		networks.POST("/cleanup", AuthorizeAdmin, api.CleanupNetworks)
+	}
+
+	// the volumes are mounted by the services of any namespace, only admins list them on every node,
+	// read them and overwrite them
+	volumes := router.Group("/api/v1", Authorization, AuthorizeAdmin)
+	{
+		volumes.GET("/nodes/:node_id/volumes/:volume_id/backup", api.BackupVolume)
+		volumes.POST("/nodes/:node_id/volumes/:volume_id/restore", api.RestoreVolume)
+		volumes.GET("/volumes", api.ListClusterVolumes)
+	}
+
+	// only admins manage the swarm and read its join tokens
+	swarm := router.Group("/api/v1/swarm", Authorization, AuthorizeAdmin)
+	{
+		swarm.GET("", api.InspectSwarm)
+		swarm.POST("", api.InitSwarm)
+		swarm.PATCH("", api.UpdateSwarm)
+		swarm.PUT("/join_tokens", api.RotateJoinToken)
+	}
+
+	// only admins prune the nodes
+	prune := router.Group("/api/v1/prune", Authorization, AuthorizeAdmin)
+	{
+		prune.GET("", api.PruneReport)
+		prune.POST("", api.Prune)
+		prune.GET("/schedule", api.PruneSchedule)
+	}
+
 	router.PUT("/api/v1/stacks/:namespace/services/:service_id/rolling_update", api.UpdateServiceImage) // skip authorization, public access
 
 	misc := router.Group("/misc/v1")
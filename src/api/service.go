@@ -25,8 +25,17 @@ const (
 	CodeScaleServiceParamError  = "400-11403"
 
 	CodeListTaskParamError = "400-11404"
+
+	CodeExecServiceParamError = "400-11405"
 )
 
+// the command is run on the tasks of TaskIDs, on every running task if All is set, or else on one of them
+type ServiceExecRequest struct {
+	dockerclient.ExecOptions
+	TaskIDs []string `json:"TaskIDs"`
+	All     bool     `json:"All"`
+}
+
 func reverseString(s string) string {
 	runes := []rune(s)
 	for from, to := 0, len(runes)-1; from < to; from, to = from+1, to-1 {
@@ -60,7 +69,7 @@ func (api *Api) UpdateServiceImage(ctx *gin.Context) {
 		return
 	}
 
-	service, err := api.GetDockerClient().InspectServiceWithRaw(serviceId)
+	service, err := api.GetDockerClient().InspectServiceUncached(serviceId)
 	if err != nil {
 		httpresponse.Error(ctx, err)
 		return
@@ -100,7 +109,7 @@ func (api *Api) UpdateService(ctx *gin.Context) {
 		return
 	}
 
-	service, err := api.GetDockerClient().InspectServiceWithRaw(serviceId)
+	service, err := api.GetDockerClient().InspectServiceUncached(serviceId)
 	if err != nil {
 		httpresponse.Error(ctx, err)
 		return
@@ -237,6 +246,28 @@ func (api *Api) ScaleService(ctx *gin.Context) {
 	return
 }
 
+// run a command on running tasks of the service and return the result of each task
+func (api *Api) ExecService(ctx *gin.Context) {
+	serviceId := ctx.Param("service_id")
+	var request ServiceExecRequest
+	if err := ctx.BindJSON(&request); err != nil {
+		log.Errorf("Parse param of exec service %s got error: %s", serviceId, err.Error())
+		httpresponse.Error(ctx, cranerror.NewError(CodeExecServiceParamError, err.Error()))
+		return
+	}
+
+	craneContext, _ := ctx.Get("craneContext")
+	results, err := api.GetDockerClient().ExecService(craneContext.(context.Context), serviceId, request.ExecOptions, request.TaskIDs, request.All)
+	if err != nil {
+		log.Errorf("Exec in service %s got error: %s", serviceId, err.Error())
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	httpresponse.Ok(ctx, results)
+	return
+}
+
 func (api *Api) LogsService(ctx *gin.Context) {
 	serviceId := ctx.Param("service_id")
 	taskFilter := filters.NewArgs()
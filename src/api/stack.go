@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"strconv"
 
+	"github.com/Dataman-Cloud/crane/src/dockerclient"
 	"github.com/Dataman-Cloud/crane/src/dockerclient/model"
 	"github.com/Dataman-Cloud/crane/src/plugins/auth"
 	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
@@ -20,6 +21,7 @@ const (
 	CodeCreateStackParamError = "400-11501"
 	CodeInvalidStackName      = "503-11502"
 	CodeStackNotFound         = "404-11503"
+	CodeRemoveStackParamError = "400-11504"
 
 	CodeInvalidGroupId = "400-12001"
 )
@@ -73,6 +75,8 @@ func (api *Api) CreateStack(ctx *gin.Context) {
 		return
 	}
 
+	api.publishCraneEvent(ctx, dockerclient.EventTypeStack, "deploy", stackBundle.Namespace, stackBundle.Namespace)
+
 	httpresponse.Ok(ctx, "success")
 	return
 }
@@ -127,14 +131,23 @@ func (api *Api) ListStackService(ctx *gin.Context) {
 	return
 }
 
+// query force=true detaches the services and containers outside of the stack from its networks
 func (api *Api) RemoveStack(ctx *gin.Context) {
+	force, err := strconv.ParseBool(ctx.DefaultQuery("force", "false"))
+	if err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRemoveStackParamError, "invalid force"))
+		return
+	}
+
 	namespace := ctx.Param("namespace")
-	if err := api.GetDockerClient().RemoveStack(namespace); err != nil {
+	if err := api.GetDockerClient().RemoveStack(namespace, force); err != nil {
 		log.Error("Remove stack got error: ", err)
 		httpresponse.Error(ctx, err)
 		return
 	}
 
+	api.publishCraneEvent(ctx, dockerclient.EventTypeStack, "remove", namespace, namespace)
+
 	httpresponse.Ok(ctx, "success")
 	return
 }
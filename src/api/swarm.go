@@ -0,0 +1,105 @@
+package api
+
+import (
+	"encoding/json"
+
+	"github.com/Dataman-Cloud/crane/src/model"
+	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
+	"github.com/Dataman-Cloud/crane/src/utils/httpresponse"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/engine-api/types/swarm"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	//Swarm error code
+	CodeInitSwarmParamError   = "400-11804"
+	CodeUpdateSwarmParamError = "400-11805"
+	CodeRotateTokenParamError = "400-11806"
+)
+
+func (api *Api) InspectSwarm(ctx *gin.Context) {
+	swarmInfo, err := api.GetDockerClient().InspectSwarm()
+	if err != nil {
+		log.Error("InspectSwarm got error: ", err)
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	// the join tokens are only returned by the rotation
+	swarmInfo.JoinTokens = swarm.JoinTokens{}
+	httpresponse.Ok(ctx, swarmInfo)
+	return
+}
+
+func (api *Api) InitSwarm(ctx *gin.Context) {
+	var initOptions model.SwarmInitOptions
+
+	if err := ctx.BindJSON(&initOptions); err != nil {
+		switch jsonErr := err.(type) {
+		case *json.SyntaxError:
+			log.Errorf("Swarm init JSON syntax error at byte %v: %s", jsonErr.Offset, jsonErr.Error())
+		case *json.UnmarshalTypeError:
+			log.Errorf("Unexpected type at by type %v. Expected %s but received %s.",
+				jsonErr.Offset, jsonErr.Type, jsonErr.Value)
+		}
+		craneError := cranerror.NewError(CodeInitSwarmParamError, err.Error())
+		httpresponse.Error(ctx, craneError)
+		return
+	}
+
+	nodeId, err := api.GetDockerClient().InitSwarm(initOptions)
+	if err != nil {
+		log.Error("InitSwarm got error: ", err)
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	httpresponse.Ok(ctx, nodeId)
+	return
+}
+
+func (api *Api) UpdateSwarm(ctx *gin.Context) {
+	var settings model.SwarmSettings
+
+	if err := ctx.BindJSON(&settings); err != nil {
+		craneError := cranerror.NewError(CodeUpdateSwarmParamError, err.Error())
+		httpresponse.Error(ctx, craneError)
+		return
+	}
+
+	if err := api.GetDockerClient().UpdateSwarm(settings); err != nil {
+		log.Error("UpdateSwarm got error: ", err)
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	httpresponse.Ok(ctx, "success")
+	return
+}
+
+func (api *Api) RotateJoinToken(ctx *gin.Context) {
+	var rotateOptions model.SwarmRotateOptions
+
+	if err := ctx.BindJSON(&rotateOptions); err != nil {
+		craneError := cranerror.NewError(CodeRotateTokenParamError, err.Error())
+		httpresponse.Error(ctx, craneError)
+		return
+	}
+
+	tokens, err := api.GetDockerClient().RotateJoinToken(rotateOptions)
+	if err != nil {
+		log.Error("RotateJoinToken got error: ", err)
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	httpresponse.Ok(ctx, tokens)
+	return
+}
+
+func (api *Api) SwarmCacheStatus(ctx *gin.Context) {
+	httpresponse.Ok(ctx, api.GetDockerClient().SwarmCacheStatus())
+	return
+}
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Dataman-Cloud/crane/src/dockerclient"
+	containertty "github.com/Dataman-Cloud/crane/src/plugins/tty"
+	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
+	"github.com/Dataman-Cloud/crane/src/utils/httpresponse"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	//Terminal session error code
+	CodeTerminalRecordingDisabled   = "503-18001"
+	CodeTerminalSessionNotFound     = "404-18002"
+	CodeTerminalSessionParamError   = "400-18003"
+	CodeTerminalSessionReadError    = "503-18004"
+	CodeTerminalSessionRemoveFailed = "503-18005"
+	CodeTerminalNoLiveSession       = "404-18007"
+
+	SSETypeTerminalCastHeader = "terminal-header"
+	SSETypeTerminalCastEvent  = "terminal-event"
+	SSETypeTerminalCastEnd    = "terminal-end"
+
+	// a replay waits this long at most between two events
+	terminalReplayMaxIdle = 2 * time.Second
+)
+
+// list the live terminal sessions, query account, node_id and container_id filter them
+func (api *Api) ListLiveTerminals(ctx *gin.Context) {
+	httpresponse.Ok(ctx, api.liveTerminals().List(containertty.Session{
+		Account:     ctx.Query("account"),
+		NodeID:      ctx.Query("node_id"),
+		ContainerID: ctx.Query("container_id"),
+	}))
+	return
+}
+
+// end a live terminal session, the exec and the viewers following it are closed
+func (api *Api) EndLiveTerminal(ctx *gin.Context) {
+	session, err := api.liveTerminals().End(ctx.Param("session_id"))
+	if err == containertty.ErrSessionNotFound {
+		httpresponse.Error(ctx, cranerror.NewError(CodeTerminalSessionNotFound, err.Error()))
+		return
+	} else if err != nil {
+		log.Errorf("end terminal session %s got error: %s", session.ID, err.Error())
+	}
+
+	api.publishCraneEvent(ctx, dockerclient.EventTypeTerminal, "end", session.ID, "")
+	httpresponse.Ok(ctx, session)
+	return
+}
+
+// list the recorded sessions, query account, node_id and container_id filter them
+func (api *Api) ListTerminalSessions(ctx *gin.Context) {
+	if api.TerminalRecordings == nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeTerminalRecordingDisabled, "terminal recording disabled"))
+		return
+	}
+
+	sessions, err := api.TerminalRecordings.List(containertty.Session{
+		Account:     ctx.Query("account"),
+		NodeID:      ctx.Query("node_id"),
+		ContainerID: ctx.Query("container_id"),
+	})
+	if err != nil {
+		log.Error("ListTerminalSessions got error: ", err)
+		httpresponse.Error(ctx, cranerror.NewError(CodeTerminalSessionReadError, err.Error()))
+		return
+	}
+
+	httpresponse.Ok(ctx, sessions)
+	return
+}
+
+func (api *Api) InspectTerminalSession(ctx *gin.Context) {
+	session, err := api.terminalSession(ctx.Param("session_id"))
+	if err != nil {
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	httpresponse.Ok(ctx, session)
+	return
+}
+
+// download the asciicast of the session, playable with asciinema
+func (api *Api) DownloadTerminalSession(ctx *gin.Context) {
+	session, err := api.terminalSession(ctx.Param("session_id"))
+	if err != nil {
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	cast, err := api.TerminalRecordings.Open(session.ID)
+	if err != nil {
+		log.Errorf("open terminal session %s got error: %s", session.ID, err.Error())
+		httpresponse.Error(ctx, cranerror.NewError(CodeTerminalSessionReadError, err.Error()))
+		return
+	}
+	defer cast.Close()
+
+	ctx.Header("Content-Type", "application/x-asciicast")
+	ctx.Header("Content-Disposition", "attachment; filename="+session.ID+".cast")
+	http.ServeContent(ctx.Writer, ctx.Request, session.ID+".cast", session.StartedAt, cast)
+}
+
+// replay the session as server sent events at the recorded pace,
+// query speed multiplies the pace and idle time is cut to a few seconds
+func (api *Api) ReplayTerminalSession(ctx *gin.Context) {
+	speed := 1.0
+	if ctx.Query("speed") != "" {
+		var err error
+		if speed, err = strconv.ParseFloat(ctx.Query("speed"), 64); err != nil || speed <= 0 {
+			httpresponse.Error(ctx, cranerror.NewError(CodeTerminalSessionParamError, "invalid replay speed"))
+			return
+		}
+	}
+
+	session, err := api.terminalSession(ctx.Param("session_id"))
+	if err != nil {
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	cast, err := api.TerminalRecordings.Open(session.ID)
+	if err != nil {
+		log.Errorf("open terminal session %s got error: %s", session.ID, err.Error())
+		httpresponse.Error(ctx, cranerror.NewError(CodeTerminalSessionReadError, err.Error()))
+		return
+	}
+	defer cast.Close()
+
+	reader, err := containertty.NewCastReader(cast)
+	if err != nil {
+		log.Errorf("read terminal session %s got error: %s", session.ID, err.Error())
+		httpresponse.Error(ctx, cranerror.NewError(CodeTerminalSessionReadError, err.Error()))
+		return
+	}
+
+	w := ctx.Writer
+	w.Header().Set("Cache-Control", "no-cache")
+	httpresponse.SSEventOk(ctx, SSETypeTerminalCastHeader, reader.Header)
+
+	clientGone := w.CloseNotify()
+	var elapsed float64
+	for {
+		event, err := reader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Errorf("replay terminal session %s got error: %s", session.ID, err.Error())
+			return
+		}
+
+		wait := time.Duration((event.Time - elapsed) / speed * float64(time.Second))
+		if wait > terminalReplayMaxIdle {
+			wait = terminalReplayMaxIdle
+		}
+		elapsed = event.Time
+
+		select {
+		case <-clientGone:
+			return
+		case <-time.After(wait):
+		}
+
+		httpresponse.SSEventOk(ctx, SSETypeTerminalCastEvent, event)
+	}
+
+	httpresponse.SSEventOk(ctx, SSETypeTerminalCastEnd, session)
+}
+
+func (api *Api) RemoveTerminalSession(ctx *gin.Context) {
+	session, err := api.terminalSession(ctx.Param("session_id"))
+	if err != nil {
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	if err := api.TerminalRecordings.Remove(session.ID); err != nil {
+		log.Errorf("remove terminal session %s got error: %s", session.ID, err.Error())
+		httpresponse.Error(ctx, cranerror.NewError(CodeTerminalSessionRemoveFailed, err.Error()))
+		return
+	}
+
+	httpresponse.Delete(ctx, session)
+	return
+}
+
+func (api *Api) terminalSession(id string) (containertty.Session, error) {
+	if api.TerminalRecordings == nil {
+		return containertty.Session{}, cranerror.NewError(CodeTerminalRecordingDisabled, "terminal recording disabled")
+	}
+
+	session, err := api.TerminalRecordings.Get(id)
+	if err == containertty.ErrSessionNotFound {
+		return session, cranerror.NewError(CodeTerminalSessionNotFound, err.Error())
+	} else if err != nil {
+		log.Errorf("read terminal session %s got error: %s", id, err.Error())
+		return session, cranerror.NewError(CodeTerminalSessionReadError, err.Error())
+	}
+
+	return session, nil
+}
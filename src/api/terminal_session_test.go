@@ -0,0 +1,169 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dataman-Cloud/crane/src/dockerclient"
+	"github.com/Dataman-Cloud/crane/src/plugins/auth"
+	containertty "github.com/Dataman-Cloud/crane/src/plugins/tty"
+	"github.com/Dataman-Cloud/crane/src/utils/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+type nopTerminal struct{}
+
+func (nopTerminal) Read(p []byte) (int, error)        { return 0, nil }
+func (nopTerminal) Write(p []byte) (int, error)       { return len(p), nil }
+func (nopTerminal) Resize(rows, columns uint16) error { return nil }
+func (nopTerminal) Command() string                   { return "sh" }
+func (nopTerminal) Close() error                      { return nil }
+
+func TestTerminalSessions(t *testing.T) {
+	api := &Api{
+		Client: &dockerclient.CraneDockerClient{},
+	}
+	server := httptest.NewServer(api.ApiRouter())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/v1/terminal_sessions")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	dir, err := ioutil.TempDir("", "recordings")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	api.TerminalRecordings, err = containertty.NewRecordingStore(dir, 0)
+	assert.Nil(t, err)
+
+	recorded, err := api.TerminalRecordings.Record(nopTerminal{}, containertty.Session{NodeID: "node1"})
+	assert.Nil(t, err)
+	recorded.Write([]byte("exit\r"))
+	recorded.Close()
+	id := recorded.Session().ID
+
+	resp, err = http.Get(server.URL + "/api/v1/terminal_sessions?node_id=node1")
+	assert.Nil(t, err)
+	var list struct {
+		Data []containertty.Session
+	}
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&list))
+	resp.Body.Close()
+	assert.Len(t, list.Data, 1)
+	assert.Equal(t, id, list.Data[0].ID)
+
+	resp, err = http.Get(server.URL + "/api/v1/terminal_sessions/" + id + "/cast")
+	assert.Nil(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "application/x-asciicast", resp.Header.Get("Content-Type"))
+	assert.Contains(t, string(body), `"i","exit\r"`)
+
+	start := time.Now()
+	resp, err = http.Get(server.URL + "/api/v1/terminal_sessions/" + id + "/replay?speed=10")
+	assert.Nil(t, err)
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.True(t, time.Since(start) < terminalReplayMaxIdle)
+	replay := string(body)
+	assert.True(t, strings.Index(replay, SSETypeTerminalCastHeader) < strings.Index(replay, SSETypeTerminalCastEvent))
+	assert.Contains(t, replay, SSETypeTerminalCastEnd)
+
+	resp, err = http.Get(server.URL + "/api/v1/terminal_sessions/" + id + "/replay?speed=0")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	req, _ := http.NewRequest("DELETE", server.URL+"/api/v1/terminal_sessions/"+id, nil)
+	resp, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, err = http.Get(server.URL + "/api/v1/terminal_sessions/" + id)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestLiveTerminals(t *testing.T) {
+	api := &Api{
+		Client: &dockerclient.CraneDockerClient{},
+	}
+	server := httptest.NewServer(api.ApiRouter())
+	defer server.Close()
+
+	live, err := api.liveTerminals().Start(nopTerminal{}, containertty.Session{ContainerID: "container1"})
+	assert.Nil(t, err)
+
+	resp, err := http.Get(server.URL + "/api/v1/terminals?container_id=container1")
+	assert.Nil(t, err)
+	var list struct {
+		Data []containertty.Session
+	}
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&list))
+	resp.Body.Close()
+	assert.Len(t, list.Data, 1)
+
+	req, _ := http.NewRequest("DELETE", server.URL+"/api/v1/terminals/"+live.Session().ID, nil)
+	resp, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, api.liveTerminals().List(containertty.Session{}), 0)
+
+	resp, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// a read only account finds no live session to follow
+	resp, err = http.Get(server.URL + "/api/v1/nodes/node1/containers/container1/terminal?mode=view")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestContainerPermission(t *testing.T) {
+	api := &Api{
+		Client: &dockerclient.CraneDockerClient{},
+		Config: &config.Config{AccountEmailDefault: "admin@admin.com"},
+	}
+	ctx := &gin.Context{}
+
+	permission, err := api.containerPermission(ctx, context.Background(), "container1")
+	assert.Nil(t, err)
+	assert.Equal(t, auth.PermReadWrite, permission)
+
+	ctx.Set("account", auth.Account{Email: "admin@admin.com"})
+	permission, err = api.containerPermission(ctx, context.Background(), "container1")
+	assert.Nil(t, err)
+	assert.Equal(t, auth.PermAdmin, permission)
+}
+
+func TestPermissionAtLeast(t *testing.T) {
+	assert.True(t, permissionAtLeast(auth.PermAdmin, auth.PermReadWrite))
+	assert.True(t, permissionAtLeast(auth.PermReadWrite, auth.PermReadWrite))
+	assert.False(t, permissionAtLeast(auth.PermReadOnly, auth.PermReadWrite))
+	assert.True(t, permissionAtLeast(auth.Permission{Display: "r"}, auth.PermReadOnly))
+}
+
+func TestAuthorizeAdminOnQuery(t *testing.T) {
+	denyAdmin := func(ctx *gin.Context) {
+		ctx.AbortWithStatus(http.StatusForbidden)
+	}
+	router := gin.New()
+	router.DELETE("/networks/:network_id", authorizeAdminOnQuery("force", denyAdmin), func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+
+	for query, code := range map[string]int{"": http.StatusOK, "?force=false": http.StatusOK, "?force=true": http.StatusForbidden, "?force=1": http.StatusForbidden} {
+		req, _ := http.NewRequest("DELETE", "/networks/network1"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, code, w.Code, query)
+	}
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
+	"github.com/Dataman-Cloud/crane/src/utils/httpresponse"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	//Topology error code
+	CodeTopologyParamError = "400-11902"
+
+	TopologyFormatJSON = "json"
+	TopologyFormatDOT  = "dot"
+)
+
+// the graph of the networks, services, tasks, containers and nodes, query namespace keeps the services of a stack,
+// query format=dot renders it for graphviz
+func (api *Api) Topology(ctx *gin.Context) {
+	format := ctx.DefaultQuery("format", TopologyFormatJSON)
+	if format != TopologyFormatJSON && format != TopologyFormatDOT {
+		httpresponse.Error(ctx, cranerror.NewError(CodeTopologyParamError, "format must be json or dot"))
+		return
+	}
+
+	topology, err := api.GetDockerClient().Topology(ctx.Query("namespace"))
+	if err != nil {
+		log.Error("Topology got error: ", err)
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	if format == TopologyFormatDOT {
+		ctx.Data(http.StatusOK, "text/vnd.graphviz; charset=utf-8", []byte(topology.DOT()))
+		return
+	}
+
+	httpresponse.Ok(ctx, topology)
+	return
+}
@@ -1,44 +1,130 @@
 package api
 
 import (
-	"os/exec"
+	"net/http"
+	"strings"
 
+	"github.com/Dataman-Cloud/crane/src/plugins/auth"
 	containertty "github.com/Dataman-Cloud/crane/src/plugins/tty"
+	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
+	"github.com/Dataman-Cloud/crane/src/utils/httpresponse"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"golang.org/x/net/context"
 )
 
+const (
+	TerminalModeView = "view"
+
+	labelServiceId = "com.docker.swarm.service.id"
+)
+
+// attach a websocket terminal to the container, the permission of the account decides the session:
+// read write opens a new exec, the query command (space separated) and user choose what is run and as whom,
+// read only follows a live session of the container, query session_id chooses which one,
+// query mode=view lets a read write account follow too,
+// the new sessions are recorded if the terminal recording is enabled
 func (api *Api) ConnectContainer(ctx *gin.Context) {
+	craneContext, _ := ctx.Get("craneContext")
+	containerId := ctx.Param("container_id")
+
+	permission, err := api.containerPermission(ctx, craneContext.(context.Context), containerId)
+	if err != nil {
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	var viewer *containertty.TerminalViewer
+	if permission == auth.PermReadOnly || ctx.Query("mode") == TerminalModeView {
+		viewer, err = api.liveTerminals().Follow(ctx.Query("session_id"), containerId)
+		if err != nil {
+			httpresponse.Error(ctx, cranerror.NewError(CodeTerminalNoLiveSession, err.Error()))
+			return
+		}
+	}
+
 	req := ctx.Request
 	conn, err := containertty.Upgrader.Upgrade(ctx.Writer, req, nil)
 	if err != nil {
 		log.Error("Upgrade websocket connect got error: ", err)
+		if viewer != nil {
+			viewer.Close()
+		}
 		return
 	}
 
 	_, stream, err := conn.ReadMessage()
 	if err != nil {
 		log.Error("Get websocket init message got error: ", err)
+		if viewer != nil {
+			viewer.Close()
+		}
+		conn.Close()
 		return
 	}
 	log.Info("Init message: ", string(stream))
 
-	// Add TLS config and file path
-	nodeUrl, err := api.GetDockerClient().GetDaemonUrlById(ctx.Param("node_id"))
+	if viewer != nil {
+		opts := *containertty.DefaultOptions
+		opts.PermitWrite = false
+		handleTerminal(viewer, conn, req, &opts)
+		return
+	}
+
+	command := strings.Fields(ctx.Query("command"))
+	terminal, err := api.GetDockerClient().CreateExecTerminal(craneContext.(context.Context), containerId, command, ctx.Query("user"))
+	if err != nil {
+		log.Error("Create container exec got error: ", err)
+		conn.Close()
+		return
+	}
+
+	session := containertty.Session{
+		ID:          containertty.NewSessionID(),
+		NodeID:      ctx.Param("node_id"),
+		ContainerID: containerId,
+		RemoteAddr:  req.RemoteAddr,
+	}
+	if account, ok := ctx.Get("account"); ok {
+		if acc, ok := account.(auth.Account); ok {
+			session.Account = acc.Email
+		}
+	}
+
+	var tty containertty.Terminal = terminal
+	if api.TerminalRecordings != nil {
+		recorded, err := api.TerminalRecordings.Record(terminal, session)
+		if err != nil {
+			// a terminal is not opened without its recording
+			log.Error("Record terminal session got error: ", err)
+			terminal.Close()
+			conn.Close()
+			return
+		}
+		tty = recorded
+	}
+
+	live, err := api.liveTerminals().Start(tty, session)
 	if err != nil {
-		log.Error("Get container endpoint got error: ", err)
+		log.Error("Start live terminal session got error: ", err)
+		tty.Close()
+		conn.Close()
 		return
 	}
 
-	nodeUrl.Scheme = "tcp"
-	cmd := exec.Command("docker", "-H", nodeUrl.String(), "exec", "-it", ctx.Param("container_id"), "sh")
-	client, err := containertty.New(cmd, conn, req, containertty.DefaultOptions)
+	handleTerminal(live, conn, req, containertty.DefaultOptions)
+}
+
+func handleTerminal(terminal containertty.Terminal, conn *websocket.Conn, req *http.Request, opts *containertty.Options) {
+	client, err := containertty.New(terminal, conn, req, opts)
 	if err != nil {
 		log.Error("Create tty client got error: ", err)
+		terminal.Close()
+		conn.Close()
 		return
 	}
 
 	client.HandleClient()
-	return
 }
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"io"
+
 	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
 	"github.com/Dataman-Cloud/crane/src/utils/httpresponse"
 
@@ -75,3 +77,56 @@ func (api *Api) RemoveVolume(ctx *gin.Context) {
 	httpresponse.Ok(ctx, "success")
 	return
 }
+
+// list the volumes of every ready node with the containers and the services mounting them,
+// query node_id and name filter them
+func (api *Api) ListClusterVolumes(ctx *gin.Context) {
+	craneContext, _ := ctx.Get("craneContext")
+	var opts docker.ListVolumesOptions
+	if name := ctx.Query("name"); name != "" {
+		opts.Filters = map[string][]string{"name": {name}}
+	}
+
+	inventory, err := api.GetDockerClient().ListClusterVolumes(craneContext.(context.Context), ctx.Query("node_id"), opts)
+	if err != nil {
+		log.Errorf("list cluster volumes error: %v", err)
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	httpresponse.Ok(ctx, inventory)
+	return
+}
+
+// download the contents of the volume as a tar archive
+func (api *Api) BackupVolume(ctx *gin.Context) {
+	craneContext, _ := ctx.Get("craneContext")
+	name := ctx.Param("volume_id")
+	backup, err := api.GetDockerClient().OpenVolumeBackup(craneContext.(context.Context), name)
+	if err != nil {
+		log.Errorf("back up volume %s error: %v", name, err)
+		httpresponse.Error(ctx, err)
+		return
+	}
+	defer backup.Close()
+
+	ctx.Header("Content-Type", "application/x-tar")
+	ctx.Header("Content-Disposition", "attachment; filename="+name+".tar")
+	if _, err := io.Copy(ctx.Writer, backup); err != nil {
+		log.Errorf("send backup of volume %s error: %v", name, err)
+	}
+}
+
+// load the tar archive of the request body into the volume, the files of the volume out of the archive are kept
+func (api *Api) RestoreVolume(ctx *gin.Context) {
+	craneContext, _ := ctx.Get("craneContext")
+	name := ctx.Param("volume_id")
+	if err := api.GetDockerClient().RestoreVolume(craneContext.(context.Context), name, ctx.Request.Body); err != nil {
+		log.Errorf("restore volume %s error: %v", name, err)
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	httpresponse.Ok(ctx, "success")
+	return
+}
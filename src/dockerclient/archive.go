@@ -0,0 +1,408 @@
+package dockerclient
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
+
+	docker "github.com/Dataman-Cloud/go-dockerclient"
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+const (
+	ArchiveFormatTar = "tar"
+	ArchiveFormatZip = "zip"
+
+	// MB of an archive downloaded from or uploaded to a container if not configured
+	DefaultContainerFileMaxSize = 100
+	// entries of the archive of a directory read to list it
+	maxListScanEntries = 100000
+)
+
+var errArchiveTooLarge = errors.New("archive too large")
+
+// ContainerFile is an entry of a directory in a container
+type ContainerFile struct {
+	Name       string    `json:"Name"`
+	Path       string    `json:"Path"`
+	Size       int64     `json:"Size"`
+	Mode       string    `json:"Mode"`
+	IsDir      bool      `json:"IsDir"`
+	LinkTarget string    `json:"LinkTarget,omitempty"`
+	ModTime    time.Time `json:"ModTime"`
+}
+
+// the bytes an archive of a container can not exceed
+func (client *CraneDockerClient) ContainerFileMaxSize() int64 {
+	maxSize := int64(DefaultContainerFileMaxSize)
+	if client.config != nil && client.config.ContainerFileMaxSize > 0 {
+		maxSize = int64(client.config.ContainerFileMaxSize)
+	}
+
+	return maxSize << 20
+}
+
+func archiveError(err error, containerPath string) error {
+	if e, ok := err.(*docker.Error); ok && e.Status == http.StatusNotFound {
+		return cranerror.NewError(CodeContainerPathNotFound, "no such file or directory: "+containerPath)
+	}
+
+	return ToCraneError(err)
+}
+
+func cleanContainerPath(containerPath string) (string, error) {
+	if !path.IsAbs(containerPath) {
+		return "", cranerror.NewError(CodeInvalidContainerPath, "absolute path required")
+	}
+
+	return path.Clean(containerPath), nil
+}
+
+// list the entries of a directory of the container of the node stored in ctx,
+// the entry of the path itself is returned if it is not a directory
+func (client *CraneDockerClient) ListContainerDir(ctx context.Context, containerId, containerPath string) ([]ContainerFile, error) {
+	containerPath, err := cleanContainerPath(containerPath)
+	if err != nil {
+		return nil, err
+	}
+
+	swarmNode, err := client.SwarmNode(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	downloadContext, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reader, writer := io.Pipe()
+	downloaded := make(chan error, 1)
+	go func() {
+		err := swarmNode.DownloadFromContainer(containerId, docker.DownloadFromContainerOptions{
+			Path:         containerPath,
+			OutputStream: writer,
+			Context:      downloadContext,
+		})
+		writer.CloseWithError(err)
+		downloaded <- err
+	}()
+
+	// the archive of /etc has the entries etc, etc/hosts, etc/nginx/nginx.conf...
+	files := make([]ContainerFile, 0)
+	tarReader := tar.NewReader(reader)
+	var root string
+	for scanned := 0; ; scanned++ {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			reader.Close()
+			if downloadErr := <-downloaded; downloadErr != nil {
+				return nil, archiveError(downloadErr, containerPath)
+			}
+			return nil, err
+		}
+
+		if scanned >= maxListScanEntries {
+			reader.Close()
+			return nil, cranerror.NewError(CodeContainerArchiveTooLarge, fmt.Sprintf("more than %d files under %s", maxListScanEntries, containerPath))
+		}
+
+		name := strings.TrimSuffix(header.Name, "/")
+		if root == "" {
+			root = name
+			if header.Typeflag != tar.TypeDir {
+				files = append(files, newContainerFile(path.Dir(containerPath), name, header))
+				break
+			}
+			continue
+		}
+
+		relative := strings.TrimPrefix(name, root+"/")
+		if strings.Contains(relative, "/") {
+			continue
+		}
+		files = append(files, newContainerFile(containerPath, relative, header))
+	}
+	reader.Close()
+
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].IsDir != files[j].IsDir {
+			return files[i].IsDir
+		}
+		return files[i].Name < files[j].Name
+	})
+
+	return files, nil
+}
+
+func newContainerFile(dir, name string, header *tar.Header) ContainerFile {
+	return ContainerFile{
+		Name:       name,
+		Path:       path.Join(dir, name),
+		Size:       header.Size,
+		Mode:       header.FileInfo().Mode().String(),
+		IsDir:      header.Typeflag == tar.TypeDir,
+		LinkTarget: header.Linkname,
+		ModTime:    header.ModTime,
+	}
+}
+
+// stops writing once more than limit bytes were written
+type limitedWriter struct {
+	w       io.Writer
+	limit   int64
+	written int64
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	l.written += int64(len(p))
+	if l.written > l.limit {
+		return 0, errArchiveTooLarge
+	}
+
+	return l.w.Write(p)
+}
+
+// ArchiveContainerPaths downloads the paths of the container of the node stored in ctx into a tar temp file,
+// with fromRoot the entries keep their path from the root: etc/nginx/nginx.conf, var/log/app.log...,
+// or else the archive of a single path is the one of docker: /etc/nginx gives nginx/nginx.conf...
+// The caller removes the file, an archive can not exceed ContainerFileMaxSize
+func (client *CraneDockerClient) ArchiveContainerPaths(ctx context.Context, containerId string, containerPaths []string, fromRoot bool) (*os.File, error) {
+	if len(containerPaths) == 0 {
+		return nil, cranerror.NewError(CodeInvalidContainerPath, "path required")
+	}
+
+	for i := range containerPaths {
+		cleaned, err := cleanContainerPath(containerPaths[i])
+		if err != nil {
+			return nil, err
+		}
+		containerPaths[i] = cleaned
+	}
+
+	swarmNode, err := client.SwarmNode(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	archive, err := ioutil.TempFile("", "crane-archive-")
+	if err != nil {
+		return nil, err
+	}
+	fail := func(err error) (*os.File, error) {
+		archive.Close()
+		os.Remove(archive.Name())
+		return nil, err
+	}
+
+	limited := &limitedWriter{w: archive, limit: client.ContainerFileMaxSize()}
+	if len(containerPaths) == 1 && !fromRoot {
+		err = swarmNode.DownloadFromContainer(containerId, docker.DownloadFromContainerOptions{
+			Path:         containerPaths[0],
+			OutputStream: limited,
+		})
+	} else {
+		tarWriter := tar.NewWriter(limited)
+		for _, containerPath := range containerPaths {
+			if err = appendContainerPath(swarmNode, containerId, containerPath, tarWriter); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = tarWriter.Close()
+		}
+	}
+
+	if limited.written > limited.limit {
+		return fail(cranerror.NewError(CodeContainerArchiveTooLarge, fmt.Sprintf("archive larger than %d MB", limited.limit>>20)))
+	} else if err != nil {
+		return fail(archiveError(err, strings.Join(containerPaths, ",")))
+	}
+
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return fail(err)
+	}
+
+	return archive, nil
+}
+
+// copy the entries of the archive of a container path into tarWriter with their path from the root
+func appendContainerPath(swarmNode *docker.Client, containerId, containerPath string, tarWriter *tar.Writer) error {
+	reader, writer := io.Pipe()
+	downloaded := make(chan error, 1)
+	go func() {
+		err := swarmNode.DownloadFromContainer(containerId, docker.DownloadFromContainerOptions{
+			Path:         containerPath,
+			OutputStream: writer,
+		})
+		writer.CloseWithError(err)
+		downloaded <- err
+	}()
+
+	dir := strings.TrimPrefix(path.Dir(containerPath), "/")
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			reader.CloseWithError(err)
+			<-downloaded
+			return err
+		}
+
+		header.Name = path.Join(dir, header.Name)
+		if err := tarWriter.WriteHeader(header); err != nil {
+			reader.CloseWithError(err)
+			<-downloaded
+			return err
+		}
+		if _, err := io.Copy(tarWriter, tarReader); err != nil {
+			reader.CloseWithError(err)
+			<-downloaded
+			return err
+		}
+	}
+
+	return <-downloaded
+}
+
+// write the tar archive read from r as a zip archive to w
+func TarToZip(r io.Reader, w io.Writer) error {
+	zipWriter := zip.NewWriter(w)
+	tarReader := tar.NewReader(r)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA && header.Typeflag != tar.TypeDir {
+			// links and devices have no zip equivalent
+			continue
+		}
+
+		zipHeader, err := zip.FileInfoHeader(header.FileInfo())
+		if err != nil {
+			return err
+		}
+		zipHeader.Name = header.Name
+		if header.Typeflag == tar.TypeDir {
+			zipHeader.Name = strings.TrimSuffix(header.Name, "/") + "/"
+		} else {
+			zipHeader.Method = zip.Deflate
+		}
+
+		entry, err := zipWriter.CreateHeader(zipHeader)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(entry, tarReader); err != nil {
+			return err
+		}
+	}
+
+	return zipWriter.Close()
+}
+
+// upload the content read from r as the file name in the directory dir of the container of the node stored in ctx,
+// size is the length of the content and can not exceed ContainerFileMaxSize
+func (client *CraneDockerClient) UploadContainerFile(ctx context.Context, containerId, dir, name string, size int64, r io.Reader) error {
+	dir, err := cleanContainerPath(dir)
+	if err != nil {
+		return err
+	}
+
+	if name = path.Base(name); name == "." || name == "/" || name == ".." {
+		return cranerror.NewError(CodeInvalidContainerPath, "file name required")
+	}
+
+	if size > client.ContainerFileMaxSize() {
+		return cranerror.NewError(CodeContainerArchiveTooLarge, fmt.Sprintf("file larger than %d MB", client.ContainerFileMaxSize()>>20))
+	}
+
+	swarmNode, err := client.SwarmNode(ctx)
+	if err != nil {
+		return err
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		tarWriter := tar.NewWriter(writer)
+		err := tarWriter.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    size,
+			ModTime: time.Now(),
+		})
+		if err == nil {
+			_, err = io.CopyN(tarWriter, r, size)
+		}
+		if err == nil {
+			err = tarWriter.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	err = swarmNode.UploadToContainer(containerId, docker.UploadToContainerOptions{
+		Path:        dir,
+		InputStream: reader,
+	})
+	reader.Close()
+	if err != nil {
+		log.Errorf("upload %s to %s of container %s got error: %s", name, dir, containerId, err.Error())
+		return archiveError(err, dir)
+	}
+
+	return nil
+}
+
+// the paths added or modified in the container, a directory is left out if a change under it is reported
+func ChangedContainerPaths(changes []docker.Change) []string {
+	var paths []string
+	for _, change := range changes {
+		if change.Kind != docker.ChangeDelete {
+			paths = append(paths, change.Path)
+		}
+	}
+	sort.Strings(paths)
+
+	var leaves []string
+	for i, p := range paths {
+		if i+1 < len(paths) && strings.HasPrefix(paths[i+1], p+"/") {
+			continue
+		}
+		leaves = append(leaves, p)
+	}
+
+	return leaves
+}
+
+// download the files added or modified in the container into a tar temp file, see ArchiveContainerPaths
+func (client *CraneDockerClient) ArchiveContainerChanges(ctx context.Context, containerId string) (*os.File, error) {
+	changes, err := client.DiffContainer(ctx, containerId)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := ChangedContainerPaths(changes)
+	if len(paths) == 0 {
+		return nil, cranerror.NewError(CodeContainerPathNotFound, "no file changed in the container")
+	}
+
+	return client.ArchiveContainerPaths(ctx, containerId, paths, true)
+}
@@ -0,0 +1,222 @@
+package dockerclient
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/Dataman-Cloud/crane/src/utils/config"
+
+	docker "github.com/Dataman-Cloud/go-dockerclient"
+	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/swarm"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+type testArchiveEntry struct {
+	name    string
+	content string
+	dir     bool
+}
+
+func testTar(entries ...testArchiveEntry) []byte {
+	var buf bytes.Buffer
+	tarWriter := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.content)), Typeflag: tar.TypeReg}
+		if entry.dir {
+			header = &tar.Header{Name: entry.name + "/", Mode: 0755, Typeflag: tar.TypeDir}
+		}
+		tarWriter.WriteHeader(header)
+		tarWriter.Write([]byte(entry.content))
+	}
+	tarWriter.Close()
+	return buf.Bytes()
+}
+
+func testArchiveNode(t *testing.T, uploaded *bytes.Buffer) *httptest.Server {
+	archives := map[string][]byte{
+		"/etc": testTar(
+			testArchiveEntry{name: "etc", dir: true},
+			testArchiveEntry{name: "etc/hosts", content: "127.0.0.1 localhost"},
+			testArchiveEntry{name: "etc/nginx", dir: true},
+			testArchiveEntry{name: "etc/nginx/nginx.conf", content: "worker_processes 1;"},
+		),
+		"/etc/hosts":            testTar(testArchiveEntry{name: "hosts", content: "127.0.0.1 localhost"}),
+		"/tmp/heap":             testTar(testArchiveEntry{name: "heap", content: "dump"}),
+		"/etc/nginx":            testTar(testArchiveEntry{name: "nginx", dir: true}, testArchiveEntry{name: "nginx/nginx.conf", content: "worker_processes 1;"}),
+		"/var/huge":             testTar(testArchiveEntry{name: "huge", content: string(make([]byte, 2<<20))}),
+		"/etc/nginx/nginx.conf": testTar(testArchiveEntry{name: "nginx.conf", content: "worker_processes 1;"}),
+		"/etc/passwd":           testTar(testArchiveEntry{name: "passwd", content: "root:x:0:0"}),
+	}
+
+	nodeRouter := gin.New()
+	nodeRouter.GET("/info", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, types.Info{Swarm: swarm.Info{NodeID: "node1"}})
+	})
+	nodeRouter.GET("/version", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, map[string]string{"ApiVersion": "1.24"})
+	})
+	nodeRouter.GET("/containers/:id/archive", func(ctx *gin.Context) {
+		archive, ok := archives[ctx.Query("path")]
+		if !ok {
+			ctx.JSON(http.StatusNotFound, map[string]string{"message": "not found"})
+			return
+		}
+		ctx.Data(http.StatusOK, "application/x-tar", archive)
+	})
+	nodeRouter.PUT("/containers/:id/archive", func(ctx *gin.Context) {
+		uploaded.ReadFrom(ctx.Request.Body)
+		ctx.Status(http.StatusOK)
+	})
+	nodeRouter.GET("/containers/:id/changes", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, []docker.Change{
+			{Path: "/etc", Kind: docker.ChangeModify},
+			{Path: "/etc/nginx", Kind: docker.ChangeModify},
+			{Path: "/etc/nginx/nginx.conf", Kind: docker.ChangeModify},
+			{Path: "/etc/passwd", Kind: docker.ChangeModify},
+			{Path: "/tmp", Kind: docker.ChangeAdd},
+			{Path: "/tmp/heap", Kind: docker.ChangeAdd},
+			{Path: "/var/cache", Kind: docker.ChangeDelete},
+		})
+	})
+
+	return httptest.NewServer(nodeRouter)
+}
+
+func tarNames(t *testing.T, archive *os.File) map[string]string {
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	names := make(map[string]string)
+	tarReader := tar.NewReader(archive)
+	for {
+		header, err := tarReader.Next()
+		if err != nil {
+			break
+		}
+		content, _ := ioutil.ReadAll(tarReader)
+		names[header.Name] = string(content)
+	}
+
+	return names
+}
+
+func TestListContainerDir(t *testing.T) {
+	nodeServer := testArchiveNode(t, nil)
+	defer nodeServer.Close()
+	client, closeManager := testNodeClient(t, nodeServer)
+	defer closeManager()
+
+	craneContext := context.WithValue(context.Background(), "node_id", "node1")
+	files, err := client.ListContainerDir(craneContext, "container1", "/etc/")
+	assert.Nil(t, err)
+	assert.Len(t, files, 2)
+	assert.Equal(t, "nginx", files[0].Name)
+	assert.True(t, files[0].IsDir)
+	assert.Equal(t, "/etc/hosts", files[1].Path)
+	assert.Equal(t, int64(19), files[1].Size)
+
+	files, err = client.ListContainerDir(craneContext, "container1", "/etc/hosts")
+	assert.Nil(t, err)
+	assert.Len(t, files, 1)
+	assert.Equal(t, "/etc/hosts", files[0].Path)
+
+	_, err = client.ListContainerDir(craneContext, "container1", "/nothing")
+	assert.Contains(t, err.Error(), "no such file")
+	_, err = client.ListContainerDir(craneContext, "container1", "etc")
+	assert.NotNil(t, err)
+}
+
+func TestArchiveContainerPaths(t *testing.T) {
+	nodeServer := testArchiveNode(t, nil)
+	defer nodeServer.Close()
+	client, closeManager := testNodeClient(t, nodeServer)
+	defer closeManager()
+
+	craneContext := context.WithValue(context.Background(), "node_id", "node1")
+	archive, err := client.ArchiveContainerPaths(craneContext, "container1", []string{"/etc/hosts"}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"hosts": "127.0.0.1 localhost"}, tarNames(t, archive))
+
+	archive, err = client.ArchiveContainerPaths(craneContext, "container1", []string{"/etc/hosts", "/tmp/heap"}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"etc/hosts": "127.0.0.1 localhost", "tmp/heap": "dump"}, tarNames(t, archive))
+
+	client.config = nil
+	_, err = client.ArchiveContainerPaths(craneContext, "container1", []string{"/nothing"}, false)
+	assert.Contains(t, err.Error(), "no such file")
+
+	archive, err = client.ArchiveContainerChanges(craneContext, "container1")
+	assert.Nil(t, err)
+	names := tarNames(t, archive)
+	assert.Len(t, names, 3)
+	assert.Equal(t, "worker_processes 1;", names["etc/nginx/nginx.conf"])
+	assert.Equal(t, "dump", names["tmp/heap"])
+}
+
+func TestArchiveTooLarge(t *testing.T) {
+	nodeServer := testArchiveNode(t, nil)
+	defer nodeServer.Close()
+	client, closeManager := testNodeClient(t, nodeServer)
+	defer closeManager()
+
+	client.config = &config.Config{ContainerFileMaxSize: 1}
+	craneContext := context.WithValue(context.Background(), "node_id", "node1")
+	_, err := client.ArchiveContainerPaths(craneContext, "container1", []string{"/var/huge"}, false)
+	assert.Contains(t, err.Error(), "archive larger than 1 MB")
+
+	err = client.UploadContainerFile(craneContext, "container1", "/tmp", "huge", 2<<20, bytes.NewReader(nil))
+	assert.NotNil(t, err)
+}
+
+func TestUploadContainerFile(t *testing.T) {
+	var uploaded bytes.Buffer
+	nodeServer := testArchiveNode(t, &uploaded)
+	defer nodeServer.Close()
+	client, closeManager := testNodeClient(t, nodeServer)
+	defer closeManager()
+
+	craneContext := context.WithValue(context.Background(), "node_id", "node1")
+	err := client.UploadContainerFile(craneContext, "container1", "/tmp", "../app.conf", 4, bytes.NewReader([]byte("conf")))
+	assert.Nil(t, err)
+
+	tarReader := tar.NewReader(&uploaded)
+	header, err := tarReader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, "app.conf", header.Name)
+	content, _ := ioutil.ReadAll(tarReader)
+	assert.Equal(t, "conf", string(content))
+}
+
+func TestTarToZip(t *testing.T) {
+	var buf bytes.Buffer
+	err := TarToZip(bytes.NewReader(testTar(
+		testArchiveEntry{name: "etc", dir: true},
+		testArchiveEntry{name: "etc/hosts", content: "127.0.0.1 localhost"},
+	)), &buf)
+	assert.Nil(t, err)
+
+	zipReader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.Nil(t, err)
+	assert.Len(t, zipReader.File, 2)
+	assert.Equal(t, "etc/", zipReader.File[0].Name)
+	assert.Equal(t, "etc/hosts", zipReader.File[1].Name)
+}
+
+func TestChangedContainerPaths(t *testing.T) {
+	paths := ChangedContainerPaths([]docker.Change{
+		{Path: "/etc", Kind: docker.ChangeModify},
+		{Path: "/etc/hosts", Kind: docker.ChangeModify},
+		{Path: "/etcd", Kind: docker.ChangeAdd},
+		{Path: "/var/cache", Kind: docker.ChangeDelete},
+	})
+	assert.Equal(t, []string{"/etc/hosts", "/etcd"}, paths)
+}
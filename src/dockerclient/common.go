@@ -45,12 +45,17 @@ func (client *CraneDockerClient) ToCraneServiceSpec(swarmService swarm.ServiceSp
 	return craneServiceSpec
 }
 
-func EncodeRegistryAuth(authInfo *rauth.RegistryAuth) (string, error) {
-	authConfig := docker.AuthConfiguration{
+// the auth of a stored registry auth the daemons pull and push with
+func RegistryAuthConfiguration(authInfo *rauth.RegistryAuth) docker.AuthConfiguration {
+	return docker.AuthConfiguration{
 		Username: authInfo.Username,
 		Password: authInfo.Password,
 		Email:    "",
 	}
+}
+
+func EncodeRegistryAuth(authInfo *rauth.RegistryAuth) (string, error) {
+	authConfig := RegistryAuthConfiguration(authInfo)
 
 	var buf bytes.Buffer
 	if err := json.NewEncoder(&buf).Encode(authConfig); err != nil {
@@ -60,8 +65,10 @@ func EncodeRegistryAuth(authInfo *rauth.RegistryAuth) (string, error) {
 }
 
 func parseEndpoint(endpoint string) (*url.URL, error) {
-	conf := config.InitConfig()
+	// config is only needed to complete the endpoint
+	var conf *config.Config
 	if !strings.Contains(endpoint, "://") {
+		conf = config.InitConfig()
 		endpoint = conf.DockerEntryScheme + "://" + endpoint
 	}
 
@@ -72,6 +79,9 @@ func parseEndpoint(endpoint string) (*url.URL, error) {
 	}
 
 	if !strings.Contains(u.Host, ":") {
+		if conf == nil {
+			conf = config.InitConfig()
+		}
 		u.Host = u.Host + ":" + conf.DockerEntryPort
 	}
 
@@ -21,6 +21,14 @@ const (
 	SSETypeContainerStats = "container-stats"
 	SSETypeServiceLogs    = "service-logs"
 	SSETypeServiceStats   = "service-stats"
+	SSETypeImageCommit    = "image-commit"
+	SSETypeImagePush      = "image-push"
+	SSETypeImagePushEnd   = "image-push-end"
+	SSETypeImagePull      = "image-pull"
+	SSETypeImagePullEnd   = "image-pull-end"
+	SSETypeImageBuild     = "image-build"
+	SSETypeImageBuildLog  = "image-build-log"
+	SSETypeImageBuildEnd  = "image-build-end"
 )
 
 const (
@@ -35,6 +43,7 @@ const (
 	CodeInvalidServiceSpec          = "503-11411"
 	CodeInvalidServiceName          = "503-11412"
 	CodeGetServicePortConflictError = "503-11413"
+	CodeServiceNoRunningTask        = "400-11414"
 
 	// stack error code
 	CodeInvalidStackName = "503-11502"
@@ -47,12 +56,19 @@ const (
 	CodeGetNodeInfoError          = "503-11305"
 	CodeGetNodeAdvertiseAddrError = "503-11307"
 	CodeJoinNodeError             = "503-11308"
+	CodeNodeQuorumUnsafe          = "409-11309"
+	CodeInvalidNodeConstraint     = "400-11310"
+	CodeNoNodeMatched             = "400-11311"
 
 	// network error code
 	CodeNetworkPredefined         = "403-11206"
 	CodeNetworkInvalid            = "400-11207"
 	CodeNetworkOrContainerInvalid = "400-11208"
 	CodeInvalidNetworkName        = "503-11209"
+	CodeInvalidNetworkPool        = "503-11210"
+	CodeNetworkPoolExhausted      = "503-11211"
+	CodeNetworkSubnetOverlap      = "409-11212"
+	CodeNetworkInUse              = "409-11213"
 
 	//Container error code
 	CodePatchContainerParamError      = "400-11002"
@@ -61,6 +77,17 @@ const (
 	CodeContainerAlreadyRunning       = "400-11007"
 	CodeContainerNotRunning           = "400-11008"
 	CodeInvalidImageName              = "503-11009"
+	CodeInvalidExecCommand            = "400-11010"
+	CodeInvalidExecTimeout            = "400-11011"
+	CodeInvalidContainerPath          = "400-11013"
+	CodeContainerPathNotFound         = "404-11014"
+	CodeContainerArchiveTooLarge      = "413-11015"
+
+	//Image error code
+	CodeInvalidImageRepository = "400-11102"
+	CodePushImageError         = "503-11103"
+	CodePullImageError         = "503-11104"
+	CodeBuildImageError        = "503-11105"
 
 	//Go docker client error code
 	CodeConnToNodeError          = "503-11701"
@@ -68,7 +95,20 @@ const (
 	CodeNodeEndpointIpMatchError = "503-11703"
 	CodeVerifyNodeEnpointFailed  = "503-11704"
 	CodeGetManagerInfoError      = "503-11705"
+	CodeNoReachableManager       = "503-11706"
 
 	//Volume error code
-	CodeInvalidVolumeName = "503-11602"
+	CodeInvalidVolumeName  = "503-11602"
+	CodeVolumeNotFound     = "404-11603"
+	CodeVolumeHelperError  = "503-11604"
+	CodeRestoreVolumeError = "503-11605"
+
+	//Swarm error code
+	CodeInitSwarmError       = "503-11801"
+	CodeUpdateSwarmError     = "503-11802"
+	CodeInvalidSwarmSettings = "400-11803"
+
+	//Prune error code
+	CodeInvalidPruneKind  = "400-12101"
+	CodePruneNodeNotReady = "400-12102"
 )
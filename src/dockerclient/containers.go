@@ -34,6 +34,25 @@ func (client *CraneDockerClient) InspectContainer(ctx context.Context, id string
 	return container, err
 }
 
+// commit the container of the node stored in ctx into an image
+func (client *CraneDockerClient) CommitContainer(ctx context.Context, opts docker.CommitContainerOptions) (*docker.Image, error) {
+	swarmNode, err := client.SwarmNode(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ValidateImageReference(opts.Repository, opts.Tag); err != nil {
+		return nil, err
+	}
+
+	image, err := swarmNode.CommitContainer(opts)
+	if err != nil {
+		err = ToCraneError(err)
+	}
+
+	return image, err
+}
+
 func (client *CraneDockerClient) RemoveContainer(ctx context.Context, opts docker.RemoveContainerOptions) error {
 	swarmNode, err := client.SwarmNode(ctx)
 	if err != nil {
@@ -5,6 +5,8 @@ import (
 	"net/http"
 	"net/url"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/Dataman-Cloud/crane/src/utils/config"
 	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
@@ -12,17 +14,37 @@ import (
 
 	docker "github.com/Dataman-Cloud/go-dockerclient"
 	log "github.com/Sirupsen/logrus"
+	"github.com/docker/engine-api/types"
 	"golang.org/x/net/context"
 )
 
 type CraneDockerClient struct {
 	DockerClientInterface
 
-	// client connect to swarm cluster manager
-	// TODO swarm cluster has multiple manager and can be changed at runtime
-	// make sure swarmManager could connect to next if first one failed
+	// client connect to swarm cluster manager, used only if managers is nil
 	swarmManager *docker.Client
 
+	// all known swarm managers, requests are sent to the active one
+	managers *managerPool
+
+	// docker clients of nodes indexed by node id, created on first use
+	nodeClients     *nodeClientPool
+	nodeClientsOnce sync.Once
+
+	// in memory swarm state list & inspect read from, nil if disabled
+	swarmCache *swarmCache
+
+	// events of node daemons, swarm changes and crane operations, created on first use
+	events     *eventHub
+	eventsOnce sync.Once
+
+	// state of the prunes scheduled by the config
+	pruneSchedule PruneSchedule
+	pruneMu       sync.Mutex
+
+	// serializes the subnet allocations from the network pools
+	networkPoolMu sync.Mutex
+
 	// http client shared both for cluster connection & client connection
 	sharedHttpClient         *httpclient.Client
 	swarmManagerHttpEndpoint string
@@ -46,10 +68,8 @@ func NewCraneDockerClient(config *config.Config) (*CraneDockerClient, error) {
 	}
 
 	if config.DockerTlsVerify {
-		client.swarmManager, err = NewGoDockerClientTls(swarmManagerEntry, config)
 		client.sharedHttpClient, err = NewHttpClientTls(config)
 	} else {
-		client.swarmManager, err = docker.NewVersionedClient(swarmManagerEntry, config.DockerApiVersion)
 		client.sharedHttpClient, err = NewHttpClient()
 	}
 
@@ -58,22 +78,188 @@ func NewCraneDockerClient(config *config.Config) (*CraneDockerClient, error) {
 		return nil, err
 	}
 
-	if client.swarmManager == nil {
-		return nil, fmt.Errorf("create swarmManager client failed")
+	endpoints := []string{swarmManagerEntry}
+	for _, endpoint := range config.SwarmManagerEndpoints {
+		if len(endpoint) > 0 {
+			endpoints = append(endpoints, normalizeManagerEndpoint(endpoint, config.DockerEntryScheme, config.DockerEntryPort))
+		}
+	}
+
+	client.managers, err = newManagerPool(endpoints, config.DockerEntryScheme, config.DockerEntryPort, client.sharedHttpClient, client.newManagerClient)
+	if err != nil {
+		log.Error("Unable to connect to docker daemon . Ensure docker is running endpoint ", swarmManagerEntry, "err: ", err)
+		return nil, err
 	}
 
-	err = client.swarmManager.Ping()
+	err = client.managers.Refresh()
 	if err != nil {
-		log.Error("Unable to ping docker daemon. Ensure docker is running endpoint ", swarmManagerEntry, "err: ", err)
+		log.Error("Unable to ping docker daemon. Ensure docker is running endpoint ", endpoints, "err: ", err)
 		return nil, err
 	}
 
+	if config.SwarmManagerCheckInterval > 0 {
+		go client.managers.Watch(time.Second*time.Duration(config.SwarmManagerCheckInterval), nil)
+	}
+
+	if config.SwarmCacheInterval > 0 {
+		client.swarmCache = newSwarmCache(time.Second*time.Duration(config.SwarmCacheInterval), client.fetchSwarmSnapshot)
+		client.swarmCache.onSync = client.publishSwarmChanges
+		if err := client.swarmCache.Sync(); err != nil {
+			log.Warnf("initial sync of swarm cache got error: %s", err.Error())
+		}
+
+		go client.swarmCache.Watch(nil)
+		go client.watchSwarmEvents(nil)
+	} else {
+		log.Warn("swarm cache disabled, service, task and node changes are not published as events")
+	}
+
+	go client.watchNodeEvents(nil)
+
+	if config.PruneInterval > 0 {
+		if kinds, err := ParsePruneKinds(config.PruneKinds); err != nil {
+			log.Errorf("scheduled prune disabled: %s", err.Error())
+		} else {
+			go client.watchPrune(time.Second*time.Duration(config.PruneInterval), kinds, nil)
+		}
+	}
+
 	return client, nil
 }
 
+func (client *CraneDockerClient) newManagerClient(endpoint string) (*docker.Client, error) {
+	if client.config.DockerTlsVerify {
+		return NewGoDockerClientTls(endpoint, client.config)
+	}
+
+	return docker.NewVersionedClient(endpoint, client.config.DockerApiVersion)
+}
+
 // return swarm docker client
 func (client *CraneDockerClient) SwarmManager() *docker.Client {
-	return client.swarmManager
+	if client.managers == nil {
+		return client.swarmManager
+	}
+
+	return client.managers.ActiveClient()
+}
+
+// return the http client of the requests to the swarm manager, it fails over to another manager
+func (client *CraneDockerClient) managerHttpClient() *httpclient.Client {
+	if client.managers == nil {
+		return client.sharedHttpClient
+	}
+
+	return client.managers.requests
+}
+
+// return the http endpoint of the swarm manager requests are sent to
+func (client *CraneDockerClient) ManagerEndpoint() string {
+	if client.managers == nil {
+		return client.swarmManagerHttpEndpoint
+	}
+
+	return client.managers.Active()
+}
+
+// return the state of all known swarm managers
+func (client *CraneDockerClient) ManagerEndpoints() []ManagerEndpointStatus {
+	if client.managers == nil {
+		return []ManagerEndpointStatus{{Endpoint: client.swarmManagerHttpEndpoint, Reachable: true}}
+	}
+
+	return client.managers.Status()
+}
+
+// read the whole swarm state from the active manager
+func (client *CraneDockerClient) fetchSwarmSnapshot() (*swarmSnapshot, error) {
+	var err error
+	snapshot := &swarmSnapshot{}
+
+	if snapshot.nodes, err = client.listNode(); err != nil {
+		return nil, err
+	}
+
+	if snapshot.services, err = client.listServiceSpec(types.ServiceListOptions{}); err != nil {
+		return nil, err
+	}
+
+	if snapshot.tasks, err = client.listTasks(types.TaskListOptions{}); err != nil {
+		return nil, err
+	}
+
+	if snapshot.networks, err = client.SwarmManager().FilteredListNetworks(docker.NetworkFilterOpts{}); err != nil {
+		return nil, err
+	}
+
+	snapshot.syncedAt = time.Now()
+	return snapshot, nil
+}
+
+// forward the events of the active manager to the swarm cache,
+// the listener follows the active manager when crane fails over
+func (client *CraneDockerClient) watchSwarmEvents(stop <-chan struct{}) {
+	ticker := time.NewTicker(client.swarmCache.interval)
+	defer ticker.Stop()
+
+	var listener chan *docker.APIEvents
+	var subscribed *docker.Client
+	for {
+		if manager := client.SwarmManager(); manager != subscribed {
+			if subscribed != nil {
+				subscribed.RemoveEventListener(listener)
+			}
+
+			listener = make(chan *docker.APIEvents, 64)
+			subscribed = nil
+			if err := manager.AddEventListener(listener); err != nil {
+				log.Warnf("listen to swarm manager events got error: %s", err.Error())
+			} else {
+				subscribed = manager
+			}
+		}
+
+		select {
+		case <-stop:
+			if subscribed != nil {
+				subscribed.RemoveEventListener(listener)
+			}
+			return
+		case event, ok := <-listener:
+			// closed by go-dockerclient when the event stream is lost
+			if !ok {
+				subscribed = nil
+				continue
+			}
+			client.swarmCache.Notify(event)
+		case <-ticker.C:
+		}
+	}
+}
+
+// return the cached swarm state if the cache is enabled and fresh
+func (client *CraneDockerClient) swarmSnapshot() (*swarmSnapshot, bool) {
+	if client.swarmCache == nil {
+		return nil, false
+	}
+
+	return client.swarmCache.Snapshot()
+}
+
+// called after every write to the swarm cluster
+func (client *CraneDockerClient) invalidateSwarmCache() {
+	if client.swarmCache != nil {
+		client.swarmCache.Invalidate()
+	}
+}
+
+// return the freshness and the usage of the swarm cache
+func (client *CraneDockerClient) SwarmCacheStatus() SwarmCacheStatus {
+	if client.swarmCache == nil {
+		return SwarmCacheStatus{Stale: true}
+	}
+
+	return client.swarmCache.Status()
 }
 
 // create a daemon docker client base on host id stored in ctx
@@ -106,15 +292,26 @@ func (client *CraneDockerClient) SwarmNode(ctx context.Context) (*docker.Client,
 		}
 	}
 
+	pool := client.nodeClientPool()
+	if nodeClient := pool.Get(nodeId); nodeClient != nil {
+		return nodeClient, nil
+	}
+
 	nodeUrl, err := client.GetDaemonUrlById(nodeId)
 	if err != nil {
 		return nil, err
 	}
 
 	if err := client.VerifyNodeEndpoint(nodeId, nodeUrl); err != nil {
+		pool.Invalidate(nodeId)
 		return nil, err
 	}
 
+	endpoint := nodeUrl.String()
+	if nodeClient := pool.Renew(nodeId, endpoint); nodeClient != nil {
+		return nodeClient, nil
+	}
+
 	nodeClient, err := client.createNodeClient(nodeUrl)
 	if err != nil {
 		return nil, &cranerror.CraneError{
@@ -123,9 +320,28 @@ func (client *CraneDockerClient) SwarmNode(ctx context.Context) (*docker.Client,
 		}
 	}
 
+	usePooledTransport(nodeClient)
+	pool.Put(nodeId, endpoint, nodeClient)
 	return nodeClient, nil
 }
 
+func (client *CraneDockerClient) nodeClientPool() *nodeClientPool {
+	client.nodeClientsOnce.Do(func() {
+		ttl := DefaultNodeClientTTL
+		if client.config != nil && client.config.NodeClientTTL > 0 {
+			ttl = time.Second * time.Duration(client.config.NodeClientTTL)
+		}
+		client.nodeClients = newNodeClientPool(ttl)
+	})
+
+	return client.nodeClients
+}
+
+// return the usage of cached node clients
+func (client *CraneDockerClient) NodeClientStats() NodeClientPoolStats {
+	return client.nodeClientPool().Stats()
+}
+
 func (client *CraneDockerClient) VerifyNodeEndpoint(nodeId string, nodeUrl *url.URL) error {
 	if nodeUrl == nil {
 		return &cranerror.CraneError{
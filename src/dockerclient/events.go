@@ -0,0 +1,461 @@
+package dockerclient
+
+import (
+	"sync"
+	"time"
+
+	docker "github.com/Dataman-Cloud/go-dockerclient"
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/swarm"
+	"golang.org/x/net/context"
+)
+
+const (
+	EventSourceDocker = "docker"
+	EventSourceSwarm  = "swarm"
+	EventSourceCrane  = "crane"
+
+	EventTypeService    = "service"
+	EventTypeTask       = "task"
+	EventTypeNode       = "node"
+	EventTypeStack      = "stack"
+	EventTypePermission = "permission"
+	EventTypeTerminal   = "terminal"
+	EventTypePrune      = "prune"
+
+	// events kept in memory for clients resuming with Last-Event-ID
+	DefaultEventHistorySize = 1000
+	// how often the node event listeners follow the nodes joining & leaving
+	eventNodeWatchInterval = 30 * time.Second
+	// events buffered for each subscriber, a slower subscriber misses events
+	eventSubscriberBuffer = 256
+
+	// the attribute of the service of the container and task events
+	EventAttributeServiceID = "com.docker.swarm.service.id"
+)
+
+// swarm level event types of docker 17.06, crane derives them from the swarm cache instead
+var swarmDockerEventTypes = map[string]bool{
+	"service": true,
+	"node":    true,
+	"secret":  true,
+	"config":  true,
+}
+
+type CraneEvent struct {
+	ID         uint64            `json:"ID"`
+	Source     string            `json:"Source"`
+	Type       string            `json:"Type"`
+	Action     string            `json:"Action"`
+	ActorID    string            `json:"ActorID"`
+	Namespace  string            `json:"Namespace,omitempty"`
+	NodeID     string            `json:"NodeID,omitempty"`
+	Account    string            `json:"Account,omitempty"`
+	Attributes map[string]string `json:"Attributes,omitempty"`
+	Time       time.Time         `json:"Time"`
+}
+
+// events are delivered if they match every set field
+type EventFilter struct {
+	Types      []string
+	Namespaces []string
+	Nodes      []string
+	// the events an account may see, nil for every event
+	Scope *EventScope
+}
+
+func (filter EventFilter) Match(event CraneEvent) bool {
+	return matchEventField(filter.Types, event.Type) &&
+		matchEventField(filter.Namespaces, event.Namespace) &&
+		matchEventField(filter.Nodes, event.NodeID) &&
+		filter.Scope.Match(event)
+}
+
+// the services an account has a permission on and their namespaces
+type EventScope struct {
+	Services   map[string]bool
+	Namespaces map[string]bool
+}
+
+// the events of a service are in the scope of the service, the other events of a namespace
+// in the one of the namespace, the events of neither, of the nodes or the accounts, are in none
+func (scope *EventScope) Match(event CraneEvent) bool {
+	if scope == nil {
+		return true
+	}
+
+	if serviceId := eventServiceId(event); serviceId != "" {
+		return scope.Services[serviceId]
+	}
+
+	return event.Namespace != "" && scope.Namespaces[event.Namespace]
+}
+
+func eventServiceId(event CraneEvent) string {
+	switch event.Type {
+	case EventTypeService, EventTypePermission:
+		return event.ActorID
+	}
+
+	return event.Attributes[EventAttributeServiceID]
+}
+
+func matchEventField(accepted []string, value string) bool {
+	if len(accepted) == 0 {
+		return true
+	}
+
+	for _, a := range accepted {
+		if a == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+type eventSubscriber struct {
+	filter EventFilter
+	events chan CraneEvent
+}
+
+// eventHub numbers the events, keeps the last ones and fans them out to subscribers
+type eventHub struct {
+	mu          sync.Mutex
+	seq         uint64
+	history     []CraneEvent
+	historySize int
+	subscribers map[chan CraneEvent]*eventSubscriber
+}
+
+func newEventHub(historySize int) *eventHub {
+	if historySize <= 0 {
+		historySize = DefaultEventHistorySize
+	}
+
+	return &eventHub{
+		// ids keep growing when crane restarts, an old Last-Event-ID replays the whole history
+		seq:         uint64(time.Now().UnixNano()),
+		historySize: historySize,
+		subscribers: make(map[chan CraneEvent]*eventSubscriber),
+	}
+}
+
+func (hub *eventHub) Publish(event CraneEvent) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	hub.seq++
+	event.ID = hub.seq
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	hub.history = append(hub.history, event)
+	if len(hub.history) > hub.historySize {
+		hub.history = hub.history[len(hub.history)-hub.historySize:]
+	}
+
+	for _, subscriber := range hub.subscribers {
+		if !subscriber.filter.Match(event) {
+			continue
+		}
+
+		select {
+		case subscriber.events <- event:
+		default:
+			log.Warnf("event subscriber is too slow, event %d dropped", event.ID)
+		}
+	}
+}
+
+// subscribe to the events matching filter, the events after lastEventId
+// still in history are returned to be sent first
+func (hub *eventHub) Subscribe(filter EventFilter, lastEventId uint64) ([]CraneEvent, chan CraneEvent) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	var missed []CraneEvent
+	if lastEventId > 0 {
+		for _, event := range hub.history {
+			if event.ID > lastEventId && filter.Match(event) {
+				missed = append(missed, event)
+			}
+		}
+	}
+
+	events := make(chan CraneEvent, eventSubscriberBuffer)
+	hub.subscribers[events] = &eventSubscriber{filter: filter, events: events}
+	return missed, events
+}
+
+func (hub *eventHub) Unsubscribe(events chan CraneEvent) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	delete(hub.subscribers, events)
+}
+
+func (client *CraneDockerClient) eventHub() *eventHub {
+	client.eventsOnce.Do(func() {
+		historySize := DefaultEventHistorySize
+		if client.config != nil && client.config.EventHistorySize > 0 {
+			historySize = client.config.EventHistorySize
+		}
+		client.events = newEventHub(historySize)
+	})
+
+	return client.events
+}
+
+// publish an event, crane operations are published by the api handlers
+func (client *CraneDockerClient) PublishEvent(event CraneEvent) {
+	if event.Source == "" {
+		event.Source = EventSourceCrane
+	}
+
+	client.eventHub().Publish(event)
+}
+
+func (client *CraneDockerClient) SubscribeEvents(filter EventFilter, lastEventId uint64) ([]CraneEvent, chan CraneEvent) {
+	return client.eventHub().Subscribe(filter, lastEventId)
+}
+
+func (client *CraneDockerClient) UnsubscribeEvents(events chan CraneEvent) {
+	client.eventHub().Unsubscribe(events)
+}
+
+type nodeEventListener struct {
+	client   *docker.Client
+	listener chan *docker.APIEvents
+	// closed to stop forwarding
+	stop chan struct{}
+	// closed when forwarding stopped, also when go-dockerclient lost the event stream
+	done chan struct{}
+}
+
+func (l *nodeEventListener) forwarding() bool {
+	select {
+	case <-l.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (l *nodeEventListener) close() {
+	l.client.RemoveEventListener(l.listener)
+	select {
+	case <-l.stop:
+	default:
+		close(l.stop)
+	}
+}
+
+// listen to the events of every node daemon until stop closed,
+// listeners are added and removed as nodes join and leave the cluster
+func (client *CraneDockerClient) watchNodeEvents(stop <-chan struct{}) {
+	ticker := time.NewTicker(eventNodeWatchInterval)
+	defer ticker.Stop()
+
+	listeners := make(map[string]*nodeEventListener)
+	for {
+		client.reconcileNodeEventListeners(listeners)
+
+		select {
+		case <-stop:
+			for _, l := range listeners {
+				l.close()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (client *CraneDockerClient) reconcileNodeEventListeners(listeners map[string]*nodeEventListener) {
+	nodes, err := client.ListNode(types.NodeListOptions{})
+	if err != nil {
+		log.Warnf("list nodes for event listeners got error: %s", err.Error())
+		return
+	}
+
+	readyNodes := make(map[string]bool)
+	for _, node := range nodes {
+		if node.Status.State != swarm.NodeStateReady {
+			continue
+		}
+		readyNodes[node.ID] = true
+
+		nodeClient, err := client.SwarmNode(context.WithValue(context.Background(), "node_id", node.ID))
+		if err != nil {
+			log.Warnf("connect to node %s for events got error: %s", node.ID, err.Error())
+			continue
+		}
+
+		if l, ok := listeners[node.ID]; ok {
+			if l.client == nodeClient && l.forwarding() {
+				continue
+			}
+			// the pooled client of the node was replaced or the event stream lost
+			l.close()
+			delete(listeners, node.ID)
+		}
+
+		l := &nodeEventListener{
+			client:   nodeClient,
+			listener: make(chan *docker.APIEvents, eventSubscriberBuffer),
+			stop:     make(chan struct{}),
+			done:     make(chan struct{}),
+		}
+		if err := nodeClient.AddEventListener(l.listener); err != nil {
+			log.Warnf("listen to events of node %s got error: %s", node.ID, err.Error())
+			continue
+		}
+
+		listeners[node.ID] = l
+		go client.forwardNodeEvents(node.ID, l)
+	}
+
+	for nodeId, l := range listeners {
+		if !readyNodes[nodeId] {
+			l.close()
+			delete(listeners, nodeId)
+		}
+	}
+}
+
+// publish the events of a node daemon until stopped or go-dockerclient closes the listener
+func (client *CraneDockerClient) forwardNodeEvents(nodeId string, l *nodeEventListener) {
+	defer close(l.done)
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case event, ok := <-l.listener:
+			if !ok {
+				return
+			}
+
+			if event == nil || swarmDockerEventTypes[event.Type] {
+				continue
+			}
+
+			client.PublishEvent(dockerEventToCraneEvent(nodeId, event))
+		}
+	}
+}
+
+func dockerEventToCraneEvent(nodeId string, event *docker.APIEvents) CraneEvent {
+	craneEvent := CraneEvent{
+		Source:     EventSourceDocker,
+		Type:       event.Type,
+		Action:     event.Action,
+		ActorID:    event.Actor.ID,
+		Namespace:  event.Actor.Attributes[LabelNamespace],
+		NodeID:     nodeId,
+		Attributes: event.Actor.Attributes,
+		Time:       time.Now(),
+	}
+
+	if event.TimeNano > 0 {
+		craneEvent.Time = time.Unix(0, event.TimeNano)
+	} else if event.Time > 0 {
+		craneEvent.Time = time.Unix(event.Time, 0)
+	}
+
+	return craneEvent
+}
+
+// publish the changes between two syncs of the swarm cache
+func (client *CraneDockerClient) publishSwarmChanges(previous, current *swarmSnapshot) {
+	for _, event := range diffSwarmSnapshots(previous, current) {
+		client.PublishEvent(event)
+	}
+}
+
+// the service, task and node events between two snapshots,
+// nothing is reported for the first snapshot
+func diffSwarmSnapshots(previous, current *swarmSnapshot) []CraneEvent {
+	if previous == nil || current == nil {
+		return nil
+	}
+
+	var events []CraneEvent
+	newEvent := func(eventType, action, actorId, namespace, nodeId string) *CraneEvent {
+		events = append(events, CraneEvent{
+			Source:    EventSourceSwarm,
+			Type:      eventType,
+			Action:    action,
+			ActorID:   actorId,
+			Namespace: namespace,
+			NodeID:    nodeId,
+			Time:      current.syncedAt,
+		})
+		return &events[len(events)-1]
+	}
+
+	previousServices := make(map[string]swarm.Service)
+	for _, service := range previous.services {
+		previousServices[service.ID] = service
+	}
+	namespaces := make(map[string]string)
+	for _, service := range current.services {
+		namespace := service.Spec.Labels[LabelNamespace]
+		namespaces[service.ID] = namespace
+
+		old, ok := previousServices[service.ID]
+		delete(previousServices, service.ID)
+		if !ok {
+			newEvent(EventTypeService, "create", service.ID, namespace, "")
+		} else if old.Version.Index != service.Version.Index {
+			newEvent(EventTypeService, "update", service.ID, namespace, "")
+		}
+	}
+	for _, service := range previousServices {
+		namespace := service.Spec.Labels[LabelNamespace]
+		namespaces[service.ID] = namespace
+		newEvent(EventTypeService, "remove", service.ID, namespace, "")
+	}
+
+	previousTasks := make(map[string]swarm.Task)
+	for _, task := range previous.tasks {
+		previousTasks[task.ID] = task
+	}
+	for _, task := range current.tasks {
+		old, ok := previousTasks[task.ID]
+		delete(previousTasks, task.ID)
+		if !ok || old.Status.State != task.Status.State {
+			// the action of a task event is the state it reached
+			event := newEvent(EventTypeTask, string(task.Status.State), task.ID, namespaces[task.ServiceID], task.NodeID)
+			event.Attributes = map[string]string{EventAttributeServiceID: task.ServiceID}
+		}
+	}
+	for _, task := range previousTasks {
+		event := newEvent(EventTypeTask, "remove", task.ID, namespaces[task.ServiceID], task.NodeID)
+		event.Attributes = map[string]string{EventAttributeServiceID: task.ServiceID}
+	}
+
+	previousNodes := make(map[string]swarm.Node)
+	for _, node := range previous.nodes {
+		previousNodes[node.ID] = node
+	}
+	for _, node := range current.nodes {
+		old, ok := previousNodes[node.ID]
+		delete(previousNodes, node.ID)
+		if !ok {
+			newEvent(EventTypeNode, "create", node.ID, "", node.ID)
+		} else if old.Status.State != node.Status.State {
+			newEvent(EventTypeNode, string(node.Status.State), node.ID, "", node.ID)
+		} else if old.Version.Index != node.Version.Index {
+			newEvent(EventTypeNode, "update", node.ID, "", node.ID)
+		}
+	}
+	for _, node := range previousNodes {
+		newEvent(EventTypeNode, "remove", node.ID, "", node.ID)
+	}
+
+	return events
+}
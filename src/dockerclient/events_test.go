@@ -0,0 +1,107 @@
+package dockerclient
+
+import (
+	"testing"
+	"time"
+
+	docker "github.com/Dataman-Cloud/go-dockerclient"
+	"github.com/docker/engine-api/types/swarm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventHubResume(t *testing.T) {
+	hub := newEventHub(2)
+	hub.Publish(CraneEvent{Type: EventTypeStack, Namespace: "blog"})
+	hub.Publish(CraneEvent{Type: EventTypeStack, Namespace: "shop"})
+	hub.Publish(CraneEvent{Type: "container", Namespace: "blog"})
+
+	// only the last two events are kept
+	missed, events := hub.Subscribe(EventFilter{}, 1)
+	assert.Len(t, missed, 2)
+	assert.Equal(t, "shop", missed[0].Namespace)
+	assert.True(t, missed[0].ID < missed[1].ID)
+	hub.Unsubscribe(events)
+
+	missed, events = hub.Subscribe(EventFilter{Namespaces: []string{"blog"}}, missed[0].ID)
+	defer hub.Unsubscribe(events)
+	assert.Len(t, missed, 1)
+	assert.Equal(t, "container", missed[0].Type)
+
+	hub.Publish(CraneEvent{Type: EventTypeStack, Namespace: "shop"})
+	hub.Publish(CraneEvent{Type: EventTypeStack, Namespace: "blog"})
+	select {
+	case event := <-events:
+		assert.Equal(t, "blog", event.Namespace)
+		assert.False(t, event.Time.IsZero())
+	case <-time.After(time.Second):
+		t.Fatal("event not delivered")
+	}
+	assert.Len(t, events, 0)
+}
+
+func TestEventFilter(t *testing.T) {
+	event := CraneEvent{Type: EventTypeTask, Namespace: "blog", NodeID: "node1"}
+	assert.True(t, EventFilter{}.Match(event))
+	assert.True(t, EventFilter{Types: []string{EventTypeService, EventTypeTask}, Nodes: []string{"node1"}}.Match(event))
+	assert.False(t, EventFilter{Namespaces: []string{"shop"}}.Match(event))
+}
+
+func TestEventScope(t *testing.T) {
+	scope := &EventScope{Services: map[string]bool{"service1": true}, Namespaces: map[string]bool{"blog": true}}
+	filter := EventFilter{Scope: scope}
+
+	assert.True(t, filter.Match(CraneEvent{Type: EventTypeService, ActorID: "service1", Namespace: "blog"}))
+	assert.True(t, filter.Match(CraneEvent{Type: EventTypeStack, ActorID: "blog", Namespace: "blog"}))
+	assert.True(t, filter.Match(CraneEvent{Type: "container", Namespace: "blog", Attributes: map[string]string{EventAttributeServiceID: "service1"}}))
+	// another service of the namespace
+	assert.False(t, filter.Match(CraneEvent{Type: EventTypeTask, Namespace: "blog", Attributes: map[string]string{EventAttributeServiceID: "service2"}}))
+	assert.False(t, filter.Match(CraneEvent{Type: EventTypePermission, ActorID: "service2"}))
+	assert.False(t, filter.Match(CraneEvent{Type: EventTypeStack, ActorID: "shop", Namespace: "shop"}))
+	assert.False(t, filter.Match(CraneEvent{Type: EventTypeNode, ActorID: "node1", NodeID: "node1"}))
+	assert.False(t, filter.Match(CraneEvent{Type: EventTypeTerminal, ActorID: "session1"}))
+
+	assert.True(t, EventFilter{}.Match(CraneEvent{Type: EventTypeNode, ActorID: "node1"}))
+}
+
+func TestDockerEventToCraneEvent(t *testing.T) {
+	event := dockerEventToCraneEvent("node1", &docker.APIEvents{
+		Type:     "container",
+		Action:   "die",
+		Actor:    docker.APIActor{ID: "c1", Attributes: map[string]string{LabelNamespace: "blog"}},
+		TimeNano: 1e9,
+	})
+
+	assert.Equal(t, EventSourceDocker, event.Source)
+	assert.Equal(t, "blog", event.Namespace)
+	assert.Equal(t, "node1", event.NodeID)
+	assert.Equal(t, time.Unix(1, 0), event.Time)
+}
+
+func TestDiffSwarmSnapshots(t *testing.T) {
+	previous := testSwarmSnapshot()
+	assert.Nil(t, diffSwarmSnapshots(nil, previous))
+
+	current := testSwarmSnapshot()
+	current.services[0].Version.Index = 2
+	current.services = current.services[:1]
+	current.tasks[0].Status.State = swarm.TaskStateFailed
+	current.tasks = append(current.tasks, swarm.Task{ID: "task3", ServiceID: "service1", NodeID: "node1", Status: swarm.TaskStatus{State: swarm.TaskStateNew}})
+	current.nodes[0].Status.State = swarm.NodeStateDown
+
+	actions := make(map[string]string)
+	for _, event := range diffSwarmSnapshots(previous, current) {
+		assert.Equal(t, EventSourceSwarm, event.Source)
+		actions[event.Type+"/"+event.ActorID] = event.Action
+		if event.Type == EventTypeTask {
+			assert.Equal(t, "service1", event.Attributes[EventAttributeServiceID])
+		}
+	}
+
+	assert.Equal(t, map[string]string{
+		"service/service1": "update",
+		"service/service2": "remove",
+		"task/task1":       string(swarm.TaskStateFailed),
+		"task/task3":       string(swarm.TaskStateNew),
+		"node/node1":       string(swarm.NodeStateDown),
+	}, actions)
+}
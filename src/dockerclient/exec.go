@@ -0,0 +1,378 @@
+package dockerclient
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
+
+	docker "github.com/Dataman-Cloud/go-dockerclient"
+	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/filters"
+	"github.com/docker/engine-api/types/swarm"
+	"golang.org/x/net/context"
+)
+
+const (
+	DefaultExecTimeout = 30 * time.Second
+	MaxExecTimeout     = 10 * time.Minute
+	// bytes of stdout and stderr kept each, the rest is dropped
+	MaxExecOutput = 1 << 20
+)
+
+var (
+	// the command of a terminal if the caller does not choose one
+	DefaultTerminalCommand = []string{"sh"}
+
+	errExecTaskNotRunning = errors.New("task is not running")
+)
+
+// ExecTerminal is an interactive docker exec session with a tty,
+// the input and output are streamed over the hijacked connection to the node daemon
+type ExecTerminal struct {
+	client  *docker.Client
+	execId  string
+	command []string
+
+	stdin  *io.PipeWriter
+	stdout *io.PipeReader
+	waiter docker.CloseWaiter
+
+	closeOnce sync.Once
+}
+
+// create and start an exec with a tty in the container of the node stored in ctx
+func (client *CraneDockerClient) CreateExecTerminal(ctx context.Context, containerId string, command []string, user string) (*ExecTerminal, error) {
+	swarmNode, err := client.SwarmNode(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(command) == 0 {
+		command = DefaultTerminalCommand
+	}
+
+	exec, err := swarmNode.CreateExec(docker.CreateExecOptions{
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          true,
+		Cmd:          command,
+		Container:    containerId,
+		User:         user,
+	})
+	if err != nil {
+		return nil, ToCraneError(err)
+	}
+
+	stdinReader, stdinWriter := io.Pipe()
+	stdoutReader, stdoutWriter := io.Pipe()
+	waiter, err := swarmNode.StartExecNonBlocking(exec.ID, docker.StartExecOptions{
+		InputStream:  stdinReader,
+		OutputStream: stdoutWriter,
+		ErrorStream:  stdoutWriter,
+		Tty:          true,
+		RawTerminal:  true,
+	})
+	if err != nil {
+		return nil, ToCraneError(err)
+	}
+
+	terminal := &ExecTerminal{
+		client:  swarmNode,
+		execId:  exec.ID,
+		command: command,
+		stdin:   stdinWriter,
+		stdout:  stdoutReader,
+		waiter:  waiter,
+	}
+
+	// the reader of the output gets EOF once the exec exits
+	go func() {
+		stdoutWriter.CloseWithError(waiter.Wait())
+	}()
+
+	return terminal, nil
+}
+
+func (terminal *ExecTerminal) Read(p []byte) (int, error) {
+	return terminal.stdout.Read(p)
+}
+
+func (terminal *ExecTerminal) Write(p []byte) (int, error) {
+	return terminal.stdin.Write(p)
+}
+
+func (terminal *ExecTerminal) Resize(rows, columns uint16) error {
+	return terminal.client.ResizeExecTTY(terminal.execId, int(rows), int(columns))
+}
+
+func (terminal *ExecTerminal) Command() string {
+	return strings.Join(terminal.command, " ")
+}
+
+func (terminal *ExecTerminal) ID() string {
+	return terminal.execId
+}
+
+// close the input of the exec and the connection to the node daemon
+func (terminal *ExecTerminal) Close() error {
+	terminal.closeOnce.Do(func() {
+		terminal.stdin.Close()
+		terminal.waiter.Close()
+	})
+
+	return nil
+}
+
+type ExecOptions struct {
+	Cmd  []string `json:"Cmd"`
+	User string   `json:"User"`
+	// seconds before giving up the command, DefaultExecTimeout if 0
+	Timeout int `json:"Timeout"`
+}
+
+type ExecResult struct {
+	ExecID   string `json:"ExecID"`
+	Stdout   string `json:"Stdout"`
+	Stderr   string `json:"Stderr"`
+	ExitCode int    `json:"ExitCode"`
+	// the output was larger than MaxExecOutput
+	Truncated bool `json:"Truncated"`
+	// the command was still running at the timeout, it is left running as docker can not kill an exec
+	TimedOut bool          `json:"TimedOut"`
+	Duration time.Duration `json:"Duration"`
+}
+
+// keeps the first bytes written and drops the rest, the copy of the output may still write it
+// after the waiter returned on a timeout
+type limitedBuffer struct {
+	mu        sync.Mutex
+	buf       []byte
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if room := b.limit - len(b.buf); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.buf = append(b.buf, p[:room]...)
+		}
+	} else {
+		b.buf = append(b.buf, p...)
+	}
+
+	return len(p), nil
+}
+
+// the bytes kept so far and whether some were dropped
+func (b *limitedBuffer) snapshot() (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return string(b.buf), b.truncated
+}
+
+func (opts ExecOptions) timeout() (time.Duration, error) {
+	if opts.Timeout < 0 || time.Duration(opts.Timeout)*time.Second > MaxExecTimeout {
+		return 0, cranerror.NewError(CodeInvalidExecTimeout, "timeout must be between 0 and "+MaxExecTimeout.String())
+	}
+
+	if opts.Timeout == 0 {
+		return DefaultExecTimeout, nil
+	}
+
+	return time.Duration(opts.Timeout) * time.Second, nil
+}
+
+// run a command in the container of the node stored in ctx and wait for its output and exit code
+func (client *CraneDockerClient) ExecContainer(ctx context.Context, containerId string, opts ExecOptions) (*ExecResult, error) {
+	if len(opts.Cmd) == 0 {
+		return nil, cranerror.NewError(CodeInvalidExecCommand, "command required")
+	}
+
+	timeout, err := opts.timeout()
+	if err != nil {
+		return nil, err
+	}
+
+	return client.ExecContainerWithin(ctx, containerId, opts, timeout)
+}
+
+// run a command as ExecContainer does, for the commands crane runs itself the timeout is not limited to MaxExecTimeout,
+// docker does not stop the command at the timeout
+func (client *CraneDockerClient) ExecContainerWithin(ctx context.Context, containerId string, opts ExecOptions, timeout time.Duration) (*ExecResult, error) {
+	swarmNode, err := client.SwarmNode(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	exec, err := swarmNode.CreateExec(docker.CreateExecOptions{
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          opts.Cmd,
+		Container:    containerId,
+		User:         opts.User,
+	})
+	if err != nil {
+		return nil, ToCraneError(err)
+	}
+
+	start := time.Now()
+	stdout := &limitedBuffer{limit: MaxExecOutput}
+	stderr := &limitedBuffer{limit: MaxExecOutput}
+	waiter, err := swarmNode.StartExecNonBlocking(exec.ID, docker.StartExecOptions{
+		OutputStream: stdout,
+		ErrorStream:  stderr,
+	})
+	if err != nil {
+		return nil, ToCraneError(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- waiter.Wait()
+	}()
+
+	result := &ExecResult{ExecID: exec.ID}
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		result.TimedOut = true
+		waiter.Close()
+		err = <-done
+	}
+	result.Duration = time.Since(start)
+
+	var stdoutTruncated, stderrTruncated bool
+	result.Stdout, stdoutTruncated = stdout.snapshot()
+	result.Stderr, stderrTruncated = stderr.snapshot()
+	result.Truncated = stdoutTruncated || stderrTruncated
+	if result.TimedOut {
+		result.ExitCode = -1
+		return result, nil
+	}
+	if err != nil {
+		return nil, ToCraneError(err)
+	}
+
+	inspect, err := swarmNode.InspectExec(exec.ID)
+	if err != nil {
+		return nil, ToCraneError(err)
+	}
+	result.ExitCode = inspect.ExitCode
+
+	return result, nil
+}
+
+// wait for the exec of the node stored in ctx to end, its state is checked every interval
+func (client *CraneDockerClient) WaitExec(ctx context.Context, execId string, interval time.Duration) error {
+	swarmNode, err := client.SwarmNode(ctx)
+	if err != nil {
+		return err
+	}
+
+	for {
+		inspect, err := swarmNode.InspectExec(execId)
+		if err != nil {
+			return ToCraneError(err)
+		}
+		if !inspect.Running {
+			return nil
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+type TaskExecResult struct {
+	TaskID      string      `json:"TaskID"`
+	NodeID      string      `json:"NodeID"`
+	ContainerID string      `json:"ContainerID"`
+	Result      *ExecResult `json:"Result,omitempty"`
+	Error       string      `json:"Error,omitempty"`
+}
+
+// run a command in the running tasks of a service: the tasks of taskIds, all of them if all is set,
+// or else the first one, the commands run at the same time and the result of each task is returned
+func (client *CraneDockerClient) ExecService(ctx context.Context, serviceId string, opts ExecOptions, taskIds []string, all bool) ([]TaskExecResult, error) {
+	if len(opts.Cmd) == 0 {
+		return nil, cranerror.NewError(CodeInvalidExecCommand, "command required")
+	}
+
+	if _, err := opts.timeout(); err != nil {
+		return nil, err
+	}
+
+	taskFilter := filters.NewArgs()
+	taskFilter.Add("service", serviceId)
+	taskFilter.Add("desired-state", string(swarm.TaskStateRunning))
+	tasks, err := client.ListTasks(types.TaskListOptions{Filter: taskFilter})
+	if err != nil {
+		return nil, err
+	}
+
+	running := make(map[string]swarm.Task)
+	var runningIds []string
+	for _, task := range tasks {
+		if task.Status.State == swarm.TaskStateRunning && task.Status.ContainerStatus.ContainerID != "" {
+			running[task.ID] = task
+			runningIds = append(runningIds, task.ID)
+		}
+	}
+	if len(runningIds) == 0 {
+		return nil, cranerror.NewError(CodeServiceNoRunningTask, "service has no running task")
+	}
+
+	var results []TaskExecResult
+	if len(taskIds) > 0 {
+		for _, id := range taskIds {
+			results = append(results, TaskExecResult{TaskID: id})
+		}
+	} else {
+		sort.Slice(runningIds, func(i, j int) bool {
+			return running[runningIds[i]].Slot < running[runningIds[j]].Slot
+		})
+		if !all {
+			runningIds = runningIds[:1]
+		}
+		for _, id := range runningIds {
+			results = append(results, TaskExecResult{TaskID: id})
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := range results {
+		task, ok := running[results[i].TaskID]
+		if !ok {
+			results[i].Error = errExecTaskNotRunning.Error()
+			continue
+		}
+
+		results[i].NodeID = task.NodeID
+		results[i].ContainerID = task.Status.ContainerStatus.ContainerID
+		wg.Add(1)
+		go func(result *TaskExecResult) {
+			defer wg.Done()
+
+			nodeContext := context.WithValue(ctx, "node_id", result.NodeID)
+			execResult, err := client.ExecContainer(nodeContext, result.ContainerID, opts)
+			if err != nil {
+				result.Error = err.Error()
+				return
+			}
+			result.Result = execResult
+		}(&results[i])
+	}
+	wg.Wait()
+
+	return results, nil
+}
@@ -0,0 +1,279 @@
+package dockerclient
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/swarm"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func TestCreateExecTerminal(t *testing.T) {
+	var execCmd []string
+	var execUser, resized string
+	nodeRouter := gin.New()
+	nodeRouter.GET("/info", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, types.Info{Swarm: swarm.Info{NodeID: "node1"}})
+	})
+	nodeRouter.GET("/version", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, map[string]string{"ApiVersion": "1.24"})
+	})
+	nodeRouter.POST("/containers/:id/exec", func(ctx *gin.Context) {
+		var opts struct {
+			Cmd  []string
+			User string
+		}
+		ctx.BindJSON(&opts)
+		execCmd, execUser = opts.Cmd, opts.User
+		ctx.JSON(http.StatusCreated, map[string]string{"Id": "exec1"})
+	})
+	nodeRouter.POST("/exec/:id/start", func(ctx *gin.Context) {
+		conn, rw, err := ctx.Writer.Hijack()
+		assert.Nil(t, err)
+		defer conn.Close()
+
+		rw.WriteString("HTTP/1.1 200 OK\r\nContent-Type: application/vnd.docker.raw-stream\r\n\r\n")
+		rw.Flush()
+
+		// echo one line of input
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		conn.Write([]byte(line))
+	})
+	nodeRouter.POST("/exec/:id/resize", func(ctx *gin.Context) {
+		resized = ctx.Query("h") + "x" + ctx.Query("w")
+		ctx.Status(http.StatusOK)
+	})
+	nodeServer := httptest.NewServer(nodeRouter)
+	defer nodeServer.Close()
+
+	managerRouter := gin.New()
+	managerRouter.GET("/nodes/node1", func(ctx *gin.Context) {
+		var node swarm.Node
+		node.ID = "node1"
+		node.Spec.Annotations.Labels = map[string]string{LabelNodeEndpoint: nodeServer.URL}
+		ctx.JSON(http.StatusOK, node)
+	})
+	manager := httptest.NewServer(managerRouter)
+	defer manager.Close()
+
+	httpClient, err := NewHttpClient()
+	assert.Nil(t, err)
+	client := &CraneDockerClient{
+		sharedHttpClient:         httpClient,
+		swarmManagerHttpEndpoint: manager.URL,
+	}
+
+	craneContext := context.WithValue(context.Background(), "node_id", "node1")
+	terminal, err := client.CreateExecTerminal(craneContext, "container1", nil, "nobody")
+	assert.Nil(t, err)
+	defer terminal.Close()
+
+	assert.Equal(t, DefaultTerminalCommand, execCmd)
+	assert.Equal(t, "nobody", execUser)
+	assert.Equal(t, "sh", terminal.Command())
+	assert.Equal(t, "exec1", terminal.ID())
+
+	_, err = terminal.Write([]byte("ls\n"))
+	assert.Nil(t, err)
+	output := make([]byte, 3)
+	_, err = io.ReadFull(terminal, output)
+	assert.Nil(t, err)
+	assert.Equal(t, "ls\n", string(output))
+
+	assert.Nil(t, terminal.Resize(24, 80))
+	assert.Equal(t, "24x80", resized)
+}
+
+func stdcopyFrame(stream byte, data string) []byte {
+	size := len(data)
+	header := []byte{stream, 0, 0, 0, byte(size >> 24), byte(size >> 16), byte(size >> 8), byte(size)}
+	return append(header, data...)
+}
+
+func testExecNode(t *testing.T, block chan struct{}) *httptest.Server {
+	nodeRouter := gin.New()
+	nodeRouter.GET("/info", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, types.Info{Swarm: swarm.Info{NodeID: "node1"}})
+	})
+	nodeRouter.GET("/version", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, map[string]string{"ApiVersion": "1.24"})
+	})
+	nodeRouter.POST("/containers/:id/exec", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusCreated, map[string]string{"Id": "exec-" + ctx.Param("id")})
+	})
+	nodeRouter.POST("/exec/:id/start", func(ctx *gin.Context) {
+		conn, rw, err := ctx.Writer.Hijack()
+		assert.Nil(t, err)
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 200 OK\r\nContent-Type: application/vnd.docker.raw-stream\r\n\r\n")
+		rw.Write(stdcopyFrame(1, "migrated\n"))
+		rw.Write(stdcopyFrame(2, "warning\n"))
+		rw.Flush()
+		if block != nil {
+			<-block
+		}
+	})
+	nodeRouter.GET("/exec/:id/json", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, map[string]interface{}{"ID": ctx.Param("id"), "ExitCode": 3})
+	})
+
+	return httptest.NewServer(nodeRouter)
+}
+
+func testNodeClient(t *testing.T, nodeServer *httptest.Server) (*CraneDockerClient, func()) {
+	managerRouter := gin.New()
+	managerRouter.GET("/nodes/:id", func(ctx *gin.Context) {
+		var node swarm.Node
+		node.ID = ctx.Param("id")
+		node.Spec.Annotations.Labels = map[string]string{LabelNodeEndpoint: nodeServer.URL}
+		ctx.JSON(http.StatusOK, node)
+	})
+	manager := httptest.NewServer(managerRouter)
+
+	httpClient, err := NewHttpClient()
+	assert.Nil(t, err)
+	return &CraneDockerClient{
+		sharedHttpClient:         httpClient,
+		swarmManagerHttpEndpoint: manager.URL,
+	}, manager.Close
+}
+
+func TestExecContainer(t *testing.T) {
+	nodeServer := testExecNode(t, nil)
+	defer nodeServer.Close()
+	client, closeManager := testNodeClient(t, nodeServer)
+	defer closeManager()
+
+	craneContext := context.WithValue(context.Background(), "node_id", "node1")
+	_, err := client.ExecContainer(craneContext, "container1", ExecOptions{})
+	assert.NotNil(t, err)
+	_, err = client.ExecContainer(craneContext, "container1", ExecOptions{Cmd: []string{"true"}, Timeout: 3600})
+	assert.NotNil(t, err)
+
+	result, err := client.ExecContainer(craneContext, "container1", ExecOptions{Cmd: []string{"migrate"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "exec-container1", result.ExecID)
+	assert.Equal(t, "migrated\n", result.Stdout)
+	assert.Equal(t, "warning\n", result.Stderr)
+	assert.Equal(t, 3, result.ExitCode)
+	assert.False(t, result.TimedOut)
+}
+
+func TestExecContainerTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	nodeServer := testExecNode(t, block)
+	defer nodeServer.Close()
+	client, closeManager := testNodeClient(t, nodeServer)
+	defer closeManager()
+
+	craneContext := context.WithValue(context.Background(), "node_id", "node1")
+	result, err := client.ExecContainer(craneContext, "container1", ExecOptions{Cmd: []string{"sleep", "60"}, Timeout: 1})
+	assert.Nil(t, err)
+	assert.True(t, result.TimedOut)
+	assert.Equal(t, -1, result.ExitCode)
+	assert.Equal(t, "migrated\n", result.Stdout)
+}
+
+func TestExecContainerWithin(t *testing.T) {
+	nodeServer := testExecNode(t, nil)
+	defer nodeServer.Close()
+	client, closeManager := testNodeClient(t, nodeServer)
+	defer closeManager()
+
+	// the timeout of crane's own commands is not limited
+	craneContext := context.WithValue(context.Background(), "node_id", "node1")
+	result, err := client.ExecContainerWithin(craneContext, "container1", ExecOptions{Cmd: []string{"migrate"}}, 2*MaxExecTimeout)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, result.ExitCode)
+}
+
+func TestWaitExec(t *testing.T) {
+	inspects := 0
+	nodeRouter := gin.New()
+	nodeRouter.GET("/info", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, types.Info{Swarm: swarm.Info{NodeID: "node1"}})
+	})
+	nodeRouter.GET("/version", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, map[string]string{"ApiVersion": "1.24"})
+	})
+	nodeRouter.GET("/exec/:id/json", func(ctx *gin.Context) {
+		inspects++
+		ctx.JSON(http.StatusOK, map[string]interface{}{"ID": ctx.Param("id"), "Running": inspects < 3})
+	})
+	nodeServer := httptest.NewServer(nodeRouter)
+	defer nodeServer.Close()
+	client, closeManager := testNodeClient(t, nodeServer)
+	defer closeManager()
+
+	craneContext := context.WithValue(context.Background(), "node_id", "node1")
+	assert.Nil(t, client.WaitExec(craneContext, "exec1", time.Millisecond))
+	assert.Equal(t, 3, inspects)
+
+	nodeServer.Close()
+	assert.NotNil(t, client.WaitExec(craneContext, "exec1", time.Millisecond))
+}
+
+func TestExecService(t *testing.T) {
+	nodeServer := testExecNode(t, nil)
+	defer nodeServer.Close()
+	client, closeManager := testNodeClient(t, nodeServer)
+	defer closeManager()
+
+	snapshot := testSwarmSnapshot()
+	snapshot.nodes[0].Spec.Annotations.Labels = map[string]string{LabelNodeEndpoint: nodeServer.URL}
+	snapshot.tasks = Tasks{
+		{ID: "task1", ServiceID: "service1", NodeID: "node1", Slot: 2, DesiredState: swarm.TaskStateRunning},
+		{ID: "task2", ServiceID: "service1", NodeID: "node1", Slot: 1, DesiredState: swarm.TaskStateRunning},
+		{ID: "task3", ServiceID: "service1", NodeID: "node1", Slot: 3, DesiredState: swarm.TaskStateRunning},
+	}
+	snapshot.tasks[0].Status.State = swarm.TaskStateRunning
+	snapshot.tasks[0].Status.ContainerStatus.ContainerID = "container1"
+	snapshot.tasks[1].Status.State = swarm.TaskStateRunning
+	snapshot.tasks[1].Status.ContainerStatus.ContainerID = "container2"
+	snapshot.tasks[2].Status.State = swarm.TaskStatePreparing
+	client.swarmCache = newSwarmCache(time.Minute, func() (*swarmSnapshot, error) {
+		return snapshot, nil
+	})
+	assert.Nil(t, client.swarmCache.Sync())
+
+	opts := ExecOptions{Cmd: []string{"flush"}}
+	results, err := client.ExecService(context.Background(), "service1", opts, nil, false)
+	assert.Nil(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, "task2", results[0].TaskID)
+	assert.Empty(t, results[0].Error)
+	assert.Equal(t, "exec-container2", results[0].Result.ExecID)
+
+	results, err = client.ExecService(context.Background(), "service1", opts, nil, true)
+	assert.Nil(t, err)
+	assert.Len(t, results, 2)
+
+	results, err = client.ExecService(context.Background(), "service1", opts, []string{"task1", "task3"}, false)
+	assert.Nil(t, err)
+	assert.Len(t, results, 2)
+	assert.Equal(t, "container1", results[0].ContainerID)
+	assert.Equal(t, 3, results[0].Result.ExitCode)
+	assert.Equal(t, errExecTaskNotRunning.Error(), results[1].Error)
+
+	_, err = client.ExecService(context.Background(), "service2", opts, nil, true)
+	assert.NotNil(t, err)
+}
+
+func TestLimitedBuffer(t *testing.T) {
+	buf := &limitedBuffer{limit: 4}
+	n, _ := buf.Write([]byte("abc"))
+	assert.Equal(t, 3, n)
+	n, _ = buf.Write([]byte("def"))
+	assert.Equal(t, 3, n)
+	output, truncated := buf.snapshot()
+	assert.Equal(t, "abcd", output)
+	assert.True(t, truncated)
+}
@@ -1,10 +1,68 @@
 package dockerclient
 
 import (
+	"encoding/json"
+	"io"
+	"regexp"
+	"sync"
+
+	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
+
 	docker "github.com/Dataman-Cloud/go-dockerclient"
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/engine-api/types/swarm"
 	"golang.org/x/net/context"
 )
 
+var (
+	// [host[:port]/]path[/path...], the path components as the docker distribution reference grammar
+	imageRepositoryPattern = regexp.MustCompile(`^([a-zA-Z0-9.-]+(:[0-9]+)?/)?[a-z0-9]+([._-]+[a-z0-9]+)*(/[a-z0-9]+([._-]+[a-z0-9]+)*)*$`)
+	imageTagPattern        = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	imageDigestPattern     = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+)
+
+// ImageProgress is a progress message of the docker daemon pushing, pulling or building an image
+type ImageProgress struct {
+	Stream         string           `json:"stream,omitempty"`
+	ID             string           `json:"id,omitempty"`
+	Status         string           `json:"status,omitempty"`
+	Progress       string           `json:"progress,omitempty"`
+	ProgressDetail *ProgressDetail  `json:"progressDetail,omitempty"`
+	Aux            *json.RawMessage `json:"aux,omitempty"`
+	Error          string           `json:"error,omitempty"`
+}
+
+type ProgressDetail struct {
+	Current int64 `json:"current,omitempty"`
+	Total   int64 `json:"total,omitempty"`
+}
+
+// NodeImageProgress is a progress message of the docker daemon of a node
+type NodeImageProgress struct {
+	NodeID string `json:"NodeID"`
+	ImageProgress
+}
+
+// NodePullResult is the end of the pull of an image on a node
+type NodePullResult struct {
+	NodeID   string `json:"NodeID"`
+	Hostname string `json:"Hostname"`
+	Error    string `json:"Error,omitempty"`
+}
+
+// check the repository and the tag an image is named with, an empty tag is latest, a digest is accepted as the tag
+func ValidateImageReference(repository, tag string) error {
+	if !imageRepositoryPattern.MatchString(repository) {
+		return cranerror.NewError(CodeInvalidImageRepository, "invalid image repository: "+repository)
+	}
+
+	if tag != "" && !imageTagPattern.MatchString(tag) && !imageDigestPattern.MatchString(tag) {
+		return cranerror.NewError(CodeInvalidImageRepository, "invalid image tag: "+tag)
+	}
+
+	return nil
+}
+
 func (client *CraneDockerClient) ListImages(ctx context.Context, opts docker.ListImagesOptions) ([]docker.APIImages, error) {
 	swarmNode, err := client.SwarmNode(ctx)
 	if err != nil {
@@ -37,3 +95,143 @@ func (client *CraneDockerClient) RemoveImage(ctx context.Context, imageID string
 	}
 	return swarmNode.RemoveImage(imageID)
 }
+
+// PushImage pushes the image of the node stored in ctx to its registry with auth,
+// the progress messages of the daemon are sent to progress, the digest pushed is returned
+func (client *CraneDockerClient) PushImage(ctx context.Context, opts docker.PushImageOptions, auth docker.AuthConfiguration, progress chan<- ImageProgress) (string, error) {
+	swarmNode, err := client.SwarmNode(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	reader, writer := io.Pipe()
+	opts.OutputStream = writer
+	opts.RawJSONStream = true
+	go func() {
+		writer.CloseWithError(swarmNode.PushImage(opts, auth))
+	}()
+
+	return readImageProgress(reader, progress, CodePushImageError)
+}
+
+// PullImage pulls the image on the node stored in ctx with auth,
+// the progress messages of the daemon are sent to progress
+func (client *CraneDockerClient) PullImage(ctx context.Context, opts docker.PullImageOptions, auth docker.AuthConfiguration, progress chan<- ImageProgress) error {
+	if err := ValidateImageReference(opts.Repository, opts.Tag); err != nil {
+		return err
+	}
+
+	swarmNode, err := client.SwarmNode(ctx)
+	if err != nil {
+		return err
+	}
+
+	reader, writer := io.Pipe()
+	opts.OutputStream = writer
+	opts.RawJSONStream = true
+	go func() {
+		writer.CloseWithError(swarmNode.PullImage(opts, auth))
+	}()
+
+	_, err = readImageProgress(reader, progress, CodePullImageError)
+	return err
+}
+
+// PrePullImage pulls the image on the nodes at the same time,
+// the progress messages of all the nodes are sent to progress and the result of each node is returned
+func (client *CraneDockerClient) PrePullImage(ctx context.Context, opts docker.PullImageOptions, auth docker.AuthConfiguration, nodes []swarm.Node, progress chan<- NodeImageProgress) []NodePullResult {
+	var results []NodePullResult
+	for _, node := range nodes {
+		results = append(results, NodePullResult{NodeID: node.ID, Hostname: node.Description.Hostname})
+	}
+
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(result *NodePullResult) {
+			defer wg.Done()
+
+			nodeProgress := make(chan ImageProgress)
+			forwarded := make(chan struct{})
+			go func() {
+				for message := range nodeProgress {
+					if progress != nil {
+						progress <- NodeImageProgress{NodeID: result.NodeID, ImageProgress: message}
+					}
+				}
+				close(forwarded)
+			}()
+
+			nodeContext := context.WithValue(ctx, "node_id", result.NodeID)
+			err := client.PullImage(nodeContext, opts, auth, nodeProgress)
+			close(nodeProgress)
+			<-forwarded
+			if err != nil {
+				log.Errorf("pull image %s:%s on node %s got error: %s", opts.Repository, opts.Tag, result.NodeID, err.Error())
+				result.Error = err.Error()
+			}
+		}(&results[i])
+	}
+	wg.Wait()
+
+	return results
+}
+
+// BuildImage builds the image on the node stored in ctx from the context of opts.InputStream,
+// the build log messages of the daemon are sent to progress
+func (client *CraneDockerClient) BuildImage(ctx context.Context, opts docker.BuildImageOptions, progress chan<- ImageProgress) error {
+	if err := ValidateImageReference(docker.ParseRepositoryTag(opts.Name)); err != nil {
+		return err
+	}
+
+	swarmNode, err := client.SwarmNode(ctx)
+	if err != nil {
+		return err
+	}
+
+	reader, writer := io.Pipe()
+	opts.OutputStream = writer
+	opts.RawJSONStream = true
+	go func() {
+		writer.CloseWithError(swarmNode.BuildImage(opts))
+	}()
+
+	_, err = readImageProgress(reader, progress, CodeBuildImageError)
+	return err
+}
+
+// forward the json messages of the daemon read from r to progress until the end of the stream,
+// an error message is returned as errCode, so is the digest of the aux messages
+func readImageProgress(r io.ReadCloser, progress chan<- ImageProgress, errCode string) (string, error) {
+	defer r.Close()
+
+	var digest string
+	decoder := json.NewDecoder(r)
+	for {
+		var message ImageProgress
+		if err := decoder.Decode(&message); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+
+		if message.Error != "" {
+			return "", cranerror.NewError(errCode, message.Error)
+		}
+
+		if message.Aux != nil {
+			var aux struct {
+				Digest string
+			}
+			if err := json.Unmarshal(*message.Aux, &aux); err == nil && aux.Digest != "" {
+				digest = aux.Digest
+			}
+		}
+
+		if progress != nil {
+			progress <- message
+		}
+	}
+
+	return digest, nil
+}
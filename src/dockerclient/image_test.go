@@ -1,10 +1,20 @@
 package dockerclient
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	docker "github.com/Dataman-Cloud/go-dockerclient"
+	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/swarm"
+	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/net/context"
 )
@@ -68,3 +78,230 @@ func TestImage(t *testing.T) {
 	err = craneClient.RemoveImage(craneContext, imageId)
 	assert.Nil(t, err)
 }
+
+func TestValidateImageReference(t *testing.T) {
+	assert.Nil(t, ValidateImageReference("nginx", ""))
+	assert.Nil(t, ValidateImageReference("registry.crane.io:5000/test_namespace/nginx-debug", "1.0_rc"))
+	assert.NotNil(t, ValidateImageReference("", "latest"))
+	assert.NotNil(t, ValidateImageReference("registry/Nginx", "latest"))
+	assert.NotNil(t, ValidateImageReference("registry/nginx", ".latest"))
+}
+
+func TestCommitAndPushImage(t *testing.T) {
+	var commitQuery map[string][]string
+	var pushAuth docker.AuthConfiguration
+	nodeRouter := gin.New()
+	nodeRouter.GET("/info", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, types.Info{Swarm: swarm.Info{NodeID: "node1"}})
+	})
+	nodeRouter.GET("/version", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, map[string]string{"ApiVersion": "1.24"})
+	})
+	nodeRouter.POST("/commit", func(ctx *gin.Context) {
+		commitQuery = ctx.Request.URL.Query()
+		ctx.JSON(http.StatusCreated, map[string]string{"Id": "sha256:c0ffee"})
+	})
+	nodeRouter.NoRoute(func(ctx *gin.Context) {
+		if !strings.HasPrefix(ctx.Request.URL.Path, "/images/") || !strings.HasSuffix(ctx.Request.URL.Path, "/push") {
+			ctx.Status(http.StatusNotFound)
+			return
+		}
+		authJSON, _ := base64.URLEncoding.DecodeString(ctx.Request.Header.Get("X-Registry-Auth"))
+		json.Unmarshal(authJSON, &pushAuth)
+
+		ctx.Status(http.StatusOK)
+		encoder := json.NewEncoder(ctx.Writer)
+		encoder.Encode(ImageProgress{Status: "The push refers to a repository [registry:5000/test_namespace/nginx]"})
+		encoder.Encode(ImageProgress{ID: "5f70bf18a086", Status: "Pushing", ProgressDetail: &ProgressDetail{Current: 512, Total: 1024}})
+		if ctx.Query("tag") == "broken" {
+			encoder.Encode(ImageProgress{Error: "unauthorized: authentication required"})
+			return
+		}
+		aux := json.RawMessage(`{"Tag":"v1","Digest":"sha256:d1ge57","Size":1024}`)
+		encoder.Encode(ImageProgress{Status: "v1: digest: sha256:d1ge57 size: 1024", Aux: &aux})
+	})
+	nodeServer := httptest.NewServer(nodeRouter)
+	defer nodeServer.Close()
+	client, closeManager := testNodeClient(t, nodeServer)
+	defer closeManager()
+
+	craneContext := context.WithValue(context.Background(), "node_id", "node1")
+	_, err := client.CommitContainer(craneContext, docker.CommitContainerOptions{Container: "container1", Repository: "registry:5000/Test"})
+	assert.NotNil(t, err)
+
+	image, err := client.CommitContainer(craneContext, docker.CommitContainerOptions{
+		Container:  "container1",
+		Repository: "registry:5000/test_namespace/nginx",
+		Tag:        "v1",
+		Message:    "debug tools",
+		Author:     "test@test.com",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "sha256:c0ffee", image.ID)
+	assert.Equal(t, "registry:5000/test_namespace/nginx", commitQuery["repo"][0])
+	assert.Equal(t, "debug tools", commitQuery["comment"][0])
+	assert.Equal(t, "test@test.com", commitQuery["author"][0])
+
+	progress := make(chan ImageProgress, 10)
+	auth := docker.AuthConfiguration{Username: "test_namespace", Password: "credential", ServerAddress: "registry:5000"}
+	digest, err := client.PushImage(craneContext, docker.PushImageOptions{Name: "registry:5000/test_namespace/nginx", Tag: "v1"}, auth, progress)
+	assert.Nil(t, err)
+	assert.Equal(t, "sha256:d1ge57", digest)
+	assert.Equal(t, "credential", pushAuth.Password)
+	assert.Len(t, progress, 3)
+	<-progress
+	assert.Equal(t, int64(512), (<-progress).ProgressDetail.Current)
+
+	_, err = client.PushImage(craneContext, docker.PushImageOptions{Name: "registry:5000/test_namespace/nginx", Tag: "broken"}, auth, nil)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "unauthorized")
+}
+
+func TestBuildImage(t *testing.T) {
+	var buildQuery map[string][]string
+	var buildContext []byte
+	nodeRouter := gin.New()
+	nodeRouter.GET("/info", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, types.Info{Swarm: swarm.Info{NodeID: "node1"}})
+	})
+	nodeRouter.GET("/version", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, map[string]string{"ApiVersion": "1.24"})
+	})
+	nodeRouter.POST("/build", func(ctx *gin.Context) {
+		buildQuery = ctx.Request.URL.Query()
+		buildContext, _ = ioutil.ReadAll(ctx.Request.Body)
+
+		ctx.Status(http.StatusOK)
+		encoder := json.NewEncoder(ctx.Writer)
+		encoder.Encode(ImageProgress{Stream: "Step 1/2 : FROM nginx\n"})
+		if ctx.Query("dockerfile") == "broken/Dockerfile" {
+			encoder.Encode(ImageProgress{Error: "The command '/bin/sh -c make' returned a non-zero code: 2"})
+			return
+		}
+		encoder.Encode(ImageProgress{Stream: "Step 2/2 : COPY . /usr/share/nginx/html\n"})
+		encoder.Encode(ImageProgress{Stream: "Successfully built c0ffee\n"})
+	})
+	nodeServer := httptest.NewServer(nodeRouter)
+	defer nodeServer.Close()
+	client, closeManager := testNodeClient(t, nodeServer)
+	defer closeManager()
+
+	craneContext := context.WithValue(context.Background(), "node_id", "node1")
+	err := client.BuildImage(craneContext, docker.BuildImageOptions{Name: "registry:5000/Test:v1", InputStream: strings.NewReader("context")}, nil)
+	assert.NotNil(t, err)
+
+	progress := make(chan ImageProgress, 10)
+	err = client.BuildImage(craneContext, docker.BuildImageOptions{
+		Name:        "registry:5000/test_namespace/nginx:v1",
+		Dockerfile:  "web/Dockerfile",
+		BuildArgs:   []docker.BuildArg{{Name: "VERSION", Value: "1.0"}},
+		InputStream: strings.NewReader("context"),
+	}, progress)
+	assert.Nil(t, err)
+	assert.Equal(t, "registry:5000/test_namespace/nginx:v1", buildQuery["t"][0])
+	assert.Equal(t, "web/Dockerfile", buildQuery["dockerfile"][0])
+	assert.Contains(t, buildQuery["buildargs"][0], "VERSION")
+	assert.Equal(t, "context", string(buildContext))
+	assert.Len(t, progress, 3)
+	assert.Equal(t, "Step 1/2 : FROM nginx\n", (<-progress).Stream)
+
+	err = client.BuildImage(craneContext, docker.BuildImageOptions{
+		Name:        "registry:5000/test_namespace/nginx:v1",
+		Dockerfile:  "broken/Dockerfile",
+		InputStream: strings.NewReader("context"),
+	}, nil)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "non-zero code")
+}
+
+func testPullNode(t *testing.T, pulled chan string) *httptest.Server {
+	nodeRouter := gin.New()
+	nodeRouter.GET("/info", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, types.Info{Swarm: swarm.Info{NodeID: "node1"}})
+	})
+	nodeRouter.GET("/version", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, map[string]string{"ApiVersion": "1.24"})
+	})
+	nodeRouter.POST("/images/create", func(ctx *gin.Context) {
+		pulled <- ctx.Query("fromImage") + ":" + ctx.Query("tag")
+
+		ctx.Status(http.StatusOK)
+		encoder := json.NewEncoder(ctx.Writer)
+		encoder.Encode(ImageProgress{Status: "Pulling from library/nginx", ID: ctx.Query("tag")})
+		if ctx.Query("fromImage") == "private/nginx" {
+			encoder.Encode(ImageProgress{Error: "unauthorized: authentication required"})
+			return
+		}
+		encoder.Encode(ImageProgress{ID: "5f70bf18a086", Status: "Downloading", ProgressDetail: &ProgressDetail{Current: 512, Total: 1024}})
+		encoder.Encode(ImageProgress{ID: "5f70bf18a086", Status: "Pull complete"})
+	})
+
+	return httptest.NewServer(nodeRouter)
+}
+
+func TestPullImage(t *testing.T) {
+	pulled := make(chan string, 10)
+	nodeServer := testPullNode(t, pulled)
+	defer nodeServer.Close()
+	client, closeManager := testNodeClient(t, nodeServer)
+	defer closeManager()
+
+	craneContext := context.WithValue(context.Background(), "node_id", "node1")
+	progress := make(chan ImageProgress, 10)
+	err := client.PullImage(craneContext, docker.PullImageOptions{Repository: "nginx", Tag: "1.11"}, docker.AuthConfiguration{}, progress)
+	assert.Nil(t, err)
+	assert.Equal(t, "nginx:1.11", <-pulled)
+	assert.Len(t, progress, 3)
+
+	err = client.PullImage(craneContext, docker.PullImageOptions{Repository: "private/nginx", Tag: "latest"}, docker.AuthConfiguration{}, nil)
+	assert.NotNil(t, err)
+	assert.Equal(t, "private/nginx:latest", <-pulled)
+
+	err = client.PullImage(craneContext, docker.PullImageOptions{Repository: "Nginx"}, docker.AuthConfiguration{}, nil)
+	assert.NotNil(t, err)
+}
+
+func TestPrePullImage(t *testing.T) {
+	pulled := make(chan string, 10)
+	nodeServer := testPullNode(t, pulled)
+	defer nodeServer.Close()
+	client, closeManager := testNodeClient(t, nodeServer)
+	defer closeManager()
+
+	var node1, node2, node3 swarm.Node
+	node1.ID, node1.Description.Hostname = "node1", "host1"
+	node2.ID, node2.Description.Hostname = "node2", "host2"
+	node3.ID, node3.Description.Hostname = "node3", "host3"
+	node1.Status.State, node2.Status.State, node3.Status.State = swarm.NodeStateReady, swarm.NodeStateReady, swarm.NodeStateDown
+	node2.Spec.Availability = swarm.NodeAvailabilityDrain
+	for _, node := range []*swarm.Node{&node1, &node2, &node3} {
+		node.Spec.Annotations.Labels = map[string]string{LabelNodeEndpoint: nodeServer.URL, "zone": "a"}
+	}
+	snapshot := testSwarmSnapshot()
+	snapshot.nodes = []swarm.Node{node1, node2, node3}
+	client.swarmCache = newSwarmCache(time.Minute, func() (*swarmSnapshot, error) {
+		return snapshot, nil
+	})
+	assert.Nil(t, client.swarmCache.Sync())
+
+	_, err := client.ReadyNodesMatching([]string{"node.labels.zone==b"})
+	assert.NotNil(t, err)
+	_, err = client.ReadyNodesMatching([]string{"node.zone==a"})
+	assert.NotNil(t, err)
+	nodes, err := client.ReadyNodesMatching([]string{"node.labels.zone==a"})
+	assert.Nil(t, err)
+	assert.Len(t, nodes, 1)
+	// the endpoint of node3 answers as node1, its pull fails alone
+	nodes = append(nodes, node3)
+
+	progress := make(chan NodeImageProgress, 10)
+	results := client.PrePullImage(context.Background(), docker.PullImageOptions{Repository: "nginx", Tag: "1.11"}, docker.AuthConfiguration{}, nodes, progress)
+	assert.Len(t, results, 2)
+	assert.Equal(t, "host1", results[0].Hostname)
+	assert.Empty(t, results[0].Error)
+	assert.Equal(t, "node3", results[1].NodeID)
+	assert.NotEmpty(t, results[1].Error)
+	assert.Len(t, progress, 3)
+	assert.Equal(t, "node1", (<-progress).NodeID)
+	assert.Equal(t, "nginx:1.11", <-pulled)
+}
@@ -15,6 +15,9 @@ import (
 type DockerClientInterface interface {
 	Ping() error
 	InspectSwarm() (swarm.Swarm, error)
+	InitSwarm(opts node.SwarmInitOptions) (string, error)
+	RotateJoinToken(opts node.SwarmRotateOptions) (swarm.JoinTokens, error)
+	UpdateSwarm(settings node.SwarmSettings) error
 	ManagerInfo() (types.Info, error)
 
 	Info(nodeId string) (types.Info, error)
@@ -36,7 +39,7 @@ type DockerClientInterface interface {
 	DisconnectNetwork(id string, opts docker.NetworkConnectionOptions) error
 	InspectNetwork(id string) (*docker.Network, error)
 	ListNetworks(opts docker.NetworkFilterOpts) ([]docker.Network, error)
-	RemoveNetwork(id string) error
+	RemoveNetwork(id string, force bool) error
 
 	CreateNodeNetwork(ctx context.Context, opts docker.CreateNetworkOptions) (*docker.Network, error)
 	ConnectNodeNetwork(ctx context.Context, networkID string, opts docker.NetworkConnectionOptions) error
@@ -57,7 +60,7 @@ type DockerClientInterface interface {
 	ListStack() ([]Stack, error)
 	ListStackService(namespace string, opts types.ServiceListOptions) ([]ServiceStatus, error)
 	InspectStack(namespace string) (*model.Bundle, error)
-	RemoveStack(namespace string) error
+	RemoveStack(namespace string, force bool) error
 	FilterServiceByStack(namespace string, opts types.ServiceListOptions) ([]swarm.Service, error)
 	ToCraneServiceSpec(swarmService swarm.ServiceSpec) model.CraneServiceSpec
 	GetStackGroup(bundle *model.Bundle) (uint64, error)
@@ -70,6 +73,7 @@ type DockerClientInterface interface {
 	UpdateService(serviceID string, version swarm.Version, service swarm.ServiceSpec, header map[string][]string) error
 	ScaleService(serviceID string, serviceScale ServiceScale) error
 	InspectServiceWithRaw(serviceID string) (swarm.Service, error)
+	InspectServiceUncached(serviceID string) (swarm.Service, error)
 	ServiceAddLabel(serviceID string, labels map[string]string) error
 	ServiceRemoveLabel(serviceID string, labels []string) error
 	GetServiceNetworkNames(networkAttachmentConfigs []swarm.NetworkAttachmentConfig) []string
@@ -0,0 +1,334 @@
+package dockerclient
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
+
+	docker "github.com/Dataman-Cloud/go-dockerclient"
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// prefix size of the subnets allocated from the pools if not configured
+	DefaultNetworkPoolPrefixSize = 24
+	// the smallest subnet allocated keeps room for the network, the gateway and the broadcast addresses
+	maxNetworkPoolPrefixSize = 29
+)
+
+// SubnetAllocation is a subnet of a network of the cluster
+type SubnetAllocation struct {
+	Subnet      string `json:"Subnet"`
+	NetworkID   string `json:"NetworkID"`
+	NetworkName string `json:"NetworkName"`
+	Namespace   string `json:"Namespace,omitempty"`
+}
+
+// NetworkPoolUsage is a configured pool with the subnets allocated in it and the free space left
+type NetworkPoolUsage struct {
+	CIDR        string             `json:"CIDR"`
+	Allocations []SubnetAllocation `json:"Allocations"`
+	// subnets of the prefix size still allocatable
+	FreeSubnets uint64 `json:"FreeSubnets"`
+	// addresses of the pool not covered by an allocation
+	FreeAddresses uint64 `json:"FreeAddresses"`
+}
+
+// NetworkPoolReport lists the subnets of the pools and the ones of the networks outside of the pools
+type NetworkPoolReport struct {
+	PrefixSize int                `json:"PrefixSize"`
+	Pools      []NetworkPoolUsage `json:"Pools"`
+	Outside    []SubnetAllocation `json:"Outside"`
+}
+
+type networkPool struct {
+	cidr       *net.IPNet
+	prefixSize int
+}
+
+// the subnets candidate for an allocation
+func (pool networkPool) size() uint64 {
+	ones, _ := pool.cidr.Mask.Size()
+	return 1 << uint(pool.prefixSize-ones)
+}
+
+func (pool networkPool) subnet(i uint64) *net.IPNet {
+	start := binary.BigEndian.Uint32(pool.cidr.IP.To4()) + uint32(i<<uint(32-pool.prefixSize))
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, start)
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(pool.prefixSize, 32)}
+}
+
+// the pools of the config, nil if crane does not allocate the subnets
+func (client *CraneDockerClient) networkPools() ([]networkPool, error) {
+	if client.config == nil || len(client.config.NetworkPoolCIDRs) == 0 {
+		return nil, nil
+	}
+
+	prefixSize := client.config.NetworkPoolPrefixSize
+	if prefixSize == 0 {
+		prefixSize = DefaultNetworkPoolPrefixSize
+	}
+
+	return parseNetworkPools(client.config.NetworkPoolCIDRs, prefixSize)
+}
+
+func parseNetworkPools(cidrs []string, prefixSize int) ([]networkPool, error) {
+	if prefixSize <= 0 || prefixSize > maxNetworkPoolPrefixSize {
+		return nil, cranerror.NewError(CodeInvalidNetworkPool, fmt.Sprintf("invalid prefix size %d", prefixSize))
+	}
+
+	var pools []networkPool
+	for _, cidr := range cidrs {
+		if cidr == "" {
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil || ipNet.IP.To4() == nil {
+			return nil, cranerror.NewError(CodeInvalidNetworkPool, "invalid IPv4 CIDR "+cidr)
+		}
+
+		if ones, _ := ipNet.Mask.Size(); ones > prefixSize {
+			return nil, cranerror.NewError(CodeInvalidNetworkPool, fmt.Sprintf("%s smaller than a /%d", cidr, prefixSize))
+		}
+
+		for _, pool := range pools {
+			if subnetsOverlap(pool.cidr, ipNet) {
+				return nil, cranerror.NewError(CodeInvalidNetworkPool, fmt.Sprintf("%s overlaps %s", cidr, pool.cidr))
+			}
+		}
+		pools = append(pools, networkPool{cidr: ipNet, prefixSize: prefixSize})
+	}
+
+	return pools, nil
+}
+
+func subnetsOverlap(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// the subnets of the networks, the local ones of the manager included
+func subnetAllocations(networks []docker.Network) []SubnetAllocation {
+	var allocations []SubnetAllocation
+	for _, network := range networks {
+		for _, config := range network.IPAM.Config {
+			if config.Subnet == "" {
+				continue
+			}
+			allocations = append(allocations, SubnetAllocation{
+				Subnet:      config.Subnet,
+				NetworkID:   network.ID,
+				NetworkName: network.Name,
+				Namespace:   network.Labels[LabelNamespace],
+			})
+		}
+	}
+
+	return allocations
+}
+
+// a subnet allocation with its subnet parsed
+type parsedAllocation struct {
+	subnet     *net.IPNet
+	allocation SubnetAllocation
+}
+
+// the allocations of a valid subnet
+func parseAllocations(allocations []SubnetAllocation) []parsedAllocation {
+	var parsed []parsedAllocation
+	for _, allocation := range allocations {
+		if _, subnet, err := net.ParseCIDR(allocation.Subnet); err == nil {
+			parsed = append(parsed, parsedAllocation{subnet: subnet, allocation: allocation})
+		}
+	}
+
+	return parsed
+}
+
+func allocatedSubnets(parsed []parsedAllocation) []*net.IPNet {
+	subnets := make([]*net.IPNet, 0, len(parsed))
+	for _, p := range parsed {
+		subnets = append(subnets, p.subnet)
+	}
+
+	return subnets
+}
+
+// the first subnet of the pools overlapping none of the used ones
+func allocateSubnet(pools []networkPool, used []*net.IPNet) (*net.IPNet, error) {
+	for _, pool := range pools {
+		for i := uint64(0); i < pool.size(); i++ {
+			candidate := pool.subnet(i)
+			free := true
+			for _, subnet := range used {
+				if subnetsOverlap(candidate, subnet) {
+					free = false
+					break
+				}
+			}
+			if free {
+				return candidate, nil
+			}
+		}
+	}
+
+	return nil, cranerror.NewError(CodeNetworkPoolExhausted, "no free subnet left in the network pools")
+}
+
+// with pools configured, the networks created without a subnet get the next free one,
+// the subnets requested can not overlap the ones of the existing networks
+func (client *CraneDockerClient) assignSubnet(opts *docker.CreateNetworkOptions) error {
+	pools, err := client.networkPools()
+	if err != nil || pools == nil {
+		return err
+	}
+
+	networks, err := client.SwarmManager().ListNetworks()
+	if err != nil {
+		return err
+	}
+	used := parseAllocations(subnetAllocations(networks))
+
+	requested := false
+	for _, config := range opts.IPAM.Config {
+		if config.Subnet == "" {
+			continue
+		}
+		requested = true
+
+		_, subnet, err := net.ParseCIDR(config.Subnet)
+		if err != nil {
+			return cranerror.NewError(CodeNetworkInvalid, "invalid subnet "+config.Subnet)
+		}
+		for _, u := range used {
+			if subnetsOverlap(subnet, u.subnet) {
+				return cranerror.NewError(CodeNetworkSubnetOverlap, fmt.Sprintf("%s overlaps %s of network %s", config.Subnet, u.allocation.Subnet, u.allocation.NetworkName))
+			}
+		}
+	}
+	if requested {
+		return nil
+	}
+
+	subnet, err := allocateSubnet(pools, allocatedSubnets(used))
+	if err != nil {
+		return err
+	}
+
+	log.Infof("allocating subnet %s to network %s", subnet, opts.Name)
+	if opts.IPAM.Driver == "" {
+		opts.IPAM.Driver = "default"
+	}
+	opts.IPAM.Config = []docker.IPAMConfig{{Subnet: subnet.String()}}
+	return nil
+}
+
+// the allocations of the configured pools and the free space left in them
+func (client *CraneDockerClient) NetworkPoolUsage() (*NetworkPoolReport, error) {
+	pools, err := client.networkPools()
+	if err != nil {
+		return nil, err
+	}
+
+	networks, err := client.ListNetworks(docker.NetworkFilterOpts{})
+	if err != nil {
+		return nil, err
+	}
+
+	prefixSize := DefaultNetworkPoolPrefixSize
+	if len(pools) > 0 {
+		prefixSize = pools[0].prefixSize
+	}
+
+	return buildNetworkPoolReport(pools, prefixSize, subnetAllocations(networks)), nil
+}
+
+func buildNetworkPoolReport(pools []networkPool, prefixSize int, allocations []SubnetAllocation) *NetworkPoolReport {
+	sort.Slice(allocations, func(i, j int) bool { return allocations[i].Subnet < allocations[j].Subnet })
+	used := parseAllocations(allocations)
+
+	report := &NetworkPoolReport{
+		PrefixSize: prefixSize,
+		Pools:      make([]NetworkPoolUsage, 0),
+		Outside:    make([]SubnetAllocation, 0),
+	}
+	inPool := make(map[int]bool)
+	for _, pool := range pools {
+		usage := NetworkPoolUsage{CIDR: pool.cidr.String(), Allocations: make([]SubnetAllocation, 0)}
+
+		ones, _ := pool.cidr.Mask.Size()
+		usage.FreeAddresses = 1 << uint(32-ones)
+		var overlapping []*net.IPNet
+		for i, u := range used {
+			if !subnetsOverlap(pool.cidr, u.subnet) {
+				continue
+			}
+			inPool[i] = true
+			overlapping = append(overlapping, u.subnet)
+			usage.Allocations = append(usage.Allocations, u.allocation)
+		}
+		usage.FreeAddresses -= coveredAddresses(pool.cidr, overlapping)
+
+		for i := uint64(0); i < pool.size(); i++ {
+			candidate := pool.subnet(i)
+			free := true
+			for _, subnet := range overlapping {
+				if subnetsOverlap(candidate, subnet) {
+					free = false
+					break
+				}
+			}
+			if free {
+				usage.FreeSubnets++
+			}
+		}
+		report.Pools = append(report.Pools, usage)
+	}
+
+	for i, u := range used {
+		if !inPool[i] {
+			report.Outside = append(report.Outside, u.allocation)
+		}
+	}
+
+	return report
+}
+
+// the addresses of the pool covered by the subnets, a subnet inside another one counted once
+func coveredAddresses(pool *net.IPNet, subnets []*net.IPNet) uint64 {
+	ones, _ := pool.Mask.Size()
+	var covered uint64
+	var counted []*net.IPNet
+	for _, subnet := range subnets {
+		subnetOnes, _ := subnet.Mask.Size()
+		if subnetOnes <= ones {
+			// the subnet covers the whole pool
+			return 1 << uint(32-ones)
+		}
+
+		nested := false
+		for _, other := range subnets {
+			otherOnes, _ := other.Mask.Size()
+			if other != subnet && otherOnes < subnetOnes && other.Contains(subnet.IP) {
+				nested = true
+				break
+			}
+		}
+		for _, other := range counted {
+			if other.String() == subnet.String() {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			counted = append(counted, subnet)
+			covered += 1 << uint(32-subnetOnes)
+		}
+	}
+
+	return covered
+}
@@ -0,0 +1,122 @@
+package dockerclient
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Dataman-Cloud/crane/src/utils/config"
+
+	docker "github.com/Dataman-Cloud/go-dockerclient"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseNetworkPools(t *testing.T) {
+	pools, err := parseNetworkPools([]string{"10.10.0.0/16", "", "192.168.4.0/22"}, 24)
+	assert.Nil(t, err)
+	assert.Len(t, pools, 2)
+	assert.Equal(t, uint64(256), pools[0].size())
+	assert.Equal(t, "192.168.6.0/24", pools[1].subnet(2).String())
+
+	_, err = parseNetworkPools([]string{"10.10.0.0/16"}, 30)
+	assert.NotNil(t, err)
+	_, err = parseNetworkPools([]string{"10.10.0.0/25"}, 24)
+	assert.NotNil(t, err)
+	_, err = parseNetworkPools([]string{"10.10.0.0/16", "10.10.8.0/22"}, 24)
+	assert.NotNil(t, err)
+	_, err = parseNetworkPools([]string{"fd00::/64"}, 24)
+	assert.NotNil(t, err)
+}
+
+func TestAllocateSubnet(t *testing.T) {
+	pools, err := parseNetworkPools([]string{"10.10.0.0/23", "10.20.0.0/24"}, 24)
+	assert.Nil(t, err)
+
+	var used []*net.IPNet
+	for _, cidr := range []string{"10.10.0.128/25", "10.0.0.0/8"} {
+		_, subnet, _ := net.ParseCIDR(cidr)
+		used = append(used, subnet)
+	}
+	_, err = allocateSubnet(pools, used)
+	assert.NotNil(t, err)
+
+	used = used[:1]
+	subnet, err := allocateSubnet(pools, used)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.10.1.0/24", subnet.String())
+
+	used = append(used, subnet)
+	subnet, err = allocateSubnet(pools, used)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.20.0.0/24", subnet.String())
+}
+
+func TestBuildNetworkPoolReport(t *testing.T) {
+	pools, err := parseNetworkPools([]string{"10.10.0.0/22"}, 24)
+	assert.Nil(t, err)
+
+	report := buildNetworkPoolReport(pools, 24, subnetAllocations([]docker.Network{
+		// sorted first, an invalid subnet does not shift the others
+		{ID: "network0", Name: "broken", IPAM: docker.IPAMOptions{Config: []docker.IPAMConfig{{Subnet: "0.0.0.0/99"}}}},
+		{ID: "network1", Name: "blog_default", Labels: map[string]string{LabelNamespace: "blog"},
+			IPAM: docker.IPAMOptions{Config: []docker.IPAMConfig{{Subnet: "10.10.1.0/24"}}}},
+		{ID: "network2", Name: "small", IPAM: docker.IPAMOptions{Config: []docker.IPAMConfig{{Subnet: "10.10.3.0/26"}}}},
+		{ID: "network3", Name: "bridge", IPAM: docker.IPAMOptions{Config: []docker.IPAMConfig{{Subnet: "172.17.0.0/16"}}}},
+		{ID: "network4", Name: "host"},
+	}))
+
+	assert.Equal(t, 24, report.PrefixSize)
+	assert.Len(t, report.Pools, 1)
+	usage := report.Pools[0]
+	assert.Equal(t, "10.10.0.0/22", usage.CIDR)
+	assert.Len(t, usage.Allocations, 2)
+	assert.Equal(t, "blog", usage.Allocations[0].Namespace)
+	assert.Equal(t, "blog_default", usage.Allocations[0].NetworkName)
+	assert.Equal(t, "small", usage.Allocations[1].NetworkName)
+	assert.Equal(t, uint64(2), usage.FreeSubnets)
+	assert.Equal(t, uint64(1024-256-64), usage.FreeAddresses)
+	assert.Len(t, report.Outside, 1)
+	assert.Equal(t, "bridge", report.Outside[0].NetworkName)
+}
+
+func TestCreateNetworkSubnet(t *testing.T) {
+	var created []docker.CreateNetworkOptions
+	managerRouter := gin.New()
+	managerRouter.GET("/networks", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, []docker.Network{
+			{ID: "network1", Name: "blog_default", IPAM: docker.IPAMOptions{Config: []docker.IPAMConfig{{Subnet: "10.10.0.0/24"}}}},
+		})
+	})
+	managerRouter.POST("/networks/create", func(ctx *gin.Context) {
+		var opts docker.CreateNetworkOptions
+		ctx.BindJSON(&opts)
+		created = append(created, opts)
+		ctx.JSON(http.StatusCreated, map[string]string{"Id": "network2"})
+	})
+	manager := httptest.NewServer(managerRouter)
+	defer manager.Close()
+
+	swarmManager, err := docker.NewClient(manager.URL)
+	assert.Nil(t, err)
+	client := &CraneDockerClient{
+		swarmManager: swarmManager,
+		config:       &config.Config{NetworkPoolCIDRs: []string{"10.10.0.0/16"}, NetworkPoolPrefixSize: 24},
+	}
+
+	_, err = client.CreateNetwork(docker.CreateNetworkOptions{Name: "shop"})
+	assert.Nil(t, err)
+	assert.Equal(t, "default", created[0].IPAM.Driver)
+	assert.Equal(t, []docker.IPAMConfig{{Subnet: "10.10.1.0/24"}}, created[0].IPAM.Config)
+
+	_, err = client.CreateNetwork(docker.CreateNetworkOptions{Name: "clash",
+		IPAM: docker.IPAMOptions{Config: []docker.IPAMConfig{{Subnet: "10.10.0.128/25"}}}})
+	assert.NotNil(t, err)
+	assert.Len(t, created, 1)
+
+	_, err = client.CreateNetwork(docker.CreateNetworkOptions{Name: "manual",
+		IPAM: docker.IPAMOptions{Config: []docker.IPAMConfig{{Subnet: "192.168.0.0/24"}}}})
+	assert.Nil(t, err)
+	assert.Equal(t, "192.168.0.0/24", created[1].IPAM.Config[0].Subnet)
+}
@@ -0,0 +1,508 @@
+package dockerclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
+	"github.com/Dataman-Cloud/crane/src/utils/httpclient"
+
+	docker "github.com/Dataman-Cloud/go-dockerclient"
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/swarm"
+)
+
+// the last known state of a swarm manager endpoint
+type ManagerEndpointStatus struct {
+	Endpoint    string    `json:"Endpoint"`
+	NodeID      string    `json:"NodeID"`
+	Leader      bool      `json:"Leader"`
+	Reachable   bool      `json:"Reachable"`
+	Discovered  bool      `json:"Discovered"`
+	LastChecked time.Time `json:"LastChecked"`
+	Error       string    `json:"Error,omitempty"`
+}
+
+// managerPool keeps every known manager endpoint, health checks them
+// and chooses the one requests to the swarm cluster are sent to
+type managerPool struct {
+	// serialize refresh, the check of endpoints could be slow
+	refreshMu sync.Mutex
+
+	mu        sync.RWMutex
+	endpoints []*ManagerEndpointStatus
+	clients   map[string]*docker.Client
+	active    string
+
+	// checks the managers, requests is the one failing over sending to them
+	httpClient *httpclient.Client
+	requests   *httpclient.Client
+	newClient  func(endpoint string) (*docker.Client, error)
+	// used to build the endpoint of discovered managers
+	scheme string
+	port   string
+}
+
+func newManagerPool(endpoints []string, scheme, port string, httpClient *httpclient.Client,
+	newClient func(endpoint string) (*docker.Client, error)) (*managerPool, error) {
+	pool := &managerPool{
+		clients:    make(map[string]*docker.Client),
+		httpClient: httpClient,
+		newClient:  newClient,
+		scheme:     scheme,
+		port:       port,
+	}
+	pool.requests = pool.failoverHttpClient(httpClient)
+
+	for _, endpoint := range endpoints {
+		if err := pool.add(endpoint, false); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(pool.endpoints) == 0 {
+		return nil, fmt.Errorf("no swarm manager endpoint configured")
+	}
+
+	pool.active = pool.endpoints[0].Endpoint
+	return pool, nil
+}
+
+func (pool *managerPool) add(endpoint string, discovered bool) error {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	for _, e := range pool.endpoints {
+		if e.Endpoint == endpoint {
+			return nil
+		}
+	}
+
+	client, err := pool.newClient(endpoint)
+	if err != nil {
+		return err
+	}
+
+	if client.HTTPClient != nil {
+		failover := *client.HTTPClient
+		failover.Transport = &failoverTransport{pool: pool, base: roundTripper(client.HTTPClient)}
+		client.HTTPClient = &failover
+	}
+	pool.clients[endpoint] = client
+	pool.endpoints = append(pool.endpoints, &ManagerEndpointStatus{Endpoint: endpoint, Discovered: discovered})
+	return nil
+}
+
+// the endpoint of the manager all requests are sent to
+func (pool *managerPool) Active() string {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	return pool.active
+}
+
+func (pool *managerPool) ActiveClient() *docker.Client {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	return pool.clients[pool.active]
+}
+
+func (pool *managerPool) Status() []ManagerEndpointStatus {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	status := make([]ManagerEndpointStatus, 0, len(pool.endpoints))
+	for _, e := range pool.endpoints {
+		status = append(status, *e)
+	}
+
+	return status
+}
+
+// health check all known managers, discover new managers from the node list
+// and switch to a reachable manager, the raft leader is preferred
+func (pool *managerPool) Refresh() error {
+	pool.refreshMu.Lock()
+	defer pool.refreshMu.Unlock()
+
+	pool.check()
+	leaders, err := pool.discover()
+	if err != nil {
+		log.Warnf("discover swarm managers got error: %s", err.Error())
+	} else {
+		// check the managers just discovered
+		pool.check()
+		pool.markLeader(leaders)
+	}
+
+	return pool.elect()
+}
+
+// refresh the pool every interval until stop closed
+func (pool *managerPool) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			previous := pool.Active()
+			if err := pool.Refresh(); err != nil {
+				log.Errorf("refresh swarm managers got error: %s", err.Error())
+				continue
+			}
+
+			if active := pool.Active(); active != previous {
+				log.Infof("swarm manager switched from %s to %s", previous, active)
+			}
+		}
+	}
+}
+
+func (pool *managerPool) check() {
+	pool.mu.RLock()
+	endpoints := make([]string, 0, len(pool.endpoints))
+	for _, e := range pool.endpoints {
+		endpoints = append(endpoints, e.Endpoint)
+	}
+	pool.mu.RUnlock()
+
+	results := make([]ManagerEndpointStatus, len(endpoints))
+	var wg sync.WaitGroup
+	for i, endpoint := range endpoints {
+		wg.Add(1)
+		go func(i int, endpoint string) {
+			defer wg.Done()
+			results[i] = pool.checkEndpoint(endpoint)
+		}(i, endpoint)
+	}
+	wg.Wait()
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	for _, result := range results {
+		for _, e := range pool.endpoints {
+			if e.Endpoint == result.Endpoint {
+				e.NodeID, e.Reachable, e.LastChecked, e.Error = result.NodeID, result.Reachable, result.LastChecked, result.Error
+			}
+		}
+	}
+}
+
+func (pool *managerPool) checkEndpoint(endpoint string) ManagerEndpointStatus {
+	status := ManagerEndpointStatus{Endpoint: endpoint, LastChecked: time.Now()}
+
+	// the docker clients of the managers fail over, the check pings the endpoint itself
+	if _, err := pool.httpClient.GET(nil, endpoint+"/_ping", nil, nil); err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Reachable = true
+
+	// node id is optional, the daemon may not be in a swarm yet
+	content, err := pool.httpClient.GET(nil, endpoint+"/info", nil, nil)
+	if err != nil {
+		return status
+	}
+
+	var info types.Info
+	if err := json.Unmarshal(content, &info); err == nil {
+		status.NodeID = info.Swarm.NodeID
+	}
+
+	return status
+}
+
+// list nodes from a reachable manager, add the managers not known yet,
+// returns whether the manager is the leader indexed by node id
+func (pool *managerPool) discover() (map[string]bool, error) {
+	var nodes []swarm.Node
+	var lastErr error
+	for _, status := range pool.reachable() {
+		content, err := pool.httpClient.GET(nil, status.Endpoint+"/nodes", nil, nil)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if err := json.Unmarshal(content, &nodes); err != nil {
+			lastErr = err
+			continue
+		}
+
+		lastErr = nil
+		break
+	}
+
+	if lastErr != nil {
+		return nil, lastErr
+	}
+
+	leaders := make(map[string]bool)
+	for _, node := range nodes {
+		if node.ManagerStatus == nil {
+			continue
+		}
+
+		leaders[node.ID] = node.ManagerStatus.Leader
+		endpoint := pool.managerEndpoint(node)
+		if endpoint == "" || pool.knownNode(node.ID) {
+			continue
+		}
+
+		if err := pool.add(endpoint, true); err != nil {
+			log.Warnf("add discovered swarm manager %s got error: %s", endpoint, err.Error())
+		}
+	}
+
+	return leaders, nil
+}
+
+func (pool *managerPool) markLeader(leaders map[string]bool) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	for _, e := range pool.endpoints {
+		e.Leader = leaders[e.NodeID]
+	}
+}
+
+// prefer the reachable leader, then the current manager and then the first reachable one
+func (pool *managerPool) elect() error {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	var current, first *ManagerEndpointStatus
+	for _, e := range pool.endpoints {
+		if !e.Reachable {
+			continue
+		}
+
+		if e.Leader {
+			pool.active = e.Endpoint
+			return nil
+		}
+
+		if e.Endpoint == pool.active {
+			current = e
+		}
+
+		if first == nil {
+			first = e
+		}
+	}
+
+	if current != nil {
+		return nil
+	}
+
+	if first != nil {
+		pool.active = first.Endpoint
+		return nil
+	}
+
+	return cranerror.NewError(CodeNoReachableManager, "no reachable swarm manager")
+}
+
+// check the managers again on a connection error to one of them, switch to another reachable one
+// if it was the active one, returns the manager to retry on
+func (pool *managerPool) failover(endpoint string, cause error) (string, bool) {
+	pool.refreshMu.Lock()
+	defer pool.refreshMu.Unlock()
+
+	// another request failed over already
+	if active := pool.Active(); active != endpoint {
+		return active, true
+	}
+
+	pool.check()
+	pool.mu.Lock()
+	for _, e := range pool.endpoints {
+		if e.Endpoint == endpoint && e.Reachable {
+			// the dial just failed, keep it down until the next check
+			e.Reachable, e.Error = false, cause.Error()
+		}
+	}
+	pool.mu.Unlock()
+
+	if err := pool.elect(); err != nil {
+		return "", false
+	}
+
+	active := pool.Active()
+	if active == endpoint {
+		return "", false
+	}
+	log.Warnf("swarm manager %s unreachable, switched to %s: %s", endpoint, active, cause.Error())
+	return active, true
+}
+
+// the known manager the url is sent to, empty if none
+func (pool *managerPool) endpointOf(u *url.URL) string {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	for _, e := range pool.endpoints {
+		if endpointUrl, err := url.Parse(e.Endpoint); err == nil && endpointUrl.Host == u.Host {
+			return e.Endpoint
+		}
+	}
+
+	return ""
+}
+
+func (pool *managerPool) failoverHttpClient(httpClient *httpclient.Client) *httpclient.Client {
+	failover := *httpClient
+	if httpClient.HttpClient != nil {
+		client := *httpClient.HttpClient
+		client.Transport = &failoverTransport{pool: pool, base: roundTripper(httpClient.HttpClient)}
+		failover.HttpClient = &client
+	}
+	return &failover
+}
+
+func roundTripper(client *http.Client) http.RoundTripper {
+	if client.Transport == nil {
+		return http.DefaultTransport
+	}
+	return client.Transport
+}
+
+// failoverTransport sends a request to a manager once more to another reachable manager
+// when it could not connect, the manager is marked down until the next check
+type failoverTransport struct {
+	pool *managerPool
+	base http.RoundTripper
+}
+
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoint := t.pool.endpointOf(req.URL)
+	if endpoint == "" {
+		return t.base.RoundTrip(req)
+	}
+
+	// keep the body to send it again
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+
+	resp, err := t.base.RoundTrip(requestTo(req, "", body))
+	if err == nil || !isConnectionError(err) {
+		return resp, err
+	}
+
+	next, ok := t.pool.failover(endpoint, err)
+	if !ok {
+		return nil, err
+	}
+	return t.base.RoundTrip(requestTo(req, next, body))
+}
+
+// a copy of the request with the body, sent to the endpoint if not empty
+func requestTo(req *http.Request, endpoint string, body []byte) *http.Request {
+	r := new(http.Request)
+	*r = *req
+
+	u := *req.URL
+	r.URL = &u
+	if endpoint != "" {
+		if endpointUrl, err := url.Parse(endpoint); err == nil {
+			r.URL.Scheme, r.URL.Host = endpointUrl.Scheme, endpointUrl.Host
+			r.Host = ""
+		}
+	}
+
+	if req.Body != nil {
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+	}
+	return r
+}
+
+// the request did not reach the manager, it is safe to send it again
+func isConnectionError(err error) bool {
+	opErr, ok := err.(*net.OpError)
+	return ok && opErr.Op == "dial"
+}
+
+func (pool *managerPool) reachable() []ManagerEndpointStatus {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	var status []ManagerEndpointStatus
+	for _, e := range pool.endpoints {
+		if e.Reachable {
+			status = append(status, *e)
+		}
+	}
+
+	return status
+}
+
+func (pool *managerPool) knownNode(nodeId string) bool {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	for _, e := range pool.endpoints {
+		if e.NodeID == nodeId {
+			return true
+		}
+	}
+
+	return false
+}
+
+// the docker endpoint of a manager node: the endpoint label stored by crane,
+// or the raft address host with the default docker port
+func (pool *managerPool) managerEndpoint(node swarm.Node) string {
+	if endpoint, ok := node.Spec.Annotations.Labels[LabelNodeEndpoint]; ok {
+		return normalizeManagerEndpoint(endpoint, pool.scheme, pool.port)
+	}
+
+	host, _, err := net.SplitHostPort(node.ManagerStatus.Addr)
+	if err != nil || host == "" {
+		return ""
+	}
+
+	return normalizeManagerEndpoint(host, pool.scheme, pool.port)
+}
+
+// complete the endpoint with scheme and port if missing
+func normalizeManagerEndpoint(endpoint, scheme, port string) string {
+	endpoint = strings.TrimSpace(strings.TrimRight(endpoint, "/"))
+	if !strings.Contains(endpoint, "://") {
+		endpoint = scheme + "://" + endpoint
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return endpoint
+	}
+
+	if u.Scheme == "tcp" {
+		u.Scheme = "http"
+	}
+
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		u.Host = net.JoinHostPort(u.Host, port)
+	}
+
+	return u.String()
+}
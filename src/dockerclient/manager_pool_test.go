@@ -0,0 +1,157 @@
+package dockerclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	docker "github.com/Dataman-Cloud/go-dockerclient"
+	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/swarm"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func fakeManager(nodeId string, nodes func() []swarm.Node) *httptest.Server {
+	router := gin.New()
+	router.GET("/_ping", func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, "OK")
+	})
+	router.GET("/version", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, map[string]string{"ApiVersion": "1.24"})
+	})
+	router.GET("/info", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, types.Info{Swarm: swarm.Info{NodeID: nodeId}})
+	})
+	router.GET("/nodes", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, nodes())
+	})
+
+	return httptest.NewServer(router)
+}
+
+func newTestManagerPool(t *testing.T, endpoints ...string) *managerPool {
+	httpClient, err := NewHttpClient()
+	assert.Nil(t, err)
+
+	newClient := func(endpoint string) (*docker.Client, error) {
+		return docker.NewVersionedClient(endpoint, "")
+	}
+	pool, err := newManagerPool(endpoints, "http", "2375", httpClient, newClient)
+	assert.Nil(t, err)
+
+	return pool
+}
+
+func TestManagerPoolFailover(t *testing.T) {
+	noNodes := func() []swarm.Node { return nil }
+	down := fakeManager("manager1", noNodes)
+	down.Close()
+
+	up := fakeManager("manager2", noNodes)
+	defer up.Close()
+
+	pool := newTestManagerPool(t, down.URL, up.URL)
+	assert.Equal(t, down.URL, pool.Active())
+
+	err := pool.Refresh()
+	assert.Nil(t, err)
+	assert.Equal(t, up.URL, pool.Active())
+	assert.NotNil(t, pool.ActiveClient())
+
+	status := pool.Status()
+	assert.Len(t, status, 2)
+	assert.False(t, status[0].Reachable)
+	assert.True(t, status[1].Reachable)
+	assert.Equal(t, "manager2", status[1].NodeID)
+}
+
+func TestManagerPoolRetryOnConnectionError(t *testing.T) {
+	noNodes := func() []swarm.Node { return nil }
+	down := fakeManager("manager1", noNodes)
+	down.Close()
+
+	up := fakeManager("manager2", noNodes)
+	defer up.Close()
+
+	// never refreshed, the failover does not wait for the watch
+	pool := newTestManagerPool(t, down.URL, up.URL)
+	assert.Equal(t, down.URL, pool.Active())
+
+	content, err := pool.requests.GET(nil, down.URL+"/info", nil, nil)
+	assert.Nil(t, err)
+	assert.Contains(t, string(content), "manager2")
+	assert.Equal(t, up.URL, pool.Active())
+	assert.False(t, pool.Status()[0].Reachable)
+
+	client := pool.clients[down.URL]
+	assert.Nil(t, client.Ping())
+}
+
+func TestManagerPoolNoRetryWithoutReachable(t *testing.T) {
+	down := fakeManager("manager1", func() []swarm.Node { return nil })
+	down.Close()
+
+	pool := newTestManagerPool(t, down.URL)
+	_, err := pool.requests.GET(nil, down.URL+"/info", nil, nil)
+	assert.NotNil(t, err)
+	assert.Equal(t, down.URL, pool.Active())
+}
+
+func TestManagerPoolPreferLeader(t *testing.T) {
+	var leaderUrl string
+	nodes := func() []swarm.Node {
+		return []swarm.Node{
+			{
+				ID:            "manager1",
+				ManagerStatus: &swarm.ManagerStatus{Reachability: swarm.ReachabilityReachable},
+			},
+			{
+				ID:            "manager2",
+				Spec:          swarm.NodeSpec{Annotations: swarm.Annotations{Labels: map[string]string{LabelNodeEndpoint: leaderUrl}}},
+				ManagerStatus: &swarm.ManagerStatus{Leader: true, Reachability: swarm.ReachabilityReachable},
+			},
+			{ID: "worker1"},
+		}
+	}
+
+	follower := fakeManager("manager1", nodes)
+	defer follower.Close()
+	leader := fakeManager("manager2", nodes)
+	defer leader.Close()
+	leaderUrl = leader.URL
+
+	pool := newTestManagerPool(t, follower.URL)
+	err := pool.Refresh()
+	assert.Nil(t, err)
+	assert.Equal(t, leader.URL, pool.Active())
+
+	status := pool.Status()
+	assert.Len(t, status, 2)
+	assert.True(t, status[1].Discovered)
+	assert.True(t, status[1].Leader)
+}
+
+func TestManagerPoolNoReachable(t *testing.T) {
+	down := fakeManager("manager1", func() []swarm.Node { return nil })
+	down.Close()
+
+	pool := newTestManagerPool(t, down.URL)
+	err := pool.Refresh()
+	assert.NotNil(t, err)
+}
+
+func TestNormalizeManagerEndpoint(t *testing.T) {
+	assert.Equal(t, "http://192.168.1.2:2375", normalizeManagerEndpoint("192.168.1.2", "http", "2375"))
+	assert.Equal(t, "https://192.168.1.2:2376", normalizeManagerEndpoint("https://192.168.1.2:2376/", "http", "2375"))
+	assert.Equal(t, "http://192.168.1.2:2376", normalizeManagerEndpoint("tcp://192.168.1.2:2376", "http", "2375"))
+}
+
+func TestManagerEndpoint(t *testing.T) {
+	client := &CraneDockerClient{swarmManagerHttpEndpoint: "http://127.0.0.1:2375"}
+	assert.Equal(t, "http://127.0.0.1:2375", client.ManagerEndpoint())
+	assert.Len(t, client.ManagerEndpoints(), 1)
+
+	client.managers = newTestManagerPool(t, "http://127.0.0.2:2375")
+	assert.Equal(t, "http://127.0.0.2:2375", client.ManagerEndpoint())
+}
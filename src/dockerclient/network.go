@@ -1,16 +1,103 @@
 package dockerclient
 
 import (
+	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
 
 	docker "github.com/Dataman-Cloud/go-dockerclient"
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/swarm"
 	"golang.org/x/net/context"
 )
 
+// the containers of removed or detached services take a while to leave the network
+var (
+	networkRemoveRetries       = 10
+	networkRemoveRetryInterval = time.Second
+)
+
+type NetworkAttachedService struct {
+	ID        string `json:"ID"`
+	Name      string `json:"Name"`
+	Namespace string `json:"Namespace,omitempty"`
+}
+
+// NetworkAttachedContainer is the container of a task, or a container connected to the network by hand if TaskID is empty
+type NetworkAttachedContainer struct {
+	ID        string `json:"ID"`
+	Name      string `json:"Name"`
+	NodeID    string `json:"NodeID,omitempty"`
+	TaskID    string `json:"TaskID,omitempty"`
+	ServiceID string `json:"ServiceID,omitempty"`
+	Namespace string `json:"Namespace,omitempty"`
+}
+
+// NetworkUsage lists what a network can not be removed with
+type NetworkUsage struct {
+	NetworkID   string                     `json:"NetworkID"`
+	NetworkName string                     `json:"NetworkName"`
+	Namespace   string                     `json:"Namespace,omitempty"`
+	Services    []NetworkAttachedService   `json:"Services"`
+	Containers  []NetworkAttachedContainer `json:"Containers"`
+}
+
+func (usage *NetworkUsage) InUse() bool {
+	return len(usage.Services) > 0 || len(usage.Containers) > 0
+}
+
+// the usage left once the services of the stack namespace and their containers are gone
+func (usage *NetworkUsage) outsideStack(namespace string) *NetworkUsage {
+	outside := &NetworkUsage{
+		NetworkID:   usage.NetworkID,
+		NetworkName: usage.NetworkName,
+		Namespace:   usage.Namespace,
+		Services:    make([]NetworkAttachedService, 0),
+		Containers:  make([]NetworkAttachedContainer, 0),
+	}
+	for _, service := range usage.Services {
+		if service.Namespace != namespace {
+			outside.Services = append(outside.Services, service)
+		}
+	}
+	for _, container := range usage.Containers {
+		if container.TaskID == "" || container.Namespace != namespace {
+			outside.Containers = append(outside.Containers, container)
+		}
+	}
+
+	return outside
+}
+
+func (usage *NetworkUsage) inUseError() error {
+	var users []string
+	for _, service := range usage.Services {
+		users = append(users, "service "+service.Name)
+	}
+	for _, container := range usage.Containers {
+		if container.TaskID == "" {
+			users = append(users, "container "+container.Name)
+		}
+	}
+	if len(users) == 0 {
+		users = append(users, strconv.Itoa(len(usage.Containers))+" task containers")
+	}
+
+	return cranerror.NewError(CodeNetworkInUse, fmt.Sprintf("network %s is used by %s", usage.NetworkName, strings.Join(users, ", ")))
+}
+
 func (client *CraneDockerClient) ConnectNetwork(id string, opts docker.NetworkConnectionOptions) error {
-	return client.SwarmManager().ConnectNetwork(id, opts)
+	if err := client.SwarmManager().ConnectNetwork(id, opts); err != nil {
+		return err
+	}
+
+	client.invalidateSwarmCache()
+	return nil
 }
 
 func (client *CraneDockerClient) CreateNetwork(opts docker.CreateNetworkOptions) (*docker.Network, error) {
@@ -18,34 +105,294 @@ func (client *CraneDockerClient) CreateNetwork(opts docker.CreateNetworkOptions)
 		return nil, cranerror.NewError(CodeInvalidNetworkName, "invalid name, only [a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9] are allowed")
 	}
 
-	return client.SwarmManager().CreateNetwork(opts)
+	// two networks created at once must not get the same subnet
+	client.networkPoolMu.Lock()
+	defer client.networkPoolMu.Unlock()
+	if err := client.assignSubnet(&opts); err != nil {
+		return nil, err
+	}
+
+	network, err := client.SwarmManager().CreateNetwork(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	client.invalidateSwarmCache()
+	return network, nil
 }
 
 func (client *CraneDockerClient) DisconnectNetwork(id string, opts docker.NetworkConnectionOptions) error {
-	return client.SwarmManager().DisconnectNetwork(id, opts)
+	if err := client.SwarmManager().DisconnectNetwork(id, opts); err != nil {
+		return err
+	}
+
+	client.invalidateSwarmCache()
+	return nil
 }
 
 func (client *CraneDockerClient) InspectNetwork(id string) (*docker.Network, error) {
+	if snapshot, ok := client.swarmSnapshot(); ok {
+		if network, ok := snapshot.network(id); ok {
+			return &network, nil
+		}
+	}
+
 	return client.SwarmManager().NetworkInfo(id)
 }
 
 func (client *CraneDockerClient) ListNetworks(opts docker.NetworkFilterOpts) ([]docker.Network, error) {
+	if snapshot, ok := client.swarmSnapshot(); ok {
+		if networks, ok := snapshot.filterNetworks(opts); ok {
+			return networks, nil
+		}
+	}
+
 	return client.SwarmManager().FilteredListNetworks(opts)
 }
 
-func (client *CraneDockerClient) RemoveNetwork(id string) error {
-	err := client.SwarmManager().RemoveNetwork(id)
+// the services and the containers attached to the network
+func (client *CraneDockerClient) NetworkUsage(id string) (*NetworkUsage, error) {
+	network, err := client.InspectNetwork(id)
+	if err != nil {
+		return nil, err
+	}
+
+	services, err := client.ListServiceSpec(types.ServiceListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	tasks, err := client.ListTasks(types.TaskListOptions{})
 	if err != nil {
-		if strings.Contains(err.Error(), "API error (403)") {
+		return nil, err
+	}
+
+	return networkUsage(*network, services, tasks), nil
+}
+
+func networkUsage(network docker.Network, services []swarm.Service, tasks []swarm.Task) *NetworkUsage {
+	usage := &NetworkUsage{
+		NetworkID:   network.ID,
+		NetworkName: network.Name,
+		Namespace:   network.Labels[LabelNamespace],
+		Services:    make([]NetworkAttachedService, 0),
+		Containers:  make([]NetworkAttachedContainer, 0),
+	}
+
+	serviceIndex := make(map[string]swarm.Service)
+	for _, service := range services {
+		serviceIndex[service.ID] = service
+		if serviceAttached(service, network) {
+			usage.Services = append(usage.Services, NetworkAttachedService{
+				ID:        service.ID,
+				Name:      service.Spec.Name,
+				Namespace: service.Spec.Labels[LabelNamespace],
+			})
+		}
+	}
+
+	containers := make(map[string]bool)
+	for _, task := range tasks {
+		containerId := task.Status.ContainerStatus.ContainerID
+		if task.DesiredState != swarm.TaskStateRunning || containerId == "" {
+			continue
+		}
+
+		for _, attachment := range task.NetworksAttachments {
+			if attachment.Network.ID != network.ID {
+				continue
+			}
+			service := serviceIndex[task.ServiceID]
+			containers[containerId] = true
+			usage.Containers = append(usage.Containers, NetworkAttachedContainer{
+				ID:        containerId,
+				Name:      fmt.Sprintf("%s.%d", service.Spec.Name, task.Slot),
+				NodeID:    task.NodeID,
+				TaskID:    task.ID,
+				ServiceID: task.ServiceID,
+				Namespace: service.Spec.Labels[LabelNamespace],
+			})
+		}
+	}
+
+	// the endpoints listed by the manager are the ones of its own containers
+	var endpoints []NetworkAttachedContainer
+	for containerId, endpoint := range network.Containers {
+		if !containers[containerId] {
+			endpoints = append(endpoints, NetworkAttachedContainer{ID: containerId, Name: endpoint.Name})
+		}
+	}
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Name < endpoints[j].Name })
+	usage.Containers = append(usage.Containers, endpoints...)
+
+	return usage
+}
+
+// the service specs name their networks by name or by id
+func serviceAttached(service swarm.Service, network docker.Network) bool {
+	for _, attachment := range service.Spec.Networks {
+		if attachment.Target == network.ID || attachment.Target == network.Name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// remove the network if nothing uses it, with force the services attached are updated without it
+// and the containers connected by hand are disconnected first
+func (client *CraneDockerClient) RemoveNetwork(id string, force bool) error {
+	usage, err := client.NetworkUsage(id)
+	if err != nil {
+		return err
+	}
+
+	if usage.InUse() {
+		if !force {
+			return usage.inUseError()
+		}
+		if err := client.detachNetwork(usage); err != nil {
+			return err
+		}
+	}
+
+	return client.removeNetwork(usage.NetworkID)
+}
+
+func (client *CraneDockerClient) detachNetwork(usage *NetworkUsage) error {
+	for _, attached := range usage.Services {
+		service, err := client.inspectService(attached.ID)
+		if err != nil {
+			return err
+		}
+
+		var networks []swarm.NetworkAttachmentConfig
+		for _, attachment := range service.Spec.Networks {
+			if attachment.Target != usage.NetworkID && attachment.Target != usage.NetworkName {
+				networks = append(networks, attachment)
+			}
+		}
+		service.Spec.Networks = networks
+
+		log.Infof("detaching service %s from network %s", service.Spec.Name, usage.NetworkName)
+		if err := client.UpdateServiceAutoOption(service.ID, service.Version, service.Spec); err != nil {
+			return err
+		}
+	}
+
+	for _, container := range usage.Containers {
+		if container.TaskID != "" {
+			continue
+		}
+
+		log.Infof("disconnecting container %s from network %s", container.Name, usage.NetworkName)
+		err := client.SwarmManager().DisconnectNetwork(usage.NetworkID, docker.NetworkConnectionOptions{Container: container.ID, Force: true})
+		if err != nil {
+			return ToCraneError(err)
+		}
+	}
+
+	return nil
+}
+
+// remove the network once the containers of its tasks are gone
+func (client *CraneDockerClient) removeNetwork(id string) error {
+	var err error
+	for i := 0; ; i++ {
+		if err = client.SwarmManager().RemoveNetwork(id); err == nil {
+			break
+		}
+		if !strings.Contains(err.Error(), "active endpoints") || i >= networkRemoveRetries {
+			break
+		}
+		time.Sleep(networkRemoveRetryInterval)
+	}
+
+	if err != nil {
+		if strings.Contains(err.Error(), "active endpoints") {
+			return cranerror.NewError(CodeNetworkInUse, err.Error())
+		} else if strings.Contains(err.Error(), "API error (403)") {
 			return cranerror.NewError(CodeNetworkPredefined, err.Error())
 		} else {
 			return err
 		}
 	}
 
+	client.invalidateSwarmCache()
 	return nil
 }
 
+// OrphanNetwork is a network created for a stack that no longer exists
+type OrphanNetwork struct {
+	ID        string `json:"ID"`
+	Name      string `json:"Name"`
+	Namespace string `json:"Namespace"`
+	Removed   bool   `json:"Removed"`
+	Error     string `json:"Error,omitempty"`
+}
+
+type NetworkCleanupReport struct {
+	DryRun   bool            `json:"DryRun"`
+	Networks []OrphanNetwork `json:"Networks"`
+}
+
+// remove the networks labelled with the namespace of a stack without service,
+// the ones still used by a container are reported but kept
+func (client *CraneDockerClient) CleanupNetworks(dryRun bool) (*NetworkCleanupReport, error) {
+	networks, err := client.ListNetworks(docker.NetworkFilterOpts{"label": map[string]bool{LabelNamespace: true}})
+	if err != nil {
+		return nil, err
+	}
+
+	services, err := client.ListServiceSpec(types.ServiceListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	tasks, err := client.ListTasks(types.TaskListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces := make(map[string]bool)
+	for _, service := range services {
+		if namespace := service.Spec.Labels[LabelNamespace]; namespace != "" {
+			namespaces[namespace] = true
+		}
+	}
+
+	report := &NetworkCleanupReport{DryRun: dryRun, Networks: make([]OrphanNetwork, 0)}
+	for _, network := range orphanNetworks(networks, namespaces) {
+		orphan := OrphanNetwork{ID: network.ID, Name: network.Name, Namespace: network.Labels[LabelNamespace]}
+		if usage := networkUsage(network, services, tasks); usage.InUse() {
+			orphan.Error = usage.inUseError().Error()
+		} else if !dryRun {
+			log.Infof("removing network %s of the removed stack %s", network.Name, orphan.Namespace)
+			if err := client.removeNetwork(network.ID); err != nil {
+				orphan.Error = err.Error()
+			} else {
+				orphan.Removed = true
+			}
+		}
+		report.Networks = append(report.Networks, orphan)
+	}
+
+	return report, nil
+}
+
+func orphanNetworks(networks []docker.Network, namespaces map[string]bool) []docker.Network {
+	var orphans []docker.Network
+	for _, network := range networks {
+		namespace := network.Labels[LabelNamespace]
+		if namespace != "" && !namespaces[namespace] {
+			orphans = append(orphans, network)
+		}
+	}
+	sort.Slice(orphans, func(i, j int) bool { return orphans[i].Name < orphans[j].Name })
+
+	return orphans
+}
+
 func (client *CraneDockerClient) ConnectNodeNetwork(ctx context.Context, networkID string, opts docker.NetworkConnectionOptions) error {
 	swarmNode, err := client.SwarmNode(ctx)
 	if err != nil {
@@ -3,10 +3,16 @@ package dockerclient
 import (
 	"encoding/json"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
+
+	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
 
 	docker "github.com/Dataman-Cloud/go-dockerclient"
+	"github.com/docker/engine-api/types/swarm"
+	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/net/context"
 )
@@ -100,6 +106,15 @@ func TestNetwork(t *testing.T) {
 		}
 	}))
 
+	testServer.CustomHandler("/services", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode([]swarm.Service{})
+	}))
+	testServer.CustomHandler("/tasks", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode([]swarm.Task{})
+	}))
+
 	network, err := craneClient.CreateNetwork(docker.CreateNetworkOptions{Name: "@@@#####"})
 	assert.NotNil(t, err)
 	assert.Nil(t, network)
@@ -118,7 +133,7 @@ func TestNetwork(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, network)
 
-	err = craneClient.RemoveNetwork(networkId)
+	err = craneClient.RemoveNetwork(networkId, false)
 	assert.Nil(t, err)
 
 	err = craneClient.ConnectNetwork("test", docker.NetworkConnectionOptions{})
@@ -152,3 +167,142 @@ func TestNetwork(t *testing.T) {
 	assert.Nil(t, err)
 
 }
+
+func testNetworkServices() ([]swarm.Service, []swarm.Task) {
+	var web, report swarm.Service
+	web.ID = "service1"
+	web.Spec.Name = "blog_web"
+	web.Spec.Labels = map[string]string{LabelNamespace: "blog"}
+	web.Spec.Networks = []swarm.NetworkAttachmentConfig{{Target: "blog_default"}}
+	report.ID = "service2"
+	report.Spec.Name = "report"
+	report.Spec.Networks = []swarm.NetworkAttachmentConfig{{Target: "network1"}, {Target: "other"}}
+
+	task := swarm.Task{ID: "task1", ServiceID: "service1", NodeID: "node1", Slot: 1, DesiredState: swarm.TaskStateRunning}
+	task.Status.ContainerStatus.ContainerID = "container1"
+	task.NetworksAttachments = []swarm.NetworkAttachment{{}}
+	task.NetworksAttachments[0].Network.ID = "network1"
+	stopped := swarm.Task{ID: "task2", ServiceID: "service1", DesiredState: swarm.TaskStateShutdown}
+	stopped.Status.ContainerStatus.ContainerID = "container2"
+	stopped.NetworksAttachments = task.NetworksAttachments
+
+	return []swarm.Service{web, report}, []swarm.Task{task, stopped}
+}
+
+func TestNetworkUsage(t *testing.T) {
+	services, tasks := testNetworkServices()
+	network := docker.Network{ID: "network1", Name: "blog_default", Labels: map[string]string{LabelNamespace: "blog"},
+		Containers: map[string]docker.Endpoint{"container1": {Name: "blog_web.1.abc"}, "container3": {Name: "debug"}}}
+
+	usage := networkUsage(network, services, tasks)
+	assert.True(t, usage.InUse())
+	assert.Equal(t, "blog", usage.Namespace)
+	assert.Equal(t, []NetworkAttachedService{
+		{ID: "service1", Name: "blog_web", Namespace: "blog"},
+		{ID: "service2", Name: "report"},
+	}, usage.Services)
+	assert.Equal(t, []NetworkAttachedContainer{
+		{ID: "container1", Name: "blog_web.1", NodeID: "node1", TaskID: "task1", ServiceID: "service1", Namespace: "blog"},
+		{ID: "container3", Name: "debug"},
+	}, usage.Containers)
+	assert.Contains(t, usage.inUseError().Error(), "service blog_web, service report, container debug")
+
+	outside := usage.outsideStack("blog")
+	assert.Equal(t, []NetworkAttachedService{{ID: "service2", Name: "report"}}, outside.Services)
+	assert.Equal(t, []NetworkAttachedContainer{{ID: "container3", Name: "debug"}}, outside.Containers)
+
+	usage = networkUsage(docker.Network{ID: "network2", Name: "idle"}, services, tasks)
+	assert.False(t, usage.InUse())
+}
+
+func TestOrphanNetworks(t *testing.T) {
+	orphans := orphanNetworks([]docker.Network{
+		{ID: "network1", Name: "shop_default", Labels: map[string]string{LabelNamespace: "shop"}},
+		{ID: "network2", Name: "blog_default", Labels: map[string]string{LabelNamespace: "blog"}},
+		{ID: "network3", Name: "manual"},
+		{ID: "network4", Name: "shop_back", Labels: map[string]string{LabelNamespace: "shop"}},
+	}, map[string]bool{"blog": true})
+
+	assert.Len(t, orphans, 2)
+	assert.Equal(t, "shop_back", orphans[0].Name)
+	assert.Equal(t, "shop_default", orphans[1].Name)
+}
+
+func TestRemoveNetworkInUse(t *testing.T) {
+	services, tasks := testNetworkServices()
+	// the cache has the versions before the last updates
+	cached := append([]swarm.Service(nil), services...)
+	for i := range services {
+		services[i].Version.Index = 5
+	}
+	var updated []swarm.ServiceSpec
+	var versions []string
+	var removed, disconnected []string
+	managerRouter := gin.New()
+	managerRouter.GET("/networks/:id", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, docker.Network{ID: "network1", Name: "blog_default", Labels: map[string]string{LabelNamespace: "blog"},
+			Containers: map[string]docker.Endpoint{"container3": {Name: "debug"}}})
+	})
+	managerRouter.GET("/services", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, services)
+	})
+	managerRouter.GET("/services/:id", func(ctx *gin.Context) {
+		for _, service := range services {
+			if service.ID == ctx.Param("id") {
+				ctx.JSON(http.StatusOK, service)
+				return
+			}
+		}
+		ctx.Status(http.StatusNotFound)
+	})
+	managerRouter.POST("/services/:id/update", func(ctx *gin.Context) {
+		var spec swarm.ServiceSpec
+		ctx.BindJSON(&spec)
+		updated = append(updated, spec)
+		versions = append(versions, ctx.Query("version"))
+		ctx.Status(http.StatusOK)
+	})
+	managerRouter.GET("/tasks", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, tasks)
+	})
+	managerRouter.POST("/networks/:id/disconnect", func(ctx *gin.Context) {
+		var opts docker.NetworkConnectionOptions
+		ctx.BindJSON(&opts)
+		disconnected = append(disconnected, opts.Container)
+		ctx.Status(http.StatusOK)
+	})
+	managerRouter.DELETE("/networks/:id", func(ctx *gin.Context) {
+		removed = append(removed, ctx.Param("id"))
+		ctx.Status(http.StatusNoContent)
+	})
+	manager := httptest.NewServer(managerRouter)
+	defer manager.Close()
+
+	swarmManager, err := docker.NewClient(manager.URL)
+	assert.Nil(t, err)
+	httpClient, err := NewHttpClient()
+	assert.Nil(t, err)
+	client := &CraneDockerClient{
+		sharedHttpClient:         httpClient,
+		swarmManagerHttpEndpoint: manager.URL,
+		swarmManager:             swarmManager,
+	}
+	client.swarmCache = newSwarmCache(time.Minute, func() (*swarmSnapshot, error) {
+		return &swarmSnapshot{services: cached, tasks: tasks, syncedAt: time.Now()}, nil
+	})
+	assert.Nil(t, client.swarmCache.Sync())
+
+	err = client.RemoveNetwork("network1", false)
+	assert.NotNil(t, err)
+	assert.Equal(t, CodeNetworkInUse, err.(*cranerror.CraneError).Code)
+	assert.Empty(t, removed)
+
+	err = client.RemoveNetwork("network1", true)
+	assert.Nil(t, err)
+	assert.Len(t, updated, 2)
+	assert.Empty(t, updated[0].Networks)
+	assert.Equal(t, []swarm.NetworkAttachmentConfig{{Target: "other"}}, updated[1].Networks)
+	assert.Equal(t, []string{"5", "5"}, versions)
+	assert.Equal(t, []string{"container3"}, disconnected)
+	assert.Equal(t, []string{"network1"}, removed)
+}
@@ -0,0 +1,162 @@
+package dockerclient
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Dataman-Cloud/crane/src/utils/httpclient"
+
+	docker "github.com/Dataman-Cloud/go-dockerclient"
+)
+
+const (
+	// how long a verified node endpoint is trusted before it is checked again
+	DefaultNodeClientTTL = time.Minute
+	// idle connections kept for each node daemon
+	NodeClientMaxIdleConns = 10
+)
+
+type NodeClientStats struct {
+	NodeID     string    `json:"NodeID"`
+	Endpoint   string    `json:"Endpoint"`
+	VerifiedAt time.Time `json:"VerifiedAt"`
+	Hits       uint64    `json:"Hits"`
+}
+
+type NodeClientPoolStats struct {
+	Size          int               `json:"Size"`
+	TTL           time.Duration     `json:"TTL"`
+	Hits          uint64            `json:"Hits"`
+	Misses        uint64            `json:"Misses"`
+	Verifications uint64            `json:"Verifications"`
+	Invalidations uint64            `json:"Invalidations"`
+	Nodes         []NodeClientStats `json:"Nodes"`
+}
+
+type nodeClientEntry struct {
+	client     *docker.Client
+	endpoint   string
+	verifiedAt time.Time
+	hits       uint64
+}
+
+// nodeClientPool caches one docker client per node id, the client reuses
+// its http connections and the verification of the node endpoint is trusted until ttl
+type nodeClientPool struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*nodeClientEntry
+
+	hits          uint64
+	misses        uint64
+	verifications uint64
+	invalidations uint64
+}
+
+func newNodeClientPool(ttl time.Duration) *nodeClientPool {
+	if ttl <= 0 {
+		ttl = DefaultNodeClientTTL
+	}
+
+	return &nodeClientPool{
+		ttl:     ttl,
+		entries: make(map[string]*nodeClientEntry),
+	}
+}
+
+// return the client of the node if the endpoint verification is not expired
+func (pool *nodeClientPool) Get(nodeId string) *docker.Client {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	entry, ok := pool.entries[nodeId]
+	if !ok || time.Since(entry.verifiedAt) > pool.ttl {
+		pool.misses++
+		return nil
+	}
+
+	pool.hits++
+	entry.hits++
+	return entry.client
+}
+
+// return the client of the node if it was created for the same endpoint,
+// the verification is renewed as the caller has just verified the endpoint
+func (pool *nodeClientPool) Renew(nodeId, endpoint string) *docker.Client {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	pool.verifications++
+	entry, ok := pool.entries[nodeId]
+	if !ok {
+		return nil
+	}
+
+	if entry.endpoint != endpoint {
+		delete(pool.entries, nodeId)
+		pool.invalidations++
+		return nil
+	}
+
+	entry.verifiedAt = time.Now()
+	return entry.client
+}
+
+func (pool *nodeClientPool) Put(nodeId, endpoint string, client *docker.Client) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	pool.entries[nodeId] = &nodeClientEntry{
+		client:     client,
+		endpoint:   endpoint,
+		verifiedAt: time.Now(),
+	}
+}
+
+// drop the client of the node, called when the node endpoint may change
+func (pool *nodeClientPool) Invalidate(nodeId string) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if _, ok := pool.entries[nodeId]; ok {
+		delete(pool.entries, nodeId)
+		pool.invalidations++
+	}
+}
+
+func (pool *nodeClientPool) Stats() NodeClientPoolStats {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	stats := NodeClientPoolStats{
+		Size:          len(pool.entries),
+		TTL:           pool.ttl,
+		Hits:          pool.hits,
+		Misses:        pool.misses,
+		Verifications: pool.verifications,
+		Invalidations: pool.invalidations,
+		Nodes:         make([]NodeClientStats, 0, len(pool.entries)),
+	}
+
+	for nodeId, entry := range pool.entries {
+		stats.Nodes = append(stats.Nodes, NodeClientStats{
+			NodeID:     nodeId,
+			Endpoint:   entry.endpoint,
+			VerifiedAt: entry.verifiedAt,
+			Hits:       entry.hits,
+		})
+	}
+	sort.Slice(stats.Nodes, func(i, j int) bool { return stats.Nodes[i].NodeID < stats.Nodes[j].NodeID })
+
+	return stats
+}
+
+// replace the transport of go-dockerclient, which disables keepalives, by a pooled one
+func usePooledTransport(client *docker.Client) {
+	tr := httpclient.DefaultPooledTransport()
+	tr.MaxIdleConnsPerHost = NodeClientMaxIdleConns
+	tr.TLSClientConfig = client.TLSConfig
+	client.HTTPClient = &http.Client{Transport: tr}
+}
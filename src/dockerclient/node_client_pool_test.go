@@ -0,0 +1,98 @@
+package dockerclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	docker "github.com/Dataman-Cloud/go-dockerclient"
+	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/swarm"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func TestNodeClientPool(t *testing.T) {
+	pool := newNodeClientPool(time.Minute)
+	assert.Nil(t, pool.Get("node1"))
+	assert.Nil(t, pool.Renew("node1", "http://127.0.0.1:2375"))
+
+	client := &docker.Client{}
+	pool.Put("node1", "http://127.0.0.1:2375", client)
+	assert.Equal(t, client, pool.Get("node1"))
+	assert.Equal(t, client, pool.Renew("node1", "http://127.0.0.1:2375"))
+
+	// endpoint label changed
+	assert.Nil(t, pool.Renew("node1", "http://127.0.0.2:2375"))
+	assert.Nil(t, pool.Get("node1"))
+
+	pool.Put("node1", "http://127.0.0.1:2375", client)
+	pool.Invalidate("node1")
+	assert.Nil(t, pool.Get("node1"))
+
+	stats := pool.Stats()
+	assert.Equal(t, 0, stats.Size)
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(3), stats.Misses)
+	assert.Equal(t, uint64(3), stats.Verifications)
+	assert.Equal(t, uint64(2), stats.Invalidations)
+}
+
+func TestNodeClientPoolExpired(t *testing.T) {
+	pool := newNodeClientPool(time.Minute)
+	pool.Put("node1", "http://127.0.0.1:2375", &docker.Client{})
+	pool.entries["node1"].verifiedAt = time.Now().Add(-2 * time.Minute)
+
+	assert.Nil(t, pool.Get("node1"))
+	assert.NotNil(t, pool.Renew("node1", "http://127.0.0.1:2375"))
+	assert.NotNil(t, pool.Get("node1"))
+	assert.Len(t, pool.Stats().Nodes, 1)
+}
+
+func TestSwarmNodeCached(t *testing.T) {
+	infoCount := 0
+	nodeRouter := gin.New()
+	nodeRouter.GET("/info", func(ctx *gin.Context) {
+		infoCount++
+		ctx.JSON(http.StatusOK, types.Info{Swarm: swarm.Info{NodeID: "node1"}})
+	})
+	nodeServer := httptest.NewServer(nodeRouter)
+	defer nodeServer.Close()
+
+	managerRouter := gin.New()
+	managerRouter.GET("/nodes/node1", func(ctx *gin.Context) {
+		var node swarm.Node
+		node.ID = "node1"
+		node.Spec.Annotations.Labels = map[string]string{LabelNodeEndpoint: nodeServer.URL}
+		ctx.JSON(http.StatusOK, node)
+	})
+	manager := httptest.NewServer(managerRouter)
+	defer manager.Close()
+
+	httpClient, err := NewHttpClient()
+	assert.Nil(t, err)
+	client := &CraneDockerClient{
+		sharedHttpClient:         httpClient,
+		swarmManagerHttpEndpoint: manager.URL,
+	}
+
+	craneContext := context.WithValue(context.Background(), "node_id", "node1")
+	first, err := client.SwarmNode(craneContext)
+	assert.Nil(t, err)
+	second, err := client.SwarmNode(craneContext)
+	assert.Nil(t, err)
+	assert.Equal(t, first, second)
+	assert.Equal(t, 1, infoCount)
+
+	client.nodeClientPool().Invalidate("node1")
+	third, err := client.SwarmNode(craneContext)
+	assert.Nil(t, err)
+	assert.NotEqual(t, first, third)
+	assert.Equal(t, 2, infoCount)
+
+	stats := client.NodeClientStats()
+	assert.Equal(t, 1, stats.Size)
+	assert.Equal(t, nodeServer.URL, stats.Nodes[0].Endpoint)
+}
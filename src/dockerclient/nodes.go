@@ -6,6 +6,7 @@ import (
 	"net/url"
 	"path"
 	"strconv"
+	"strings"
 
 	"github.com/Dataman-Cloud/crane/src/model"
 	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
@@ -13,6 +14,7 @@ import (
 	docker "github.com/Dataman-Cloud/go-dockerclient"
 	"github.com/docker/engine-api/types"
 	"github.com/docker/engine-api/types/swarm"
+	"github.com/docker/swarmkit/manager/scheduler"
 	"golang.org/x/net/context"
 )
 
@@ -25,13 +27,87 @@ const (
 	flagLabelRemove        = "label-rm"
 	flagLabelUpdate        = "label-update"
 	flagEndpointUpdate     = "endpoint-update"
+
+	nodeLabelPrefix   = "node.labels."
+	engineLabelPrefix = "engine.labels."
 )
 
 // NodeList returns the list of nodes.
 func (client *CraneDockerClient) ListNode(opts types.NodeListOptions) ([]swarm.Node, error) {
+	if snapshot, ok := client.swarmSnapshot(); ok {
+		return snapshot.nodes, nil
+	}
+
+	return client.listNode()
+}
+
+// MatchNodeConstraints tells if the node matches every constraint, the constraints are the placement constraints of a service:
+// node.id, node.hostname, node.role, node.labels.<label> or engine.labels.<label> compared with == or !=
+func MatchNodeConstraints(node swarm.Node, constraints []string) (bool, error) {
+	exprs, err := scheduler.ParseExprs(constraints)
+	if err != nil {
+		return false, cranerror.NewError(CodeInvalidNodeConstraint, err.Error())
+	}
+
+	for _, expr := range exprs {
+		var value string
+		key := strings.ToLower(expr.Key)
+		switch {
+		case key == "node.id":
+			value = node.ID
+		case key == "node.hostname":
+			value = node.Description.Hostname
+		case key == "node.role":
+			value = string(node.Spec.Role)
+		case len(key) > len(nodeLabelPrefix) && strings.HasPrefix(key, nodeLabelPrefix):
+			// the label itself is case sensitive
+			value = node.Spec.Annotations.Labels[expr.Key[len(nodeLabelPrefix):]]
+		case len(key) > len(engineLabelPrefix) && strings.HasPrefix(key, engineLabelPrefix):
+			value = node.Description.Engine.Labels[expr.Key[len(engineLabelPrefix):]]
+		default:
+			return false, cranerror.NewError(CodeInvalidNodeConstraint, "invalid constraint key: "+expr.Key)
+		}
+
+		if !expr.Match(value) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// the ready nodes matching the constraints, drained nodes are left out
+func (client *CraneDockerClient) ReadyNodesMatching(constraints []string) ([]swarm.Node, error) {
+	nodes, err := client.ListNode(types.NodeListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var matchedNodes []swarm.Node
+	for _, node := range nodes {
+		if node.Status.State != swarm.NodeStateReady || node.Spec.Availability == swarm.NodeAvailabilityDrain {
+			continue
+		}
+
+		matched, err := MatchNodeConstraints(node, constraints)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			matchedNodes = append(matchedNodes, node)
+		}
+	}
+	if len(matchedNodes) == 0 {
+		return nil, cranerror.NewError(CodeNoNodeMatched, "no ready node matches the constraints")
+	}
+
+	return matchedNodes, nil
+}
+
+func (client *CraneDockerClient) listNode() ([]swarm.Node, error) {
 	var nodes []swarm.Node
 
-	content, err := client.sharedHttpClient.GET(nil, client.swarmManagerHttpEndpoint+"/nodes", nil, nil)
+	content, err := client.managerHttpClient().GET(nil, client.ManagerEndpoint()+"/nodes", nil, nil)
 	if err != nil {
 		return nodes, err
 	}
@@ -117,21 +193,21 @@ func (client *CraneDockerClient) CreateNode(joiningNode model.JoiningNode) error
 		node.Spec.Annotations.Labels = make(map[string]string)
 	}
 	node.Spec.Annotations.Labels[LabelNodeEndpoint] = joiningNode.Endpoint
-	query := url.Values{}
-	query.Set("version", strconv.FormatUint(node.Version.Index, 10))
-	_, err = client.sharedHttpClient.POST(nil, client.swarmManagerHttpEndpoint+"/"+path.Join("nodes", nodeId, "update"), query, node.Spec, nil)
-	if err != nil {
-		return err
-	}
 
-	return nil
+	return client.updateNodeSpec(node)
 }
 
 // Inspect node returns the single node.
 func (client *CraneDockerClient) InspectNode(nodeId string) (swarm.Node, error) {
+	if snapshot, ok := client.swarmSnapshot(); ok {
+		if node, ok := snapshot.node(nodeId); ok {
+			return node, nil
+		}
+	}
+
 	var node swarm.Node
 
-	content, err := client.sharedHttpClient.GET(nil, client.swarmManagerHttpEndpoint+"/"+path.Join("nodes", nodeId), nil, nil)
+	content, err := client.managerHttpClient().GET(nil, client.ManagerEndpoint()+"/"+path.Join("nodes", nodeId), nil, nil)
 	if err != nil {
 		return node, err
 	}
@@ -145,11 +221,13 @@ func (client *CraneDockerClient) InspectNode(nodeId string) (swarm.Node, error)
 
 // Remove a single node
 func (client *CraneDockerClient) RemoveNode(nodeId string) error {
-	_, err := client.sharedHttpClient.DELETE(nil, client.swarmManagerHttpEndpoint+"/"+path.Join("nodes", nodeId), nil, nil)
+	_, err := client.managerHttpClient().DELETE(nil, client.ManagerEndpoint()+"/"+path.Join("nodes", nodeId), nil, nil)
 	if err != nil {
 		return err
 	}
 
+	client.nodeClientPool().Invalidate(nodeId)
+	client.invalidateSwarmCache()
 	return nil
 }
 
@@ -162,6 +240,9 @@ func (client *CraneDockerClient) UpdateNode(node swarm.Node, opts model.UpdateOp
 		if err != nil {
 			return err
 		}
+		if err := client.checkRoleChangeQuorum(node, role); err != nil {
+			return err
+		}
 		spec.Role = role
 	case flagUpdateAvailability:
 		availability, err := nodeAvailability(opts.Options)
@@ -189,16 +270,108 @@ func (client *CraneDockerClient) UpdateNode(node swarm.Node, opts model.UpdateOp
 		return cranerror.NewError(CodeErrorUpdateNodeMethod, fmt.Sprintf("Invalid update node method %s", opts.Method))
 	}
 
+	if err := client.updateNodeSpec(node); err != nil {
+		return err
+	}
+
+	// labels may carry a new endpoint of the node
+	if opts.Method != flagUpdateRole && opts.Method != flagUpdateAvailability {
+		client.nodeClientPool().Invalidate(node.ID)
+	}
+
+	return nil
+}
+
+func (client *CraneDockerClient) updateNodeSpec(node swarm.Node) error {
 	query := url.Values{}
 	query.Set("version", strconv.FormatUint(node.Version.Index, 10))
-	_, err := client.sharedHttpClient.POST(nil, client.swarmManagerHttpEndpoint+"/"+path.Join("nodes", node.ID, "update"), query, node.Spec, nil)
+	_, err := client.managerHttpClient().POST(nil, client.ManagerEndpoint()+"/"+path.Join("nodes", node.ID, "update"), query, node.Spec, nil)
+	if err != nil {
+		return err
+	}
+
+	client.invalidateSwarmCache()
+	return nil
+}
+
+// refuse the role change if the managers left reachable can not hold the raft quorum any more
+func (client *CraneDockerClient) checkRoleChangeQuorum(node swarm.Node, role swarm.NodeRole) error {
+	if node.Spec.Role == role {
+		return nil
+	}
+
+	nodes, err := client.listNode()
 	if err != nil {
 		return err
 	}
 
+	return roleChangeQuorumSafe(nodes, node.ID, role)
+}
+
+func roleChangeQuorumSafe(nodes []swarm.Node, nodeId string, role swarm.NodeRole) error {
+	var managers, reachable int
+	var target *swarm.Node
+	for i, n := range nodes {
+		if n.ID == nodeId {
+			target = &nodes[i]
+		}
+
+		if n.Spec.Role != swarm.NodeRoleManager {
+			continue
+		}
+
+		managers++
+		if n.ManagerStatus != nil && n.ManagerStatus.Reachability == swarm.ReachabilityReachable {
+			reachable++
+		}
+	}
+
+	if target == nil {
+		return cranerror.NewError(CodeNodeQuorumUnsafe, fmt.Sprintf("node %s not found in cluster", nodeId))
+	}
+
+	if target.Spec.Role == role {
+		return nil
+	}
+
+	switch role {
+	case swarm.NodeRoleWorker:
+		remaining := managers - 1
+		if remaining < 1 {
+			return cranerror.NewError(CodeNodeQuorumUnsafe, "can not demote the last manager of the cluster")
+		}
+
+		reachableRemaining := reachable
+		if target.ManagerStatus != nil && target.ManagerStatus.Reachability == swarm.ReachabilityReachable {
+			reachableRemaining--
+		}
+
+		if reachableRemaining < quorum(remaining) {
+			errMsg := fmt.Sprintf("demote node %s leaves %d reachable of %d managers, quorum needs %d",
+				nodeId, reachableRemaining, remaining, quorum(remaining))
+			return cranerror.NewError(CodeNodeQuorumUnsafe, errMsg)
+		}
+	case swarm.NodeRoleManager:
+		if target.Status.State != swarm.NodeStateReady {
+			errMsg := fmt.Sprintf("can not promote node %s in state %s", nodeId, target.Status.State)
+			return cranerror.NewError(CodeNodeQuorumUnsafe, errMsg)
+		}
+
+		if reachable+1 < quorum(managers+1) {
+			errMsg := fmt.Sprintf("promote node %s leaves %d reachable of %d managers, quorum needs %d",
+				nodeId, reachable+1, managers+1, quorum(managers+1))
+			return cranerror.NewError(CodeNodeQuorumUnsafe, errMsg)
+		}
+	}
+
 	return nil
 }
 
+// raft majority of the given managers count
+func quorum(managers int) int {
+	return managers/2 + 1
+}
+
 func nodeRole(rawMessage []byte) (swarm.NodeRole, error) {
 	var err error
 	var role swarm.NodeRole
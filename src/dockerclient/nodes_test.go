@@ -148,11 +148,21 @@ func TestUpdateNodeRole(t *testing.T) {
 	requestBody := node.Spec
 	requestBody.Role = "worker"
 
+	reachable := &swarm.ManagerStatus{Reachability: swarm.ReachabilityReachable}
+	nodes := []swarm.Node{
+		{ID: "nodeid", Spec: swarm.NodeSpec{Role: "manager"}, ManagerStatus: reachable},
+		{ID: "manager1", Spec: swarm.NodeSpec{Role: "manager"}, ManagerStatus: reachable},
+		{ID: "manager2", Spec: swarm.NodeSpec{Role: "manager"}, ManagerStatus: reachable},
+	}
+
 	mockServer.AddRouter("/_ping", "get").RGroup().
 		Reply(200)
 	mockServer.AddRouter("/version", "get").RGroup().
 		Reply(200).
 		WJSON(envs)
+	mockServer.AddRouter("/nodes", "get").RGroup().
+		Reply(200).
+		WJSON(nodes)
 	mockServer.AddRouter("/nodes/nodeid/update", "post").RGroup().
 		Reply(200).
 		RQuery("version=100").
@@ -1042,6 +1052,36 @@ func TestNodeAvailability(t *testing.T) {
 	assert.Equal(t, swarm.NodeAvailabilityDrain, availability)
 }
 
+func TestRoleChangeQuorumSafe(t *testing.T) {
+	reachable := &swarm.ManagerStatus{Reachability: swarm.ReachabilityReachable}
+	unreachable := &swarm.ManagerStatus{Reachability: swarm.ReachabilityUnreachable}
+	ready := swarm.NodeStatus{State: swarm.NodeStateReady}
+	nodes := []swarm.Node{
+		{ID: "m1", Spec: swarm.NodeSpec{Role: swarm.NodeRoleManager}, ManagerStatus: reachable, Status: ready},
+		{ID: "m2", Spec: swarm.NodeSpec{Role: swarm.NodeRoleManager}, ManagerStatus: reachable, Status: ready},
+		{ID: "m3", Spec: swarm.NodeSpec{Role: swarm.NodeRoleManager}, ManagerStatus: unreachable},
+		{ID: "w1", Spec: swarm.NodeSpec{Role: swarm.NodeRoleWorker}, Status: ready},
+		{ID: "w2", Spec: swarm.NodeSpec{Role: swarm.NodeRoleWorker}},
+	}
+
+	// 1 reachable of 2 managers left
+	assert.NotNil(t, roleChangeQuorumSafe(nodes, "m1", swarm.NodeRoleWorker))
+	// 2 reachable of 2 managers left
+	assert.Nil(t, roleChangeQuorumSafe(nodes, "m3", swarm.NodeRoleWorker))
+	// 3 reachable of 4 managers
+	assert.Nil(t, roleChangeQuorumSafe(nodes, "w1", swarm.NodeRoleManager))
+	// node not ready
+	assert.NotNil(t, roleChangeQuorumSafe(nodes, "w2", swarm.NodeRoleManager))
+	// role not changed
+	assert.Nil(t, roleChangeQuorumSafe(nodes, "m1", swarm.NodeRoleManager))
+	assert.NotNil(t, roleChangeQuorumSafe(nodes, "unknown", swarm.NodeRoleWorker))
+
+	lastManager := []swarm.Node{
+		{ID: "m1", Spec: swarm.NodeSpec{Role: swarm.NodeRoleManager}, ManagerStatus: reachable},
+	}
+	assert.NotNil(t, roleChangeQuorumSafe(lastManager, "m1", swarm.NodeRoleWorker))
+}
+
 func TestGetDaemonUrlByIdErrorKey(t *testing.T) {
 	body := `
 	{
@@ -1217,3 +1257,39 @@ func TestGetNodeIdByUrl(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.Equal(t, returnedNodeId, "")
 }
+
+func TestMatchNodeConstraints(t *testing.T) {
+	var node swarm.Node
+	node.ID = "node1"
+	node.Description.Hostname = "host1"
+	node.Description.Engine.Labels = map[string]string{"storage": "ssd"}
+	node.Spec.Role = swarm.NodeRoleManager
+	node.Spec.Annotations.Labels = map[string]string{"Zone": "a"}
+
+	for _, constraints := range [][]string{
+		nil,
+		{"node.id==node1"},
+		{"node.hostname!=host2", "node.role==MANAGER"},
+		{"node.labels.Zone==a", "engine.labels.storage==ssd"},
+		{"node.labels.zone!=a"},
+	} {
+		matched, err := MatchNodeConstraints(node, constraints)
+		assert.Nil(t, err)
+		assert.True(t, matched, "%v", constraints)
+	}
+
+	for _, constraints := range [][]string{
+		{"node.role==worker"},
+		{"node.labels.Zone==b"},
+		{"node.id==node1", "engine.labels.storage!=ssd"},
+	} {
+		matched, err := MatchNodeConstraints(node, constraints)
+		assert.Nil(t, err)
+		assert.False(t, matched, "%v", constraints)
+	}
+
+	_, err := MatchNodeConstraints(node, []string{"node.zone==a"})
+	assert.NotNil(t, err)
+	_, err = MatchNodeConstraints(node, []string{"node.id"})
+	assert.NotNil(t, err)
+}
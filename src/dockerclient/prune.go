@@ -0,0 +1,452 @@
+package dockerclient
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
+
+	docker "github.com/Dataman-Cloud/go-dockerclient"
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/swarm"
+	"golang.org/x/net/context"
+)
+
+const (
+	PruneImages     = "images"
+	PruneContainers = "containers"
+	PruneVolumes    = "volumes"
+	PruneNetworks   = "networks"
+)
+
+var (
+	PruneKinds = []string{PruneContainers, PruneImages, PruneVolumes, PruneNetworks}
+	// the volumes are pruned only if asked, they hold the data of the services scaled to 0
+	DefaultPruneKinds = []string{PruneContainers, PruneImages, PruneNetworks}
+
+	// containers in these states are reclaimable
+	stoppedContainerStates = map[string]bool{"created": true, "exited": true, "dead": true}
+	// networks docker creates on every node
+	predefinedNetworks = map[string]bool{"bridge": true, "host": true, "none": true, "docker_gwbridge": true, "ingress": true}
+	// the image of a container given by its id
+	imageIdPattern = regexp.MustCompile(`^(sha256:)?[a-f0-9]{12,64}$`)
+)
+
+// PruneOptions chooses what is pruned on which nodes, the default kinds on every ready node if empty
+type PruneOptions struct {
+	Kinds   []string `json:"Kinds"`
+	NodeIDs []string `json:"NodeIDs"`
+}
+
+// PruneItem is a container, an image, a volume or a network reclaimable on a node
+type PruneItem struct {
+	Kind string `json:"Kind"`
+	ID   string `json:"ID"`
+	Name string `json:"Name,omitempty"`
+	// bytes freed by its removal, the docker api does not report the size of volumes
+	Size    int64  `json:"Size"`
+	Removed bool   `json:"Removed"`
+	Error   string `json:"Error,omitempty"`
+}
+
+// NodePruneReport is what is reclaimable on a node, or what a prune removed from it
+type NodePruneReport struct {
+	NodeID           string      `json:"NodeID"`
+	Hostname         string      `json:"Hostname"`
+	Items            []PruneItem `json:"Items"`
+	ReclaimableSpace int64       `json:"ReclaimableSpace"`
+	SpaceReclaimed   int64       `json:"SpaceReclaimed"`
+	Error            string      `json:"Error,omitempty"`
+}
+
+type PruneReport struct {
+	DryRun           bool              `json:"DryRun"`
+	Kinds            []string          `json:"Kinds"`
+	StartedAt        time.Time         `json:"StartedAt"`
+	FinishedAt       time.Time         `json:"FinishedAt"`
+	Nodes            []NodePruneReport `json:"Nodes"`
+	ReclaimableSpace int64             `json:"ReclaimableSpace"`
+	SpaceReclaimed   int64             `json:"SpaceReclaimed"`
+}
+
+// PruneSchedule is the state of the prunes run every interval
+type PruneSchedule struct {
+	Enabled   bool         `json:"Enabled"`
+	Interval  int          `json:"Interval"`
+	Kinds     []string     `json:"Kinds"`
+	NextRunAt time.Time    `json:"NextRunAt"`
+	LastRun   *PruneReport `json:"LastRun"`
+}
+
+// the kinds to prune in the order they are pruned, the default kinds if none,
+// the containers go first as their images, volumes and networks are reclaimable once they are removed
+func ParsePruneKinds(kinds []string) ([]string, error) {
+	if len(kinds) == 0 {
+		return append([]string(nil), DefaultPruneKinds...), nil
+	}
+
+	chosen := make(map[string]bool)
+	for _, kind := range kinds {
+		valid := false
+		for _, k := range PruneKinds {
+			valid = valid || k == kind
+		}
+		if !valid {
+			return nil, cranerror.NewError(CodeInvalidPruneKind, "invalid prune kind: "+kind)
+		}
+		chosen[kind] = true
+	}
+
+	var ordered []string
+	for _, kind := range PruneKinds {
+		if chosen[kind] {
+			ordered = append(ordered, kind)
+		}
+	}
+
+	return ordered, nil
+}
+
+// PruneNodes finds what the kinds of opts make reclaimable on its nodes and removes it unless dryRun,
+// the images referenced by the service specs are never pruned
+func (client *CraneDockerClient) PruneNodes(ctx context.Context, opts PruneOptions, dryRun bool) (*PruneReport, error) {
+	kinds, err := ParsePruneKinds(opts.Kinds)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := client.pruneNodes(opts.NodeIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	services, err := client.ListServiceSpec(types.ServiceListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	protected := make(imageReferences)
+	for _, service := range services {
+		protected.add(service.Spec.TaskTemplate.ContainerSpec.Image)
+	}
+
+	report := &PruneReport{
+		DryRun:    dryRun,
+		Kinds:     kinds,
+		StartedAt: time.Now(),
+		Nodes:     make([]NodePruneReport, len(nodes)),
+	}
+
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i int, node swarm.Node) {
+			defer wg.Done()
+			report.Nodes[i] = client.pruneNode(ctx, node, kinds, protected, dryRun)
+		}(i, node)
+	}
+	wg.Wait()
+
+	for _, nodeReport := range report.Nodes {
+		report.ReclaimableSpace += nodeReport.ReclaimableSpace
+		report.SpaceReclaimed += nodeReport.SpaceReclaimed
+	}
+	report.FinishedAt = time.Now()
+
+	return report, nil
+}
+
+// the ready nodes of nodeIds, every ready node if none
+func (client *CraneDockerClient) pruneNodes(nodeIds []string) ([]swarm.Node, error) {
+	nodes, err := client.ListNode(types.NodeListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	readyNodes := make(map[string]swarm.Node)
+	var chosen []swarm.Node
+	for _, node := range nodes {
+		if node.Status.State == swarm.NodeStateReady {
+			readyNodes[node.ID] = node
+			if len(nodeIds) == 0 {
+				chosen = append(chosen, node)
+			}
+		}
+	}
+
+	for _, id := range nodeIds {
+		node, ok := readyNodes[id]
+		if !ok {
+			return nil, cranerror.NewError(CodePruneNodeNotReady, "node not found or not ready: "+id)
+		}
+		chosen = append(chosen, node)
+	}
+
+	return chosen, nil
+}
+
+func (client *CraneDockerClient) pruneNode(ctx context.Context, node swarm.Node, kinds []string, protected imageReferences, dryRun bool) NodePruneReport {
+	report := NodePruneReport{NodeID: node.ID, Hostname: node.Description.Hostname, Items: make([]PruneItem, 0)}
+
+	swarmNode, err := client.SwarmNode(context.WithValue(ctx, "node_id", node.ID))
+	if err == nil {
+		report.Items, err = reclaimableItems(ctx, swarmNode, kinds, protected)
+	}
+	if err != nil {
+		log.Errorf("find what is reclaimable on node %s got error: %s", node.ID, err.Error())
+		report.Error = err.Error()
+		return report
+	}
+
+	for i := range report.Items {
+		item := &report.Items[i]
+		report.ReclaimableSpace += item.Size
+		if dryRun {
+			continue
+		}
+
+		if err := removeItem(swarmNode, item); err != nil {
+			log.Warnf("prune %s %s on node %s got error: %s", item.Kind, item.ID, node.ID, err.Error())
+			item.Error = err.Error()
+			continue
+		}
+		item.Removed = true
+		report.SpaceReclaimed += item.Size
+	}
+
+	return report
+}
+
+// the items of the kinds reclaimable on the node, the containers kept hold their images, volumes and networks
+func reclaimableItems(ctx context.Context, swarmNode *docker.Client, kinds []string, protected imageReferences) ([]PruneItem, error) {
+	pruned := make(map[string]bool)
+	for _, kind := range kinds {
+		pruned[kind] = true
+	}
+
+	containers, err := swarmNode.ListContainers(docker.ListContainersOptions{All: true, Size: pruned[PruneContainers], Context: ctx})
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]PruneItem, 0)
+	used := make(imageReferences)
+	mounted := make(map[string]bool)
+	attached := make(map[string]bool)
+	for _, container := range containers {
+		if pruned[PruneContainers] && stoppedContainerStates[container.State] {
+			var name string
+			if len(container.Names) > 0 {
+				name = strings.TrimPrefix(container.Names[0], "/")
+			}
+			items = append(items, PruneItem{Kind: PruneContainers, ID: container.ID, Name: name, Size: container.SizeRw})
+			continue
+		}
+
+		used.add(container.Image)
+		for _, mount := range container.Mounts {
+			mounted[mount.Name] = true
+		}
+		for name := range container.Networks.Networks {
+			attached[name] = true
+		}
+	}
+
+	if pruned[PruneImages] {
+		images, err := swarmNode.ListImages(docker.ListImagesOptions{Context: ctx})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, image := range images {
+			if protected.match(image) || used.match(image) {
+				continue
+			}
+
+			name := "<none>"
+			if tags := imageTags(image); len(tags) > 0 {
+				name = strings.Join(tags, ",")
+			}
+			items = append(items, PruneItem{Kind: PruneImages, ID: image.ID, Name: name, Size: image.Size})
+		}
+	}
+
+	if pruned[PruneVolumes] {
+		volumes, err := swarmNode.ListVolumes(docker.ListVolumesOptions{Context: ctx})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, volume := range volumes {
+			if !mounted[volume.Name] {
+				items = append(items, PruneItem{Kind: PruneVolumes, ID: volume.Name, Name: volume.Name})
+			}
+		}
+	}
+
+	if pruned[PruneNetworks] {
+		networks, err := swarmNode.ListNetworks()
+		if err != nil {
+			return nil, err
+		}
+
+		// the swarm networks are removed through the managers
+		for _, network := range networks {
+			if network.Scope == "local" && !predefinedNetworks[network.Name] && !attached[network.Name] && len(network.Containers) == 0 {
+				items = append(items, PruneItem{Kind: PruneNetworks, ID: network.ID, Name: network.Name})
+			}
+		}
+	}
+
+	return items, nil
+}
+
+func removeItem(swarmNode *docker.Client, item *PruneItem) error {
+	switch item.Kind {
+	case PruneContainers:
+		return swarmNode.RemoveContainer(docker.RemoveContainerOptions{ID: item.ID})
+	case PruneImages:
+		// an image of several tags is removed by untagging them, removing it by id would need a force
+		refs := strings.Split(item.Name, ",")
+		if item.Name == "<none>" || len(refs) < 2 {
+			return swarmNode.RemoveImage(item.ID)
+		}
+		for _, ref := range refs {
+			if err := swarmNode.RemoveImage(ref); err != nil {
+				return err
+			}
+		}
+		return nil
+	case PruneVolumes:
+		return swarmNode.RemoveVolume(item.ID)
+	case PruneNetworks:
+		return swarmNode.RemoveNetwork(item.ID)
+	}
+
+	return nil
+}
+
+func imageTags(image docker.APIImages) []string {
+	var tags []string
+	for _, tag := range image.RepoTags {
+		if tag != "<none>:<none>" {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags
+}
+
+// image references normalized to compare the image of a service or a container
+// with the tags, the digests and the id of an image: nginx, nginx:latest and docker.io/library/nginx:latest are the same
+type imageReferences map[string]bool
+
+func (refs imageReferences) add(image string) {
+	if image == "" {
+		return
+	}
+
+	if imageIdPattern.MatchString(image) {
+		refs[strings.TrimPrefix(image, "sha256:")] = true
+		return
+	}
+
+	name, digest := image, ""
+	if i := strings.Index(image, "@"); i >= 0 {
+		name, digest = image[:i], image[i+1:]
+	}
+
+	repository, tag := docker.ParseRepositoryTag(name)
+	repository = normalizeRepository(repository)
+	if digest != "" {
+		refs[repository+"@"+digest] = true
+	} else if tag == "" {
+		tag = "latest"
+	}
+	if tag != "" {
+		refs[repository+":"+tag] = true
+	}
+}
+
+func (refs imageReferences) match(image docker.APIImages) bool {
+	id := strings.TrimPrefix(image.ID, "sha256:")
+	for ref := range refs {
+		if imageIdPattern.MatchString(ref) && strings.HasPrefix(id, ref) {
+			return true
+		}
+	}
+
+	for _, tag := range image.RepoTags {
+		repository, tag := docker.ParseRepositoryTag(tag)
+		if refs[normalizeRepository(repository)+":"+tag] {
+			return true
+		}
+	}
+
+	for _, digest := range image.RepoDigests {
+		if i := strings.Index(digest, "@"); i >= 0 && refs[normalizeRepository(digest[:i])+digest[i:]] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func normalizeRepository(repository string) string {
+	repository = strings.TrimPrefix(repository, "docker.io/")
+	return strings.TrimPrefix(repository, "library/")
+}
+
+// the state of the scheduled prunes
+func (client *CraneDockerClient) PruneSchedule() PruneSchedule {
+	client.pruneMu.Lock()
+	defer client.pruneMu.Unlock()
+
+	return client.pruneSchedule
+}
+
+// prune the kinds on every ready node every interval until stop closed
+func (client *CraneDockerClient) watchPrune(interval time.Duration, kinds []string, stop <-chan struct{}) {
+	client.pruneMu.Lock()
+	client.pruneSchedule = PruneSchedule{
+		Enabled:   true,
+		Interval:  int(interval / time.Second),
+		Kinds:     kinds,
+		NextRunAt: time.Now().Add(interval),
+	}
+	client.pruneMu.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			report, err := client.PruneNodes(context.Background(), PruneOptions{Kinds: kinds}, false)
+
+			client.pruneMu.Lock()
+			client.pruneSchedule.NextRunAt = time.Now().Add(interval)
+			if err == nil {
+				client.pruneSchedule.LastRun = report
+			}
+			client.pruneMu.Unlock()
+
+			if err != nil {
+				log.Errorf("scheduled prune got error: %s", err.Error())
+				continue
+			}
+
+			log.Infof("scheduled prune reclaimed %d bytes on %d nodes", report.SpaceReclaimed, len(report.Nodes))
+			client.PublishEvent(CraneEvent{
+				Type:       EventTypePrune,
+				Action:     "scheduled",
+				Attributes: map[string]string{"SpaceReclaimed": strconv.FormatInt(report.SpaceReclaimed, 10)},
+			})
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package dockerclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	docker "github.com/Dataman-Cloud/go-dockerclient"
+	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/swarm"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func TestParsePruneKinds(t *testing.T) {
+	// the volumes are opt in
+	kinds, err := ParsePruneKinds(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{PruneContainers, PruneImages, PruneNetworks}, kinds)
+	kinds[0] = PruneVolumes
+	assert.Equal(t, PruneContainers, DefaultPruneKinds[0])
+
+	kinds, err = ParsePruneKinds([]string{PruneVolumes})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{PruneVolumes}, kinds)
+
+	kinds, err = ParsePruneKinds([]string{PruneNetworks, PruneImages, PruneContainers})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{PruneContainers, PruneImages, PruneNetworks}, kinds)
+
+	_, err = ParsePruneKinds([]string{"secrets"})
+	assert.NotNil(t, err)
+}
+
+func TestImageReferences(t *testing.T) {
+	refs := make(imageReferences)
+	refs.add("nginx")
+	refs.add("registry:5000/blog/web:v1@sha256:0123")
+	refs.add("sha256:c0ffee0123456789")
+
+	assert.True(t, refs.match(docker.APIImages{ID: "sha256:1", RepoTags: []string{"docker.io/library/nginx:latest"}}))
+	assert.False(t, refs.match(docker.APIImages{ID: "sha256:2", RepoTags: []string{"nginx:1.11"}}))
+	assert.True(t, refs.match(docker.APIImages{ID: "sha256:3", RepoDigests: []string{"registry:5000/blog/web@sha256:0123"}}))
+	assert.True(t, refs.match(docker.APIImages{ID: "sha256:4", RepoTags: []string{"registry:5000/blog/web:v1"}}))
+	assert.True(t, refs.match(docker.APIImages{ID: "sha256:c0ffee0123456789abcdef"}))
+	assert.False(t, refs.match(docker.APIImages{ID: "sha256:5", RepoTags: []string{"<none>:<none>"}}))
+}
+
+func testPruneNode(t *testing.T, removed *[]string) *httptest.Server {
+	var mu sync.Mutex
+	remove := func(ctx *gin.Context) {
+		mu.Lock()
+		defer mu.Unlock()
+		*removed = append(*removed, ctx.Param("name"))
+		if ctx.Param("name") == "sha256:dangling" {
+			ctx.JSON(http.StatusConflict, map[string]string{"message": "image has dependent child images"})
+			return
+		}
+		ctx.Status(http.StatusNoContent)
+	}
+
+	nodeRouter := gin.New()
+	nodeRouter.GET("/info", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, types.Info{Swarm: swarm.Info{NodeID: "node1"}})
+	})
+	nodeRouter.GET("/version", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, map[string]string{"ApiVersion": "1.24"})
+	})
+	nodeRouter.GET("/containers/json", func(ctx *gin.Context) {
+		running := docker.APIContainers{ID: "web", Image: "nginx:latest@sha256:n1", State: "running",
+			Mounts: []docker.APIMount{{Name: "data"}}}
+		running.Networks.Networks = map[string]docker.ContainerNetwork{"appnet": {}}
+		ctx.JSON(http.StatusOK, []docker.APIContainers{
+			running,
+			{ID: "job", Names: []string{"/job"}, Image: "busybox", State: "exited", SizeRw: 100,
+				Mounts: []docker.APIMount{{Name: "cache"}}},
+		})
+	})
+	nodeRouter.GET("/images/json", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, []docker.APIImages{
+			{ID: "sha256:nginx", RepoTags: []string{"nginx:latest"}, RepoDigests: []string{"nginx@sha256:n1"}, Size: 10000},
+			{ID: "sha256:redis", RepoTags: []string{"redis:3.2"}, RepoDigests: []string{"redis@sha256:r1"}, Size: 10000},
+			{ID: "sha256:busybox", RepoTags: []string{"busybox:latest"}, Size: 1000},
+			{ID: "sha256:dangling", RepoTags: []string{"<none>:<none>"}, Size: 500},
+			{ID: "sha256:app", RepoTags: []string{"app:v1", "app:v2"}, Size: 2000},
+		})
+	})
+	nodeRouter.GET("/volumes", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, map[string][]docker.Volume{"Volumes": {{Name: "data"}, {Name: "cache"}, {Name: "orphan"}}})
+	})
+	nodeRouter.GET("/networks", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, []docker.Network{
+			{ID: "net-bridge", Name: "bridge", Scope: "local"},
+			{ID: "net-app", Name: "appnet", Scope: "local"},
+			{ID: "net-old", Name: "oldnet", Scope: "local"},
+			{ID: "net-blog", Name: "blog_default", Scope: "swarm"},
+		})
+	})
+	nodeRouter.DELETE("/containers/:name", remove)
+	nodeRouter.DELETE("/images/:name", remove)
+	nodeRouter.DELETE("/volumes/:name", remove)
+	nodeRouter.DELETE("/networks/:name", remove)
+
+	return httptest.NewServer(nodeRouter)
+}
+
+func TestPruneNodes(t *testing.T) {
+	var removed []string
+	nodeServer := testPruneNode(t, &removed)
+	defer nodeServer.Close()
+	client, closeManager := testNodeClient(t, nodeServer)
+	defer closeManager()
+
+	var node swarm.Node
+	node.ID = "node1"
+	node.Description.Hostname = "host1"
+	node.Status.State = swarm.NodeStateReady
+	node.Spec.Annotations.Labels = map[string]string{LabelNodeEndpoint: nodeServer.URL}
+	var service swarm.Service
+	service.ID = "service1"
+	service.Spec.TaskTemplate.ContainerSpec.Image = "redis:3.2@sha256:r1"
+	client.swarmCache = newSwarmCache(time.Minute, func() (*swarmSnapshot, error) {
+		return &swarmSnapshot{nodes: []swarm.Node{node}, services: []swarm.Service{service}, syncedAt: time.Now()}, nil
+	})
+	client.swarmCache.Sync()
+
+	_, err := client.PruneNodes(context.Background(), PruneOptions{NodeIDs: []string{"node2"}}, true)
+	assert.NotNil(t, err)
+
+	// the image of the stopped container is kept with the container
+	report, err := client.PruneNodes(context.Background(), PruneOptions{Kinds: []string{PruneImages}}, true)
+	assert.Nil(t, err)
+	assert.Len(t, report.Nodes, 1)
+	assert.Equal(t, int64(2500), report.ReclaimableSpace)
+
+	// the volumes only if asked
+	report, err = client.PruneNodes(context.Background(), PruneOptions{}, true)
+	assert.Nil(t, err)
+	for _, item := range report.Nodes[0].Items {
+		assert.NotEqual(t, PruneVolumes, item.Kind)
+	}
+
+	report, err = client.PruneNodes(context.Background(), PruneOptions{Kinds: PruneKinds}, true)
+	assert.Nil(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, "host1", report.Nodes[0].Hostname)
+	assert.Equal(t, int64(3600), report.ReclaimableSpace)
+	var reclaimable []string
+	for _, item := range report.Nodes[0].Items {
+		reclaimable = append(reclaimable, item.Kind+":"+item.ID)
+	}
+	assert.Equal(t, []string{
+		"containers:job",
+		"images:sha256:busybox", "images:sha256:dangling", "images:sha256:app",
+		"volumes:cache", "volumes:orphan",
+		"networks:net-old",
+	}, reclaimable)
+	assert.Empty(t, removed)
+
+	report, err = client.PruneNodes(context.Background(), PruneOptions{Kinds: PruneKinds, NodeIDs: []string{"node1"}}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"job", "sha256:busybox", "sha256:dangling", "app:v1", "app:v2", "cache", "orphan", "net-old"}, removed)
+	assert.Equal(t, int64(3100), report.SpaceReclaimed)
+	items := report.Nodes[0].Items
+	assert.True(t, items[0].Removed)
+	assert.False(t, items[2].Removed)
+	assert.Contains(t, items[2].Error, "dependent child images")
+}
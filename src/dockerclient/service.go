@@ -54,10 +54,11 @@ func (client *CraneDockerClient) CreateService(service swarm.ServiceSpec, option
 		}
 	}
 
-	content, err := client.sharedHttpClient.POST(nil, client.swarmManagerHttpEndpoint+"/services/create", nil, service, headers)
+	content, err := client.managerHttpClient().POST(nil, client.ManagerEndpoint()+"/services/create", nil, service, headers)
 	if err != nil {
 		return response, err
 	}
+	client.invalidateSwarmCache()
 
 	if err := json.Unmarshal(content, &response); err != nil {
 		return response, err
@@ -68,6 +69,16 @@ func (client *CraneDockerClient) CreateService(service swarm.ServiceSpec, option
 
 // ServiceList returns the list of services config
 func (client *CraneDockerClient) ListServiceSpec(options types.ServiceListOptions) ([]swarm.Service, error) {
+	if snapshot, ok := client.swarmSnapshot(); ok {
+		if services, ok := snapshot.filterServices(options.Filter); ok {
+			return services, nil
+		}
+	}
+
+	return client.listServiceSpec(options)
+}
+
+func (client *CraneDockerClient) listServiceSpec(options types.ServiceListOptions) ([]swarm.Service, error) {
 	var services []swarm.Service
 	query := url.Values{}
 	if options.Filter.Len() > 0 {
@@ -79,7 +90,7 @@ func (client *CraneDockerClient) ListServiceSpec(options types.ServiceListOption
 		query.Set("filters", filterJSON)
 	}
 
-	content, err := client.sharedHttpClient.GET(nil, client.swarmManagerHttpEndpoint+"/services", query, nil)
+	content, err := client.managerHttpClient().GET(nil, client.ManagerEndpoint()+"/services", query, nil)
 	if err != nil {
 		return services, err
 	}
@@ -189,8 +200,12 @@ func (client *CraneDockerClient) GetServicesStatus(services []swarm.Service) ([]
 
 // ServiceRemove kills and removes a service.
 func (client *CraneDockerClient) RemoveService(serviceID string) error {
-	_, err := client.sharedHttpClient.DELETE(nil, client.swarmManagerHttpEndpoint+"/services/"+serviceID, nil, nil)
-	return err
+	if _, err := client.managerHttpClient().DELETE(nil, client.ManagerEndpoint()+"/services/"+serviceID, nil, nil); err != nil {
+		return err
+	}
+
+	client.invalidateSwarmCache()
+	return nil
 }
 
 // ServiceUpdate updates a Service.o
@@ -204,10 +219,11 @@ func (client *CraneDockerClient) UpdateService(serviceID string, version swarm.V
 
 	query := url.Values{}
 	query.Set("version", strconv.FormatUint(version.Index, 10))
-	if _, err := client.sharedHttpClient.POST(nil, client.swarmManagerHttpEndpoint+"/services/"+serviceID+"/update", query, service, headers); err != nil {
+	if _, err := client.managerHttpClient().POST(nil, client.ManagerEndpoint()+"/services/"+serviceID+"/update", query, service, headers); err != nil {
 		return err
 	}
 
+	client.invalidateSwarmCache()
 	return nil
 }
 
@@ -233,7 +249,7 @@ func (client *CraneDockerClient) UpdateServiceAutoOption(serviceID string, versi
 
 // ScaleService update service replicas
 func (client *CraneDockerClient) ScaleService(serviceID string, serviceScale ServiceScale) error {
-	service, err := client.InspectServiceWithRaw(serviceID)
+	service, err := client.inspectService(serviceID)
 	if err != nil {
 		return err
 	}
@@ -249,9 +265,25 @@ func (client *CraneDockerClient) ScaleService(serviceID string, serviceScale Ser
 
 // InspectServiceWithRaw returns the service information and the raw data.
 func (client *CraneDockerClient) InspectServiceWithRaw(serviceID string) (swarm.Service, error) {
+	if snapshot, ok := client.swarmSnapshot(); ok {
+		if service, ok := snapshot.service(serviceID); ok {
+			return service, nil
+		}
+	}
+
+	return client.inspectService(serviceID)
+}
+
+// InspectServiceUncached reads the service from the manager, the updates send its version
+// and must not read it from the cache
+func (client *CraneDockerClient) InspectServiceUncached(serviceID string) (swarm.Service, error) {
+	return client.inspectService(serviceID)
+}
+
+func (client *CraneDockerClient) inspectService(serviceID string) (swarm.Service, error) {
 	var service swarm.Service
 
-	content, err := client.sharedHttpClient.GET(nil, client.swarmManagerHttpEndpoint+"/services/"+serviceID, nil, nil)
+	content, err := client.managerHttpClient().GET(nil, client.ManagerEndpoint()+"/services/"+serviceID, nil, nil)
 	if err != nil {
 		return service, err
 	}
@@ -265,7 +297,7 @@ func (client *CraneDockerClient) InspectServiceWithRaw(serviceID string) (swarm.
 
 // grant service permissions
 func (client *CraneDockerClient) ServiceAddLabel(serviceID string, labels map[string]string) error {
-	service, err := client.InspectServiceWithRaw(serviceID)
+	service, err := client.inspectService(serviceID)
 	if err != nil {
 		return err
 	}
@@ -279,7 +311,7 @@ func (client *CraneDockerClient) ServiceAddLabel(serviceID string, labels map[st
 
 // revoke service permissions
 func (client *CraneDockerClient) ServiceRemoveLabel(serviceID string, labels []string) error {
-	service, err := client.InspectServiceWithRaw(serviceID)
+	service, err := client.inspectService(serviceID)
 	if err != nil {
 		return err
 	}
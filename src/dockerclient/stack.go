@@ -126,6 +126,7 @@ func (client *CraneDockerClient) ListStack() (Stacks, error) {
 	}
 
 	stackMap := make(map[string]*Stack, 0)
+	serviceNamespaces := make(map[string]string)
 	for _, service := range services {
 		labels := service.Spec.Labels
 		name, ok := labels[LabelNamespace]
@@ -134,6 +135,7 @@ func (client *CraneDockerClient) ListStack() (Stacks, error) {
 			continue
 		}
 
+		serviceNamespaces[service.ID] = name
 		stack, ok := stackMap[name]
 		if !ok {
 			stackMap[name] = &Stack{
@@ -145,12 +147,20 @@ func (client *CraneDockerClient) ListStack() (Stacks, error) {
 		}
 	}
 
-	var stacks Stacks
-	for _, stack := range stackMap {
-		stackServices, err := client.ListStackService(stack.Namespace, types.ServiceListOptions{})
+	// the status of all stack services is computed at once instead of stack by stack
+	if len(serviceNamespaces) > 0 {
+		servicesSt, err := client.GetServicesStatus(services)
 		if err == nil {
-			stack.Services = stackServices
+			for _, serviceSt := range servicesSt {
+				if stack, ok := stackMap[serviceNamespaces[serviceSt.ID]]; ok {
+					stack.Services = append(stack.Services, serviceSt)
+				}
+			}
 		}
+	}
+
+	var stacks Stacks
+	for _, stack := range stackMap {
 		stacks = append(stacks, *stack)
 	}
 	sort.Sort(sort.Reverse(stacks))
@@ -189,13 +199,40 @@ func (client *CraneDockerClient) InspectStack(namespace string) (*model.Bundle,
 	}, nil
 }
 
-// remove all service and network in the stack
-func (client *CraneDockerClient) RemoveStack(namespace string) error {
+// remove all service and network in the stack, a network of the stack used outside of it
+// is detached from the services and containers using it with force or else nothing is removed
+func (client *CraneDockerClient) RemoveStack(namespace string, force bool) error {
 	services, err := client.FilterServiceByStack(namespace, types.ServiceListOptions{})
 	if err != nil {
 		return err
 	}
 
+	networks, err := client.filterStackNetwork(namespace)
+	if err != nil {
+		return err
+	}
+
+	var usages []*NetworkUsage
+	if len(networks) > 0 {
+		allServices, err := client.ListServiceSpec(types.ServiceListOptions{})
+		if err != nil {
+			return err
+		}
+
+		tasks, err := client.ListTasks(types.TaskListOptions{})
+		if err != nil {
+			return err
+		}
+
+		for _, network := range networks {
+			usage := networkUsage(network, allServices, tasks).outsideStack(namespace)
+			if usage.InUse() && !force {
+				return usage.inUseError()
+			}
+			usages = append(usages, usage)
+		}
+	}
+
 	for _, service := range services {
 		log.Info("begin to remove service ", service.Spec.Name)
 		if err := client.RemoveService(service.ID); err != nil {
@@ -203,14 +240,15 @@ func (client *CraneDockerClient) RemoveStack(namespace string) error {
 		}
 	}
 
-	networks, err := client.filterStackNetwork(namespace)
-	if err != nil {
-		return err
-	}
+	for _, usage := range usages {
+		if usage.InUse() {
+			if err := client.detachNetwork(usage); err != nil {
+				return err
+			}
+		}
 
-	for _, network := range networks {
-		log.Info("begin to remove network ", network.Name)
-		if err := client.RemoveNetwork(network.ID); err != nil {
+		log.Info("begin to remove network ", usage.NetworkName)
+		if err := client.removeNetwork(usage.NetworkID); err != nil {
 			return err
 		}
 	}
@@ -280,7 +318,8 @@ func (client *CraneDockerClient) updateNetworks(networks map[string]bool, namesp
 	createOpts := &docker.CreateNetworkOptions{
 		Labels: client.getStackLabels(namespace, nil),
 		Driver: DefaultNetworkDriver,
-		// docker TODO: remove when engine-api uses omitempty for IPAM
+		// docker TODO: remove when engine-api uses omitempty for IPAM,
+		// the subnet is allocated from the network pools by CreateNetwork if configured
 		IPAM: docker.IPAMOptions{Driver: "default"},
 	}
 
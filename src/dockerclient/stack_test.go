@@ -301,6 +301,6 @@ func TestStack(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, serviceStatus)
 
-	err = craneClient.RemoveStack("stack1")
+	err = craneClient.RemoveStack("stack1", false)
 	assert.Nil(t, err)
 }
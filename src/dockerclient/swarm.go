@@ -2,18 +2,29 @@ package dockerclient
 
 import (
 	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
 
+	"github.com/Dataman-Cloud/crane/src/model"
 	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
 
 	"github.com/docker/engine-api/types"
 	"github.com/docker/engine-api/types/swarm"
 )
 
+const (
+	// the minimum node certificate expiry accepted by swarmkit
+	minNodeCertExpiry = time.Hour
+)
+
 // Inspect swarm cluster returns the swarm info
 func (client *CraneDockerClient) InspectSwarm() (swarm.Swarm, error) {
 	var swarmInfo swarm.Swarm
 
-	content, err := client.sharedHttpClient.GET(nil, client.swarmManagerHttpEndpoint+"/swarm", nil, nil)
+	content, err := client.managerHttpClient().GET(nil, client.ManagerEndpoint()+"/swarm", nil, nil)
 	if err != nil {
 		return swarmInfo, err
 	}
@@ -27,14 +38,14 @@ func (client *CraneDockerClient) InspectSwarm() (swarm.Swarm, error) {
 
 // ping to test swarmManager connection
 func (client *CraneDockerClient) Ping() error {
-	return client.swarmManager.Ping()
+	return client.SwarmManager().Ping()
 }
 
 // Get Manager information, equal to client cmd `docker info` on the manager node
 func (client *CraneDockerClient) ManagerInfo() (types.Info, error) {
 	var systemInfo types.Info
 
-	content, err := client.sharedHttpClient.GET(nil, client.swarmManagerHttpEndpoint+"/info", nil, nil)
+	content, err := client.managerHttpClient().GET(nil, client.ManagerEndpoint()+"/info", nil, nil)
 	if err != nil {
 		return systemInfo, cranerror.NewError(CodeGetManagerInfoError, err.Error())
 	}
@@ -45,3 +56,149 @@ func (client *CraneDockerClient) ManagerInfo() (types.Info, error) {
 
 	return systemInfo, nil
 }
+
+// Init a new swarm cluster on the configured manager and returns the node id of the manager
+func (client *CraneDockerClient) InitSwarm(opts model.SwarmInitOptions) (string, error) {
+	var nodeId string
+
+	if err := validateSwarmSettings(opts.Settings); err != nil {
+		return nodeId, err
+	}
+
+	managerUrl, err := url.Parse(client.ManagerEndpoint())
+	if err != nil {
+		return nodeId, cranerror.NewError(CodeInitSwarmError, err.Error())
+	}
+
+	managerHost, _, err := net.SplitHostPort(managerUrl.Host)
+	if err != nil {
+		return nodeId, cranerror.NewError(CodeInitSwarmError, err.Error())
+	}
+
+	req := swarm.InitRequest{
+		ListenAddr:      opts.ListenAddr,
+		AdvertiseAddr:   opts.AdvertiseAddr,
+		ForceNewCluster: opts.ForceNewCluster,
+	}
+	if req.ListenAddr == "" {
+		req.ListenAddr = defaultListenAddr
+	}
+	if req.AdvertiseAddr == "" {
+		req.AdvertiseAddr = managerHost
+	}
+	applySwarmSettings(&req.Spec, opts.Settings)
+
+	content, err := client.managerHttpClient().POST(nil, client.ManagerEndpoint()+"/swarm/init", nil, req, nil)
+	if err != nil {
+		return nodeId, cranerror.NewError(CodeInitSwarmError, err.Error())
+	}
+
+	if err := json.Unmarshal(content, &nodeId); err != nil {
+		return nodeId, cranerror.NewError(CodeInitSwarmError, err.Error())
+	}
+
+	// the first manager is reached by crane through the manager endpoint
+	node, err := client.InspectNode(nodeId)
+	if err != nil {
+		return nodeId, err
+	}
+	if node.Spec.Annotations.Labels == nil {
+		node.Spec.Annotations.Labels = make(map[string]string)
+	}
+	node.Spec.Annotations.Labels[LabelNodeEndpoint] = client.ManagerEndpoint()
+
+	return nodeId, client.updateNodeSpec(node)
+}
+
+// Rotate the worker and/or manager join token, returns the join tokens after rotation
+func (client *CraneDockerClient) RotateJoinToken(opts model.SwarmRotateOptions) (swarm.JoinTokens, error) {
+	swarmInfo, err := client.InspectSwarm()
+	if err != nil {
+		return swarm.JoinTokens{}, err
+	}
+
+	if !opts.Worker && !opts.Manager {
+		return swarmInfo.JoinTokens, nil
+	}
+
+	query := url.Values{}
+	query.Set("version", strconv.FormatUint(swarmInfo.Version.Index, 10))
+	query.Set("rotateWorkerToken", strconv.FormatBool(opts.Worker))
+	query.Set("rotateManagerToken", strconv.FormatBool(opts.Manager))
+	if err := client.updateSwarmSpec(query, swarmInfo.Spec); err != nil {
+		return swarm.JoinTokens{}, err
+	}
+
+	swarmInfo, err = client.InspectSwarm()
+	if err != nil {
+		return swarm.JoinTokens{}, err
+	}
+
+	return swarmInfo.JoinTokens, nil
+}
+
+// Update swarm cluster settings, only the settings not nil are changed
+func (client *CraneDockerClient) UpdateSwarm(settings model.SwarmSettings) error {
+	if err := validateSwarmSettings(settings); err != nil {
+		return err
+	}
+
+	swarmInfo, err := client.InspectSwarm()
+	if err != nil {
+		return err
+	}
+
+	applySwarmSettings(&swarmInfo.Spec, settings)
+
+	query := url.Values{}
+	query.Set("version", strconv.FormatUint(swarmInfo.Version.Index, 10))
+	return client.updateSwarmSpec(query, swarmInfo.Spec)
+}
+
+func (client *CraneDockerClient) updateSwarmSpec(query url.Values, spec swarm.Spec) error {
+	_, err := client.managerHttpClient().POST(nil, client.ManagerEndpoint()+"/swarm/update", query, spec, nil)
+	if err != nil {
+		return cranerror.NewError(CodeUpdateSwarmError, err.Error())
+	}
+
+	return nil
+}
+
+func validateSwarmSettings(settings model.SwarmSettings) error {
+	if settings.TaskHistoryRetentionLimit != nil && *settings.TaskHistoryRetentionLimit < 0 {
+		return cranerror.NewError(CodeInvalidSwarmSettings, "task history retention limit can not be negative")
+	}
+
+	if settings.HeartbeatPeriod != nil && *settings.HeartbeatPeriod == 0 {
+		return cranerror.NewError(CodeInvalidSwarmSettings, "dispatcher heartbeat period must be positive")
+	}
+
+	if settings.NodeCertExpiry != nil && *settings.NodeCertExpiry < minNodeCertExpiry {
+		errMsg := fmt.Sprintf("node certificate expiry must be at least %s", minNodeCertExpiry)
+		return cranerror.NewError(CodeInvalidSwarmSettings, errMsg)
+	}
+
+	if settings.SnapshotInterval != nil && *settings.SnapshotInterval == 0 {
+		return cranerror.NewError(CodeInvalidSwarmSettings, "raft snapshot interval must be positive")
+	}
+
+	return nil
+}
+
+func applySwarmSettings(spec *swarm.Spec, settings model.SwarmSettings) {
+	if settings.TaskHistoryRetentionLimit != nil {
+		spec.Orchestration.TaskHistoryRetentionLimit = *settings.TaskHistoryRetentionLimit
+	}
+
+	if settings.HeartbeatPeriod != nil {
+		spec.Dispatcher.HeartbeatPeriod = *settings.HeartbeatPeriod
+	}
+
+	if settings.NodeCertExpiry != nil {
+		spec.CAConfig.NodeCertExpiry = *settings.NodeCertExpiry
+	}
+
+	if settings.SnapshotInterval != nil {
+		spec.Raft.SnapshotInterval = *settings.SnapshotInterval
+	}
+}
@@ -0,0 +1,400 @@
+package dockerclient
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	docker "github.com/Dataman-Cloud/go-dockerclient"
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/engine-api/types/filters"
+	"github.com/docker/engine-api/types/swarm"
+)
+
+const (
+	// the cache is synced at least every interval
+	DefaultSwarmCacheInterval = 5 * time.Second
+	// a snapshot older than staleFactor * interval is not served anymore
+	swarmCacheStaleFactor = 3
+)
+
+// docker event types telling the swarm state has changed,
+// only emitted by managers running docker 17.06 or later
+var swarmCacheEventTypes = map[string]bool{
+	"service": true,
+	"node":    true,
+	"network": true,
+}
+
+type SwarmCacheStatus struct {
+	Enabled   bool          `json:"Enabled"`
+	Synced    bool          `json:"Synced"`
+	Stale     bool          `json:"Stale"`
+	Dirty     bool          `json:"Dirty"`
+	Interval  time.Duration `json:"Interval"`
+	LastSync  time.Time     `json:"LastSync"`
+	Age       time.Duration `json:"Age"`
+	LastEvent time.Time     `json:"LastEvent"`
+	Syncs     uint64        `json:"Syncs"`
+	Hits      uint64        `json:"Hits"`
+	Misses    uint64        `json:"Misses"`
+	Nodes     int           `json:"Nodes"`
+	Services  int           `json:"Services"`
+	Tasks     int           `json:"Tasks"`
+	Networks  int           `json:"Networks"`
+	Error     string        `json:"Error,omitempty"`
+}
+
+// the swarm state read in one sync
+type swarmSnapshot struct {
+	nodes    []swarm.Node
+	services []swarm.Service
+	tasks    Tasks
+	networks []docker.Network
+	syncedAt time.Time
+}
+
+// swarmCache keeps the nodes, services, tasks and networks of the cluster in memory,
+// it is synced every interval and as soon as a swarm event or a write through crane happens
+type swarmCache struct {
+	// serialize sync, fetching the whole state could be slow
+	syncMu sync.Mutex
+
+	mu       sync.RWMutex
+	snapshot *swarmSnapshot
+	// set by writes through crane, the snapshot is not served until the next sync
+	dirty      bool
+	generation uint64
+	lastErr    error
+	lastEvent  time.Time
+
+	syncs  uint64
+	hits   uint64
+	misses uint64
+
+	interval time.Duration
+	fetch    func() (*swarmSnapshot, error)
+	trigger  chan struct{}
+	// called with the previous and the new snapshot after every successful sync
+	onSync func(previous, current *swarmSnapshot)
+}
+
+func newSwarmCache(interval time.Duration, fetch func() (*swarmSnapshot, error)) *swarmCache {
+	if interval <= 0 {
+		interval = DefaultSwarmCacheInterval
+	}
+
+	return &swarmCache{
+		interval: interval,
+		fetch:    fetch,
+		trigger:  make(chan struct{}, 1),
+	}
+}
+
+// read the whole swarm state and replace the snapshot
+func (cache *swarmCache) Sync() error {
+	cache.syncMu.Lock()
+	defer cache.syncMu.Unlock()
+
+	cache.mu.RLock()
+	generation := cache.generation
+	cache.mu.RUnlock()
+
+	snapshot, err := cache.fetch()
+
+	cache.mu.Lock()
+	cache.syncs++
+	cache.lastErr = err
+	if err != nil {
+		cache.mu.Unlock()
+		return err
+	}
+
+	previous := cache.snapshot
+	cache.snapshot = snapshot
+	// a write happened during the sync, the snapshot may miss it
+	if cache.generation == generation {
+		cache.dirty = false
+	} else {
+		cache.requestSync()
+	}
+	cache.mu.Unlock()
+
+	if cache.onSync != nil {
+		cache.onSync(previous, snapshot)
+	}
+
+	return nil
+}
+
+// mark the snapshot outdated and sync as soon as possible
+func (cache *swarmCache) Invalidate() {
+	cache.mu.Lock()
+	cache.dirty = true
+	cache.generation++
+	cache.mu.Unlock()
+
+	cache.requestSync()
+}
+
+// record a swarm event and sync as soon as possible
+func (cache *swarmCache) Notify(event *docker.APIEvents) {
+	if event == nil || !swarmCacheEventTypes[event.Type] {
+		return
+	}
+
+	cache.mu.Lock()
+	cache.lastEvent = time.Now()
+	cache.mu.Unlock()
+
+	cache.requestSync()
+}
+
+func (cache *swarmCache) requestSync() {
+	select {
+	case cache.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// return the snapshot if it is fresh enough to be served
+func (cache *swarmCache) Snapshot() (*swarmSnapshot, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.snapshot == nil || cache.dirty || cache.stale(cache.snapshot) {
+		cache.misses++
+		return nil, false
+	}
+
+	cache.hits++
+	return cache.snapshot, true
+}
+
+func (cache *swarmCache) stale(snapshot *swarmSnapshot) bool {
+	return time.Since(snapshot.syncedAt) > swarmCacheStaleFactor*cache.interval
+}
+
+// sync the cache every interval or when requested until stop closed
+func (cache *swarmCache) Watch(stop <-chan struct{}) {
+	ticker := time.NewTicker(cache.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-cache.trigger:
+		}
+
+		if err := cache.Sync(); err != nil {
+			log.Errorf("sync swarm cache got error: %s", err.Error())
+		}
+	}
+}
+
+func (cache *swarmCache) Status() SwarmCacheStatus {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	status := SwarmCacheStatus{
+		Enabled:   true,
+		Dirty:     cache.dirty,
+		Interval:  cache.interval,
+		LastEvent: cache.lastEvent,
+		Syncs:     cache.syncs,
+		Hits:      cache.hits,
+		Misses:    cache.misses,
+		Stale:     true,
+	}
+
+	if cache.lastErr != nil {
+		status.Error = cache.lastErr.Error()
+	}
+
+	if snapshot := cache.snapshot; snapshot != nil {
+		status.Synced = true
+		status.Stale = cache.stale(snapshot)
+		status.LastSync = snapshot.syncedAt
+		status.Age = time.Since(snapshot.syncedAt)
+		status.Nodes = len(snapshot.nodes)
+		status.Services = len(snapshot.services)
+		status.Tasks = len(snapshot.tasks)
+		status.Networks = len(snapshot.networks)
+	}
+
+	return status
+}
+
+// filters the snapshot could answer, other filters are sent to the manager
+var (
+	cachedServiceFilters = map[string]bool{"id": true, "name": true, "label": true}
+	cachedTaskFilters    = map[string]bool{"id": true, "service": true, "node": true, "desired-state": true}
+	cachedNetworkFilters = map[string]bool{"id": true, "name": true, "driver": true, "label": true}
+)
+
+// return the services matched by filter, false if the filter is not supported,
+// the items returned by filters share memory with the snapshot and must not be modified
+func (snapshot *swarmSnapshot) filterServices(filter filters.Args) ([]swarm.Service, bool) {
+	if err := filter.Validate(cachedServiceFilters); err != nil {
+		return nil, false
+	}
+
+	var services []swarm.Service
+	for _, service := range snapshot.services {
+		if !filter.FuzzyMatch("id", service.ID) || !filter.FuzzyMatch("name", service.Spec.Name) {
+			continue
+		}
+
+		if !filter.MatchKVList("label", service.Spec.Labels) {
+			continue
+		}
+
+		services = append(services, service)
+	}
+
+	return services, true
+}
+
+// return the tasks matched by filter, false if the filter is not supported
+func (snapshot *swarmSnapshot) filterTasks(filter filters.Args) (Tasks, bool) {
+	if err := filter.Validate(cachedTaskFilters); err != nil {
+		return nil, false
+	}
+
+	// service and node filters accept names as well
+	serviceIds := make(map[string]bool)
+	for _, service := range snapshot.services {
+		if filter.ExactMatch("service", service.ID) || filter.ExactMatch("service", service.Spec.Name) {
+			serviceIds[service.ID] = true
+		}
+	}
+
+	nodeIds := make(map[string]bool)
+	for _, node := range snapshot.nodes {
+		if filter.ExactMatch("node", node.ID) || filter.ExactMatch("node", node.Description.Hostname) {
+			nodeIds[node.ID] = true
+		}
+	}
+
+	var tasks Tasks
+	for _, task := range snapshot.tasks {
+		if !filter.FuzzyMatch("id", task.ID) || !filter.ExactMatch("desired-state", string(task.DesiredState)) {
+			continue
+		}
+
+		if filter.Include("service") && !serviceIds[task.ServiceID] {
+			continue
+		}
+
+		if filter.Include("node") && !nodeIds[task.NodeID] {
+			continue
+		}
+
+		tasks = append(tasks, task)
+	}
+
+	return tasks, true
+}
+
+// return the networks matched by opts, false if the filter is not supported
+func (snapshot *swarmSnapshot) filterNetworks(opts docker.NetworkFilterOpts) ([]docker.Network, bool) {
+	filter := filters.NewArgs()
+	for field, values := range opts {
+		for value, include := range values {
+			if include {
+				filter.Add(field, value)
+			}
+		}
+	}
+
+	if err := filter.Validate(cachedNetworkFilters); err != nil {
+		return nil, false
+	}
+
+	var networks []docker.Network
+	for _, network := range snapshot.networks {
+		if !filter.FuzzyMatch("id", network.ID) || !filter.ExactMatch("driver", network.Driver) {
+			continue
+		}
+
+		if filter.Include("name") && !matchNetworkName(filter, network.Name) {
+			continue
+		}
+
+		if !filter.MatchKVList("label", network.Labels) {
+			continue
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks, true
+}
+
+// docker matches network names by substring
+func matchNetworkName(filter filters.Args, name string) bool {
+	for _, value := range filter.Get("name") {
+		if strings.Contains(name, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lookups return a copy, callers usually modify the spec before an update
+func (snapshot *swarmSnapshot) service(idOrName string) (swarm.Service, bool) {
+	for _, service := range snapshot.services {
+		if service.ID == idOrName || service.Spec.Name == idOrName {
+			var copied swarm.Service
+			return copied, deepCopy(service, &copied) == nil
+		}
+	}
+
+	return swarm.Service{}, false
+}
+
+func (snapshot *swarmSnapshot) task(id string) (swarm.Task, bool) {
+	for _, task := range snapshot.tasks {
+		if task.ID == id {
+			var copied swarm.Task
+			return copied, deepCopy(task, &copied) == nil
+		}
+	}
+
+	return swarm.Task{}, false
+}
+
+func (snapshot *swarmSnapshot) node(idOrHostname string) (swarm.Node, bool) {
+	for _, node := range snapshot.nodes {
+		if node.ID == idOrHostname || node.Description.Hostname == idOrHostname {
+			var copied swarm.Node
+			return copied, deepCopy(node, &copied) == nil
+		}
+	}
+
+	return swarm.Node{}, false
+}
+
+func (snapshot *swarmSnapshot) network(idOrName string) (docker.Network, bool) {
+	for _, network := range snapshot.networks {
+		if network.ID == idOrName || network.Name == idOrName {
+			var copied docker.Network
+			return copied, deepCopy(network, &copied) == nil
+		}
+	}
+
+	return docker.Network{}, false
+}
+
+func deepCopy(src, dst interface{}) error {
+	content, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(content, dst)
+}
@@ -0,0 +1,247 @@
+package dockerclient
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	docker "github.com/Dataman-Cloud/go-dockerclient"
+	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/filters"
+	"github.com/docker/engine-api/types/swarm"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func testSwarmSnapshot() *swarmSnapshot {
+	var web, db swarm.Service
+	web.ID = "service1"
+	web.Spec.Name = "blog_web"
+	web.Spec.Labels = map[string]string{LabelNamespace: "blog"}
+	replicas := uint64(1)
+	web.Spec.Mode.Replicated = &swarm.ReplicatedService{Replicas: &replicas}
+	db.ID = "service2"
+	db.Spec.Name = "db"
+
+	var node swarm.Node
+	node.ID = "node1"
+	node.Description.Hostname = "host1"
+
+	return &swarmSnapshot{
+		nodes:    []swarm.Node{node},
+		services: []swarm.Service{web, db},
+		tasks: Tasks{
+			{ID: "task1", ServiceID: "service1", NodeID: "node1", DesiredState: swarm.TaskStateRunning},
+			{ID: "task2", ServiceID: "service2", NodeID: "node2", DesiredState: swarm.TaskStateShutdown},
+		},
+		networks: []docker.Network{
+			{ID: "network1", Name: "blog_default", Driver: "overlay", Labels: map[string]string{LabelNamespace: "blog"}},
+			{ID: "network2", Name: "bridge", Driver: "bridge"},
+		},
+		syncedAt: time.Now(),
+	}
+}
+
+func TestSwarmCacheSync(t *testing.T) {
+	fetchErr := errors.New("manager down")
+	var err error
+	cache := newSwarmCache(time.Minute, func() (*swarmSnapshot, error) {
+		if err != nil {
+			return nil, err
+		}
+		return testSwarmSnapshot(), nil
+	})
+
+	_, ok := cache.Snapshot()
+	assert.False(t, ok)
+
+	assert.Nil(t, cache.Sync())
+	snapshot, ok := cache.Snapshot()
+	assert.True(t, ok)
+	assert.Len(t, snapshot.services, 2)
+
+	cache.Invalidate()
+	_, ok = cache.Snapshot()
+	assert.False(t, ok)
+
+	err = fetchErr
+	assert.Equal(t, fetchErr, cache.Sync())
+	status := cache.Status()
+	assert.True(t, status.Dirty)
+	assert.Equal(t, fetchErr.Error(), status.Error)
+
+	err = nil
+	assert.Nil(t, cache.Sync())
+	status = cache.Status()
+	assert.False(t, status.Dirty)
+	assert.False(t, status.Stale)
+	assert.Equal(t, 2, status.Tasks)
+	assert.Equal(t, uint64(3), status.Syncs)
+	assert.Equal(t, uint64(1), status.Hits)
+	assert.Equal(t, uint64(2), status.Misses)
+}
+
+func TestSwarmCacheStale(t *testing.T) {
+	cache := newSwarmCache(time.Second, func() (*swarmSnapshot, error) {
+		snapshot := testSwarmSnapshot()
+		snapshot.syncedAt = time.Now().Add(-time.Minute)
+		return snapshot, nil
+	})
+
+	assert.Nil(t, cache.Sync())
+	_, ok := cache.Snapshot()
+	assert.False(t, ok)
+	assert.True(t, cache.Status().Stale)
+}
+
+func TestSwarmCacheNotify(t *testing.T) {
+	cache := newSwarmCache(time.Minute, nil)
+	cache.Notify(&docker.APIEvents{Type: "container"})
+	assert.True(t, cache.Status().LastEvent.IsZero())
+	assert.Len(t, cache.trigger, 0)
+
+	cache.Notify(&docker.APIEvents{Type: "service"})
+	cache.Notify(&docker.APIEvents{Type: "node"})
+	assert.False(t, cache.Status().LastEvent.IsZero())
+	assert.Len(t, cache.trigger, 1)
+}
+
+func TestSwarmSnapshotFilter(t *testing.T) {
+	snapshot := testSwarmSnapshot()
+
+	filter := filters.NewArgs()
+	filter.Add("label", LabelNamespace+"=blog")
+	services, ok := snapshot.filterServices(filter)
+	assert.True(t, ok)
+	assert.Len(t, services, 1)
+	assert.Equal(t, "service1", services[0].ID)
+
+	filter = filters.NewArgs()
+	filter.Add("mode", "global")
+	_, ok = snapshot.filterServices(filter)
+	assert.False(t, ok)
+
+	filter = filters.NewArgs()
+	filter.Add("service", "db")
+	tasks, ok := snapshot.filterTasks(filter)
+	assert.True(t, ok)
+	assert.Len(t, tasks, 1)
+	assert.Equal(t, "task2", tasks[0].ID)
+
+	filter = filters.NewArgs()
+	filter.Add("node", "host1")
+	filter.Add("desired-state", string(swarm.TaskStateRunning))
+	tasks, ok = snapshot.filterTasks(filter)
+	assert.True(t, ok)
+	assert.Len(t, tasks, 1)
+	assert.Equal(t, "task1", tasks[0].ID)
+
+	networks, ok := snapshot.filterNetworks(docker.NetworkFilterOpts{"driver": {"overlay": true}})
+	assert.True(t, ok)
+	assert.Len(t, networks, 1)
+	networks, ok = snapshot.filterNetworks(docker.NetworkFilterOpts{"name": {"blog": true}})
+	assert.True(t, ok)
+	assert.Len(t, networks, 1)
+	_, ok = snapshot.filterNetworks(docker.NetworkFilterOpts{"type": {"custom": true}})
+	assert.False(t, ok)
+}
+
+func TestSwarmSnapshotLookupCopy(t *testing.T) {
+	snapshot := testSwarmSnapshot()
+
+	service, ok := snapshot.service("blog_web")
+	assert.True(t, ok)
+	service.Spec.Labels["foo"] = "bar"
+	_, exists := snapshot.services[0].Spec.Labels["foo"]
+	assert.False(t, exists)
+
+	_, ok = snapshot.node("host1")
+	assert.True(t, ok)
+	_, ok = snapshot.task("task3")
+	assert.False(t, ok)
+	_, ok = snapshot.network("bridge")
+	assert.True(t, ok)
+}
+
+func TestListStackFromCache(t *testing.T) {
+	requests := 0
+	router := gin.New()
+	router.Use(func(ctx *gin.Context) {
+		requests++
+	})
+	router.GET("/services", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, testSwarmSnapshot().services)
+	})
+	router.GET("/tasks", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, testSwarmSnapshot().tasks)
+	})
+	router.GET("/nodes", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, testSwarmSnapshot().nodes)
+	})
+	manager := httptest.NewServer(router)
+	defer manager.Close()
+
+	httpClient, err := NewHttpClient()
+	assert.Nil(t, err)
+	client := &CraneDockerClient{
+		sharedHttpClient:         httpClient,
+		swarmManagerHttpEndpoint: manager.URL,
+	}
+
+	// one request for services, tasks and nodes each whatever the stack count
+	stacks, err := client.ListStack()
+	assert.Nil(t, err)
+	assert.Len(t, stacks, 1)
+	assert.Len(t, stacks[0].Services, 1)
+	assert.Equal(t, 3, requests)
+
+	client.swarmCache = newSwarmCache(time.Minute, func() (*swarmSnapshot, error) {
+		return testSwarmSnapshot(), nil
+	})
+	assert.Nil(t, client.swarmCache.Sync())
+
+	requests = 0
+	stacks, err = client.ListStack()
+	assert.Nil(t, err)
+	assert.Len(t, stacks, 1)
+	assert.Equal(t, 1, stacks[0].Services[0].NumTasksTotal)
+	assert.Equal(t, 0, requests)
+
+	_, err = client.ListTasks(types.TaskListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, requests)
+	assert.False(t, client.SwarmCacheStatus().Stale)
+}
+
+func TestInspectServiceUncached(t *testing.T) {
+	router := gin.New()
+	router.GET("/services/service1", func(ctx *gin.Context) {
+		service := testSwarmSnapshot().services[0]
+		service.Version.Index = 7
+		ctx.JSON(http.StatusOK, service)
+	})
+	manager := httptest.NewServer(router)
+	defer manager.Close()
+
+	httpClient, err := NewHttpClient()
+	assert.Nil(t, err)
+	client := &CraneDockerClient{
+		sharedHttpClient:         httpClient,
+		swarmManagerHttpEndpoint: manager.URL,
+	}
+	client.swarmCache = newSwarmCache(time.Minute, func() (*swarmSnapshot, error) {
+		return testSwarmSnapshot(), nil
+	})
+	assert.Nil(t, client.swarmCache.Sync())
+
+	cached, err := client.InspectServiceWithRaw("service1")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), cached.Version.Index)
+
+	// the updates send the version of the manager
+	service, err := client.InspectServiceUncached("service1")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(7), service.Version.Index)
+}
@@ -1,15 +1,18 @@
 package dockerclient
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/docker/engine-api/types"
 	"github.com/docker/engine-api/types/swarm"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/Dataman-Cloud/crane/src/model"
 	mock "github.com/Dataman-Cloud/crane/src/testing"
 	"github.com/Dataman-Cloud/crane/src/utils/config"
 )
@@ -151,3 +154,134 @@ func TestManagerInfoErrorJSON(t *testing.T) {
 	_, err = client.ManagerInfo()
 	assert.NotNil(t, err)
 }
+
+func TestInitSwarm(t *testing.T) {
+	var initRequest swarm.InitRequest
+	var nodeSpec swarm.NodeSpec
+	managerRouter := gin.New()
+	managerRouter.POST("/swarm/init", func(ctx *gin.Context) {
+		ctx.BindJSON(&initRequest)
+		ctx.JSON(http.StatusOK, "fakeManagerID")
+	})
+	managerRouter.GET("/nodes/fakeManagerID", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, swarm.Node{ID: "fakeManagerID"})
+	})
+	managerRouter.POST("/nodes/fakeManagerID/update", func(ctx *gin.Context) {
+		ctx.BindJSON(&nodeSpec)
+		ctx.JSON(http.StatusOK, "")
+	})
+
+	manager := httptest.NewServer(managerRouter)
+	defer manager.Close()
+
+	httpClient, err := NewHttpClient()
+	client := &CraneDockerClient{
+		sharedHttpClient:         httpClient,
+		swarmManagerHttpEndpoint: manager.URL,
+	}
+
+	limit := int64(10)
+	nodeId, err := client.InitSwarm(model.SwarmInitOptions{
+		Settings: model.SwarmSettings{TaskHistoryRetentionLimit: &limit},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "fakeManagerID", nodeId)
+	assert.Equal(t, defaultListenAddr, initRequest.ListenAddr)
+	assert.Equal(t, "127.0.0.1", initRequest.AdvertiseAddr)
+	assert.Equal(t, limit, initRequest.Spec.Orchestration.TaskHistoryRetentionLimit)
+	assert.Equal(t, manager.URL, nodeSpec.Labels[LabelNodeEndpoint])
+
+	negative := int64(-1)
+	_, err = client.InitSwarm(model.SwarmInitOptions{
+		Settings: model.SwarmSettings{TaskHistoryRetentionLimit: &negative},
+	})
+	assert.NotNil(t, err)
+}
+
+func TestUpdateSwarm(t *testing.T) {
+	var version string
+	var spec swarm.Spec
+	managerRouter := gin.New()
+	managerRouter.GET("/swarm", func(ctx *gin.Context) {
+		var body swarm.Swarm
+		body.Version.Index = 11
+		body.Spec.Raft.SnapshotInterval = 10000
+		ctx.JSON(http.StatusOK, body)
+	})
+	managerRouter.POST("/swarm/update", func(ctx *gin.Context) {
+		version = ctx.Query("version")
+		ctx.BindJSON(&spec)
+		ctx.JSON(http.StatusOK, "")
+	})
+
+	manager := httptest.NewServer(managerRouter)
+	defer manager.Close()
+
+	httpClient, err := NewHttpClient()
+	client := &CraneDockerClient{
+		sharedHttpClient:         httpClient,
+		swarmManagerHttpEndpoint: manager.URL,
+	}
+
+	expiry := 48 * time.Hour
+	err = client.UpdateSwarm(model.SwarmSettings{NodeCertExpiry: &expiry})
+	assert.Nil(t, err)
+	assert.Equal(t, "11", version)
+	assert.Equal(t, expiry, spec.CAConfig.NodeCertExpiry)
+	assert.Equal(t, uint64(10000), spec.Raft.SnapshotInterval)
+
+	expiry = time.Minute
+	err = client.UpdateSwarm(model.SwarmSettings{NodeCertExpiry: &expiry})
+	assert.NotNil(t, err)
+
+	var zero uint64
+	err = client.UpdateSwarm(model.SwarmSettings{HeartbeatPeriod: &zero})
+	assert.NotNil(t, err)
+}
+
+func TestRotateJoinToken(t *testing.T) {
+	rotated := false
+	var rotateWorker, rotateManager string
+	managerRouter := gin.New()
+	managerRouter.GET("/swarm", func(ctx *gin.Context) {
+		var body swarm.Swarm
+		body.JoinTokens.Worker = "FakeWorkerToken"
+		if rotated {
+			body.JoinTokens.Worker = "NewWorkerToken"
+		}
+		ctx.JSON(http.StatusOK, body)
+	})
+	managerRouter.POST("/swarm/update", func(ctx *gin.Context) {
+		rotateWorker = ctx.Query("rotateWorkerToken")
+		rotateManager = ctx.Query("rotateManagerToken")
+		rotated = true
+		ctx.JSON(http.StatusOK, "")
+	})
+
+	manager := httptest.NewServer(managerRouter)
+	defer manager.Close()
+
+	httpClient, err := NewHttpClient()
+	client := &CraneDockerClient{
+		sharedHttpClient:         httpClient,
+		swarmManagerHttpEndpoint: manager.URL,
+	}
+
+	tokens, err := client.RotateJoinToken(model.SwarmRotateOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "FakeWorkerToken", tokens.Worker)
+
+	tokens, err = client.RotateJoinToken(model.SwarmRotateOptions{Worker: true})
+	assert.Nil(t, err)
+	assert.Equal(t, "NewWorkerToken", tokens.Worker)
+	assert.Equal(t, "true", rotateWorker)
+	assert.Equal(t, "false", rotateManager)
+}
+
+func TestSwarmSettingsJSON(t *testing.T) {
+	var settings model.SwarmSettings
+	err := json.Unmarshal([]byte(`{"SnapshotInterval": 5000}`), &settings)
+	assert.Nil(t, err)
+	assert.Nil(t, settings.TaskHistoryRetentionLimit)
+	assert.Equal(t, uint64(5000), *settings.SnapshotInterval)
+}
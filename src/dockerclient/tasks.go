@@ -26,6 +26,16 @@ func (t Tasks) Less(i, j int) bool {
 
 // TaskList returns the list of tasks.
 func (client *CraneDockerClient) ListTasks(options types.TaskListOptions) (Tasks, error) {
+	if snapshot, ok := client.swarmSnapshot(); ok {
+		if tasks, ok := snapshot.filterTasks(options.Filter); ok {
+			return tasks, nil
+		}
+	}
+
+	return client.listTasks(options)
+}
+
+func (client *CraneDockerClient) listTasks(options types.TaskListOptions) (Tasks, error) {
 	query := url.Values{}
 
 	if options.Filter.Len() > 0 {
@@ -38,7 +48,7 @@ func (client *CraneDockerClient) ListTasks(options types.TaskListOptions) (Tasks
 	}
 
 	var tasks Tasks
-	content, err := client.sharedHttpClient.GET(nil, client.swarmManagerHttpEndpoint+"/tasks", query, nil)
+	content, err := client.managerHttpClient().GET(nil, client.ManagerEndpoint()+"/tasks", query, nil)
 	if err != nil {
 		return tasks, err
 	}
@@ -54,9 +64,15 @@ func (client *CraneDockerClient) ListTasks(options types.TaskListOptions) (Tasks
 
 // TaskInspect returns the list of tasks.
 func (client *CraneDockerClient) InspectTask(taskID string) (*swarm.Task, error) {
+	if snapshot, ok := client.swarmSnapshot(); ok {
+		if task, ok := snapshot.task(taskID); ok {
+			return &task, nil
+		}
+	}
+
 	task := &swarm.Task{}
 
-	content, err := client.sharedHttpClient.GET(nil, client.swarmManagerHttpEndpoint+"/tasks/"+taskID, nil, nil)
+	content, err := client.managerHttpClient().GET(nil, client.ManagerEndpoint()+"/tasks/"+taskID, nil, nil)
 	if err != nil {
 		return task, err
 	}
@@ -0,0 +1,251 @@
+package dockerclient
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	docker "github.com/Dataman-Cloud/go-dockerclient"
+	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/swarm"
+)
+
+const (
+	TopologyNetwork   = "network"
+	TopologyService   = "service"
+	TopologyTask      = "task"
+	TopologyContainer = "container"
+	TopologyNode      = "node"
+	TopologyPort      = "port"
+
+	// service to network and task to network
+	TopologyEdgeAttachment = "attachment"
+	// service to task
+	TopologyEdgeTask = "task"
+	// task to container
+	TopologyEdgeContainer = "container"
+	// task to node
+	TopologyEdgePlacement = "placement"
+	// published port to service
+	TopologyEdgePublish = "publish"
+)
+
+var topologyShapes = map[string]string{
+	TopologyNetwork:   "ellipse",
+	TopologyService:   "box",
+	TopologyTask:      "note",
+	TopologyContainer: "component",
+	TopologyNode:      "box3d",
+	TopologyPort:      "diamond",
+}
+
+// TopologyVertex is a network, a service, a task, a container, a node or a published port,
+// its ID is its kind and its docker id: service:2kcmd1bp...
+type TopologyVertex struct {
+	ID         string            `json:"ID"`
+	Kind       string            `json:"Kind"`
+	Name       string            `json:"Name"`
+	Attributes map[string]string `json:"Attributes,omitempty"`
+}
+
+type TopologyEdge struct {
+	From       string            `json:"From"`
+	To         string            `json:"To"`
+	Kind       string            `json:"Kind"`
+	Attributes map[string]string `json:"Attributes,omitempty"`
+}
+
+type Topology struct {
+	Vertices []TopologyVertex `json:"Vertices"`
+	Edges    []TopologyEdge   `json:"Edges"`
+
+	vertexIndex map[string]bool
+}
+
+func (topology *Topology) addVertex(kind, id, name string, attributes map[string]string) string {
+	vertexId := kind + ":" + id
+	if !topology.vertexIndex[vertexId] {
+		topology.vertexIndex[vertexId] = true
+		topology.Vertices = append(topology.Vertices, TopologyVertex{ID: vertexId, Kind: kind, Name: name, Attributes: attributes})
+	}
+
+	return vertexId
+}
+
+func (topology *Topology) addEdge(kind, from, to string, attributes map[string]string) {
+	topology.Edges = append(topology.Edges, TopologyEdge{From: from, To: to, Kind: kind, Attributes: attributes})
+}
+
+// Topology returns the graph of the services of the stack namespace, every service if empty,
+// with the networks they are attached to, their ports, their running tasks with the containers and the nodes of the tasks
+func (client *CraneDockerClient) Topology(namespace string) (*Topology, error) {
+	services, err := client.ListServiceSpec(types.ServiceListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	tasks, err := client.ListTasks(types.TaskListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	networks, err := client.ListNetworks(docker.NetworkFilterOpts{})
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := client.ListNode(types.NodeListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return buildTopology(namespace, services, tasks, networks, nodes), nil
+}
+
+func buildTopology(namespace string, services []swarm.Service, tasks Tasks, networks []docker.Network, nodes []swarm.Node) *Topology {
+	topology := &Topology{
+		Vertices:    make([]TopologyVertex, 0),
+		Edges:       make([]TopologyEdge, 0),
+		vertexIndex: make(map[string]bool),
+	}
+
+	// the service specs name their networks by name or by id
+	networkIndex := make(map[string]docker.Network)
+	for _, network := range networks {
+		networkIndex[network.ID] = network
+		networkIndex[network.Name] = network
+	}
+	addNetwork := func(idOrName string) string {
+		network, ok := networkIndex[idOrName]
+		if !ok {
+			return topology.addVertex(TopologyNetwork, idOrName, idOrName, nil)
+		}
+		return topology.addVertex(TopologyNetwork, network.ID, network.Name, map[string]string{"Driver": network.Driver, "Scope": network.Scope})
+	}
+
+	nodeIndex := make(map[string]swarm.Node)
+	for _, node := range nodes {
+		nodeIndex[node.ID] = node
+	}
+
+	// the lists may be the ones of the swarm cache
+	services = append([]swarm.Service(nil), services...)
+	sort.Slice(services, func(i, j int) bool { return services[i].Spec.Name < services[j].Spec.Name })
+	serviceVertices := make(map[string]string)
+	serviceNames := make(map[string]string)
+	for _, service := range services {
+		if namespace != "" && service.Spec.Labels[LabelNamespace] != namespace {
+			continue
+		}
+
+		attributes := map[string]string{"Image": service.Spec.TaskTemplate.ContainerSpec.Image}
+		if service.Spec.Labels[LabelNamespace] != "" {
+			attributes["Namespace"] = service.Spec.Labels[LabelNamespace]
+		}
+		serviceVertex := topology.addVertex(TopologyService, service.ID, service.Spec.Name, attributes)
+		serviceVertices[service.ID] = serviceVertex
+		serviceNames[service.ID] = service.Spec.Name
+
+		vips := make(map[string]string)
+		for _, vip := range service.Endpoint.VirtualIPs {
+			vips[vip.NetworkID] = vip.Addr
+		}
+		for _, attachment := range service.Spec.Networks {
+			edgeAttributes := make(map[string]string)
+			if len(attachment.Aliases) > 0 {
+				edgeAttributes["Aliases"] = strings.Join(attachment.Aliases, ",")
+			}
+			if network, ok := networkIndex[attachment.Target]; ok && vips[network.ID] != "" {
+				edgeAttributes["VIP"] = vips[network.ID]
+			}
+			topology.addEdge(TopologyEdgeAttachment, serviceVertex, addNetwork(attachment.Target), edgeAttributes)
+		}
+
+		for _, port := range service.Endpoint.Ports {
+			if port.PublishedPort == 0 {
+				continue
+			}
+			name := fmt.Sprintf("%d/%s", port.PublishedPort, port.Protocol)
+			portVertex := topology.addVertex(TopologyPort, name, name, nil)
+			topology.addEdge(TopologyEdgePublish, portVertex, serviceVertex, map[string]string{
+				"TargetPort": strconv.FormatUint(uint64(port.TargetPort), 10),
+			})
+		}
+	}
+
+	tasks = append(Tasks(nil), tasks...)
+	sort.Sort(tasks)
+	for _, task := range tasks {
+		serviceVertex, ok := serviceVertices[task.ServiceID]
+		if !ok || task.DesiredState != swarm.TaskStateRunning {
+			continue
+		}
+
+		// named like its container: blog_web.1, or blog_web.<node id> for a global service
+		name := serviceNames[task.ServiceID] + "." + task.NodeID
+		if task.Slot > 0 {
+			name = serviceNames[task.ServiceID] + "." + strconv.Itoa(task.Slot)
+		}
+		taskVertex := topology.addVertex(TopologyTask, task.ID, name, map[string]string{"State": string(task.Status.State)})
+		topology.addEdge(TopologyEdgeTask, serviceVertex, taskVertex, nil)
+
+		for _, attachment := range task.NetworksAttachments {
+			topology.addEdge(TopologyEdgeAttachment, taskVertex, addNetwork(attachment.Network.ID), map[string]string{
+				"Addresses": strings.Join(attachment.Addresses, ","),
+			})
+		}
+
+		if containerId := task.Status.ContainerStatus.ContainerID; containerId != "" {
+			containerVertex := topology.addVertex(TopologyContainer, containerId, shortId(containerId), nil)
+			topology.addEdge(TopologyEdgeContainer, taskVertex, containerVertex, nil)
+		}
+
+		if task.NodeID != "" {
+			node := nodeIndex[task.NodeID]
+			name := node.Description.Hostname
+			if name == "" {
+				name = task.NodeID
+			}
+			nodeVertex := topology.addVertex(TopologyNode, task.NodeID, name, map[string]string{"State": string(node.Status.State)})
+			topology.addEdge(TopologyEdgePlacement, taskVertex, nodeVertex, nil)
+		}
+	}
+
+	return topology
+}
+
+func shortId(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+
+	return id
+}
+
+// DOT renders the topology in the graphviz dot language
+func (topology *Topology) DOT() string {
+	var buf bytes.Buffer
+	buf.WriteString("digraph topology {\n\trankdir=LR;\n")
+	for _, vertex := range topology.Vertices {
+		label := vertex.Kind + "\\n" + vertex.Name
+		fmt.Fprintf(&buf, "\t%s [label=%s, shape=%s];\n", dotQuote(vertex.ID), dotQuote(label), topologyShapes[vertex.Kind])
+	}
+	for _, edge := range topology.Edges {
+		var labels []string
+		for _, key := range []string{"VIP", "Aliases", "Addresses", "TargetPort"} {
+			if value := edge.Attributes[key]; value != "" {
+				labels = append(labels, value)
+			}
+		}
+		fmt.Fprintf(&buf, "\t%s -> %s [label=%s];\n", dotQuote(edge.From), dotQuote(edge.To), dotQuote(strings.Join(labels, " ")))
+	}
+	buf.WriteString("}\n")
+
+	return buf.String()
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
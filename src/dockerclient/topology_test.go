@@ -0,0 +1,85 @@
+package dockerclient
+
+import (
+	"strings"
+	"testing"
+
+	docker "github.com/Dataman-Cloud/go-dockerclient"
+	"github.com/docker/engine-api/types/swarm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildTopology(t *testing.T) {
+	var web, db, other swarm.Service
+	web.ID = "service1"
+	web.Spec.Name = "blog_web"
+	web.Spec.Labels = map[string]string{LabelNamespace: "blog"}
+	web.Spec.Networks = []swarm.NetworkAttachmentConfig{{Target: "blog_default", Aliases: []string{"web"}}}
+	web.Endpoint.VirtualIPs = []swarm.EndpointVirtualIP{{NetworkID: "network1", Addr: "10.0.0.2/24"}}
+	web.Endpoint.Ports = []swarm.PortConfig{{Protocol: swarm.PortConfigProtocolTCP, TargetPort: 80, PublishedPort: 8080}}
+	db.ID = "service2"
+	db.Spec.Name = "blog_db"
+	db.Spec.Labels = map[string]string{LabelNamespace: "blog"}
+	db.Spec.Networks = []swarm.NetworkAttachmentConfig{{Target: "network1", Aliases: []string{"db"}}}
+	other.ID = "service3"
+	other.Spec.Name = "shop_web"
+	other.Spec.Labels = map[string]string{LabelNamespace: "shop"}
+
+	var node swarm.Node
+	node.ID = "node1"
+	node.Description.Hostname = "host1"
+	node.Status.State = swarm.NodeStateReady
+
+	running := swarm.Task{ID: "task1", ServiceID: "service1", NodeID: "node1", Slot: 1, DesiredState: swarm.TaskStateRunning}
+	running.Status.State = swarm.TaskStateRunning
+	running.Status.ContainerStatus.ContainerID = "c0ffee0123456789"
+	running.NetworksAttachments = []swarm.NetworkAttachment{{Addresses: []string{"10.0.0.3/24"}}}
+	running.NetworksAttachments[0].Network.ID = "network1"
+	tasks := Tasks{
+		running,
+		{ID: "task2", ServiceID: "service1", NodeID: "node1", Slot: 1, DesiredState: swarm.TaskStateShutdown},
+		{ID: "task3", ServiceID: "service3", NodeID: "node1", Slot: 1, DesiredState: swarm.TaskStateRunning},
+	}
+	networks := []docker.Network{{ID: "network1", Name: "blog_default", Driver: "overlay", Scope: "swarm"}}
+
+	topology := buildTopology("blog", []swarm.Service{web, db, other}, tasks, networks, []swarm.Node{node})
+
+	var vertices []string
+	for _, vertex := range topology.Vertices {
+		vertices = append(vertices, vertex.ID+"="+vertex.Name)
+	}
+	assert.Equal(t, []string{
+		"service:service2=blog_db",
+		"network:network1=blog_default",
+		"service:service1=blog_web",
+		"port:8080/tcp=8080/tcp",
+		"task:task1=blog_web.1",
+		"container:c0ffee0123456789=c0ffee012345",
+		"node:node1=host1",
+	}, vertices)
+
+	var edges []string
+	for _, edge := range topology.Edges {
+		edges = append(edges, edge.Kind+" "+edge.From+" "+edge.To)
+	}
+	assert.Equal(t, []string{
+		"attachment service:service2 network:network1",
+		"attachment service:service1 network:network1",
+		"publish port:8080/tcp service:service1",
+		"task service:service1 task:task1",
+		"attachment task:task1 network:network1",
+		"container task:task1 container:c0ffee0123456789",
+		"placement task:task1 node:node1",
+	}, edges)
+	assert.Equal(t, "10.0.0.2/24", topology.Edges[1].Attributes["VIP"])
+	assert.Equal(t, "web", topology.Edges[1].Attributes["Aliases"])
+	assert.Equal(t, "10.0.0.3/24", topology.Edges[4].Attributes["Addresses"])
+
+	dot := topology.DOT()
+	assert.True(t, strings.HasPrefix(dot, "digraph topology {"))
+	assert.Contains(t, dot, `"service:service1" [label="service\nblog_web", shape=box];`)
+	assert.Contains(t, dot, `"service:service1" -> "network:network1" [label="10.0.0.2/24 web"];`)
+
+	topology = buildTopology("", []swarm.Service{web, db, other}, tasks, networks, []swarm.Node{node})
+	assert.Len(t, topology.Vertices, 9)
+}
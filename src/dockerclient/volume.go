@@ -1,12 +1,32 @@
 package dockerclient
 
 import (
+	"archive/tar"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
 	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
 
 	docker "github.com/Dataman-Cloud/go-dockerclient"
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/swarm"
 	"golang.org/x/net/context"
 )
 
+const (
+	// the image of the helper containers backing up and restoring volumes if not configured
+	DefaultVolumeHelperImage = "busybox:latest"
+	// label of the helper containers, the volume they mount
+	LabelVolumeHelper = "com.crane.volume.helper"
+
+	// where a helper container mounts its volume
+	volumeHelperMountPath = "/volume"
+)
+
 func (client *CraneDockerClient) InspectVolume(ctx context.Context, name string) (*docker.Volume, error) {
 	swarmNode, err := client.SwarmNode(ctx)
 	if err != nil {
@@ -43,3 +63,305 @@ func (client *CraneDockerClient) RemoveVolume(ctx context.Context, name string)
 	}
 	return swarmNode.RemoveVolume(name)
 }
+
+// VolumeMount is a container mounting a volume
+type VolumeMount struct {
+	ContainerID   string `json:"ContainerID"`
+	ContainerName string `json:"ContainerName"`
+	State         string `json:"State"`
+	Destination   string `json:"Destination"`
+	RW            bool   `json:"RW"`
+	ServiceID     string `json:"ServiceID,omitempty"`
+	ServiceName   string `json:"ServiceName,omitempty"`
+	TaskID        string `json:"TaskID,omitempty"`
+}
+
+// NodeVolume is a volume of a node with the containers mounting it
+type NodeVolume struct {
+	docker.Volume
+	NodeID   string        `json:"NodeID"`
+	Hostname string        `json:"Hostname"`
+	Mounts   []VolumeMount `json:"Mounts"`
+}
+
+type VolumeInventory struct {
+	Volumes []NodeVolume `json:"Volumes"`
+	// the nodes whose volumes could not be listed
+	NodeErrors map[string]string `json:"NodeErrors,omitempty"`
+}
+
+// ListClusterVolumes lists the volumes of the ready nodes, or of the node nodeId if set,
+// with the containers and the services mounting them
+func (client *CraneDockerClient) ListClusterVolumes(ctx context.Context, nodeId string, opts docker.ListVolumesOptions) (VolumeInventory, error) {
+	inventory := VolumeInventory{Volumes: make([]NodeVolume, 0)}
+
+	nodes, err := client.ListNode(types.NodeListOptions{})
+	if err != nil {
+		return inventory, err
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		if node.Status.State != swarm.NodeStateReady || (nodeId != "" && node.ID != nodeId) {
+			continue
+		}
+
+		wg.Add(1)
+		go func(node swarm.Node) {
+			defer wg.Done()
+
+			volumes, err := client.nodeVolumes(context.WithValue(ctx, "node_id", node.ID), node, opts)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Errorf("list volumes of node %s got error: %s", node.ID, err.Error())
+				if inventory.NodeErrors == nil {
+					inventory.NodeErrors = make(map[string]string)
+				}
+				inventory.NodeErrors[node.ID] = err.Error()
+				return
+			}
+			inventory.Volumes = append(inventory.Volumes, volumes...)
+		}(node)
+	}
+	wg.Wait()
+
+	sort.Slice(inventory.Volumes, func(i, j int) bool {
+		if inventory.Volumes[i].Name != inventory.Volumes[j].Name {
+			return inventory.Volumes[i].Name < inventory.Volumes[j].Name
+		}
+		return inventory.Volumes[i].Hostname < inventory.Volumes[j].Hostname
+	})
+
+	return inventory, nil
+}
+
+func (client *CraneDockerClient) nodeVolumes(ctx context.Context, node swarm.Node, opts docker.ListVolumesOptions) ([]NodeVolume, error) {
+	swarmNode, err := client.SwarmNode(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.Context = ctx
+	volumes, err := swarmNode.ListVolumes(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	containers, err := swarmNode.ListContainers(docker.ListContainersOptions{All: true, Context: ctx})
+	if err != nil {
+		return nil, err
+	}
+
+	mounts := make(map[string][]VolumeMount)
+	for _, container := range containers {
+		var name string
+		if len(container.Names) > 0 {
+			name = strings.TrimPrefix(container.Names[0], "/")
+		}
+
+		for _, mount := range container.Mounts {
+			if mount.Name == "" {
+				continue
+			}
+			mounts[mount.Name] = append(mounts[mount.Name], VolumeMount{
+				ContainerID:   container.ID,
+				ContainerName: name,
+				State:         container.State,
+				Destination:   mount.Destination,
+				RW:            mount.RW,
+				ServiceID:     container.Labels["com.docker.swarm.service.id"],
+				ServiceName:   container.Labels["com.docker.swarm.service.name"],
+				TaskID:        container.Labels["com.docker.swarm.task.id"],
+			})
+		}
+	}
+
+	nodeVolumes := make([]NodeVolume, 0, len(volumes))
+	for _, volume := range volumes {
+		volumeMounts := mounts[volume.Name]
+		if volumeMounts == nil {
+			volumeMounts = make([]VolumeMount, 0)
+		}
+		nodeVolumes = append(nodeVolumes, NodeVolume{
+			Volume:   volume,
+			NodeID:   node.ID,
+			Hostname: node.Description.Hostname,
+			Mounts:   volumeMounts,
+		})
+	}
+
+	return nodeVolumes, nil
+}
+
+// the backup of a volume being read, closing it removes the helper container
+type volumeBackup struct {
+	io.Reader
+
+	archive   *io.PipeReader
+	cancel    context.CancelFunc
+	done      chan struct{}
+	swarmNode *docker.Client
+	helperId  string
+}
+
+func (backup *volumeBackup) Close() error {
+	backup.archive.Close()
+	backup.cancel()
+	<-backup.done
+
+	return removeVolumeHelper(backup.swarmNode, backup.helperId)
+}
+
+// OpenVolumeBackup reads the contents of the volume of the node stored in ctx as a tar archive,
+// the entries are relative to the root of the volume. Closing the reader removes the helper container it is read from
+func (client *CraneDockerClient) OpenVolumeBackup(ctx context.Context, name string) (io.ReadCloser, error) {
+	swarmNode, helperId, err := client.createVolumeHelper(ctx, name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	downloadContext, cancel := context.WithCancel(context.Background())
+	downloaded, downloadWriter := io.Pipe()
+	archive, archiveWriter := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		err := swarmNode.DownloadFromContainer(helperId, docker.DownloadFromContainerOptions{
+			Path:         volumeHelperMountPath,
+			OutputStream: downloadWriter,
+			Context:      downloadContext,
+		})
+		downloadWriter.CloseWithError(err)
+	}()
+	go func() {
+		err := rebaseVolumeArchive(downloaded, archiveWriter)
+		if err != nil {
+			log.Errorf("back up volume %s got error: %s", name, err.Error())
+		}
+		downloaded.CloseWithError(err)
+		archiveWriter.CloseWithError(err)
+		close(done)
+	}()
+
+	return &volumeBackup{
+		Reader:    archive,
+		archive:   archive,
+		cancel:    cancel,
+		done:      done,
+		swarmNode: swarmNode,
+		helperId:  helperId,
+	}, nil
+}
+
+// the archive docker makes of /volume has the entries volume, volume/data/db...,
+// they are written to w relative to the root of the volume: data/db...
+func rebaseVolumeArchive(r io.Reader, w io.Writer) error {
+	root := path.Base(volumeHelperMountPath)
+	tarReader := tar.NewReader(r)
+	tarWriter := tar.NewWriter(w)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		header.Name = strings.TrimPrefix(strings.TrimPrefix(header.Name, root), "/")
+		if header.Name == "" {
+			continue
+		}
+		if header.Typeflag == tar.TypeLink {
+			header.Linkname = strings.TrimPrefix(strings.TrimPrefix(header.Linkname, root), "/")
+		}
+
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tarWriter, tarReader); err != nil {
+			return err
+		}
+	}
+
+	return tarWriter.Close()
+}
+
+// RestoreVolume loads the tar archive read from r into the volume of the node stored in ctx through a helper container,
+// the entries are relative to the root of the volume and the files of the volume out of the archive are kept
+func (client *CraneDockerClient) RestoreVolume(ctx context.Context, name string, r io.Reader) error {
+	swarmNode, helperId, err := client.createVolumeHelper(ctx, name, false)
+	if err != nil {
+		return err
+	}
+	defer removeVolumeHelper(swarmNode, helperId)
+
+	err = swarmNode.UploadToContainer(helperId, docker.UploadToContainerOptions{
+		Path:        volumeHelperMountPath,
+		InputStream: r,
+	})
+	if err != nil {
+		log.Errorf("restore volume %s got error: %s", name, err.Error())
+		return cranerror.NewError(CodeRestoreVolumeError, err.Error())
+	}
+
+	return nil
+}
+
+// create the helper container mounting the volume at volumeHelperMountPath, its image is pulled if missing.
+// It is never started: the archive api mounts the volumes of a container itself
+func (client *CraneDockerClient) createVolumeHelper(ctx context.Context, name string, readOnly bool) (*docker.Client, string, error) {
+	swarmNode, err := client.SwarmNode(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// binding a missing volume would create it
+	if _, err := swarmNode.InspectVolume(name); err == docker.ErrNoSuchVolume {
+		return nil, "", cranerror.NewError(CodeVolumeNotFound, "no such volume: "+name)
+	} else if err != nil {
+		return nil, "", err
+	}
+
+	image := DefaultVolumeHelperImage
+	if client.config != nil && client.config.VolumeHelperImage != "" {
+		image = client.config.VolumeHelperImage
+	}
+
+	bind := name + ":" + volumeHelperMountPath
+	if readOnly {
+		bind += ":ro"
+	}
+	opts := docker.CreateContainerOptions{
+		Config: &docker.Config{
+			Image:  image,
+			Cmd:    []string{"true"},
+			Labels: map[string]string{LabelVolumeHelper: name},
+		},
+		HostConfig: &docker.HostConfig{Binds: []string{bind}},
+		Context:    ctx,
+	}
+
+	container, err := swarmNode.CreateContainer(opts)
+	if err == docker.ErrNoSuchImage {
+		repository, tag := docker.ParseRepositoryTag(image)
+		if err = swarmNode.PullImage(docker.PullImageOptions{Repository: repository, Tag: tag}, docker.AuthConfiguration{}); err == nil {
+			container, err = swarmNode.CreateContainer(opts)
+		}
+	}
+	if err != nil {
+		log.Errorf("create helper container of volume %s got error: %s", name, err.Error())
+		return nil, "", cranerror.NewError(CodeVolumeHelperError, err.Error())
+	}
+
+	return swarmNode, container.ID, nil
+}
+
+func removeVolumeHelper(swarmNode *docker.Client, helperId string) error {
+	err := swarmNode.RemoveContainer(docker.RemoveContainerOptions{ID: helperId, Force: true})
+	if err != nil {
+		log.Errorf("remove volume helper container %s got error: %s", helperId, err.Error())
+	}
+
+	return err
+}
@@ -1,12 +1,22 @@
 package dockerclient
 
 import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
 
 	docker "github.com/Dataman-Cloud/go-dockerclient"
+	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/swarm"
+	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/net/context"
 )
@@ -76,3 +86,150 @@ func TestVolume(t *testing.T) {
 	err = craneClient.RemoveVolume(craneContext, "testupc")
 	assert.Nil(t, err)
 }
+
+type testVolumeNode struct {
+	binds    []string
+	pulled   []string
+	restored []byte
+	removed  []string
+}
+
+func (node *testVolumeNode) server(t *testing.T) *httptest.Server {
+	nodeRouter := gin.New()
+	nodeRouter.GET("/info", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, types.Info{Swarm: swarm.Info{NodeID: "node1"}})
+	})
+	nodeRouter.GET("/version", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, map[string]string{"ApiVersion": "1.24"})
+	})
+	nodeRouter.GET("/volumes", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, map[string][]docker.Volume{"Volumes": {{Name: "data", Driver: "local"}, {Name: "logs", Driver: "local"}}})
+	})
+	nodeRouter.GET("/volumes/:name", func(ctx *gin.Context) {
+		if ctx.Param("name") != "data" {
+			ctx.JSON(http.StatusNotFound, map[string]string{"message": "no such volume"})
+			return
+		}
+		ctx.JSON(http.StatusOK, docker.Volume{Name: "data", Driver: "local"})
+	})
+	nodeRouter.GET("/containers/json", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, []docker.APIContainers{{
+			ID:     "web",
+			Names:  []string{"/blog_web.1.x"},
+			State:  "running",
+			Labels: map[string]string{"com.docker.swarm.service.id": "service1", "com.docker.swarm.service.name": "blog_web"},
+			Mounts: []docker.APIMount{{Name: "data", Destination: "/var/lib/web", RW: true}},
+		}})
+	})
+	nodeRouter.POST("/containers/create", func(ctx *gin.Context) {
+		// the helper image is missing until pulled
+		if len(node.pulled) == 0 {
+			ctx.JSON(http.StatusNotFound, map[string]string{"message": "No such image: busybox:latest"})
+			return
+		}
+		var opts struct {
+			HostConfig docker.HostConfig
+		}
+		ctx.BindJSON(&opts)
+		node.binds = append(node.binds, opts.HostConfig.Binds...)
+		ctx.JSON(http.StatusCreated, map[string]string{"Id": "helper"})
+	})
+	nodeRouter.POST("/images/create", func(ctx *gin.Context) {
+		node.pulled = append(node.pulled, ctx.Query("fromImage")+":"+ctx.Query("tag"))
+		ctx.JSON(http.StatusOK, map[string]string{"status": "Downloaded newer image for busybox:latest"})
+	})
+	nodeRouter.GET("/containers/helper/archive", func(ctx *gin.Context) {
+		var buf bytes.Buffer
+		tarWriter := tar.NewWriter(&buf)
+		tarWriter.WriteHeader(&tar.Header{Name: "volume/", Typeflag: tar.TypeDir, Mode: 0755})
+		tarWriter.WriteHeader(&tar.Header{Name: "volume/db", Mode: 0644, Size: 4})
+		tarWriter.Write([]byte("rows"))
+		tarWriter.WriteHeader(&tar.Header{Name: "volume/db.link", Typeflag: tar.TypeLink, Linkname: "volume/db"})
+		tarWriter.Close()
+		ctx.Data(http.StatusOK, "application/x-tar", buf.Bytes())
+	})
+	nodeRouter.PUT("/containers/helper/archive", func(ctx *gin.Context) {
+		node.restored, _ = ioutil.ReadAll(ctx.Request.Body)
+		ctx.Status(http.StatusOK)
+	})
+	nodeRouter.DELETE("/containers/helper", func(ctx *gin.Context) {
+		node.removed = append(node.removed, "helper")
+		ctx.Status(http.StatusNoContent)
+	})
+
+	return httptest.NewServer(nodeRouter)
+}
+
+func TestListClusterVolumes(t *testing.T) {
+	node := &testVolumeNode{}
+	nodeServer := node.server(t)
+	defer nodeServer.Close()
+	client, closeManager := testNodeClient(t, nodeServer)
+	defer closeManager()
+
+	var node1, node2 swarm.Node
+	node1.ID = "node1"
+	node1.Description.Hostname = "host1"
+	node1.Status.State = swarm.NodeStateReady
+	node1.Spec.Annotations.Labels = map[string]string{LabelNodeEndpoint: nodeServer.URL}
+	node2.ID = "node2"
+	node2.Status.State = swarm.NodeStateDown
+	client.swarmCache = newSwarmCache(time.Minute, func() (*swarmSnapshot, error) {
+		return &swarmSnapshot{nodes: []swarm.Node{node1, node2}, syncedAt: time.Now()}, nil
+	})
+	client.swarmCache.Sync()
+
+	inventory, err := client.ListClusterVolumes(context.Background(), "", docker.ListVolumesOptions{})
+	assert.Nil(t, err)
+	assert.Empty(t, inventory.NodeErrors)
+	assert.Len(t, inventory.Volumes, 2)
+	data := inventory.Volumes[0]
+	assert.Equal(t, "data", data.Name)
+	assert.Equal(t, "host1", data.Hostname)
+	assert.Len(t, data.Mounts, 1)
+	assert.Equal(t, "blog_web.1.x", data.Mounts[0].ContainerName)
+	assert.Equal(t, "blog_web", data.Mounts[0].ServiceName)
+	assert.Equal(t, "/var/lib/web", data.Mounts[0].Destination)
+	assert.Empty(t, inventory.Volumes[1].Mounts)
+
+	inventory, err = client.ListClusterVolumes(context.Background(), "node2", docker.ListVolumesOptions{})
+	assert.Nil(t, err)
+	assert.Empty(t, inventory.Volumes)
+}
+
+func TestVolumeBackupRestore(t *testing.T) {
+	node := &testVolumeNode{}
+	nodeServer := node.server(t)
+	defer nodeServer.Close()
+	client, closeManager := testNodeClient(t, nodeServer)
+	defer closeManager()
+
+	craneContext := context.WithValue(context.Background(), "node_id", "node1")
+	_, err := client.OpenVolumeBackup(craneContext, "missing")
+	assert.NotNil(t, err)
+	assert.Equal(t, CodeVolumeNotFound, err.(*cranerror.CraneError).Code)
+
+	backup, err := client.OpenVolumeBackup(craneContext, "data")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"busybox:latest"}, node.pulled)
+	assert.Equal(t, []string{"data:/volume:ro"}, node.binds)
+
+	var names []string
+	tarReader := tar.NewReader(backup)
+	for {
+		header, err := tarReader.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, header.Name+header.Linkname)
+	}
+	assert.Equal(t, []string{"db", "db.linkdb"}, names)
+	assert.Nil(t, backup.Close())
+	assert.Equal(t, []string{"helper"}, node.removed)
+
+	err = client.RestoreVolume(craneContext, "data", strings.NewReader("archive"))
+	assert.Nil(t, err)
+	assert.Equal(t, "archive", string(node.restored))
+	assert.Equal(t, "data:/volume", node.binds[1])
+	assert.Equal(t, []string{"helper", "helper"}, node.removed)
+}
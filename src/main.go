@@ -3,10 +3,12 @@ package main
 import (
 	"flag"
 	"net/http"
+	"time"
 
 	"github.com/Dataman-Cloud/crane/src/api"
 	"github.com/Dataman-Cloud/crane/src/dockerclient"
 	"github.com/Dataman-Cloud/crane/src/plugins"
+	containertty "github.com/Dataman-Cloud/crane/src/plugins/tty"
 	"github.com/Dataman-Cloud/crane/src/utils/config"
 	log "github.com/Dataman-Cloud/crane/src/utils/log"
 
@@ -34,6 +36,15 @@ func main() {
 		Config: conf,
 	}
 
+	if conf.TerminalRecordingDir != "" {
+		retention := time.Duration(conf.TerminalRecordingRetention) * 24 * time.Hour
+		api.TerminalRecordings, err = containertty.NewRecordingStore(conf.TerminalRecordingDir, retention)
+		if err != nil {
+			log.G(ctx).Fatal("can't create terminal recording store: ", err)
+		}
+		go api.TerminalRecordings.Watch(nil)
+	}
+
 	ctx = log.WithLogger(ctx, log.G(ctx).WithField("module", "main"))
 
 	server := &http.Server{
@@ -0,0 +1,25 @@
+package model
+
+import (
+	"time"
+)
+
+// cluster wide settings could be changed by crane, nil fields are kept as they are
+type SwarmSettings struct {
+	TaskHistoryRetentionLimit *int64         `json:"TaskHistoryRetentionLimit"`
+	HeartbeatPeriod           *uint64        `json:"HeartbeatPeriod"`
+	NodeCertExpiry            *time.Duration `json:"NodeCertExpiry"`
+	SnapshotInterval          *uint64        `json:"SnapshotInterval"`
+}
+
+type SwarmInitOptions struct {
+	ListenAddr      string        `json:"ListenAddr"`
+	AdvertiseAddr   string        `json:"AdvertiseAddr"`
+	ForceNewCluster bool          `json:"ForceNewCluster"`
+	Settings        SwarmSettings `json:"Settings"`
+}
+
+type SwarmRotateOptions struct {
+	Worker  bool `json:"Worker"`
+	Manager bool `json:"Manager"`
+}
@@ -6,6 +6,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/Dataman-Cloud/crane/src/dockerclient"
 	"github.com/Dataman-Cloud/crane/src/plugins/auth"
 	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
 	"github.com/Dataman-Cloud/crane/src/utils/httpresponse"
@@ -334,6 +335,8 @@ func (a *AccountApi) GrantServicePermission(ctx *gin.Context) {
 		httpresponse.Error(ctx, craneerr)
 		return
 	}
+
+	a.publishPermissionEvent(ctx, "grant", fmt.Sprintf("%d-%s", param.GroupID, param.Perm))
 	httpresponse.Ok(ctx, "success")
 }
 
@@ -354,5 +357,28 @@ func (a *AccountApi) RevokeServicePermission(ctx *gin.Context) {
 		httpresponse.Error(ctx, craneerr)
 		return
 	}
+
+	a.publishPermissionEvent(ctx, "revoke", permissionId)
 	httpresponse.Ok(ctx, "success")
 }
+
+// publish the permission change of a service to the crane event stream
+func (a *AccountApi) publishPermissionEvent(ctx *gin.Context, action, permissionId string) {
+	if a.CraneDockerClient == nil {
+		return
+	}
+
+	event := dockerclient.CraneEvent{
+		Type:       dockerclient.EventTypePermission,
+		Action:     action,
+		ActorID:    ctx.Param("service_id"),
+		Attributes: map[string]string{"permission": permissionId},
+	}
+	if account, ok := ctx.Get("account"); ok {
+		if acc, ok := account.(auth.Account); ok {
+			event.Account = acc.Email
+		}
+	}
+
+	a.CraneDockerClient.PublishEvent(event)
+}
@@ -0,0 +1,292 @@
+package api
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Dataman-Cloud/crane/src/plugins/auth"
+	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
+	"github.com/Dataman-Cloud/crane/src/utils/httpresponse"
+
+	"github.com/gin-gonic/gin"
+)
+
+const robotNamePattern = `^[a-z0-9][a-z0-9_.\-]{0,62}$`
+
+// the account of ctx, false if the response is written already, the robots do not manage robots
+func (a *AccountApi) robotManager(ctx *gin.Context) (auth.Account, bool) {
+	if a.Robots == nil {
+		httpresponse.Error(ctx, cranerror.NewError(auth.CodeRobotSaveError, "robot accounts disabled"))
+		return auth.Account{}, false
+	}
+
+	if _, isRobot := ctx.Get("robot"); isRobot {
+		httpresponse.Error(ctx, cranerror.NewError(auth.CodeRobotForbidden, "robots can not manage robots"))
+		return auth.Account{}, false
+	}
+
+	account, found := ctx.Get("account")
+	if !found {
+		httpresponse.Error(ctx, cranerror.NewError(auth.CodeAccountTokenInvalidError, "Invalid Authorization"))
+		return auth.Account{}, false
+	}
+
+	return account.(auth.Account), true
+}
+
+func (a *AccountApi) isAdmin(account auth.Account) bool {
+	return a.Config != nil && a.Config.AccountIsAdmin(account.Email)
+}
+
+func inGroups(ctx *gin.Context, groupId uint64) bool {
+	groups, _ := ctx.Get("groups")
+	list, _ := groups.([]auth.Group)
+	for _, group := range list {
+		if group.ID == groupId {
+			return true
+		}
+	}
+	return false
+}
+
+// the account created the robot, is in its group, owns its namespace or is an admin
+func (a *AccountApi) managesRobot(ctx *gin.Context, account auth.Account, robot *auth.RobotAccount) bool {
+	if a.isAdmin(account) || robot.CreaterId == account.ID {
+		return true
+	}
+	if robot.GroupID != 0 && inGroups(ctx, robot.GroupID) {
+		return true
+	}
+	return robot.Namespace != "" && a.RegistryNamespace != nil && a.RegistryNamespace(account) == robot.Namespace
+}
+
+// the robot of the path managed by the account of ctx, false if the response is written already
+func (a *AccountApi) managedRobot(ctx *gin.Context) (*auth.RobotAccount, bool) {
+	account, ok := a.robotManager(ctx)
+	if !ok {
+		return nil, false
+	}
+
+	id, err := strconv.ParseUint(ctx.Param("robot_id"), 10, 64)
+	if err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(auth.CodeRobotNotFound, "invalid robot id"))
+		return nil, false
+	}
+
+	robot, err := a.Robots.Robot(id)
+	if err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(auth.CodeRobotNotFound, err.Error()))
+		return nil, false
+	}
+
+	if !a.managesRobot(ctx, account, robot) {
+		httpresponse.Error(ctx, cranerror.NewError(auth.CodeRobotForbidden, "robot not managed by the account"))
+		return nil, false
+	}
+
+	return robot, true
+}
+
+// the robots the account manages
+func (a *AccountApi) ListRobots(ctx *gin.Context) {
+	account, ok := a.robotManager(ctx)
+	if !ok {
+		return
+	}
+
+	robots, err := a.Robots.Robots()
+	if err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(auth.CodeRobotSaveError, err.Error()))
+		return
+	}
+
+	managed := make([]auth.RobotAccount, 0)
+	for i := range robots {
+		if a.managesRobot(ctx, account, &robots[i]) {
+			managed = append(managed, robots[i])
+		}
+	}
+
+	httpresponse.Ok(ctx, managed)
+}
+
+func (a *AccountApi) GetRobot(ctx *gin.Context) {
+	robot, ok := a.managedRobot(ctx)
+	if !ok {
+		return
+	}
+
+	httpresponse.Ok(ctx, robot)
+}
+
+// create a robot of a group of the account or of its registry namespace
+func (a *AccountApi) CreateRobot(ctx *gin.Context) {
+	account, ok := a.robotManager(ctx)
+	if !ok {
+		return
+	}
+
+	var robot auth.RobotAccount
+	if err := ctx.BindJSON(&robot); err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(auth.CodeRobotParamError, err.Error()))
+		return
+	}
+
+	if matched, _ := regexp.MatchString(robotNamePattern, robot.Name); !matched {
+		httpresponse.Error(ctx, cranerror.NewError(auth.CodeRobotParamError, "invalid robot name "+robot.Name))
+		return
+	}
+
+	if (robot.GroupID == 0) == (robot.Namespace == "") {
+		httpresponse.Error(ctx, cranerror.NewError(auth.CodeRobotParamError, "a robot belongs to either a group or a namespace"))
+		return
+	}
+
+	if robot.GroupID != 0 && !a.isAdmin(account) && !inGroups(ctx, robot.GroupID) {
+		httpresponse.Error(ctx, cranerror.NewError(auth.CodeRobotForbidden, "the account is not in the group"))
+		return
+	}
+
+	if robot.Namespace != "" {
+		if a.RegistryNamespace == nil {
+			httpresponse.Error(ctx, cranerror.NewError(auth.CodeRobotParamError, "registry disabled"))
+			return
+		}
+		if !a.isAdmin(account) && a.RegistryNamespace(account) != robot.Namespace {
+			httpresponse.Error(ctx, cranerror.NewError(auth.CodeRobotForbidden, "the account does not own the namespace"))
+			return
+		}
+	}
+
+	robot.ID = 0
+	robot.CreaterId = account.ID
+	if err := a.Robots.CreateRobot(&robot); err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(auth.CodeRobotSaveError, err.Error()))
+		return
+	}
+
+	httpresponse.Ok(ctx, robot)
+}
+
+// delete the robot, its tokens stop working
+func (a *AccountApi) DeleteRobot(ctx *gin.Context) {
+	robot, ok := a.managedRobot(ctx)
+	if !ok {
+		return
+	}
+
+	if err := a.Robots.DeleteRobot(robot); err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(auth.CodeRobotSaveError, err.Error()))
+		return
+	}
+
+	httpresponse.Ok(ctx, "success")
+}
+
+func (a *AccountApi) ListRobotTokens(ctx *gin.Context) {
+	robot, ok := a.managedRobot(ctx)
+	if !ok {
+		return
+	}
+
+	tokens, err := a.Robots.Tokens(robot.ID)
+	if err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(auth.CodeRobotSaveError, err.Error()))
+		return
+	}
+
+	httpresponse.Ok(ctx, tokens)
+}
+
+// the scopes of the token of the robot, the registry scopes of a namespace robot stay in its namespace
+func validateRobotScopes(robot *auth.RobotAccount, scopes []string) error {
+	if len(scopes) == 0 {
+		return cranerror.NewError(auth.CodeRobotParamError, "scopes required")
+	}
+
+	for _, s := range scopes {
+		scope, err := auth.ParseRobotScope(s)
+		if err != nil {
+			return cranerror.NewError(auth.CodeRobotParamError, err.Error())
+		}
+		if scope.Resource == auth.RobotScopeRegistry && robot.Namespace != "" &&
+			strings.Split(scope.Target, "/")[0] != robot.Namespace {
+			return cranerror.NewError(auth.CodeRobotParamError, "scope "+s+" outside of namespace "+robot.Namespace)
+		}
+	}
+	return nil
+}
+
+// create a token of the robot, its value is only returned once
+func (a *AccountApi) CreateRobotToken(ctx *gin.Context) {
+	robot, ok := a.managedRobot(ctx)
+	if !ok {
+		return
+	}
+
+	var param struct {
+		Name   string   `json:"Name"`
+		Scopes []string `json:"Scopes"`
+		// seconds, DefaultRobotTokenExpiration if 0
+		ExpiresIn int64 `json:"ExpiresIn"`
+	}
+	if err := ctx.BindJSON(&param); err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(auth.CodeRobotParamError, err.Error()))
+		return
+	}
+
+	if param.Name == "" {
+		httpresponse.Error(ctx, cranerror.NewError(auth.CodeRobotParamError, "token name required"))
+		return
+	}
+	if err := validateRobotScopes(robot, param.Scopes); err != nil {
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	expiration := time.Duration(param.ExpiresIn) * time.Second
+	if expiration == 0 {
+		expiration = auth.DefaultRobotTokenExpiration
+	}
+	if expiration < 0 || expiration > auth.MaxRobotTokenExpiration {
+		httpresponse.Error(ctx, cranerror.NewError(auth.CodeRobotParamError, "ExpiresIn out of range"))
+		return
+	}
+
+	token := &auth.RobotToken{
+		RobotID:   robot.ID,
+		Name:      param.Name,
+		Scopes:    param.Scopes,
+		ExpiresAt: time.Now().Add(expiration),
+	}
+	value, err := a.Robots.CreateToken(token)
+	if err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(auth.CodeRobotSaveError, err.Error()))
+		return
+	}
+
+	httpresponse.Ok(ctx, gin.H{"Token": value, "Robot": robot.Name, "Detail": token})
+}
+
+func (a *AccountApi) RevokeRobotToken(ctx *gin.Context) {
+	robot, ok := a.managedRobot(ctx)
+	if !ok {
+		return
+	}
+
+	tokenId, err := strconv.ParseUint(ctx.Param("token_id"), 10, 64)
+	if err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(auth.CodeRobotNotFound, "invalid token id"))
+		return
+	}
+
+	token, err := a.Robots.RevokeToken(robot.ID, tokenId)
+	if err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(auth.CodeRobotNotFound, err.Error()))
+		return
+	}
+
+	httpresponse.Ok(ctx, token)
+}
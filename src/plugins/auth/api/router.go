@@ -8,6 +8,7 @@ import (
 	chains "github.com/Dataman-Cloud/crane/src/plugins/auth/middlewares"
 	"github.com/Dataman-Cloud/crane/src/plugins/auth/token_store"
 	"github.com/Dataman-Cloud/crane/src/utils/config"
+	"github.com/Dataman-Cloud/crane/src/utils/db"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/gin-gonic/gin"
@@ -19,6 +20,11 @@ type AccountApi struct {
 	TokenStore        auth.TokenStore
 	CraneDockerClient *dockerclient.CraneDockerClient
 	Authorization     gin.HandlerFunc
+	AuthorizeAdmin    gin.HandlerFunc
+	// nil if the db is unavailable
+	Robots *auth.RobotStore
+	// the registry namespace of an account, set when the registry is enabled
+	RegistryNamespace func(a auth.Account) string
 }
 
 func Init(conf *config.Config) {
@@ -36,7 +42,14 @@ func Init(conf *config.Config) {
 		accountApi.Authenticator = authenticators.NewDBAuthenticator(conf.DbDriver, conf.DbDSN)
 	}
 
-	accountApi.Authorization = chains.Authorization(accountApi.TokenStore, accountApi.Authenticator)
+	if dbClient, err := db.NewDB(conf.DbDriver, conf.DbDSN); err == nil {
+		accountApi.Robots = auth.NewRobotStore(dbClient)
+	} else {
+		log.Errorf("robot accounts disabled, open db got error: %v", err)
+	}
+
+	accountApi.Authorization = chains.Authorization(accountApi.TokenStore, accountApi.Authenticator, accountApi.Robots)
+	accountApi.AuthorizeAdmin = chains.AuthorizeAdmin(conf)
 
 	apiPlugin := &apiplugin.ApiPlugin{
 		Name:         apiplugin.Account,
@@ -70,6 +83,14 @@ func (account *AccountApi) ApiRegister(router *gin.Engine, middlewares ...gin.Ha
 		accountV1.DELETE("/groups/:group_id", account.DeleteGroup)
 		accountV1.POST("/groups/:group_id/account", account.CreateAccount)
 		accountV1.GET("/groups/:group_id/accounts", account.GetGroup)
+
+		accountV1.GET("/robots", account.ListRobots)
+		accountV1.POST("/robots", account.CreateRobot)
+		accountV1.GET("/robots/:robot_id", account.GetRobot)
+		accountV1.DELETE("/robots/:robot_id", account.DeleteRobot)
+		accountV1.GET("/robots/:robot_id/tokens", account.ListRobotTokens)
+		accountV1.POST("/robots/:robot_id/tokens", account.CreateRobotToken)
+		accountV1.DELETE("/robots/:robot_id/tokens/:token_id", account.RevokeRobotToken)
 	}
 
 	router.POST("/account/v1/login", account.AccountLogin)
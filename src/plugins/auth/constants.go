@@ -53,4 +53,10 @@ const (
 	CodeAccountTokenInvalidError                        = "401-12033"
 	CodeAccountLoginFailedEmailNotValidError            = "401-12034"
 	CodeAccountLoginFailedPasswordNotValidError         = "401-12035"
+	CodeAccountAdminRequiredError                       = "403-12036"
+	CodeRobotParamError                                 = "400-12037"
+	CodeRobotNotFound                                   = "404-12038"
+	CodeRobotSaveError                                  = "503-12039"
+	CodeRobotForbidden                                  = "403-12040"
+	CodeRobotScopeDenied                                = "403-12041"
 )
@@ -11,7 +11,8 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func Authorization(tokenStore auth.TokenStore, authenticator auth.Authenticator) gin.HandlerFunc {
+// the robot tokens of robots are accepted too when it is not nil, their scopes limit the requests
+func Authorization(tokenStore auth.TokenStore, authenticator auth.Authenticator, robots *auth.RobotStore) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		if len(ctx.Query("Authorization")) != 0 {
 			ctx.Request.Header.Set("Authorization", ctx.Query("Authorization"))
@@ -27,6 +28,11 @@ func Authorization(tokenStore auth.TokenStore, authenticator auth.Authenticator)
 			return
 		}
 
+		if _, _, ok := auth.ParseRobotToken(ctx.Request.Header.Get("Authorization")); ok && robots != nil {
+			authorizeRobot(ctx, robots)
+			return
+		}
+
 		value, err := tokenStore.Get(ctx, ctx.Request.Header.Get("Authorization"))
 		if err != nil {
 			httpresponse.Error(ctx, cranerror.NewError(auth.CodeAccountTokenInvalidError, "Invalid Authorization"))
@@ -60,3 +66,29 @@ func Authorization(tokenStore auth.TokenStore, authenticator auth.Authenticator)
 		ctx.Next()
 	}
 }
+
+// the robot acts as an account without id, in its group if it has one
+func authorizeRobot(ctx *gin.Context, robots *auth.RobotStore) {
+	robot, token, err := robots.Verify(ctx.Request.Header.Get("Authorization"))
+	if err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(auth.CodeAccountTokenInvalidError, "Invalid Authorization"))
+		ctx.Abort()
+		return
+	}
+
+	if !auth.RobotScopesAllowRequest(token.ParsedScopes(), ctx.Request.Method, ctx.Request.URL.Path) {
+		httpresponse.Error(ctx, cranerror.NewError(auth.CodeRobotScopeDenied, "robot token scopes do not allow the request"))
+		ctx.Abort()
+		return
+	}
+
+	ctx.Set("account", robot.Account())
+	ctx.Set("robot", *robot)
+	groups := []auth.Group{}
+	if robot.GroupID != 0 {
+		groups = append(groups, auth.Group{ID: robot.GroupID})
+	}
+	ctx.Set("groups", groups)
+
+	ctx.Next()
+}
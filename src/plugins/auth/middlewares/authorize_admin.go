@@ -0,0 +1,30 @@
+package middlewares
+
+import (
+	"github.com/Dataman-Cloud/crane/src/plugins/auth"
+	"github.com/Dataman-Cloud/crane/src/utils/config"
+	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
+	"github.com/Dataman-Cloud/crane/src/utils/httpresponse"
+
+	"github.com/gin-gonic/gin"
+)
+
+// only the admin accounts of conf pass, Authorization must run before
+func AuthorizeAdmin(conf *config.Config) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		account, ok := ctx.Get("account")
+		if !ok {
+			httpresponse.Error(ctx, cranerror.NewError(auth.CodeAccountTokenInvalidError, "Invalid Authorization"))
+			ctx.Abort()
+			return
+		}
+
+		if acc, ok := account.(auth.Account); !ok || !conf.AccountIsAdmin(acc.Email) {
+			httpresponse.Error(ctx, cranerror.NewError(auth.CodeAccountAdminRequiredError, "admin required"))
+			ctx.Abort()
+			return
+		}
+
+		ctx.Next()
+	}
+}
@@ -91,3 +91,16 @@ func PermissionGrantLabelsPairFromGroupIdAndPerm(groupId uint64, perm string) ma
 
 	return labels
 }
+
+// the highest permission granted to any of the groups by the labels of a service
+func GroupsPermission(labels map[string]string, groups []Group) (Permission, bool) {
+	for i := len(Perms) - 1; i >= 0; i-- {
+		for _, group := range groups {
+			if labels[fmt.Sprintf("%s.%d.%s", PERMISSION_LABEL_PREFIX, group.ID, Perms[i].Display)] == "true" {
+				return Perms[i], true
+			}
+		}
+	}
+
+	return Permission{}, false
+}
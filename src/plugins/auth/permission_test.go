@@ -70,3 +70,24 @@ func TestPermissionRevokeLabelsFromPermissionId(T *testing.T) {
 		T.Error("labels from permission 3 item is x")
 	}
 }
+
+func TestGroupsPermission(T *testing.T) {
+	labels := PermissionGrantLabelsPairFromGroupIdAndPerm(1, "r")
+	for k, v := range PermissionGrantLabelsPairFromGroupIdAndPerm(2, "w") {
+		labels[k] = v
+	}
+
+	p, ok := GroupsPermission(labels, []Group{{ID: 1}, {ID: 2}})
+	if !ok || p != PermReadWrite {
+		T.Error("groups 1 and 2 should be granted w")
+	}
+
+	p, ok = GroupsPermission(labels, []Group{{ID: 1}})
+	if !ok || p != PermReadOnly {
+		T.Error("group 1 should be granted r")
+	}
+
+	if _, ok = GroupsPermission(labels, []Group{{ID: 3}}); ok {
+		T.Error("group 3 should be granted nothing")
+	}
+}
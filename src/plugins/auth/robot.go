@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Dataman-Cloud/crane/src/utils"
+)
+
+const (
+	// robot scopes: registry:<namespace>/<image or *>:<pull,push>, stack:<namespace or *>:<read,scale,deploy>, api:*:<read,write>
+	RobotScopeRegistry = "registry"
+	RobotScopeStack    = "stack"
+	RobotScopeApi      = "api"
+
+	// the tokens are robot-<token id>-<secret>
+	robotTokenPrefix = "robot-"
+	// the email of the account a robot acts as on the api is robot:<name>
+	robotEmailPrefix = "robot:"
+
+	DefaultRobotTokenExpiration = 30 * 24 * time.Hour
+	MaxRobotTokenExpiration     = 365 * 24 * time.Hour
+)
+
+var robotScopeActions = map[string][]string{
+	RobotScopeRegistry: {"pull", "push"},
+	RobotScopeStack:    {"read", "scale", "deploy"},
+	RobotScopeApi:      {"read", "write"},
+}
+
+// RobotAccount is an account of the pipelines, it belongs to a group or to a registry namespace
+type RobotAccount struct {
+	ID        uint64    `json:"Id"`
+	CreatedAt time.Time `json:"CreatedAt"`
+	UpdatedAt time.Time `json:"UpdatedAt"`
+
+	Name        string `json:"Name" gorm:"not null" sql:"unique"`
+	Description string `json:"Description"`
+	GroupID     uint64 `json:"GroupID"`
+	Namespace   string `json:"Namespace"`
+	CreaterId   uint64 `json:"CreaterId"`
+}
+
+// the account the robot acts as on the api
+func (robot *RobotAccount) Account() Account {
+	return Account{Title: robot.Name, Email: robotEmailPrefix + robot.Name}
+}
+
+// RobotToken is a named access token of a robot, only the hash of its secret is kept
+type RobotToken struct {
+	ID        uint64    `json:"Id"`
+	CreatedAt time.Time `json:"CreatedAt"`
+
+	RobotID uint64 `json:"RobotID" gorm:"not null"`
+	Name    string `json:"Name" gorm:"not null"`
+	// the scopes separated by spaces
+	Scope      string     `json:"-"`
+	Scopes     []string   `json:"Scopes" gorm:"-"`
+	Hash       string     `json:"-" gorm:"not null"`
+	ExpiresAt  time.Time  `json:"ExpiresAt"`
+	RevokedAt  *time.Time `json:"RevokedAt"`
+	LastUsedAt *time.Time `json:"LastUsedAt"`
+}
+
+func (token *RobotToken) Valid(now time.Time) bool {
+	return token.RevokedAt == nil && now.Before(token.ExpiresAt)
+}
+
+func (token *RobotToken) ParsedScopes() []RobotScope {
+	var scopes []RobotScope
+	for _, s := range strings.Fields(token.Scope) {
+		if scope, err := ParseRobotScope(s); err == nil {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+type RobotScope struct {
+	Resource string
+	Target   string
+	Actions  []string
+}
+
+func ParseRobotScope(s string) (RobotScope, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return RobotScope{}, fmt.Errorf("invalid scope %s", s)
+	}
+
+	scope := RobotScope{Resource: parts[0], Target: parts[1], Actions: strings.Split(parts[2], ",")}
+	known, ok := robotScopeActions[scope.Resource]
+	if !ok {
+		return RobotScope{}, fmt.Errorf("unknown resource of scope %s", s)
+	}
+	for _, action := range scope.Actions {
+		if !utils.StringInSlice(action, known) {
+			return RobotScope{}, fmt.Errorf("unknown action %s of scope %s", action, s)
+		}
+	}
+
+	switch scope.Resource {
+	case RobotScopeRegistry:
+		if strings.Count(scope.Target, "/") != 1 || strings.HasPrefix(scope.Target, "/") || strings.HasSuffix(scope.Target, "/") {
+			return RobotScope{}, fmt.Errorf("registry scope target must be <namespace>/<image or *>: %s", s)
+		}
+	case RobotScopeStack:
+		if scope.Target == "" {
+			return RobotScope{}, fmt.Errorf("stack scope target required: %s", s)
+		}
+	case RobotScopeApi:
+		if scope.Target != "*" {
+			return RobotScope{}, fmt.Errorf("api scope target must be *: %s", s)
+		}
+	}
+
+	return scope, nil
+}
+
+func (scope RobotScope) String() string {
+	return fmt.Sprintf("%s:%s:%s", scope.Resource, scope.Target, strings.Join(scope.Actions, ","))
+}
+
+// the target is the one of the scope or matched by its trailing *
+func (scope RobotScope) Matches(target string) bool {
+	if strings.HasSuffix(scope.Target, "*") {
+		return strings.HasPrefix(target, strings.TrimSuffix(scope.Target, "*"))
+	}
+	return scope.Target == target
+}
+
+func (scope RobotScope) Allows(action string) bool {
+	return utils.StringInSlice(action, scope.Actions)
+}
+
+// the actions of the scopes on the resource target
+func RobotScopesActions(scopes []RobotScope, resource, target string) []string {
+	var actions []string
+	for _, scope := range scopes {
+		if scope.Resource != resource || !scope.Matches(target) {
+			continue
+		}
+		for _, action := range scope.Actions {
+			if !utils.StringInSlice(action, actions) {
+				actions = append(actions, action)
+			}
+		}
+	}
+	return actions
+}
+
+// the scopes let the robot send the api request, on a stack scale and deploy imply read,
+// the other requests need the api scope, write implying read
+func RobotScopesAllowRequest(scopes []RobotScope, method, path string) bool {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) >= 4 && parts[0] == "api" && parts[2] == "stacks" {
+		actions := RobotScopesActions(scopes, RobotScopeStack, parts[3])
+		switch {
+		case method == http.MethodGet:
+			return len(actions) > 0
+		case method == http.MethodPatch && len(parts) == 6 && parts[4] == "services":
+			return utils.StringInSlice("scale", actions) || utils.StringInSlice("deploy", actions)
+		default:
+			return utils.StringInSlice("deploy", actions)
+		}
+	}
+
+	actions := RobotScopesActions(scopes, RobotScopeApi, "*")
+	if method == http.MethodGet {
+		return len(actions) > 0
+	}
+	return utils.StringInSlice("write", actions)
+}
+
+// the id and the secret of a robot token, false if value is not one
+func ParseRobotToken(value string) (uint64, string, bool) {
+	if !strings.HasPrefix(value, robotTokenPrefix) {
+		return 0, "", false
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(value, robotTokenPrefix), "-", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return 0, "", false
+	}
+
+	id, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	return id, parts[1], true
+}
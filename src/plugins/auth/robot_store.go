@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	ErrRobotNotFound     = errors.New("robot not found")
+	ErrRobotTokenInvalid = errors.New("invalid robot token")
+)
+
+// RobotStore keeps the robots and their tokens in the db of crane
+type RobotStore struct {
+	DbClient *gorm.DB
+}
+
+func NewRobotStore(dbClient *gorm.DB) *RobotStore {
+	store := &RobotStore{DbClient: dbClient}
+	store.DbClient.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&RobotAccount{})
+	store.DbClient.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&RobotToken{})
+	return store
+}
+
+func (store *RobotStore) Robots() ([]RobotAccount, error) {
+	robots := make([]RobotAccount, 0)
+	if err := store.DbClient.Order("id").Find(&robots).Error; err != nil {
+		return nil, err
+	}
+	return robots, nil
+}
+
+func (store *RobotStore) Robot(id uint64) (*RobotAccount, error) {
+	var robot RobotAccount
+	if err := store.DbClient.Where("id = ?", id).Find(&robot).Error; err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return nil, ErrRobotNotFound
+		}
+		return nil, err
+	}
+	return &robot, nil
+}
+
+func (store *RobotStore) CreateRobot(robot *RobotAccount) error {
+	var count int
+	if err := store.DbClient.Model(&RobotAccount{}).Where("name = ?", robot.Name).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("robot %s already exists", robot.Name)
+	}
+	return store.DbClient.Save(robot).Error
+}
+
+// delete the robot and its tokens
+func (store *RobotStore) DeleteRobot(robot *RobotAccount) error {
+	if err := store.DbClient.Where("robot_id = ?", robot.ID).Delete(&RobotToken{}).Error; err != nil {
+		return err
+	}
+	return store.DbClient.Delete(robot).Error
+}
+
+func (store *RobotStore) Tokens(robotID uint64) ([]RobotToken, error) {
+	tokens := make([]RobotToken, 0)
+	if err := store.DbClient.Where("robot_id = ?", robotID).Order("id").Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+	for i := range tokens {
+		tokens[i].Scopes = strings.Fields(tokens[i].Scope)
+	}
+	return tokens, nil
+}
+
+// CreateToken saves a token of the scopes and returns its value, the value can not be read again
+func (store *RobotStore) CreateToken(token *RobotToken) (string, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	encoded := hex.EncodeToString(secret)
+
+	token.Hash = hashRobotSecret(encoded)
+	token.Scope = strings.Join(token.Scopes, " ")
+	if err := store.DbClient.Save(token).Error; err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s%d-%s", robotTokenPrefix, token.ID, encoded), nil
+}
+
+func (store *RobotStore) RevokeToken(robotID, tokenID uint64) (*RobotToken, error) {
+	var token RobotToken
+	if err := store.DbClient.Where("id = ? AND robot_id = ?", tokenID, robotID).Find(&token).Error; err != nil {
+		return nil, ErrRobotNotFound
+	}
+
+	if token.RevokedAt == nil {
+		now := time.Now()
+		token.RevokedAt = &now
+		if err := store.DbClient.Model(&token).Update("revoked_at", now).Error; err != nil {
+			return nil, err
+		}
+	}
+	token.Scopes = strings.Fields(token.Scope)
+	return &token, nil
+}
+
+// Verify returns the robot and the token of value, if the token is neither expired nor revoked
+func (store *RobotStore) Verify(value string) (*RobotAccount, *RobotToken, error) {
+	id, secret, ok := ParseRobotToken(value)
+	if !ok {
+		return nil, nil, ErrRobotTokenInvalid
+	}
+
+	var token RobotToken
+	if err := store.DbClient.Where("id = ?", id).Find(&token).Error; err != nil {
+		return nil, nil, ErrRobotTokenInvalid
+	}
+
+	now := time.Now()
+	if subtle.ConstantTimeCompare([]byte(token.Hash), []byte(hashRobotSecret(secret))) != 1 || !token.Valid(now) {
+		return nil, nil, ErrRobotTokenInvalid
+	}
+
+	robot, err := store.Robot(token.RobotID)
+	if err != nil {
+		return nil, nil, ErrRobotTokenInvalid
+	}
+
+	store.DbClient.Model(&token).Update("last_used_at", now)
+	token.LastUsedAt = &now
+	token.Scopes = strings.Fields(token.Scope)
+	return robot, &token, nil
+}
+
+func hashRobotSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRobotScope(T *testing.T) {
+	scope, err := ParseRobotScope("registry:shop/*:pull,push")
+	if err != nil {
+		T.Fatal(err)
+	}
+	if scope.Resource != RobotScopeRegistry || scope.Target != "shop/*" || len(scope.Actions) != 2 {
+		T.Errorf("unexpected scope %v", scope)
+	}
+	if scope.String() != "registry:shop/*:pull,push" {
+		T.Errorf("unexpected string %s", scope.String())
+	}
+
+	for _, s := range []string{"registry:shop:pull", "registry:shop/x:delete", "stack::read", "api:ns:read", "cluster:*:read", "api:*"} {
+		if _, err := ParseRobotScope(s); err == nil {
+			T.Errorf("scope %s should be invalid", s)
+		}
+	}
+}
+
+func TestRobotScopesActions(T *testing.T) {
+	scopes := []RobotScope{
+		{Resource: RobotScopeRegistry, Target: "shop/*", Actions: []string{"pull"}},
+		{Resource: RobotScopeRegistry, Target: "shop/web", Actions: []string{"pull", "push"}},
+	}
+
+	if actions := RobotScopesActions(scopes, RobotScopeRegistry, "shop/web"); len(actions) != 2 {
+		T.Errorf("shop/web should allow pull and push, got %v", actions)
+	}
+	if actions := RobotScopesActions(scopes, RobotScopeRegistry, "shop/db"); len(actions) != 1 || actions[0] != "pull" {
+		T.Errorf("shop/db should allow pull, got %v", actions)
+	}
+	if actions := RobotScopesActions(scopes, RobotScopeRegistry, "other/web"); len(actions) != 0 {
+		T.Errorf("other/web should allow nothing, got %v", actions)
+	}
+}
+
+func TestRobotScopesAllowRequest(T *testing.T) {
+	scopes := []RobotScope{
+		{Resource: RobotScopeStack, Target: "shop", Actions: []string{"scale"}},
+		{Resource: RobotScopeApi, Target: "*", Actions: []string{"read"}},
+	}
+
+	cases := []struct {
+		method  string
+		path    string
+		allowed bool
+	}{
+		{"GET", "/api/v1/stacks/shop", true},
+		{"PATCH", "/api/v1/stacks/shop/services/web", true},
+		{"PUT", "/api/v1/stacks/shop", false},
+		{"GET", "/api/v1/stacks/other", false},
+		{"GET", "/api/v1/nodes", true},
+		{"POST", "/api/v1/nodes", false},
+	}
+	for _, c := range cases {
+		if RobotScopesAllowRequest(scopes, c.method, c.path) != c.allowed {
+			T.Errorf("%s %s should be allowed %v", c.method, c.path, c.allowed)
+		}
+	}
+}
+
+func TestParseRobotToken(T *testing.T) {
+	id, secret, ok := ParseRobotToken("robot-12-abcdef")
+	if !ok || id != 12 || secret != "abcdef" {
+		T.Errorf("unexpected token %d %s %v", id, secret, ok)
+	}
+
+	for _, value := range []string{"abcdef", "robot-x-abcdef", "robot-12-", "robot-12"} {
+		if _, _, ok := ParseRobotToken(value); ok {
+			T.Errorf("token %s should be invalid", value)
+		}
+	}
+}
+
+func TestRobotTokenValid(T *testing.T) {
+	now := time.Now()
+	token := RobotToken{ExpiresAt: now.Add(time.Hour)}
+	if !token.Valid(now) {
+		T.Error("token should be valid")
+	}
+	if token.Valid(now.Add(2 * time.Hour)) {
+		T.Error("expired token should be invalid")
+	}
+	token.RevokedAt = &now
+	if token.Valid(now) {
+		T.Error("revoked token should be invalid")
+	}
+}
@@ -1,6 +1,8 @@
 package plugins
 
 import (
+	"time"
+
 	"github.com/Dataman-Cloud/crane/src/plugins/apiplugin"
 	authApi "github.com/Dataman-Cloud/crane/src/plugins/auth/api"
 	"github.com/Dataman-Cloud/crane/src/plugins/catalog"
@@ -48,7 +50,9 @@ func Init(conf *config.Config) error {
 			if err != nil {
 				return err
 			}
-			registry.Init(conf.AccountAuthenticator, conf.RegistryPrivateKeyPath, conf.RegistryAddr, conf.DbDriver, conf.DbDSN, dbClient)
+			registry.Init(conf.AccountAuthenticator, conf.RegistryPrivateKeyPath, conf.RegistryAddr, conf.DbDriver, conf.DbDSN, dbClient,
+				time.Duration(conf.RegistryRetentionInterval)*time.Second, conf.RegistryGCNodeID, conf.RegistryGCContainer,
+				time.Duration(conf.RegistryGCTimeout)*time.Second)
 		case apiplugin.Search:
 			search.Init()
 		case apiplugin.Account:
@@ -0,0 +1,253 @@
+package registry
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Dataman-Cloud/crane/src/plugins/auth"
+	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
+	"github.com/Dataman-Cloud/crane/src/utils/httpresponse"
+	"github.com/Dataman-Cloud/crane/src/utils/model"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	GrantPull  = "pull"
+	GrantPush  = "push"
+	GrantAdmin = "admin"
+)
+
+// the permission letters of GetPermission a grant gives
+var grantPermissions = map[string]string{
+	GrantPull:  "R",
+	GrantPush:  "RW",
+	GrantAdmin: "RWM",
+}
+
+// RegistryGrant gives the accounts of a group a permission on a namespace, or on one repository of it
+type RegistryGrant struct {
+	ID        uint64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	GroupID   uint64 `json:"GroupID" gorm:"not null"`
+	Namespace string `json:"Namespace" gorm:"not null"`
+	// empty for every repository of the namespace
+	Image      string `json:"Image"`
+	Permission string `json:"Permission" gorm:"not null"`
+}
+
+func (grant *RegistryGrant) validate() error {
+	if grant.GroupID == 0 {
+		return cranerror.NewError(CodeRegistryGrantParamError, "GroupID required")
+	}
+	if _, ok := grantPermissions[grant.Permission]; !ok {
+		return cranerror.NewError(CodeRegistryGrantParamError, "Permission must be one of pull, push and admin")
+	}
+	if strings.Contains(grant.Image, "/") {
+		return cranerror.NewError(CodeRegistryGrantParamError, "invalid image "+grant.Image)
+	}
+	return nil
+}
+
+// the union of the letters of the permissions
+func mergePermissions(permissions ...string) string {
+	merged := ""
+	for _, letter := range []string{"R", "W", "M"} {
+		for _, permission := range permissions {
+			if strings.Contains(permission, letter) {
+				merged += letter
+				break
+			}
+		}
+	}
+	return merged
+}
+
+// the permission the grants give on the repository of the namespace
+func grantsPermission(grants []RegistryGrant, namespace, image string) string {
+	var permissions []string
+	for _, grant := range grants {
+		if grant.Namespace == namespace && (grant.Image == "" || grant.Image == image) {
+			permissions = append(permissions, grantPermissions[grant.Permission])
+		}
+	}
+	return mergePermissions(permissions...)
+}
+
+// the namespace owned by the principal, a namespace or the email of its owner
+func (registry *Registry) principalNamespace(principal string) string {
+	var namespaceEmail NamespaceEmail
+	if err := registry.DbClient.Where("namespace = ? OR account_email = ?", principal, principal).Find(&namespaceEmail).Error; err != nil {
+		return ""
+	}
+	return namespaceEmail.Namespace
+}
+
+// the ids of the groups of the account of the principal
+func (registry *Registry) principalGroups(principal string) []uint64 {
+	if registry.Authenticator == nil {
+		return nil
+	}
+
+	email := principal
+	var namespaceEmail NamespaceEmail
+	if err := registry.DbClient.Where("namespace = ?", principal).Find(&namespaceEmail).Error; err == nil {
+		email = namespaceEmail.AccountEmail
+	}
+
+	account, err := registry.Authenticator.Account(email)
+	if err != nil {
+		return nil
+	}
+
+	groups, err := registry.Authenticator.AccountGroups(model.ListOptions{
+		Filter: map[string]interface{}{
+			"account_id": account.ID,
+		},
+	})
+	if err != nil {
+		log.Errorf("get groups of account %s got error: %v", email, err)
+		return nil
+	}
+
+	var ids []uint64
+	for _, group := range *groups {
+		ids = append(ids, group.ID)
+	}
+	return ids
+}
+
+// the permission the grants of the groups of the principal give on the repository
+func (registry *Registry) grantedPermission(principal, namespace, image string) string {
+	groups := registry.principalGroups(principal)
+	if len(groups) == 0 {
+		return ""
+	}
+
+	var grants []RegistryGrant
+	if err := registry.DbClient.Where("namespace = ? AND group_id IN (?)", namespace, groups).Find(&grants).Error; err != nil {
+		return ""
+	}
+	return grantsPermission(grants, namespace, image)
+}
+
+// the account owns the namespace or one of its groups is granted admin on the whole namespace
+func (registry *Registry) administersNamespace(account auth.Account, namespace string) bool {
+	if namespace == "" {
+		return false
+	}
+	if registry.RegistryNamespaceForAccount(account) == namespace {
+		return true
+	}
+	return strings.Contains(registry.grantedPermission(account.Email, namespace, ""), "M")
+}
+
+// the namespace of the query administered by the account of ctx, its own namespace by default,
+// false if the response is written already
+func (registry *Registry) grantNamespace(ctx *gin.Context, namespace string) (string, bool) {
+	account, found := ctx.Get("account")
+	if !found {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryUnauthorized, "invalid user"))
+		return "", false
+	}
+
+	if namespace == "" {
+		namespace = registry.RegistryNamespaceForAccount(account.(auth.Account))
+		if namespace == "" {
+			httpresponse.Error(ctx, cranerror.NewError(CodeRegistryNamespaceNotFound, "no registry namespace for the account"))
+			return "", false
+		}
+	}
+
+	if !registry.administersNamespace(account.(auth.Account), namespace) {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryGrantForbidden, "admin of namespace "+namespace+" required"))
+		return "", false
+	}
+
+	return namespace, true
+}
+
+// the grants of a namespace, query namespace defaults to the one of the account
+func (registry *Registry) ListGrants(ctx *gin.Context) {
+	namespace, ok := registry.grantNamespace(ctx, ctx.Query("namespace"))
+	if !ok {
+		return
+	}
+
+	grants := make([]RegistryGrant, 0)
+	if err := registry.DbClient.Where("namespace = ?", namespace).Order("id").Find(&grants).Error; err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryGrantSaveError, err.Error()))
+		return
+	}
+
+	httpresponse.Ok(ctx, grants)
+}
+
+func (registry *Registry) CreateGrant(ctx *gin.Context) {
+	var grant RegistryGrant
+	if err := ctx.BindJSON(&grant); err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryGrantParamError, err.Error()))
+		return
+	}
+	if err := grant.validate(); err != nil {
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	namespace, ok := registry.grantNamespace(ctx, grant.Namespace)
+	if !ok {
+		return
+	}
+
+	if registry.Authenticator == nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryGrantParamError, "no groups without an account authenticator"))
+		return
+	}
+	if _, err := registry.Authenticator.Group(grant.GroupID); err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryGrantParamError, "group not found"))
+		return
+	}
+
+	// a group has one grant per repository, granting again replaces the permission
+	var existing RegistryGrant
+	registry.DbClient.Where("group_id = ? AND namespace = ? AND image = ?", grant.GroupID, namespace, grant.Image).Find(&existing)
+	existing.GroupID = grant.GroupID
+	existing.Namespace = namespace
+	existing.Image = grant.Image
+	existing.Permission = grant.Permission
+	if err := registry.DbClient.Save(&existing).Error; err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryGrantSaveError, err.Error()))
+		return
+	}
+
+	httpresponse.Ok(ctx, existing)
+}
+
+func (registry *Registry) DeleteGrant(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("grant_id"), 10, 64)
+	if err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryGrantNotFound, "invalid grant id"))
+		return
+	}
+
+	var grant RegistryGrant
+	if err := registry.DbClient.Where("id = ?", id).Find(&grant).Error; err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryGrantNotFound, err.Error()))
+		return
+	}
+
+	if _, ok := registry.grantNamespace(ctx, grant.Namespace); !ok {
+		return
+	}
+
+	if err := registry.DbClient.Delete(&grant).Error; err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryGrantSaveError, err.Error()))
+		return
+	}
+
+	httpresponse.Ok(ctx, "success")
+}
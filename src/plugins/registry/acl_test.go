@@ -0,0 +1,35 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryGrantValidate(t *testing.T) {
+	assert.Nil(t, (&RegistryGrant{GroupID: 1, Permission: GrantPush}).validate())
+	assert.Nil(t, (&RegistryGrant{GroupID: 1, Image: "web", Permission: GrantAdmin}).validate())
+	assert.NotNil(t, (&RegistryGrant{Permission: GrantPull}).validate())
+	assert.NotNil(t, (&RegistryGrant{GroupID: 1, Permission: "write"}).validate())
+	assert.NotNil(t, (&RegistryGrant{GroupID: 1, Image: "blog/web", Permission: GrantPull}).validate())
+}
+
+func TestMergePermissions(t *testing.T) {
+	assert.Equal(t, "", mergePermissions())
+	assert.Equal(t, "R", mergePermissions("R", ""))
+	assert.Equal(t, "RWM", mergePermissions("R", "RWM", "RW"))
+}
+
+func TestGrantsPermission(t *testing.T) {
+	grants := []RegistryGrant{
+		{GroupID: 1, Namespace: "blog", Permission: GrantPull},
+		{GroupID: 2, Namespace: "blog", Image: "web", Permission: GrantPush},
+		{GroupID: 3, Namespace: "shop", Permission: GrantAdmin},
+	}
+
+	assert.Equal(t, "RW", grantsPermission(grants, "blog", "web"))
+	assert.Equal(t, "R", grantsPermission(grants, "blog", "db"))
+	assert.Equal(t, "R", grantsPermission(grants, "blog", ""))
+	assert.Equal(t, "RWM", grantsPermission(grants, "shop", "web"))
+	assert.Equal(t, "", grantsPermission(grants, "mall", "web"))
+}
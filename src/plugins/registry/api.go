@@ -6,7 +6,9 @@ import (
 	"net/http"
 	"regexp"
 	"strings"
+	"time"
 
+	"github.com/Dataman-Cloud/crane/src/dockerclient"
 	"github.com/Dataman-Cloud/crane/src/plugins/auth"
 	"github.com/Dataman-Cloud/crane/src/plugins/auth/authenticators"
 	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
@@ -36,6 +38,32 @@ const (
 	CodeNamespaceMisMatchedPatternError   = "406-14012"
 	CodeSaveNamespaceError                = "409-14013"
 	CodeGetNamespaceError                 = "400-14014"
+	CodeRegistryNamespaceNotFound         = "404-14015"
+	CodeImageBuildListError               = "503-14016"
+	CodeImageBuildNotFound                = "404-14017"
+	CodeRetentionPolicyParamError         = "400-14018"
+	CodeRetentionPolicyNotFound           = "404-14019"
+	CodeRetentionPolicySaveError          = "503-14020"
+	CodeRetentionServicesUnknown          = "503-14021"
+	CodeRetentionRunError                 = "503-14022"
+	CodeRegistryStorageError              = "503-14023"
+	CodeRegistryGCNotConfigured           = "503-14024"
+	CodeRegistryGCRunning                 = "409-14025"
+	CodeRegistryMaintenanceParamError     = "400-14026"
+	CodeRegistryReadOnly                  = "503-14027"
+	CodeRegistryGCError                   = "503-14028"
+	CodeRegistryQuotaParamError           = "400-14029"
+	CodeRegistryQuotaNotFound             = "404-14030"
+	CodeRegistryQuotaSaveError            = "503-14031"
+	CodeRegistryGrantParamError           = "400-14032"
+	CodeRegistryGrantNotFound             = "404-14033"
+	CodeRegistryGrantSaveError            = "503-14034"
+	CodeRegistryGrantForbidden            = "403-14035"
+	CodeRegistryAccessLogParamError       = "400-14036"
+	CodeRegistryAccessLogError            = "503-14037"
+	CodeWebhookParamError                 = "400-14038"
+	CodeWebhookNotFound                   = "404-14039"
+	CodeWebhookSaveError                  = "503-14040"
 )
 
 // TODO (wtzhou) move the regex match into BeforeSave refer: http://motion-express.com/blog/gorm:-a-simple-guide-on-crud
@@ -45,10 +73,23 @@ const namespacePattern = `^[\da-z][\da-z_\-]{0,28}[\da-z]$`
 type Registry struct {
 	DbClient      *gorm.DB
 	Authenticator auth.Authenticator
+	// the services whose images retention keeps, set once the api is up
+	CraneDockerClient *dockerclient.CraneDockerClient
+	// the robots of the pipelines, nil disables their tokens
+	Robots *auth.RobotStore
 
 	AccountAuthenticator string
 	PrivateKeyPath       string
 	RegistryAddr         string
+	// interval of the scheduled retention runs, 0 disables them
+	RetentionInterval time.Duration
+	// the registry container garbage collections run in and its node
+	GCNodeID    string
+	GCContainer string
+	// the time a garbage collection runs before it is reported timed out, DefaultGCTimeout if 0
+	GCTimeout time.Duration
+
+	maintenance registryMaintenance
 }
 
 func NewRegistry(AccountAuthenticator string, PrivateKeyPath string, RegistryAddr string, DbDriver string, DbDSN string, dbClient *gorm.DB) *Registry {
@@ -64,7 +105,9 @@ func NewRegistry(AccountAuthenticator string, PrivateKeyPath string, RegistryAdd
 		registry.Authenticator = authenticators.NewDefaultAuthenticator()
 	}
 
+	registry.Robots = auth.NewRobotStore(dbClient)
 	registry.migrateTable()
+	registry.resumeWebhookDeliveries()
 	return registry
 }
 
@@ -73,6 +116,13 @@ func (registry *Registry) migrateTable() {
 	registry.DbClient.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&Image{})
 	registry.DbClient.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&Tag{})
 	registry.DbClient.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&ImageAccess{})
+	registry.DbClient.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&ImageBuild{})
+	registry.DbClient.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&RetentionPolicy{})
+	registry.DbClient.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&ManifestBlob{})
+	registry.DbClient.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&NamespaceQuota{})
+	registry.DbClient.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&RegistryGrant{})
+	registry.DbClient.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&Webhook{})
+	registry.DbClient.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&WebhookDelivery{})
 }
 
 func (registry *Registry) Token(ctx *gin.Context) {
@@ -82,7 +132,11 @@ func (registry *Registry) Token(ctx *gin.Context) {
 		httpresponse.Error(ctx, craneerr)
 		return
 	}
-	authenticated := registry.authenticate(username, password)
+	// the daemons pushing for crane authenticate with a credential it issued
+	granted, isCredential := registry.verifyCredential(username, password)
+	// the pipelines authenticate with a token of a robot
+	robot, robotScopes, isRobot := registry.verifyRobot(username, password)
+	authenticated := isCredential || isRobot || registry.authenticate(username, password)
 
 	service := ctx.Query("service")
 	scope := ctx.Query("scope")
@@ -95,8 +149,19 @@ func (registry *Registry) Token(ctx *gin.Context) {
 
 	accesses := registry.ParseResourceActions(scope)
 	for _, access := range accesses {
+		if isCredential {
+			grantAccess(access, granted)
+			registry.enforceQuota(access)
+			continue
+		}
+		if isRobot {
+			registry.filterRobotAccess(robot, robotScopes, access)
+			registry.enforceQuota(access)
+			continue
+		}
 		registry.FilterAccess(username, authenticated, access)
 	}
+	registry.restrictWrites(accesses)
 
 	//create token
 	rawToken, err := registry.MakeToken(registry.PrivateKeyPath, username, service, accesses)
@@ -195,7 +260,8 @@ func (registry *Registry) Notifications(ctx *gin.Context) {
 
 	for _, e := range notification.Events {
 		matched, _ := regexp.MatchString(manifestPattern, e.Target.MediaType)
-		if matched && strings.HasPrefix(ctx.Request.UserAgent(), "Go-http-client") {
+		// the targets of the deletions have no media type
+		if (matched || e.Action == WebhookEventDelete) && strings.HasPrefix(ctx.Request.UserAgent(), "Go-http-client") {
 			registry.HandleNotification(e)
 		}
 	}
@@ -212,10 +278,7 @@ func (registry *Registry) TagList(ctx *gin.Context) {
 		return
 	}
 
-	for _, tag := range tags {
-		registry.DbClient.Model(&ImageAccess{}).Where("namespace = ? AND image = ? AND digest = ? AND action='pull'", tag.Namespace, tag.Image, tag.Digest).Count(&tag.PullCount)
-		registry.DbClient.Model(&ImageAccess{}).Where("namespace = ? AND image = ? AND digest = ? AND action='push'", tag.Namespace, tag.Image, tag.Digest).Count(&tag.PushCount)
-	}
+	registry.fillTagStats(tags)
 
 	httpresponse.Ok(ctx, tags)
 }
@@ -257,6 +320,11 @@ func (registry *Registry) DeleteManifests(ctx *gin.Context) {
 	}
 	account := account_.(auth.Account)
 
+	if err = registry.checkWritable(); err != nil {
+		httpresponse.Error(ctx, err)
+		return
+	}
+
 	var tags []*Tag
 	if ctx.Query("tag") == "" {
 		err = registry.DbClient.Where("namespace = ? AND image = ?", ctx.Param("namespace"), ctx.Param("image")).Find(&tags).Error
@@ -326,10 +394,7 @@ func (registry *Registry) MineRepositories(ctx *gin.Context) {
 		return
 	}
 
-	for _, image := range images {
-		registry.DbClient.Model(&ImageAccess{}).Where("namespace = ? AND image = ? AND action='pull'", image.Namespace, image.Image).Count(&image.PullCount)
-		registry.DbClient.Model(&ImageAccess{}).Where("namespace = ? AND image = ? AND action='push'", image.Namespace, image.Image).Count(&image.PushCount)
-	}
+	registry.fillImageStats(images)
 
 	httpresponse.Ok(ctx, images)
 }
@@ -349,10 +414,7 @@ func (registry *Registry) PublicRepositories(ctx *gin.Context) {
 		return
 	}
 
-	for _, image := range images {
-		registry.DbClient.Model(&ImageAccess{}).Where("namespace = ? AND image = ? AND action='pull'", image.Namespace, image.Image).Count(&image.PullCount)
-		registry.DbClient.Model(&ImageAccess{}).Where("namespace = ? AND image = ? AND action='push'", image.Namespace, image.Image).Count(&image.PushCount)
-	}
+	registry.fillImageStats(images)
 
 	httpresponse.Ok(ctx, images)
 }
@@ -388,64 +450,77 @@ func (registry *Registry) ImagePublicity(ctx *gin.Context) {
 }
 
 func (registry *Registry) HandleNotification(n Event) {
-	if n.Action == "push" {
-		if len(strings.Split(n.Target.Repository, "/")) != 2 {
-			return
-		}
+	if n.Action == "push" && len(strings.Split(n.Target.Repository, "/")) == 2 {
 		namespace := strings.Split(n.Target.Repository, "/")[0]
 		image := strings.Split(n.Target.Repository, "/")[1]
-
-		// create or update an image
-		var modelImage Image
-		err := registry.DbClient.Where("namespace = ? AND image = ?", namespace, image).Find(&modelImage).Error
-		if err != nil && strings.Contains(err.Error(), "not found") {
-			modelImage.Namespace = namespace
-			modelImage.Image = image
-			if namespace == "library" {
-				modelImage.Publicity = 1
-			}
+		if err := registry.syncRepository(namespace, image); err != nil {
+			log.Errorf("sync tags of %s after push got error: %v", n.Target.Repository, err)
 		}
+	}
+	// the push is logged and posted even if its tags are not synced
+	registry.LogImageAccess(n)
+	registry.dispatchWebhooks(n)
+}
 
-		resp, _, err := registry.RegistryAPIGet(fmt.Sprintf("%s/%s/tags/list", namespace, image), n.Actor.Name)
-		if err != nil {
-			return
-		}
-		var respBody struct {
-			Name string   `json:"name"`
-			Tags []string `json:"tags"`
-		}
-		err = json.Unmarshal(resp, &respBody)
-		if err != nil {
-			return
+// create or update the image and the tags of the repository from the registry
+func (registry *Registry) syncRepository(namespace, image string) error {
+	// create or update an image
+	var modelImage Image
+	err := registry.DbClient.Where("namespace = ? AND image = ?", namespace, image).Find(&modelImage).Error
+	if err != nil && strings.Contains(err.Error(), "not found") {
+		modelImage.Namespace = namespace
+		modelImage.Image = image
+		if namespace == "library" {
+			modelImage.Publicity = 1
 		}
+	}
 
-		modelImage.LatestTag = respBody.Tags[0]
-		if modelImage.ID != 0 {
-			registry.DbClient.Model(&modelImage).Updates(Image{LatestTag: modelImage.LatestTag})
-		} else {
-			registry.DbClient.Save(&modelImage)
-		}
+	// the actor may be a robot or a daemon pushing with a credential, the namespace reads its repositories
+	resp, _, err := registry.RegistryAPIGet(fmt.Sprintf("%s/%s/tags/list", namespace, image), namespace)
+	if err != nil {
+		return err
+	}
+	var respBody struct {
+		Name string   `json:"name"`
+		Tags []string `json:"tags"`
+	}
+	if err = json.Unmarshal(resp, &respBody); err != nil {
+		return err
+	}
+	if len(respBody.Tags) == 0 {
+		return fmt.Errorf("no tags in %s/%s", namespace, image)
+	}
 
-		// create or update tag
-		for _, t := range respBody.Tags {
-			tag := &Tag{}
-			err = registry.DbClient.Where("namespace = ? AND image = ? AND tag = ? ", namespace, image, t).Find(tag).Error
-			if err != nil && strings.Contains(err.Error(), "not found") {
-				tag.Namespace = namespace
-				tag.Image = image
-				tag.Tag = t
-				registry.DbClient.Save(tag)
-			}
+	modelImage.LatestTag = respBody.Tags[0]
+	if modelImage.ID != 0 {
+		registry.DbClient.Model(&modelImage).Updates(Image{LatestTag: modelImage.LatestTag})
+	} else {
+		registry.DbClient.Save(&modelImage)
+	}
 
-			tag.Size, tag.Digest, err = registry.SizeAndReferenceForTag(fmt.Sprintf("%s/%s/manifests/%s", namespace, image, t), n.Actor.Name)
-			if tag.ID != 0 {
-				registry.DbClient.Model(tag).Updates(Tag{Size: tag.Size, Digest: tag.Digest})
-			} else {
-				registry.DbClient.Save(tag)
-			}
+	// create or update tag
+	for _, t := range respBody.Tags {
+		tag := &Tag{}
+		err = registry.DbClient.Where("namespace = ? AND image = ? AND tag = ? ", namespace, image, t).Find(tag).Error
+		if err != nil && strings.Contains(err.Error(), "not found") {
+			tag.Namespace = namespace
+			tag.Image = image
+			tag.Tag = t
+			registry.DbClient.Save(tag)
+		}
+
+		v2response, digest, err := registry.manifestOfReference(fmt.Sprintf("%s/%s/manifests/%s", namespace, image, t), namespace)
+		if err == nil {
+			tag.Size, tag.Digest = v2response.size(), digest
+			registry.saveManifestBlobs(namespace, image, digest, v2response)
+		}
+		if tag.ID != 0 {
+			registry.DbClient.Model(tag).Updates(Tag{Size: tag.Size, Digest: tag.Digest})
+		} else {
+			registry.DbClient.Save(tag)
 		}
 	}
-	registry.LogImageAccess(n)
+	return nil
 }
 
 func (registry *Registry) LogImageAccess(n Event) {
@@ -454,31 +529,37 @@ func (registry *Registry) LogImageAccess(n Event) {
 		ia.Namespace = strings.Split(n.Target.Repository, "/")[0]
 		ia.Image = strings.Split(n.Target.Repository, "/")[1]
 		ia.Digest = n.Target.Digest
-		ia.AccountEmail = n.Actor.Name
+		// the deletions may come without an actor
+		if n.Actor != nil {
+			ia.AccountEmail = n.Actor.Name
+		}
 		ia.Action = n.Action
 		registry.DbClient.Save(ia)
 	}
 }
 
 func (registry *Registry) SizeAndReferenceForTag(url string, account string) (uint64, string, error) {
-	var size uint64
-	content, digest, err := registry.RegistryAPIGetSchemaV2(url, account)
+	v2response, digest, err := registry.manifestOfReference(url, account)
 	if err != nil {
 		return 0, "", err
 	}
 
-	var v2response V2RegistryResponse
-	err = json.Unmarshal(content, &v2response)
+	return v2response.size(), digest, nil
+}
+
+func (registry *Registry) manifestOfReference(url string, account string) (*V2RegistryResponse, string, error) {
+	content, digest, err := registry.RegistryAPIGetSchemaV2(url, account)
 	if err != nil {
-		return 0, "", err
+		return nil, "", err
 	}
 
-	size = v2response.Config.Size
-	for _, layer := range v2response.Layers {
-		size = size + layer.Size
+	var v2response V2RegistryResponse
+	err = json.Unmarshal(content, &v2response)
+	if err != nil {
+		return nil, "", err
 	}
 
-	return size, digest, nil
+	return &v2response, digest, nil
 }
 
 func LikeParam(like string) string {
@@ -241,3 +241,35 @@ func TestDeleteManifests(t *testing.T) {
 	}
 	assert.Equal(t, http.StatusOK, resp.StatusCode, "response status code should be equal")
 }
+
+func TestHandleNotificationLogsUnsyncedPush(t *testing.T) {
+	var inserts []string
+	testdb.SetExecWithArgsFunc(func(query string, args []driver.Value) (driver.Result, error) {
+		inserts = append(inserts, query)
+		return testdb.NewResult(1, nil, 1, nil), nil
+	})
+	defer testdb.SetExecWithArgsFunc(nil)
+
+	dbClient, _ := db.NewDB("testdb", "")
+	registry := &Registry{DbClient: dbClient, RegistryAddr: "http://127.0.0.1:1"}
+	registry.HandleNotification(Event{
+		Action: "push",
+		Target: &Target{Repository: "shop/web", Digest: "sha256:a"},
+		Actor:  &Actor{Name: "shop"},
+	})
+
+	logged := false
+	for _, query := range inserts {
+		logged = logged || strings.Contains(query, "image_accesses")
+	}
+	assert.True(t, logged)
+}
+
+func TestHandleNotificationWithoutActor(t *testing.T) {
+	dbClient, _ := db.NewDB("testdb", "")
+	registry := &Registry{DbClient: dbClient}
+	registry.HandleNotification(Event{
+		Action: "delete",
+		Target: &Target{Repository: "shop/web", Digest: "sha256:a"},
+	})
+}
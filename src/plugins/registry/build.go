@@ -0,0 +1,95 @@
+package registry
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/Dataman-Cloud/crane/src/plugins/auth"
+	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
+	"github.com/Dataman-Cloud/crane/src/utils/httpresponse"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ImageBuildStatusBuilding  = "building"
+	ImageBuildStatusPushing   = "pushing"
+	ImageBuildStatusSucceeded = "succeeded"
+	ImageBuildStatusFailed    = "failed"
+
+	// build records listed at most
+	imageBuildListLimit = 100
+)
+
+// ImageBuild records an image built by crane into a registry namespace
+type ImageBuild struct {
+	ID        uint64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	AccountEmail string  `json:"AccountEmail" gorm:"not null"`
+	NodeID       string  `json:"NodeID"`
+	Namespace    string  `json:"Namespace" gorm:"not null"`
+	Image        string  `json:"Image" gorm:"not null"`
+	Tag          string  `json:"Tag"`
+	ImageID      string  `json:"ImageID"`
+	Digest       string  `json:"Digest"`
+	Push         bool    `json:"Push"`
+	Status       string  `json:"Status"`
+	Error        string  `json:"Error" sql:"type:text"`
+	Duration     float64 `json:"Duration"` // seconds
+}
+
+func (registry *Registry) SaveImageBuild(build *ImageBuild) error {
+	return registry.DbClient.Save(build).Error
+}
+
+// the builds of the namespace of the account, the latest first, query image and status filter them
+func (registry *Registry) ListImageBuilds(ctx *gin.Context) {
+	account, found := ctx.Get("account")
+	if !found {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryUnauthorized, "invalid user"))
+		return
+	}
+
+	query := registry.DbClient.Where("namespace = ?", registry.RegistryNamespaceForAccount(account.(auth.Account)))
+	if image := ctx.Query("image"); image != "" {
+		query = query.Where("image = ?", image)
+	}
+	if status := ctx.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var builds []ImageBuild
+	if err := query.Order("id DESC").Limit(imageBuildListLimit).Find(&builds).Error; err != nil {
+		log.Errorf("list image builds got error: %v", err)
+		httpresponse.Error(ctx, cranerror.NewError(CodeImageBuildListError, err.Error()))
+		return
+	}
+
+	httpresponse.Ok(ctx, builds)
+}
+
+func (registry *Registry) InspectImageBuild(ctx *gin.Context) {
+	account, found := ctx.Get("account")
+	if !found {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryUnauthorized, "invalid user"))
+		return
+	}
+
+	id, err := strconv.ParseUint(ctx.Param("build_id"), 10, 64)
+	if err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeImageBuildNotFound, "invalid build id"))
+		return
+	}
+
+	var build ImageBuild
+	namespace := registry.RegistryNamespaceForAccount(account.(auth.Account))
+	if err := registry.DbClient.Where("id = ? AND namespace = ?", id, namespace).Find(&build).Error; err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeImageBuildNotFound, err.Error()))
+		return
+	}
+
+	httpresponse.Ok(ctx, build)
+}
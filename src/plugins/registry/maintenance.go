@@ -0,0 +1,321 @@
+package registry
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dataman-Cloud/crane/src/dockerclient"
+	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
+	"github.com/Dataman-Cloud/crane/src/utils/httpresponse"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/distribution/registry/auth/token"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/context"
+)
+
+const (
+	// the config of the registry image deployed with crane
+	RegistryConfigPath = "/etc/docker/registry/config.yml"
+
+	gcEligibleBlobPrefix = "blob eligible for deletion:"
+
+	DefaultGCTimeout = time.Hour
+)
+
+// how often a garbage collection timed out is checked for its end
+var gcExecPollInterval = 10 * time.Second
+
+// GCReport is the result of a registry garbage collection
+type GCReport struct {
+	DryRun     bool       `json:"DryRun"`
+	StartedAt  time.Time  `json:"StartedAt"`
+	FinishedAt *time.Time `json:"FinishedAt"`
+	// the blobs the registry deleted, or would delete on a dry run
+	Blobs []string `json:"Blobs"`
+	// the sum of the recorded sizes of the blobs
+	Freed uint64 `json:"Freed"`
+	// blobs crane has no size for, pushed before their manifests were recorded
+	UnknownSize int    `json:"UnknownSize"`
+	Output      string `json:"Output"`
+	Error       string `json:"Error"`
+}
+
+type MaintenanceState struct {
+	// the token requests are granted pull only
+	ReadOnly bool       `json:"ReadOnly"`
+	Since    *time.Time `json:"Since"`
+	Reason   string     `json:"Reason"`
+	// a garbage collection is running
+	Running bool      `json:"Running"`
+	LastGC  *GCReport `json:"LastGC"`
+}
+
+// registryMaintenance guards the read-only mode of the registry, its zero value is the read-write mode
+type registryMaintenance struct {
+	mu      sync.Mutex
+	state   MaintenanceState
+	running *GCReport
+	// the expiration of the last token granted a write action
+	writeGrantedUntil time.Time
+}
+
+func (registry *Registry) MaintenanceState() MaintenanceState {
+	m := &registry.maintenance
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state := m.state
+	state.Running = m.running != nil
+	return state
+}
+
+// SetReadOnly switches the registry in or out of the read-only mode, not while a garbage collection runs
+func (registry *Registry) SetReadOnly(readOnly bool, reason string) error {
+	m := &registry.maintenance
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.running != nil {
+		return cranerror.NewError(CodeRegistryGCRunning, "registry garbage collection running")
+	}
+	m.setReadOnly(readOnly, reason)
+	return nil
+}
+
+func (m *registryMaintenance) setReadOnly(readOnly bool, reason string) {
+	if m.state.ReadOnly == readOnly {
+		m.state.Reason = reason
+		return
+	}
+
+	m.state.ReadOnly = readOnly
+	m.state.Reason = reason
+	m.state.Since = nil
+	if readOnly {
+		now := time.Now()
+		m.state.Since = &now
+	}
+	log.Infof("registry read-only mode: %t, %s", readOnly, reason)
+}
+
+// drop the write actions of access in the read-only mode, otherwise remember until when the writes are granted
+func (registry *Registry) restrictWrites(access []*token.ResourceActions) {
+	m := &registry.maintenance
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, a := range access {
+		var actions []string
+		for _, action := range a.Actions {
+			if action != "push" && action != "*" {
+				actions = append(actions, action)
+			} else if !m.state.ReadOnly {
+				actions = append(actions, action)
+				m.writeGrantedUntil = time.Now().Add(expiration * time.Minute)
+			}
+		}
+		if actions == nil {
+			actions = []string{}
+		}
+		a.Actions = actions
+	}
+}
+
+func (registry *Registry) checkWritable() error {
+	if registry.MaintenanceState().ReadOnly {
+		return cranerror.NewError(CodeRegistryReadOnly, "registry in read-only maintenance mode")
+	}
+	return nil
+}
+
+// StartGC runs the garbage collection of the registry in the background,
+// the registry is read-only from the expiration of the write tokens granted till the end
+func (registry *Registry) StartGC(dryRun bool) (*GCReport, error) {
+	if registry.GCContainer == "" || registry.GCNodeID == "" || registry.CraneDockerClient == nil {
+		return nil, cranerror.NewError(CodeRegistryGCNotConfigured,
+			"registry container not configured, run 'registry garbage-collect "+RegistryConfigPath+"' in it during the read-only mode")
+	}
+
+	m := &registry.maintenance
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.running != nil {
+		return nil, cranerror.NewError(CodeRegistryGCRunning, "registry garbage collection running")
+	}
+
+	report := &GCReport{DryRun: dryRun, StartedAt: time.Now(), Blobs: make([]string, 0)}
+	m.running = report
+	started := *report
+	go registry.runGC(report)
+
+	return &started, nil
+}
+
+func (registry *Registry) runGC(report *GCReport) {
+	m := &registry.maintenance
+	// the garbage collection may still run, the registry is kept read only
+	var running bool
+	if !report.DryRun {
+		m.mu.Lock()
+		wasReadOnly, reason := m.state.ReadOnly, m.state.Reason
+		m.setReadOnly(true, "registry garbage collection")
+		wait := m.writeGrantedUntil.Sub(time.Now())
+		m.mu.Unlock()
+
+		defer func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if running {
+				// the blobs may still be deleted, the pushes would reference them
+				m.setReadOnly(true, "registry garbage collection timed out and may still be running")
+				return
+			}
+			m.setReadOnly(wasReadOnly, reason)
+		}()
+
+		// the pushes running with a token granted before are finished
+		if wait > 0 {
+			log.Infof("registry garbage collection waits %s for the write tokens to expire", wait)
+			time.Sleep(wait)
+		}
+	}
+
+	var err error
+	if running, err = registry.garbageCollect(report); err != nil {
+		report.Error = err.Error()
+		log.Errorf("registry garbage collection got error: %v", err)
+	}
+
+	m.mu.Lock()
+	finishedAt := time.Now()
+	report.FinishedAt = &finishedAt
+	m.state.LastGC = report
+	m.running = nil
+	m.mu.Unlock()
+}
+
+// run the garbage collection, returns whether it may still run on an error
+func (registry *Registry) garbageCollect(report *GCReport) (bool, error) {
+	if err := registry.syncManifestBlobs(); err != nil {
+		return false, err
+	}
+
+	var blobs []ManifestBlob
+	if err := registry.DbClient.Find(&blobs).Error; err != nil {
+		return false, err
+	}
+	sizes := make(map[string]uint64)
+	for _, blob := range blobs {
+		sizes[blob.Digest] = blob.Size
+	}
+
+	cmd := []string{"registry", "garbage-collect"}
+	if report.DryRun {
+		cmd = append(cmd, "--dry-run")
+	}
+	cmd = append(cmd, RegistryConfigPath)
+
+	ctx := context.WithValue(context.Background(), "node_id", registry.GCNodeID)
+	timeout := registry.GCTimeout
+	if timeout <= 0 {
+		timeout = DefaultGCTimeout
+	}
+	result, err := registry.CraneDockerClient.ExecContainerWithin(ctx, registry.GCContainer, dockerclient.ExecOptions{Cmd: cmd}, timeout)
+	if err != nil {
+		return false, err
+	}
+
+	report.Output = result.Stdout + result.Stderr
+	report.Blobs = eligibleBlobs(report.Output)
+	for _, digest := range report.Blobs {
+		size, ok := sizes[digest]
+		if !ok {
+			report.UnknownSize++
+		}
+		report.Freed += size
+	}
+
+	if result.TimedOut {
+		// docker can not stop the exec, the registry stays read only until it ends
+		log.Warnf("registry garbage collection timed out after %s, waiting for it to end", timeout)
+		if err := registry.CraneDockerClient.WaitExec(ctx, result.ExecID, gcExecPollInterval); err != nil {
+			return true, cranerror.NewError(CodeRegistryGCError, "registry garbage collection timed out, its end is unknown: "+err.Error())
+		}
+		return false, cranerror.NewError(CodeRegistryGCError, "registry garbage collection timed out")
+	}
+	if result.ExitCode != 0 {
+		return false, cranerror.NewError(CodeRegistryGCError, "registry garbage collection exited with code "+strconv.Itoa(result.ExitCode))
+	}
+
+	if !report.DryRun && len(report.Blobs) > 0 {
+		registry.DbClient.Where("digest IN (?)", report.Blobs).Delete(&ManifestBlob{})
+	}
+	return false, nil
+}
+
+// the digests of the blob eligible for deletion lines of the garbage collection output
+func eligibleBlobs(output string) []string {
+	blobs := make([]string, 0)
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		i := strings.Index(line, gcEligibleBlobPrefix)
+		if i < 0 {
+			continue
+		}
+
+		fields := strings.Fields(line[i+len(gcEligibleBlobPrefix):])
+		if len(fields) == 0 || seen[fields[0]] {
+			continue
+		}
+		seen[fields[0]] = true
+		blobs = append(blobs, fields[0])
+	}
+
+	return blobs
+}
+
+func (registry *Registry) InspectMaintenance(ctx *gin.Context) {
+	httpresponse.Ok(ctx, registry.MaintenanceState())
+}
+
+func (registry *Registry) UpdateMaintenance(ctx *gin.Context) {
+	var param struct {
+		ReadOnly bool   `json:"ReadOnly"`
+		Reason   string `json:"Reason"`
+	}
+	if err := ctx.BindJSON(&param); err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryMaintenanceParamError, err.Error()))
+		return
+	}
+
+	if err := registry.SetReadOnly(param.ReadOnly, param.Reason); err != nil {
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	httpresponse.Ok(ctx, registry.MaintenanceState())
+}
+
+// start a garbage collection, query dry_run=true only reports the blobs it would delete
+func (registry *Registry) GarbageCollect(ctx *gin.Context) {
+	dryRun, err := strconv.ParseBool(ctx.DefaultQuery("dry_run", "false"))
+	if err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryMaintenanceParamError, "invalid dry_run"))
+		return
+	}
+
+	report, err := registry.StartGC(dryRun)
+	if err != nil {
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	httpresponse.Ok(ctx, report)
+}
@@ -0,0 +1,49 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/docker/distribution/registry/auth/token"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEligibleBlobs(t *testing.T) {
+	output := `blog/web
+blog/web: marking manifest sha256:2
+blog/web: marking blob sha256:base
+blob eligible for deletion: sha256:a
+blob eligible for deletion: sha256:c
+time="2016-11-02T08:00:00Z" level=info msg="Deleting blob: /docker/registry/v2/blobs/sha256/a" go.version=go1.7.3
+blob eligible for deletion: sha256:a
+`
+	assert.Equal(t, []string{"sha256:a", "sha256:c"}, eligibleBlobs(output))
+	assert.Equal(t, []string{}, eligibleBlobs(""))
+}
+
+func TestRestrictWrites(t *testing.T) {
+	registry := &Registry{}
+	access := []*token.ResourceActions{{Type: "repository", Name: "blog/web", Actions: []string{"push", "*", "pull"}}}
+	registry.restrictWrites(access)
+	assert.Equal(t, []string{"push", "*", "pull"}, access[0].Actions)
+	assert.False(t, registry.maintenance.writeGrantedUntil.IsZero())
+
+	assert.Nil(t, registry.SetReadOnly(true, "gc"))
+	assert.NotNil(t, registry.checkWritable())
+	assert.NotNil(t, registry.MaintenanceState().Since)
+	registry.restrictWrites(access)
+	assert.Equal(t, []string{"pull"}, access[0].Actions)
+
+	access = []*token.ResourceActions{{Type: "repository", Name: "blog/web", Actions: []string{"push"}}}
+	registry.restrictWrites(access)
+	assert.Equal(t, []string{}, access[0].Actions)
+
+	assert.Nil(t, registry.SetReadOnly(false, ""))
+	assert.Nil(t, registry.checkWritable())
+}
+
+func TestStartGCNotConfigured(t *testing.T) {
+	registry := &Registry{}
+	_, err := registry.StartGC(true)
+	assert.NotNil(t, err)
+	assert.False(t, registry.MaintenanceState().Running)
+}
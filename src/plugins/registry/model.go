@@ -24,6 +24,7 @@ type Target struct {
 	MediaType  string
 	Digest     string
 	Repository string
+	Tag        string
 	URL        string `json:"Url"`
 }
 
@@ -62,8 +63,9 @@ type Image struct {
 	Image     string `json:"Image" gorm:"not null"`
 	Publicity uint8  `json:"Publicity" gorm:"not null default 0"`
 
-	PushCount int64 `json:"PushCount" gorm:"-"`
-	PullCount int64 `json:"PullCount" gorm:"-"`
+	PushCount    int64      `json:"PushCount" gorm:"-"`
+	PullCount    int64      `json:"PullCount" gorm:"-"`
+	LastPulledAt *time.Time `json:"LastPulledAt" gorm:"-"`
 }
 
 type Tag struct {
@@ -77,8 +79,9 @@ type Tag struct {
 	Image     string `json:"Image" gorm:"not null"`
 	Size      uint64 `json:"Size"`
 
-	PushCount int64 `json:"PushCount" gorm:"-"`
-	PullCount int64 `json:"PullCount" gorm:"-"`
+	PushCount    int64      `json:"PushCount" gorm:"-"`
+	PullCount    int64      `json:"PullCount" gorm:"-"`
+	LastPulledAt *time.Time `json:"LastPulledAt" gorm:"-"`
 }
 
 type ImageAccess struct {
@@ -100,6 +103,14 @@ type V2RegistryResponse struct {
 	Layers        V2RegistryResponseLayers `json:"layers"`
 }
 
+func (v2response *V2RegistryResponse) size() uint64 {
+	size := v2response.Config.Size
+	for _, layer := range v2response.Layers {
+		size = size + layer.Size
+	}
+	return size
+}
+
 type V2RegistryResponseConfig struct {
 	MediaType string `json:"mediaType"`
 	Size      uint64 `json:"size"`
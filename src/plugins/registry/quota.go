@@ -0,0 +1,224 @@
+package registry
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Dataman-Cloud/crane/src/plugins/auth"
+	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
+	"github.com/Dataman-Cloud/crane/src/utils/httpresponse"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/distribution/registry/auth/token"
+	"github.com/gin-gonic/gin"
+)
+
+// NamespaceQuota limits the pushes into a namespace, a namespace without quota is unlimited
+type NamespaceQuota struct {
+	ID        uint64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	Namespace string `json:"Namespace" gorm:"not null" sql:"unique"`
+	// bytes of the unique layers of the tagged manifests, 0 is unlimited
+	MaxLayerSize uint64 `json:"MaxLayerSize"`
+	// 0 is unlimited
+	MaxRepositories int `json:"MaxRepositories"`
+}
+
+type QuotaUsage struct {
+	Namespace       string `json:"Namespace"`
+	LayerSize       uint64 `json:"LayerSize"`
+	Repositories    int    `json:"Repositories"`
+	MaxLayerSize    uint64 `json:"MaxLayerSize"`
+	MaxRepositories int    `json:"MaxRepositories"`
+	// the pushes are refused, into a new repository as soon as the repositories reach the limit
+	OverQuota bool `json:"OverQuota"`
+}
+
+func (usage *QuotaUsage) sizeExceeded() bool {
+	return usage.MaxLayerSize > 0 && usage.LayerSize > usage.MaxLayerSize
+}
+
+func (usage *QuotaUsage) repositoriesExceeded() bool {
+	return usage.MaxRepositories > 0 && usage.Repositories > usage.MaxRepositories
+}
+
+// a push into the namespace is allowed, newRepository if the push creates the repository
+func (usage *QuotaUsage) allowsPush(newRepository bool) bool {
+	if usage.sizeExceeded() || usage.repositoriesExceeded() {
+		return false
+	}
+
+	return !newRepository || usage.MaxRepositories == 0 || usage.Repositories < usage.MaxRepositories
+}
+
+// the usage of the namespace against its quota, no limit if the namespace has none
+func (registry *Registry) QuotaUsage(namespace string) (*QuotaUsage, error) {
+	usage := &QuotaUsage{Namespace: namespace}
+
+	var quota NamespaceQuota
+	if err := registry.DbClient.Where("namespace = ?", namespace).Find(&quota).Error; err == nil {
+		usage.MaxLayerSize = quota.MaxLayerSize
+		usage.MaxRepositories = quota.MaxRepositories
+	} else if !strings.Contains(err.Error(), "not found") {
+		return nil, err
+	}
+
+	usages, err := registry.StorageUsage(namespace)
+	if err != nil {
+		return nil, err
+	}
+	if len(usages) > 0 {
+		usage.LayerSize = usages[0].LayerSize
+	}
+
+	if err := registry.DbClient.Model(&Image{}).Where("namespace = ?", namespace).Count(&usage.Repositories).Error; err != nil {
+		return nil, err
+	}
+
+	usage.OverQuota = usage.sizeExceeded() || usage.repositoriesExceeded()
+	return usage, nil
+}
+
+// drop the push of a repository of a namespace over its quota
+func (registry *Registry) enforceQuota(a *token.ResourceActions) {
+	if a.Type != "repository" || !strings.Contains(a.Name, "/") {
+		return
+	}
+
+	// "*" of the owners and the admins grants every action, the push included
+	pushing := false
+	for _, action := range a.Actions {
+		pushing = pushing || action == "push" || action == "*"
+	}
+	if !pushing {
+		return
+	}
+
+	namespace := strings.Split(a.Name, "/")[0]
+	image := strings.Split(a.Name, "/")[1]
+
+	var count int
+	registry.DbClient.Model(&NamespaceQuota{}).Where("namespace = ?", namespace).Count(&count)
+	if count == 0 {
+		return
+	}
+
+	usage, err := registry.QuotaUsage(namespace)
+	if err != nil {
+		log.Errorf("get quota usage of namespace %s got error: %v", namespace, err)
+		return
+	}
+
+	var modelImage Image
+	newRepository := registry.DbClient.Where("namespace = ? AND image = ?", namespace, image).Find(&modelImage).Error != nil
+	if usage.allowsPush(newRepository) {
+		return
+	}
+
+	log.Infof("namespace %s over quota, push of %s refused", namespace, a.Name)
+	actions := []string{}
+	for _, action := range a.Actions {
+		if action != "push" && action != "*" {
+			actions = append(actions, action)
+		}
+	}
+	a.Actions = actions
+}
+
+// the quota usage of the namespace of the account
+func (registry *Registry) NamespaceQuotaUsage(ctx *gin.Context) {
+	account, found := ctx.Get("account")
+	if !found {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryUnauthorized, "invalid user"))
+		return
+	}
+
+	namespace := registry.RegistryNamespaceForAccount(account.(auth.Account))
+	if namespace == "" {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryNamespaceNotFound, "no registry namespace for the account"))
+		return
+	}
+
+	usage, err := registry.QuotaUsage(namespace)
+	if err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryStorageError, err.Error()))
+		return
+	}
+
+	httpresponse.Ok(ctx, usage)
+}
+
+// the usage of every namespace with a quota
+func (registry *Registry) ListQuotas(ctx *gin.Context) {
+	var quotas []NamespaceQuota
+	if err := registry.DbClient.Order("namespace").Find(&quotas).Error; err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryStorageError, err.Error()))
+		return
+	}
+
+	usages := make([]*QuotaUsage, 0)
+	for _, quota := range quotas {
+		usage, err := registry.QuotaUsage(quota.Namespace)
+		if err != nil {
+			httpresponse.Error(ctx, cranerror.NewError(CodeRegistryStorageError, err.Error()))
+			return
+		}
+		usages = append(usages, usage)
+	}
+
+	httpresponse.Ok(ctx, usages)
+}
+
+func (registry *Registry) UpdateQuota(ctx *gin.Context) {
+	namespace := ctx.Param("namespace")
+	if matched, _ := regexp.MatchString(namespacePattern, namespace); !matched && namespace != "library" {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryQuotaParamError, "invalid namespace "+namespace))
+		return
+	}
+
+	var param NamespaceQuota
+	if err := ctx.BindJSON(&param); err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryQuotaParamError, err.Error()))
+		return
+	}
+	if param.MaxRepositories < 0 {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryQuotaParamError, "MaxRepositories can not be negative"))
+		return
+	}
+
+	var quota NamespaceQuota
+	registry.DbClient.Where("namespace = ?", namespace).Find(&quota)
+	quota.Namespace = namespace
+	quota.MaxLayerSize = param.MaxLayerSize
+	quota.MaxRepositories = param.MaxRepositories
+	if err := registry.DbClient.Save(&quota).Error; err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryQuotaSaveError, err.Error()))
+		return
+	}
+
+	usage, err := registry.QuotaUsage(namespace)
+	if err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryStorageError, err.Error()))
+		return
+	}
+
+	httpresponse.Ok(ctx, usage)
+}
+
+func (registry *Registry) DeleteQuota(ctx *gin.Context) {
+	var quota NamespaceQuota
+	if err := registry.DbClient.Where("namespace = ?", ctx.Param("namespace")).Find(&quota).Error; err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryQuotaNotFound, err.Error()))
+		return
+	}
+
+	if err := registry.DbClient.Delete(&quota).Error; err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryQuotaSaveError, err.Error()))
+		return
+	}
+
+	httpresponse.Ok(ctx, "success")
+}
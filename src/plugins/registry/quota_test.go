@@ -0,0 +1,76 @@
+package registry
+
+import (
+	"database/sql/driver"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Dataman-Cloud/crane/src/utils/db"
+
+	"github.com/docker/distribution/registry/auth/token"
+	"github.com/erikstmartin/go-testdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuotaAllowsPush(t *testing.T) {
+	usage := &QuotaUsage{LayerSize: 100, Repositories: 3}
+	assert.True(t, usage.allowsPush(true))
+
+	usage.MaxLayerSize = 100
+	usage.MaxRepositories = 3
+	assert.True(t, usage.allowsPush(false))
+	assert.False(t, usage.allowsPush(true))
+
+	usage.LayerSize = 101
+	assert.False(t, usage.allowsPush(false))
+	assert.True(t, usage.sizeExceeded())
+
+	usage = &QuotaUsage{Repositories: 4, MaxRepositories: 3}
+	assert.False(t, usage.allowsPush(false))
+	assert.True(t, usage.repositoriesExceeded())
+}
+
+// the registry of the owner of namespace shop, at most one repository and 100 bytes of layers
+func quotaTestRegistry(images, layerSize int) *Registry {
+	testdb.SetQueryWithArgsFunc(func(query string, args []driver.Value) (driver.Rows, error) {
+		switch {
+		case strings.Contains(query, "namespace_emails"):
+			return testdb.RowsFromCSVString([]string{"namespace", "account_email"}, "shop,owner@test.com"), nil
+		case strings.Contains(query, "count(*)") && strings.Contains(query, "namespace_quota"):
+			return testdb.RowsFromCSVString([]string{"count"}, "1"), nil
+		case strings.Contains(query, "namespace_quota"):
+			return testdb.RowsFromCSVString([]string{"id", "namespace", "max_layer_size", "max_repositories"}, "1,shop,100,1"), nil
+		case strings.Contains(query, "count(*)") && strings.Contains(query, "images"):
+			return testdb.RowsFromCSVString([]string{"count"}, strconv.Itoa(images)), nil
+		case strings.Contains(query, "images") && args[1] == "api":
+			return testdb.RowsFromCSVString([]string{"id", "namespace", "image"}, "1,shop,api"), nil
+		case strings.Contains(query, "tags"):
+			return testdb.RowsFromCSVString([]string{"id", "namespace", "image", "tag", "digest", "size"},
+				"1,shop,api,latest,sha256:a,"+strconv.Itoa(layerSize)), nil
+		}
+		return testdb.RowsFromCSVString([]string{"id"}, ""), nil
+	})
+
+	dbClient, _ := db.NewDB("testdb", "")
+	return &Registry{DbClient: dbClient}
+}
+
+func TestFilterAccessOwnerOverQuota(t *testing.T) {
+	// a new repository over the repository count
+	registry := quotaTestRegistry(1, 10)
+	a := &token.ResourceActions{Type: "repository", Name: "shop/web", Actions: []string{"pull", "push"}}
+	registry.FilterAccess("owner@test.com", true, a)
+	assert.Equal(t, []string{"pull"}, a.Actions)
+
+	// an existing repository under the quota
+	a = &token.ResourceActions{Type: "repository", Name: "shop/api", Actions: []string{"pull", "push"}}
+	registry.FilterAccess("owner@test.com", true, a)
+	assert.Equal(t, []string{"push", "*", "pull"}, a.Actions)
+
+	// an existing repository over the layer size
+	registry = quotaTestRegistry(1, 101)
+	a = &token.ResourceActions{Type: "repository", Name: "shop/api", Actions: []string{"pull", "push"}}
+	registry.FilterAccess("owner@test.com", true, a)
+	assert.Equal(t, []string{"pull"}, a.Actions)
+}
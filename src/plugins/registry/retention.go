@@ -0,0 +1,563 @@
+package registry
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Dataman-Cloud/crane/src/plugins/auth"
+	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
+	"github.com/Dataman-Cloud/crane/src/utils/httpresponse"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/engine-api/types"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	RetentionReasonKeepLast  = "beyond the last tags kept"
+	RetentionReasonOlderThan = "older than the days kept"
+	RetentionReasonUntagged  = "untagged"
+)
+
+// RetentionPolicy decides the digests of the repositories of a namespace deleted, of every repository if Image is empty.
+// A tag is kept if it is among the KeepLast tags pushed last or matches KeepPattern, the other ones are deleted
+// once older than OlderThan days if set, a digest still tagged or used by a service is never deleted
+type RetentionPolicy struct {
+	ID        uint64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	Namespace      string `json:"Namespace" gorm:"not null"`
+	Image          string `json:"Image"`
+	KeepLast       int    `json:"KeepLast"`
+	KeepPattern    string `json:"KeepPattern"`
+	DeleteUntagged bool   `json:"DeleteUntagged"`
+	OlderThan      int    `json:"OlderThan"` // days
+	// scheduled runs skip the disabled policies
+	Enabled bool `json:"Enabled"`
+
+	LastRunAt      *time.Time `json:"LastRunAt"`
+	LastRunDeleted int        `json:"LastRunDeleted"`
+	LastRunError   string     `json:"LastRunError" sql:"type:text"`
+}
+
+func (policy *RetentionPolicy) validate() error {
+	if policy.KeepLast < 0 || policy.OlderThan < 0 {
+		return cranerror.NewError(CodeRetentionPolicyParamError, "KeepLast and OlderThan can not be negative")
+	}
+
+	if policy.KeepPattern != "" {
+		if _, err := regexp.Compile(policy.KeepPattern); err != nil {
+			return cranerror.NewError(CodeRetentionPolicyParamError, "invalid KeepPattern: "+err.Error())
+		}
+	}
+
+	if policy.KeepLast == 0 && policy.OlderThan == 0 && !policy.DeleteUntagged {
+		return cranerror.NewError(CodeRetentionPolicyParamError, "one of KeepLast, OlderThan or DeleteUntagged required")
+	}
+
+	return nil
+}
+
+// RetentionItem is a digest of a repository deleted by a policy with the tags going with it
+type RetentionItem struct {
+	Namespace string   `json:"Namespace"`
+	Image     string   `json:"Image"`
+	Digest    string   `json:"Digest"`
+	Tags      []string `json:"Tags"`
+	Size      uint64   `json:"Size"`
+	Reason    string   `json:"Reason"`
+	Deleted   bool     `json:"Deleted"`
+	Error     string   `json:"Error,omitempty"`
+}
+
+type RetentionReport struct {
+	PolicyID  uint64          `json:"PolicyID"`
+	Namespace string          `json:"Namespace"`
+	DryRun    bool            `json:"DryRun"`
+	Items     []RetentionItem `json:"Items"`
+	// tags left in the repositories
+	Kept      int    `json:"Kept"`
+	Reclaimed uint64 `json:"Reclaimed"`
+}
+
+// the tags and digests of a repository the services are created with
+type repositoryReferences struct {
+	tags    map[string]bool
+	digests map[string]bool
+}
+
+// index the images of the services by repository: registry:5000/blog/web:v1@sha256:... is the tag v1 and the digest of blog/web
+func serviceRepositoryReferences(images []string) map[string]*repositoryReferences {
+	references := make(map[string]*repositoryReferences)
+	for _, image := range images {
+		var digest string
+		if i := strings.Index(image, "@"); i >= 0 {
+			image, digest = image[:i], image[i+1:]
+		}
+
+		tag := "latest"
+		if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+			image, tag = image[:i], image[i+1:]
+		}
+
+		parts := strings.Split(image, "/")
+		if len(parts) > 1 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+			parts = parts[1:]
+		}
+		if len(parts) == 1 {
+			parts = append([]string{"library"}, parts...)
+		}
+		repository := strings.Join(parts, "/")
+
+		if references[repository] == nil {
+			references[repository] = &repositoryReferences{tags: make(map[string]bool), digests: make(map[string]bool)}
+		}
+		references[repository].tags[tag] = true
+		if digest != "" {
+			references[repository].digests[digest] = true
+		}
+	}
+
+	return references
+}
+
+// the digests of a repository the policy deletes, tags is every tag of the repository and untagged
+// the digests pushed once and tagged no more with the time they were pushed last
+func planRetention(policy RetentionPolicy, tags []Tag, untagged map[string]time.Time, references *repositoryReferences, now time.Time) ([]RetentionItem, int, error) {
+	var keepPattern *regexp.Regexp
+	if policy.KeepPattern != "" {
+		var err error
+		if keepPattern, err = regexp.Compile(policy.KeepPattern); err != nil {
+			return nil, 0, err
+		}
+	}
+	if references == nil {
+		references = &repositoryReferences{}
+	}
+	olderThan := now.Add(-time.Duration(policy.OlderThan) * 24 * time.Hour)
+
+	// the tags pushed last first
+	sort.Slice(tags, func(i, j int) bool {
+		if !tags[i].UpdatedAt.Equal(tags[j].UpdatedAt) {
+			return tags[i].UpdatedAt.After(tags[j].UpdatedAt)
+		}
+		return tags[i].ID > tags[j].ID
+	})
+
+	// a digest is deleted with all its tags, so only if none of them is kept
+	var digests []string
+	items := make(map[string]*RetentionItem)
+	keptDigests := make(map[string]bool)
+	kept := 0
+	for i, tag := range tags {
+		// with both rules a tag is deleted once beyond the last ones and old enough
+		reason := ""
+		if (policy.KeepLast > 0 || policy.OlderThan > 0) &&
+			(policy.KeepLast == 0 || i >= policy.KeepLast) &&
+			(policy.OlderThan == 0 || tag.UpdatedAt.Before(olderThan)) {
+			reason = RetentionReasonKeepLast
+			if policy.KeepLast == 0 {
+				reason = RetentionReasonOlderThan
+			}
+		}
+
+		if reason == "" || tag.Digest == "" || (keepPattern != nil && keepPattern.MatchString(tag.Tag)) ||
+			references.tags[tag.Tag] || references.digests[tag.Digest] {
+			keptDigests[tag.Digest] = true
+			kept++
+			continue
+		}
+
+		item, ok := items[tag.Digest]
+		if !ok {
+			item = &RetentionItem{Namespace: tag.Namespace, Image: tag.Image, Digest: tag.Digest, Size: tag.Size, Reason: reason}
+			items[tag.Digest] = item
+			digests = append(digests, tag.Digest)
+		}
+		item.Tags = append(item.Tags, tag.Tag)
+	}
+
+	var planned []RetentionItem
+	for _, digest := range digests {
+		if keptDigests[digest] {
+			kept += len(items[digest].Tags)
+			continue
+		}
+		planned = append(planned, *items[digest])
+	}
+
+	if policy.DeleteUntagged {
+		var untaggedDigests []string
+		for digest, pushedAt := range untagged {
+			if keptDigests[digest] || items[digest] != nil || references.digests[digest] {
+				continue
+			}
+			if policy.OlderThan > 0 && !pushedAt.Before(olderThan) {
+				continue
+			}
+			untaggedDigests = append(untaggedDigests, digest)
+		}
+		sort.Strings(untaggedDigests)
+
+		for _, digest := range untaggedDigests {
+			planned = append(planned, RetentionItem{
+				Namespace: policy.Namespace,
+				Image:     policy.Image,
+				Digest:    digest,
+				Tags:      []string{},
+				Reason:    RetentionReasonUntagged,
+			})
+		}
+	}
+
+	return planned, kept, nil
+}
+
+// the images of every service, nil with an error if the services are unknown
+func (registry *Registry) serviceImages() ([]string, error) {
+	if registry.CraneDockerClient == nil {
+		return nil, cranerror.NewError(CodeRetentionServicesUnknown, "the services of the cluster are unknown")
+	}
+
+	services, err := registry.CraneDockerClient.ListServiceSpec(types.ServiceListOptions{})
+	if err != nil {
+		return nil, cranerror.NewError(CodeRetentionServicesUnknown, err.Error())
+	}
+
+	var images []string
+	for _, service := range services {
+		images = append(images, service.Spec.TaskTemplate.ContainerSpec.Image)
+	}
+
+	return images, nil
+}
+
+// RunRetentionPolicy deletes the digests of the policy, dryRun only reports them
+func (registry *Registry) RunRetentionPolicy(policy *RetentionPolicy, dryRun bool) (*RetentionReport, error) {
+	if !dryRun {
+		if err := registry.checkWritable(); err != nil {
+			return nil, err
+		}
+	}
+
+	images, err := registry.serviceImages()
+	if err != nil {
+		return nil, err
+	}
+	references := serviceRepositoryReferences(images)
+
+	repositories := []string{policy.Image}
+	if policy.Image == "" {
+		repositories = nil
+		var modelImages []Image
+		if err := registry.DbClient.Where("namespace = ?", policy.Namespace).Find(&modelImages).Error; err != nil {
+			return nil, cranerror.NewError(CodeRetentionRunError, err.Error())
+		}
+		for _, modelImage := range modelImages {
+			repositories = append(repositories, modelImage.Image)
+		}
+	}
+
+	report := &RetentionReport{PolicyID: policy.ID, Namespace: policy.Namespace, DryRun: dryRun, Items: make([]RetentionItem, 0)}
+	now := time.Now()
+	for _, image := range repositories {
+		var tags []Tag
+		if err := registry.DbClient.Where("namespace = ? AND image = ?", policy.Namespace, image).Find(&tags).Error; err != nil {
+			return nil, cranerror.NewError(CodeRetentionRunError, err.Error())
+		}
+
+		untagged, err := registry.untaggedDigests(policy.Namespace, image, tags)
+		if err != nil {
+			return nil, err
+		}
+
+		repositoryPolicy := *policy
+		repositoryPolicy.Image = image
+		items, kept, err := planRetention(repositoryPolicy, tags, untagged, references[policy.Namespace+"/"+image], now)
+		if err != nil {
+			return nil, cranerror.NewError(CodeRetentionPolicyParamError, err.Error())
+		}
+		report.Kept += kept
+
+		for _, item := range items {
+			if !dryRun {
+				registry.deleteRetentionItem(&item)
+			}
+			if dryRun || item.Deleted {
+				report.Reclaimed += item.Size
+			}
+			report.Items = append(report.Items, item)
+		}
+	}
+
+	return report, nil
+}
+
+// the digests pushed to the repository no tag points to anymore, with the time of their last push
+func (registry *Registry) untaggedDigests(namespace, image string, tags []Tag) (map[string]time.Time, error) {
+	var pushes []ImageAccess
+	err := registry.DbClient.Where("namespace = ? AND image = ? AND action = 'push'", namespace, image).Find(&pushes).Error
+	if err != nil {
+		return nil, cranerror.NewError(CodeRetentionRunError, err.Error())
+	}
+
+	tagged := make(map[string]bool)
+	for _, tag := range tags {
+		tagged[tag.Digest] = true
+	}
+
+	untagged := make(map[string]time.Time)
+	for _, push := range pushes {
+		if push.Digest == "" || tagged[push.Digest] {
+			continue
+		}
+		if push.CreatedAt.After(untagged[push.Digest]) {
+			untagged[push.Digest] = push.CreatedAt
+		}
+	}
+
+	return untagged, nil
+}
+
+func (registry *Registry) deleteRetentionItem(item *RetentionItem) {
+	_, _, err := registry.RegistryAPIDeleteSchemaV2(fmt.Sprintf("%s/%s/manifests/%s", item.Namespace, item.Image, item.Digest), item.Namespace)
+	// an untagged digest may be gone already
+	if err != nil && !(item.Reason == RetentionReasonUntagged && strings.Contains(err.Error(), "MANIFEST_UNKNOWN")) {
+		log.Errorf("retention delete %s/%s@%s got error: %v", item.Namespace, item.Image, item.Digest, err)
+		item.Error = err.Error()
+		return
+	}
+	item.Deleted = true
+
+	registry.DbClient.Where("namespace = ? AND image = ? AND digest = ?", item.Namespace, item.Image, item.Digest).Delete(&Tag{})
+	if item.Reason == RetentionReasonUntagged {
+		// forget it or the next runs delete it again
+		registry.DbClient.Where("namespace = ? AND image = ? AND digest = ?", item.Namespace, item.Image, item.Digest).Delete(&ImageAccess{})
+	}
+
+	var left int64
+	registry.DbClient.Model(&Tag{}).Where("namespace = ? AND image = ?", item.Namespace, item.Image).Count(&left)
+	if left == 0 {
+		registry.DbClient.Where("namespace = ? AND image = ?", item.Namespace, item.Image).Delete(&Image{})
+		registry.DbClient.Where("namespace = ? AND image = ?", item.Namespace, item.Image).Delete(&ImageAccess{})
+	}
+}
+
+// run the enabled policies every interval until stop is closed
+func (registry *Registry) WatchRetention(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			var policies []RetentionPolicy
+			if err := registry.DbClient.Where("enabled = ?", true).Find(&policies).Error; err != nil {
+				log.Errorf("list retention policies got error: %v", err)
+				continue
+			}
+
+			for i := range policies {
+				registry.runScheduledRetention(&policies[i])
+			}
+		}
+	}
+}
+
+func (registry *Registry) runScheduledRetention(policy *RetentionPolicy) {
+	report, err := registry.RunRetentionPolicy(policy, false)
+
+	now := time.Now()
+	policy.LastRunAt = &now
+	policy.LastRunDeleted = 0
+	policy.LastRunError = ""
+	if err != nil {
+		log.Errorf("retention policy %d of %s got error: %v", policy.ID, policy.Namespace, err)
+		policy.LastRunError = err.Error()
+	} else {
+		for _, item := range report.Items {
+			if item.Deleted {
+				policy.LastRunDeleted++
+			} else if policy.LastRunError == "" {
+				policy.LastRunError = item.Error
+			}
+		}
+		log.Infof("retention policy %d of %s deleted %d digests", policy.ID, policy.Namespace, policy.LastRunDeleted)
+	}
+
+	registry.DbClient.Model(policy).Updates(map[string]interface{}{
+		"last_run_at":      policy.LastRunAt,
+		"last_run_deleted": policy.LastRunDeleted,
+		"last_run_error":   policy.LastRunError,
+	})
+}
+
+// the policy of the namespace of the account in ctx, false if the response is written already
+func (registry *Registry) accountRetentionPolicy(ctx *gin.Context) (*RetentionPolicy, bool) {
+	account, found := ctx.Get("account")
+	if !found {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryUnauthorized, "invalid user"))
+		return nil, false
+	}
+
+	id, err := strconv.ParseUint(ctx.Param("policy_id"), 10, 64)
+	if err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRetentionPolicyNotFound, "invalid policy id"))
+		return nil, false
+	}
+
+	var policy RetentionPolicy
+	namespace := registry.RegistryNamespaceForAccount(account.(auth.Account))
+	if err := registry.DbClient.Where("id = ? AND namespace = ?", id, namespace).Find(&policy).Error; err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRetentionPolicyNotFound, err.Error()))
+		return nil, false
+	}
+
+	return &policy, true
+}
+
+func (registry *Registry) ListRetentionPolicies(ctx *gin.Context) {
+	account, found := ctx.Get("account")
+	if !found {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryUnauthorized, "invalid user"))
+		return
+	}
+
+	var policies []RetentionPolicy
+	namespace := registry.RegistryNamespaceForAccount(account.(auth.Account))
+	if err := registry.DbClient.Where("namespace = ?", namespace).Order("id").Find(&policies).Error; err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRetentionPolicySaveError, err.Error()))
+		return
+	}
+
+	httpresponse.Ok(ctx, policies)
+}
+
+// create a policy of the namespace of the account
+func (registry *Registry) CreateRetentionPolicy(ctx *gin.Context) {
+	account, found := ctx.Get("account")
+	if !found {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryUnauthorized, "invalid user"))
+		return
+	}
+
+	var policy RetentionPolicy
+	if err := ctx.BindJSON(&policy); err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRetentionPolicyParamError, err.Error()))
+		return
+	}
+
+	namespace := registry.RegistryNamespaceForAccount(account.(auth.Account))
+	if namespace == "" {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryNamespaceNotFound, "no registry namespace for the account"))
+		return
+	}
+	policy.ID = 0
+	policy.Namespace = namespace
+	policy.LastRunAt = nil
+	policy.LastRunDeleted = 0
+	policy.LastRunError = ""
+
+	if err := policy.validate(); err != nil {
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	if err := registry.DbClient.Save(&policy).Error; err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRetentionPolicySaveError, err.Error()))
+		return
+	}
+
+	httpresponse.Ok(ctx, policy)
+}
+
+// replace the rules of a policy
+func (registry *Registry) UpdateRetentionPolicy(ctx *gin.Context) {
+	policy, ok := registry.accountRetentionPolicy(ctx)
+	if !ok {
+		return
+	}
+
+	var rules RetentionPolicy
+	if err := ctx.BindJSON(&rules); err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRetentionPolicyParamError, err.Error()))
+		return
+	}
+
+	policy.Image = rules.Image
+	policy.KeepLast = rules.KeepLast
+	policy.KeepPattern = rules.KeepPattern
+	policy.DeleteUntagged = rules.DeleteUntagged
+	policy.OlderThan = rules.OlderThan
+	policy.Enabled = rules.Enabled
+	if err := policy.validate(); err != nil {
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	if err := registry.DbClient.Save(policy).Error; err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRetentionPolicySaveError, err.Error()))
+		return
+	}
+
+	httpresponse.Ok(ctx, policy)
+}
+
+func (registry *Registry) DeleteRetentionPolicy(ctx *gin.Context) {
+	policy, ok := registry.accountRetentionPolicy(ctx)
+	if !ok {
+		return
+	}
+
+	if err := registry.DbClient.Delete(policy).Error; err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRetentionPolicySaveError, err.Error()))
+		return
+	}
+
+	httpresponse.Ok(ctx, "success")
+}
+
+// the digests the policy would delete now
+func (registry *Registry) RetentionPolicyReport(ctx *gin.Context) {
+	policy, ok := registry.accountRetentionPolicy(ctx)
+	if !ok {
+		return
+	}
+
+	report, err := registry.RunRetentionPolicy(policy, true)
+	if err != nil {
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	httpresponse.Ok(ctx, report)
+}
+
+// delete the digests of the policy now, query dry_run=true only reports them
+func (registry *Registry) RunRetention(ctx *gin.Context) {
+	policy, ok := registry.accountRetentionPolicy(ctx)
+	if !ok {
+		return
+	}
+
+	dryRun, err := strconv.ParseBool(ctx.DefaultQuery("dry_run", "false"))
+	if err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRetentionPolicyParamError, "invalid dry_run"))
+		return
+	}
+
+	report, err := registry.RunRetentionPolicy(policy, dryRun)
+	if err != nil {
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	httpresponse.Ok(ctx, report)
+}
@@ -0,0 +1,86 @@
+package registry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetentionPolicyValidate(t *testing.T) {
+	assert.Nil(t, (&RetentionPolicy{KeepLast: 5}).validate())
+	assert.Nil(t, (&RetentionPolicy{DeleteUntagged: true}).validate())
+	assert.NotNil(t, (&RetentionPolicy{}).validate())
+	assert.NotNil(t, (&RetentionPolicy{KeepLast: -1}).validate())
+	assert.NotNil(t, (&RetentionPolicy{OlderThan: 7, KeepPattern: "v(1"}).validate())
+}
+
+func TestServiceRepositoryReferences(t *testing.T) {
+	references := serviceRepositoryReferences([]string{
+		"registry.crane.io:5000/blog/web:v1@sha256:0123",
+		"localhost/blog/db",
+		"nginx:1.11",
+	})
+
+	assert.Len(t, references, 3)
+	assert.True(t, references["blog/web"].tags["v1"])
+	assert.True(t, references["blog/web"].digests["sha256:0123"])
+	assert.True(t, references["blog/db"].tags["latest"])
+	assert.True(t, references["library/nginx"].tags["1.11"])
+}
+
+func TestPlanRetention(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+	tag := func(id uint64, name, digest string, age time.Duration) Tag {
+		return Tag{ID: id, Namespace: "blog", Image: "web", Tag: name, Digest: digest, Size: 100, UpdatedAt: now.Add(-age)}
+	}
+	tags := []Tag{
+		tag(1, "v1", "sha256:1", 40*day),
+		tag(2, "v2", "sha256:2", 30*day),
+		tag(3, "stable", "sha256:2", 1*day),
+		tag(4, "v3", "sha256:3", 20*day),
+		tag(5, "release-1", "sha256:4", 50*day),
+		tag(6, "v4", "sha256:5", 10*day),
+		tag(7, "latest", "sha256:6", 0),
+		tag(8, "v5", "sha256:7", 60*day),
+	}
+	references := serviceRepositoryReferences([]string{"registry.crane.io:5000/blog/web@sha256:3", "blog/web:v5"})
+
+	// v2 shares its digest with the recent stable, v3 and v5 are used by services
+	policy := RetentionPolicy{Namespace: "blog", Image: "web", KeepLast: 2, KeepPattern: "^release-"}
+	items, kept, err := planRetention(policy, tags, nil, references["blog/web"], now)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, kept)
+	assert.Equal(t, []RetentionItem{
+		{Namespace: "blog", Image: "web", Digest: "sha256:5", Tags: []string{"v4"}, Size: 100, Reason: RetentionReasonKeepLast},
+		{Namespace: "blog", Image: "web", Digest: "sha256:1", Tags: []string{"v1"}, Size: 100, Reason: RetentionReasonKeepLast},
+	}, items)
+
+	policy = RetentionPolicy{Namespace: "blog", Image: "web", OlderThan: 25, DeleteUntagged: true}
+	untagged := map[string]time.Time{"sha256:old": now.Add(-30 * day), "sha256:new": now.Add(-time.Hour), "sha256:3": now.Add(-90 * day)}
+	items, kept, err = planRetention(policy, tags, untagged, references["blog/web"], now)
+	assert.Nil(t, err)
+	var planned []string
+	for _, item := range items {
+		planned = append(planned, item.Digest+" "+item.Reason)
+	}
+	assert.Equal(t, []string{
+		"sha256:1 " + RetentionReasonOlderThan,
+		"sha256:4 " + RetentionReasonOlderThan,
+		"sha256:old " + RetentionReasonUntagged,
+	}, planned)
+	assert.Equal(t, 6, kept)
+
+	policy = RetentionPolicy{KeepLast: 3, OlderThan: 35}
+	items, _, err = planRetention(policy, tags, nil, nil, now)
+	assert.Nil(t, err)
+	planned = nil
+	for _, item := range items {
+		planned = append(planned, item.Digest)
+	}
+	assert.Equal(t, []string{"sha256:1", "sha256:4", "sha256:7"}, planned)
+
+	_, _, err = planRetention(RetentionPolicy{KeepLast: 1, KeepPattern: "("}, tags, nil, nil, now)
+	assert.NotNil(t, err)
+}
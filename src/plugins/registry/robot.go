@@ -0,0 +1,75 @@
+package registry
+
+import (
+	"strings"
+
+	"github.com/Dataman-Cloud/crane/src/plugins/auth"
+	"github.com/Dataman-Cloud/crane/src/utils"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/distribution/registry/auth/token"
+)
+
+// the robot logging in with a token of its own, false if password is not one
+func (registry *Registry) verifyRobot(username, password string) (*auth.RobotAccount, []auth.RobotScope, bool) {
+	if registry.Robots == nil {
+		return nil, nil, false
+	}
+	if _, _, ok := auth.ParseRobotToken(password); !ok {
+		return nil, nil, false
+	}
+
+	robot, robotToken, err := registry.Robots.Verify(password)
+	if err != nil || robot.Name != username {
+		return nil, nil, false
+	}
+
+	return robot, robotToken.ParsedScopes(), true
+}
+
+// the permission of the robot on the repository, a namespace robot manages its namespace
+// and a group robot has the grants of its group
+func (registry *Registry) robotPermission(robot *auth.RobotAccount, namespace, image string) string {
+	permission := ""
+	if namespace == "library" {
+		permission = "R"
+	} else if robot.Namespace == namespace {
+		return "RWM"
+	} else {
+		var modelImage Image
+		if err := registry.DbClient.Where("namespace = ? AND image = ?", namespace, image).Find(&modelImage).Error; err == nil && modelImage.Publicity == 1 {
+			permission = "R"
+		}
+	}
+
+	if robot.GroupID != 0 {
+		var grants []RegistryGrant
+		if err := registry.DbClient.Where("namespace = ? AND group_id = ?", namespace, robot.GroupID).Find(&grants).Error; err == nil {
+			permission = mergePermissions(permission, grantsPermission(grants, namespace, image))
+		}
+	}
+
+	return permission
+}
+
+// keep the actions of a both the permission of the robot and the scopes of its token allow
+func (registry *Registry) filterRobotAccess(robot *auth.RobotAccount, scopes []auth.RobotScope, a *token.ResourceActions) {
+	requested := a.Actions
+	a.Actions = []string{}
+	if a.Type != "repository" || strings.Count(a.Name, "/") != 1 {
+		return
+	}
+
+	namespace := strings.Split(a.Name, "/")[0]
+	image := strings.Split(a.Name, "/")[1]
+	permission := registry.robotPermission(robot, namespace, image)
+	allowed := auth.RobotScopesActions(scopes, auth.RobotScopeRegistry, a.Name)
+	for _, action := range requested {
+		granted := (action == "pull" && strings.Contains(permission, "R")) ||
+			(action == "push" && strings.Contains(permission, "W"))
+		if granted && utils.StringInSlice(action, allowed) {
+			a.Actions = append(a.Actions, action)
+		}
+	}
+	log.Infof("robot: %s, access: %s, actions: %v", robot.Name, a.Name, a.Actions)
+}
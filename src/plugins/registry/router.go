@@ -1,6 +1,8 @@
 package registry
 
 import (
+	"time"
+
 	"github.com/Dataman-Cloud/crane/src/plugins/apiplugin"
 
 	log "github.com/Sirupsen/logrus"
@@ -10,9 +12,14 @@ import (
 	_ "github.com/mattes/migrate/driver/mysql"
 )
 
-func Init(accountAuthenticator, registryPrivateKeyPath, registryAddr, dbDriver, dbDsn string, dbClient *gorm.DB) {
+func Init(accountAuthenticator, registryPrivateKeyPath, registryAddr, dbDriver, dbDsn string, dbClient *gorm.DB, retentionInterval time.Duration,
+	gcNodeId, gcContainer string, gcTimeout time.Duration) {
 	log.Infof("begin to init and enable plugin: %s", apiplugin.Registry)
 	registryApi := NewRegistry(accountAuthenticator, registryPrivateKeyPath, registryAddr, dbDriver, dbDsn, dbClient)
+	registryApi.RetentionInterval = retentionInterval
+	registryApi.GCNodeID = gcNodeId
+	registryApi.GCContainer = gcContainer
+	registryApi.GCTimeout = gcTimeout
 
 	apiPlugin := &apiplugin.ApiPlugin{
 		Name:         apiplugin.Registry,
@@ -41,5 +48,45 @@ func (registry *Registry) ApiRegister(router *gin.Engine, middlewares ...gin.Han
 		registryV1Protected.GET("/manifests/:reference/:namespace/:image", registry.GetManifests)
 		registryV1Protected.PATCH("/:namespace/:image/publicity", registry.ImagePublicity)
 		registryV1Protected.DELETE("/manifests/:namespace/:image", registry.DeleteManifests)
+		registryV1Protected.GET("/builds", registry.ListImageBuilds)
+		registryV1Protected.GET("/builds/:build_id", registry.InspectImageBuild)
+		registryV1Protected.GET("/retention_policies", registry.ListRetentionPolicies)
+		registryV1Protected.POST("/retention_policies", registry.CreateRetentionPolicy)
+		registryV1Protected.PUT("/retention_policies/:policy_id", registry.UpdateRetentionPolicy)
+		registryV1Protected.DELETE("/retention_policies/:policy_id", registry.DeleteRetentionPolicy)
+		registryV1Protected.GET("/retention_policies/:policy_id/report", registry.RetentionPolicyReport)
+		registryV1Protected.POST("/retention_policies/:policy_id/run", registry.RunRetention)
+		registryV1Protected.GET("/storage", registry.NamespaceStorage)
+		registryV1Protected.GET("/quota", registry.NamespaceQuotaUsage)
+		registryV1Protected.GET("/grants", registry.ListGrants)
+		registryV1Protected.POST("/grants", registry.CreateGrant)
+		registryV1Protected.DELETE("/grants/:grant_id", registry.DeleteGrant)
+		registryV1Protected.GET("/accesses", registry.ListImageAccesses)
+		registryV1Protected.GET("/webhooks", registry.ListWebhooks)
+		registryV1Protected.POST("/webhooks", registry.CreateWebhook)
+		registryV1Protected.GET("/webhooks/:webhook_id", registry.InspectWebhook)
+		registryV1Protected.PUT("/webhooks/:webhook_id", registry.UpdateWebhook)
+		registryV1Protected.DELETE("/webhooks/:webhook_id", registry.DeleteWebhook)
+		registryV1Protected.GET("/webhooks/:webhook_id/deliveries", registry.ListWebhookDeliveries)
+		registryV1Protected.POST("/webhooks/:webhook_id/deliveries/:delivery_id/redeliver", registry.RedeliverWebhook)
+	}
+}
+
+// the maintenance and the quotas of the whole registry, middlewares restrict them to the admins
+func (registry *Registry) MaintenanceApiRegister(router *gin.Engine, middlewares ...gin.HandlerFunc) {
+	maintenance := router.Group("/registry/v1/maintenance", middlewares...)
+	{
+		maintenance.GET("", registry.InspectMaintenance)
+		maintenance.PUT("", registry.UpdateMaintenance)
+		maintenance.GET("/storage", registry.RegistryStorage)
+		maintenance.GET("/blobs", registry.ListUnreferencedBlobs)
+		maintenance.POST("/gc", registry.GarbageCollect)
+	}
+
+	quotas := router.Group("/registry/v1/quotas", middlewares...)
+	{
+		quotas.GET("", registry.ListQuotas)
+		quotas.PUT("/:namespace", registry.UpdateQuota)
+		quotas.DELETE("/:namespace", registry.DeleteQuota)
 	}
 }
@@ -13,7 +13,7 @@ import (
 func TestInit(t *testing.T) {
 	dbClient, err := db.NewDB("testdb", "")
 	assert.Nil(t, err)
-	Init("db", "", "", "testdb", "", dbClient)
+	Init("db", "", "", "testdb", "", dbClient, 0, "", "", 0)
 }
 
 func TestApiRegister(t *testing.T) {
@@ -33,6 +33,42 @@ func TestApiRegister(t *testing.T) {
 		"/registry/v1/manifests/:reference/:namespace/:image",
 		"/registry/v1/:namespace/:image/publicity",
 		"/registry/v1/manifests/:namespace/:image",
+		"/registry/v1/builds",
+		"/registry/v1/builds/:build_id",
+		"/registry/v1/retention_policies",
+		"/registry/v1/retention_policies/:policy_id",
+		"/registry/v1/retention_policies/:policy_id/report",
+		"/registry/v1/retention_policies/:policy_id/run",
+		"/registry/v1/storage",
+		"/registry/v1/quota",
+		"/registry/v1/grants",
+		"/registry/v1/grants/:grant_id",
+		"/registry/v1/accesses",
+		"/registry/v1/webhooks",
+		"/registry/v1/webhooks/:webhook_id",
+		"/registry/v1/webhooks/:webhook_id/deliveries",
+		"/registry/v1/webhooks/:webhook_id/deliveries/:delivery_id/redeliver",
+	}
+
+	for _, info := range router.Routes() {
+		assert.Contains(t, pathMix, info.Path)
+	}
+}
+
+func TestMaintenanceApiRegister(t *testing.T) {
+	registry := &Registry{}
+
+	router := gin.New()
+
+	registry.MaintenanceApiRegister(router)
+
+	pathMix := []string{
+		"/registry/v1/maintenance",
+		"/registry/v1/maintenance/storage",
+		"/registry/v1/maintenance/blobs",
+		"/registry/v1/maintenance/gc",
+		"/registry/v1/quotas",
+		"/registry/v1/quotas/:namespace",
 	}
 
 	for _, info := range router.Routes() {
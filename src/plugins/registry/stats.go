@@ -0,0 +1,203 @@
+package registry
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
+	"github.com/Dataman-Cloud/crane/src/utils/httpresponse"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// access log entries listed by default and at most
+	accessLogDefaultLimit = 100
+	accessLogMaxLimit     = 1000
+)
+
+// the layouts of the since and until of the access log query
+var accessLogTimeLayouts = []string{time.RFC3339, "2006-01-02"}
+
+// the pushes and pulls of a repository or a digest and the time of the last pull, nil if never pulled
+type accessStats struct {
+	PushCount    int64
+	PullCount    int64
+	LastPulledAt *time.Time
+}
+
+// the accesses of an action grouped by repository or digest
+type accessCount struct {
+	Namespace string
+	Image     string
+	Digest    string
+	Action    string
+	Count     int64
+	LastAt    *time.Time
+}
+
+func accessStatsKey(namespace, image, digest string) string {
+	return namespace + "/" + image + "@" + digest
+}
+
+func distinct(values []string) []string {
+	seen := make(map[string]bool)
+	var distinctValues []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			distinctValues = append(distinctValues, value)
+		}
+	}
+	return distinctValues
+}
+
+// the stats of the repositories in one grouped query, by digest if digests are given, keyed by accessStatsKey
+func (registry *Registry) queryAccessStats(namespaces, images, digests []string) map[string]*accessStats {
+	stats := make(map[string]*accessStats)
+	if len(namespaces) == 0 || len(images) == 0 {
+		return stats
+	}
+
+	columns := "namespace, image"
+	query := registry.DbClient.Table("image_accesses").Where("namespace IN (?) AND image IN (?)", namespaces, images)
+	if digests != nil {
+		columns += ", digest"
+		query = query.Where("digest IN (?)", digests)
+	}
+
+	var counts []accessCount
+	if err := query.Select(columns + ", action, COUNT(*) AS count, MAX(created_at) AS last_at").
+		Group(columns + ", action").Scan(&counts).Error; err != nil {
+		log.Errorf("query the access stats got error: %v", err)
+		return stats
+	}
+
+	for _, count := range counts {
+		key := accessStatsKey(count.Namespace, count.Image, count.Digest)
+		if stats[key] == nil {
+			stats[key] = &accessStats{}
+		}
+		switch count.Action {
+		case "push":
+			stats[key].PushCount = count.Count
+		case "pull":
+			stats[key].PullCount = count.Count
+			stats[key].LastPulledAt = count.LastAt
+		}
+	}
+	return stats
+}
+
+func (registry *Registry) fillImageStats(images []*Image) {
+	var namespaces, names []string
+	for _, image := range images {
+		namespaces = append(namespaces, image.Namespace)
+		names = append(names, image.Image)
+	}
+
+	stats := registry.queryAccessStats(distinct(namespaces), distinct(names), nil)
+	for _, image := range images {
+		if stat, ok := stats[accessStatsKey(image.Namespace, image.Image, "")]; ok {
+			image.PushCount, image.PullCount, image.LastPulledAt = stat.PushCount, stat.PullCount, stat.LastPulledAt
+		}
+	}
+}
+
+// the accesses of a tag are the ones of the digest it points to
+func (registry *Registry) fillTagStats(tags []*Tag) {
+	var namespaces, names, digests []string
+	for _, tag := range tags {
+		namespaces = append(namespaces, tag.Namespace)
+		names = append(names, tag.Image)
+		digests = append(digests, tag.Digest)
+	}
+
+	stats := registry.queryAccessStats(distinct(namespaces), distinct(names), distinct(digests))
+	for _, tag := range tags {
+		if stat, ok := stats[accessStatsKey(tag.Namespace, tag.Image, tag.Digest)]; ok {
+			tag.PushCount, tag.PullCount, tag.LastPulledAt = stat.PushCount, stat.PullCount, stat.LastPulledAt
+		}
+	}
+}
+
+func parseAccessLogTime(value string) (time.Time, error) {
+	var err error
+	for _, layout := range accessLogTimeLayouts {
+		var t time.Time
+		if t, err = time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// the query of the access log of the namespace filtered by image, account, action and date range
+func (registry *Registry) accessLogQuery(ctx *gin.Context, namespace string) (*gorm.DB, error) {
+	query := registry.DbClient.Where("namespace = ?", namespace)
+	if image := ctx.Query("image"); image != "" {
+		query = query.Where("image = ?", image)
+	}
+	if account := ctx.Query("account"); account != "" {
+		query = query.Where("account_email = ?", account)
+	}
+	if action := ctx.Query("action"); action != "" {
+		if action != "push" && action != "pull" {
+			return nil, cranerror.NewError(CodeRegistryAccessLogParamError, "action must be push or pull")
+		}
+		query = query.Where("action = ?", action)
+	}
+	if since := ctx.Query("since"); since != "" {
+		t, err := parseAccessLogTime(since)
+		if err != nil {
+			return nil, cranerror.NewError(CodeRegistryAccessLogParamError, "invalid since "+since)
+		}
+		query = query.Where("created_at >= ?", t)
+	}
+	if until := ctx.Query("until"); until != "" {
+		t, err := parseAccessLogTime(until)
+		if err != nil {
+			return nil, cranerror.NewError(CodeRegistryAccessLogParamError, "invalid until "+until)
+		}
+		// a day until includes the whole day
+		if len(until) == len("2006-01-02") {
+			t = t.AddDate(0, 0, 1)
+		}
+		query = query.Where("created_at < ?", t)
+	}
+
+	limit := accessLogDefaultLimit
+	if value := ctx.Query("limit"); value != "" {
+		l, err := strconv.Atoi(value)
+		if err != nil || l <= 0 || l > accessLogMaxLimit {
+			return nil, cranerror.NewError(CodeRegistryAccessLogParamError, "limit must be between 1 and "+strconv.Itoa(accessLogMaxLimit))
+		}
+		limit = l
+	}
+
+	return query.Order("created_at DESC").Limit(limit), nil
+}
+
+// the pushes and pulls of a namespace, query namespace defaults to the one of the account
+func (registry *Registry) ListImageAccesses(ctx *gin.Context) {
+	namespace, ok := registry.grantNamespace(ctx, ctx.Query("namespace"))
+	if !ok {
+		return
+	}
+
+	query, err := registry.accessLogQuery(ctx, namespace)
+	if err != nil {
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	accesses := make([]ImageAccess, 0)
+	if err := query.Find(&accesses).Error; err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryAccessLogError, err.Error()))
+		return
+	}
+
+	httpresponse.Ok(ctx, accesses)
+}
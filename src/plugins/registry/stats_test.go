@@ -0,0 +1,170 @@
+package registry
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dataman-Cloud/crane/src/utils/db"
+
+	"github.com/erikstmartin/go-testdb"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAccessLogTime(t *testing.T) {
+	day, err := parseAccessLogTime("2016-11-02")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2016, 11, 2, 0, 0, 0, 0, time.Local), day)
+
+	instant, err := parseAccessLogTime("2016-11-02T10:00:00Z")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2016, 11, 2, 10, 0, 0, 0, time.UTC).Unix(), instant.Unix())
+
+	_, err = parseAccessLogTime("yesterday")
+	assert.NotNil(t, err)
+}
+
+func TestAccessLogQuery(t *testing.T) {
+	dbClient, _ := db.NewDB("testdb", "")
+	registry := &Registry{DbClient: dbClient}
+
+	for _, query := range []string{
+		"action=delete",
+		"since=yesterday",
+		"until=2016-13-01",
+		"limit=0",
+		"limit=1001",
+	} {
+		req, _ := http.NewRequest("GET", "/registry/v1/accesses?"+query, nil)
+		_, err := registry.accessLogQuery(&gin.Context{Request: req}, "shop")
+		assert.NotNil(t, err, query)
+	}
+
+	req, _ := http.NewRequest("GET", "/registry/v1/accesses?image=web&account=robot:ci&action=pull&since=2016-11-01&until=2016-11-02&limit=10", nil)
+	_, err := registry.accessLogQuery(&gin.Context{Request: req}, "shop")
+	assert.Nil(t, err)
+}
+
+// stubs the grouped accesses of shop/web: 3 pushes, 5 pulls, the last at lastPull,
+// and of its digest sha256:1: 1 push and no pull, returns the queries run
+func stubAccessStats(lastPull time.Time) *[]string {
+	var queries []string
+	testdb.SetQueryWithArgsFunc(func(query string, args []driver.Value) (driver.Rows, error) {
+		queries = append(queries, query)
+		if strings.Contains(query, "digest") {
+			return testdb.RowsFromSlice([]string{"namespace", "image", "digest", "action", "count", "last_at"}, [][]driver.Value{
+				{"shop", "web", "sha256:1", "push", int64(1), lastPull},
+			}), nil
+		}
+		return testdb.RowsFromSlice([]string{"namespace", "image", "action", "count", "last_at"}, [][]driver.Value{
+			{"shop", "web", "push", int64(3), lastPull.Add(-time.Hour)},
+			{"shop", "web", "pull", int64(5), lastPull},
+		}), nil
+	})
+	return &queries
+}
+
+func TestFillImageStats(t *testing.T) {
+	lastPull := time.Date(2016, 11, 2, 10, 0, 0, 0, time.UTC)
+	queries := stubAccessStats(lastPull)
+	defer testdb.SetQueryWithArgsFunc(nil)
+
+	dbClient, _ := db.NewDB("testdb", "")
+	registry := &Registry{DbClient: dbClient}
+	images := []*Image{{Namespace: "shop", Image: "web"}, {Namespace: "shop", Image: "api"}}
+	registry.fillImageStats(images)
+
+	// one query for all the repositories
+	if assert.Len(t, *queries, 1) {
+		assert.Contains(t, (*queries)[0], "GROUP BY namespace, image, action")
+		assert.Contains(t, (*queries)[0], "MAX(created_at)")
+	}
+
+	assert.Equal(t, int64(3), images[0].PushCount)
+	assert.Equal(t, int64(5), images[0].PullCount)
+	if assert.NotNil(t, images[0].LastPulledAt) {
+		assert.Equal(t, lastPull.Unix(), images[0].LastPulledAt.Unix())
+	}
+
+	// never accessed
+	assert.Equal(t, int64(0), images[1].PushCount)
+	assert.Equal(t, int64(0), images[1].PullCount)
+	assert.Nil(t, images[1].LastPulledAt)
+
+	*queries = nil
+	registry.fillImageStats(nil)
+	assert.Empty(t, *queries)
+}
+
+func TestFillTagStats(t *testing.T) {
+	queries := stubAccessStats(time.Now())
+	defer testdb.SetQueryWithArgsFunc(nil)
+
+	dbClient, _ := db.NewDB("testdb", "")
+	registry := &Registry{DbClient: dbClient}
+	tags := []*Tag{
+		{Namespace: "shop", Image: "web", Tag: "v1", Digest: "sha256:1"},
+		{Namespace: "shop", Image: "web", Tag: "latest", Digest: "sha256:1"},
+		{Namespace: "shop", Image: "web", Tag: "v0", Digest: "sha256:0"},
+	}
+	registry.fillTagStats(tags)
+
+	if assert.Len(t, *queries, 1) {
+		assert.Contains(t, (*queries)[0], "GROUP BY namespace, image, digest, action")
+	}
+
+	for _, tag := range tags[:2] {
+		assert.Equal(t, int64(1), tag.PushCount)
+		assert.Equal(t, int64(0), tag.PullCount)
+		assert.Nil(t, tag.LastPulledAt)
+	}
+	assert.Equal(t, int64(0), tags[2].PushCount)
+}
+
+func TestDistinct(t *testing.T) {
+	assert.Equal(t, []string{"web", "api"}, distinct([]string{"web", "api", "web"}))
+	assert.Nil(t, distinct(nil))
+}
+
+func TestAccessLogQueryFilters(t *testing.T) {
+	var queries []string
+	testdb.SetQueryWithArgsFunc(func(query string, args []driver.Value) (driver.Rows, error) {
+		queries = append(queries, fmt.Sprint(query, args))
+		return testdb.RowsFromSlice([]string{"id"}, nil), nil
+	})
+	defer testdb.SetQueryWithArgsFunc(nil)
+
+	dbClient, _ := db.NewDB("testdb", "")
+	registry := &Registry{DbClient: dbClient}
+
+	req, _ := http.NewRequest("GET", "/registry/v1/accesses?image=web&account=robot:ci&action=pull&since=2016-11-01&until=2016-11-02&limit=10", nil)
+	query, err := registry.accessLogQuery(&gin.Context{Request: req}, "shop")
+	assert.Nil(t, err)
+	var accesses []ImageAccess
+	assert.Nil(t, query.Find(&accesses).Error)
+
+	assert.Len(t, queries, 1)
+	for _, filter := range []string{"namespace = ?", "image = ?", "account_email = ?", "action = ?", "created_at >= ?", "created_at < ?",
+		"ORDER BY created_at DESC", "LIMIT 10", "shop", "web", "robot:ci", "pull"} {
+		assert.Contains(t, queries[0], filter)
+	}
+	// the day of until is included
+	assert.Contains(t, queries[0], time.Date(2016, 11, 1, 0, 0, 0, 0, time.Local).String())
+	assert.Contains(t, queries[0], time.Date(2016, 11, 3, 0, 0, 0, 0, time.Local).String())
+
+	queries = nil
+	req, _ = http.NewRequest("GET", "/registry/v1/accesses", nil)
+	query, err = registry.accessLogQuery(&gin.Context{Request: req}, "shop")
+	assert.Nil(t, err)
+	assert.Nil(t, query.Find(&accesses).Error)
+
+	assert.Len(t, queries, 1)
+	assert.Contains(t, queries[0], fmt.Sprintf("LIMIT %d", accessLogDefaultLimit))
+	for _, filter := range []string{"image = ?", "account_email", "action = ?", "created_at >="} {
+		assert.NotContains(t, queries[0], filter)
+	}
+}
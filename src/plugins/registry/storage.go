@@ -0,0 +1,312 @@
+package registry
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/Dataman-Cloud/crane/src/plugins/auth"
+	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
+	"github.com/Dataman-Cloud/crane/src/utils/httpresponse"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+)
+
+// ManifestBlob is a layer or the config of a manifest pushed to a repository,
+// kept after the manifest is deleted until the registry garbage collection removes the blob
+type ManifestBlob struct {
+	ID uint64
+
+	Namespace string `json:"Namespace" gorm:"not null"`
+	Image     string `json:"Image" gorm:"not null"`
+	Manifest  string `json:"Manifest" gorm:"not null"`
+	Digest    string `json:"Digest" gorm:"not null"`
+	Size      uint64 `json:"Size"`
+}
+
+type RepositoryUsage struct {
+	Image string `json:"Image"`
+	Tags  int    `json:"Tags"`
+	// the size of a digest tagged several times is counted once
+	Digests int    `json:"Digests"`
+	Size    uint64 `json:"Size"`
+}
+
+type NamespaceUsage struct {
+	Namespace    string            `json:"Namespace"`
+	Repositories []RepositoryUsage `json:"Repositories"`
+	Size         uint64            `json:"Size"`
+	// the blobs of the tagged manifests of the namespace, a layer shared by several images counted once,
+	// the manifests whose blobs are not recorded count their whole size
+	LayerSize uint64 `json:"LayerSize"`
+}
+
+// UnreferencedBlob is a blob no tagged manifest of any repository uses anymore
+type UnreferencedBlob struct {
+	Digest string `json:"Digest"`
+	Size   uint64 `json:"Size"`
+	// the repositories of the manifests it was pushed with
+	Repositories []string `json:"Repositories"`
+}
+
+type BlobReport struct {
+	Blobs []UnreferencedBlob `json:"Blobs"`
+	Size  uint64             `json:"Size"`
+}
+
+type repositoryKey struct {
+	namespace string
+	image     string
+}
+
+type manifestKey struct {
+	repositoryKey
+	digest string
+}
+
+// record the blobs of a manifest of the repository, once
+func (registry *Registry) saveManifestBlobs(namespace, image, manifest string, v2response *V2RegistryResponse) {
+	var count int64
+	registry.DbClient.Model(&ManifestBlob{}).Where("namespace = ? AND image = ? AND manifest = ?", namespace, image, manifest).Count(&count)
+	if count > 0 {
+		return
+	}
+
+	blobs := []V2RegistryResponseLayer{{Digest: v2response.Config.Digest, Size: v2response.Config.Size}}
+	blobs = append(blobs, v2response.Layers...)
+	for _, blob := range blobs {
+		if blob.Digest == "" {
+			continue
+		}
+		registry.DbClient.Save(&ManifestBlob{Namespace: namespace, Image: image, Manifest: manifest, Digest: blob.Digest, Size: blob.Size})
+	}
+}
+
+// record the blobs of the tagged manifests pushed before the blobs were recorded
+func (registry *Registry) syncManifestBlobs() error {
+	var tags []Tag
+	if err := registry.DbClient.Find(&tags).Error; err != nil {
+		return err
+	}
+
+	var blobs []ManifestBlob
+	if err := registry.DbClient.Find(&blobs).Error; err != nil {
+		return err
+	}
+
+	recorded := make(map[manifestKey]bool)
+	for _, blob := range blobs {
+		recorded[manifestKey{repositoryKey{blob.Namespace, blob.Image}, blob.Manifest}] = true
+	}
+
+	for _, tag := range tags {
+		key := manifestKey{repositoryKey{tag.Namespace, tag.Image}, tag.Digest}
+		if tag.Digest == "" || recorded[key] {
+			continue
+		}
+		recorded[key] = true
+
+		v2response, _, err := registry.manifestOfReference(fmt.Sprintf("%s/%s/manifests/%s", tag.Namespace, tag.Image, tag.Digest), tag.Namespace)
+		if err != nil {
+			log.Warnf("get manifest %s/%s@%s got error: %v", tag.Namespace, tag.Image, tag.Digest, err)
+			continue
+		}
+		registry.saveManifestBlobs(tag.Namespace, tag.Image, tag.Digest, v2response)
+	}
+
+	return nil
+}
+
+// the usage of the namespaces from the sizes of their tags, of every namespace if namespace is empty
+func (registry *Registry) StorageUsage(namespace string) ([]NamespaceUsage, error) {
+	var tags []Tag
+	var blobs []ManifestBlob
+	if namespace == "" {
+		if err := registry.DbClient.Find(&tags).Error; err != nil {
+			return nil, err
+		}
+		if err := registry.DbClient.Find(&blobs).Error; err != nil {
+			return nil, err
+		}
+	} else {
+		if err := registry.DbClient.Where("namespace = ?", namespace).Find(&tags).Error; err != nil {
+			return nil, err
+		}
+		if err := registry.DbClient.Where("namespace = ?", namespace).Find(&blobs).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return storageUsage(tags, blobs), nil
+}
+
+func storageUsage(tags []Tag, blobs []ManifestBlob) []NamespaceUsage {
+	repositories := make(map[repositoryKey]*RepositoryUsage)
+	tagged := make(map[manifestKey]uint64)
+	for _, tag := range tags {
+		key := repositoryKey{tag.Namespace, tag.Image}
+		usage, ok := repositories[key]
+		if !ok {
+			usage = &RepositoryUsage{Image: tag.Image}
+			repositories[key] = usage
+		}
+		usage.Tags++
+
+		manifest := manifestKey{key, tag.Digest}
+		if _, ok := tagged[manifest]; ok && tag.Digest != "" {
+			continue
+		}
+		tagged[manifest] += tag.Size
+		usage.Digests++
+		usage.Size += tag.Size
+	}
+
+	namespaces := make(map[string]*NamespaceUsage)
+	for key, repository := range repositories {
+		usage, ok := namespaces[key.namespace]
+		if !ok {
+			usage = &NamespaceUsage{Namespace: key.namespace, Repositories: make([]RepositoryUsage, 0)}
+			namespaces[key.namespace] = usage
+		}
+		usage.Repositories = append(usage.Repositories, *repository)
+		usage.Size += repository.Size
+	}
+
+	layers := make(map[string]map[string]bool)
+	recorded := make(map[manifestKey]bool)
+	for _, blob := range blobs {
+		manifest := manifestKey{repositoryKey{blob.Namespace, blob.Image}, blob.Manifest}
+		usage, ok := namespaces[blob.Namespace]
+		if _, isTagged := tagged[manifest]; !ok || !isTagged {
+			continue
+		}
+		recorded[manifest] = true
+		if layers[blob.Namespace] == nil {
+			layers[blob.Namespace] = make(map[string]bool)
+		}
+		if !layers[blob.Namespace][blob.Digest] {
+			layers[blob.Namespace][blob.Digest] = true
+			usage.LayerSize += blob.Size
+		}
+	}
+	for manifest, size := range tagged {
+		if !recorded[manifest] {
+			namespaces[manifest.namespace].LayerSize += size
+		}
+	}
+
+	usages := make([]NamespaceUsage, 0)
+	for _, usage := range namespaces {
+		sort.Slice(usage.Repositories, func(i, j int) bool { return usage.Repositories[i].Image < usage.Repositories[j].Image })
+		usages = append(usages, *usage)
+	}
+	sort.Slice(usages, func(i, j int) bool { return usages[i].Namespace < usages[j].Namespace })
+
+	return usages
+}
+
+// the blobs recorded no tagged manifest uses, they are removed by the next registry garbage collection
+func (registry *Registry) UnreferencedBlobs() (*BlobReport, error) {
+	var tags []Tag
+	if err := registry.DbClient.Find(&tags).Error; err != nil {
+		return nil, err
+	}
+
+	var blobs []ManifestBlob
+	if err := registry.DbClient.Find(&blobs).Error; err != nil {
+		return nil, err
+	}
+
+	return unreferencedBlobs(tags, blobs), nil
+}
+
+func unreferencedBlobs(tags []Tag, blobs []ManifestBlob) *BlobReport {
+	tagged := make(map[manifestKey]bool)
+	for _, tag := range tags {
+		tagged[manifestKey{repositoryKey{tag.Namespace, tag.Image}, tag.Digest}] = true
+	}
+
+	// blobs are shared by the repositories of the registry
+	referenced := make(map[string]bool)
+	for _, blob := range blobs {
+		if tagged[manifestKey{repositoryKey{blob.Namespace, blob.Image}, blob.Manifest}] {
+			referenced[blob.Digest] = true
+		}
+	}
+
+	index := make(map[string]*UnreferencedBlob)
+	var digests []string
+	for _, blob := range blobs {
+		if referenced[blob.Digest] {
+			continue
+		}
+
+		unreferenced, ok := index[blob.Digest]
+		if !ok {
+			unreferenced = &UnreferencedBlob{Digest: blob.Digest, Size: blob.Size}
+			index[blob.Digest] = unreferenced
+			digests = append(digests, blob.Digest)
+		}
+		repository := blob.Namespace + "/" + blob.Image
+		if len(unreferenced.Repositories) == 0 || unreferenced.Repositories[len(unreferenced.Repositories)-1] != repository {
+			unreferenced.Repositories = append(unreferenced.Repositories, repository)
+		}
+	}
+	sort.Strings(digests)
+
+	report := &BlobReport{Blobs: make([]UnreferencedBlob, 0)}
+	for _, digest := range digests {
+		report.Blobs = append(report.Blobs, *index[digest])
+		report.Size += index[digest].Size
+	}
+
+	return report
+}
+
+// the storage used by the namespace of the account
+func (registry *Registry) NamespaceStorage(ctx *gin.Context) {
+	account, found := ctx.Get("account")
+	if !found {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryUnauthorized, "invalid user"))
+		return
+	}
+
+	namespace := registry.RegistryNamespaceForAccount(account.(auth.Account))
+	if namespace == "" {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryNamespaceNotFound, "no registry namespace for the account"))
+		return
+	}
+
+	usages, err := registry.StorageUsage(namespace)
+	if err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryStorageError, err.Error()))
+		return
+	}
+
+	usage := NamespaceUsage{Namespace: namespace, Repositories: make([]RepositoryUsage, 0)}
+	if len(usages) > 0 {
+		usage = usages[0]
+	}
+	httpresponse.Ok(ctx, usage)
+}
+
+// the storage used by every namespace
+func (registry *Registry) RegistryStorage(ctx *gin.Context) {
+	usages, err := registry.StorageUsage("")
+	if err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryStorageError, err.Error()))
+		return
+	}
+
+	httpresponse.Ok(ctx, usages)
+}
+
+func (registry *Registry) ListUnreferencedBlobs(ctx *gin.Context) {
+	report, err := registry.UnreferencedBlobs()
+	if err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeRegistryStorageError, err.Error()))
+		return
+	}
+
+	httpresponse.Ok(ctx, report)
+}
@@ -0,0 +1,59 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStorageUsage(t *testing.T) {
+	tags := []Tag{
+		{Namespace: "blog", Image: "web", Tag: "v1", Digest: "sha256:1", Size: 100},
+		{Namespace: "blog", Image: "web", Tag: "latest", Digest: "sha256:1", Size: 100},
+		{Namespace: "blog", Image: "web", Tag: "v2", Digest: "sha256:2", Size: 150},
+		{Namespace: "blog", Image: "db", Tag: "v1", Digest: "sha256:3", Size: 70},
+		{Namespace: "shop", Image: "web", Tag: "v1", Digest: "sha256:4", Size: 30},
+	}
+	blobs := []ManifestBlob{
+		{Namespace: "blog", Image: "web", Manifest: "sha256:1", Digest: "sha256:base", Size: 60},
+		{Namespace: "blog", Image: "web", Manifest: "sha256:1", Digest: "sha256:a", Size: 40},
+		{Namespace: "blog", Image: "web", Manifest: "sha256:2", Digest: "sha256:base", Size: 60},
+		{Namespace: "blog", Image: "web", Manifest: "sha256:2", Digest: "sha256:b", Size: 90},
+		{Namespace: "blog", Image: "db", Manifest: "sha256:3", Digest: "sha256:base", Size: 60},
+		{Namespace: "blog", Image: "db", Manifest: "sha256:3", Digest: "sha256:c", Size: 10},
+		{Namespace: "blog", Image: "web", Manifest: "sha256:deleted", Digest: "sha256:d", Size: 500},
+	}
+
+	usages := storageUsage(tags, blobs)
+	assert.Len(t, usages, 2)
+	assert.Equal(t, "blog", usages[0].Namespace)
+	assert.Equal(t, uint64(320), usages[0].Size)
+	assert.Equal(t, uint64(60+40+90+10), usages[0].LayerSize)
+	assert.Equal(t, []RepositoryUsage{
+		{Image: "db", Tags: 1, Digests: 1, Size: 70},
+		{Image: "web", Tags: 3, Digests: 2, Size: 250},
+	}, usages[0].Repositories)
+	assert.Equal(t, uint64(30), usages[1].Size)
+	// no blob recorded for the manifest of shop
+	assert.Equal(t, uint64(30), usages[1].LayerSize)
+}
+
+func TestUnreferencedBlobs(t *testing.T) {
+	tags := []Tag{
+		{Namespace: "blog", Image: "web", Tag: "v2", Digest: "sha256:2"},
+	}
+	blobs := []ManifestBlob{
+		{Namespace: "blog", Image: "web", Manifest: "sha256:1", Digest: "sha256:base", Size: 60},
+		{Namespace: "blog", Image: "web", Manifest: "sha256:1", Digest: "sha256:a", Size: 40},
+		{Namespace: "blog", Image: "web", Manifest: "sha256:2", Digest: "sha256:base", Size: 60},
+		{Namespace: "shop", Image: "web", Manifest: "sha256:3", Digest: "sha256:a", Size: 40},
+		{Namespace: "shop", Image: "web", Manifest: "sha256:3", Digest: "sha256:c", Size: 5},
+	}
+
+	report := unreferencedBlobs(tags, blobs)
+	assert.Equal(t, []UnreferencedBlob{
+		{Digest: "sha256:a", Size: 40, Repositories: []string{"blog/web", "shop/web"}},
+		{Digest: "sha256:c", Size: 5, Repositories: []string{"shop/web"}},
+	}, report.Blobs)
+	assert.Equal(t, uint64(45), report.Size)
+}
@@ -10,6 +10,9 @@ import (
 	"time"
 
 	"github.com/Dataman-Cloud/crane/src/plugins/auth"
+	"github.com/Dataman-Cloud/crane/src/utils"
+	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
+
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/distribution/registry/auth/token"
 	"github.com/docker/libtrust"
@@ -18,6 +21,10 @@ import (
 const (
 	issuer     = "dataman-inc"
 	expiration = 5 //minute
+
+	// the credentials issued to the docker daemons are tokens of this audience, used as the password of the token request
+	credentialAudience   = "crane-credential"
+	credentialExpiration = 30 //minute
 )
 
 // GetResourceActions ...
@@ -53,7 +60,12 @@ func (registry *Registry) FilterAccess(username string, authenticated bool, a *t
 			image := strings.Split(a.Name, "/")[1]
 
 			log.Infof("username: %s, namespace: %s, image: %s", username, namespace, image)
-			permission := registry.GetPermission(username, namespace, image)
+			// the username of a failed login is nobody
+			principal := username
+			if !authenticated {
+				principal = ""
+			}
+			permission := registry.GetPermission(principal, namespace, image)
 			if strings.Contains(permission, "W") {
 				a.Actions = append(a.Actions, "push")
 			}
@@ -63,6 +75,7 @@ func (registry *Registry) FilterAccess(username string, authenticated bool, a *t
 			if strings.Contains(permission, "R") {
 				a.Actions = append(a.Actions, "pull")
 			}
+			registry.enforceQuota(a)
 		}
 	}
 	fmt.Printf("current access, type: %s, name:%s, actions:%v \n", a.Type, a.Name, a.Actions)
@@ -152,39 +165,114 @@ func base64UrlEncode(b []byte) string {
 	return strings.TrimRight(base64.URLEncoding.EncodeToString(b), "=")
 }
 
+// the permission of the principal on the repository, the letters of read, write and manage,
+// the owner of a namespace manages it and the grants of its groups extend the permission
 func (registry *Registry) GetPermission(principal, namespace, image string) string {
-	if namespace == "library" && len(registry.Authenticator.GetDefaultAccounts()) > 0 {
+	if namespace == "library" && registry.Authenticator != nil && len(registry.Authenticator.GetDefaultAccounts()) > 0 {
 		return "RW"
 	}
 
+	permission := ""
 	if namespace == "library" {
-		return "R"
+		permission = "R"
+	} else if registry.principalNamespace(principal) == namespace {
+		// for user access himself's repository
+		return "RWM"
+	} else {
+		var modelImage Image
+		if err := registry.DbClient.Where("namespace = ? AND image = ?", namespace, image).Find(&modelImage).Error; err == nil && modelImage.Publicity == 1 {
+			// for public repository
+			permission = "R"
+		}
 	}
 
-	// for user access himself's repository
-	var namespaceEmail NamespaceEmail
-	if err := registry.DbClient.Where("namespace = ?", principal).Find(&namespaceEmail).Error; err != nil {
-		return ""
+	return mergePermissions(permission, registry.grantedPermission(principal, namespace, image))
+}
+
+func (registry *Registry) GenTokenForUI(username, service, scope string) (string, error) {
+	access := registry.ParseResourceActions(scope)
+	for _, a := range access {
+		registry.FilterAccess(username, true, a)
 	}
+	registry.restrictWrites(access)
+	return registry.MakeToken(registry.PrivateKeyPath, username, service, access)
+}
 
-	var modelImage Image
-	if err := registry.DbClient.Where("namespace = ? AND image = ?", namespace, image).Find(&modelImage).Error; err != nil {
-		return ""
+// PushCredential issues the short lived password a docker daemon pushes the image of the namespace of the account with,
+// the namespace is the username going with it
+func (registry *Registry) PushCredential(a auth.Account, image string) (string, string, error) {
+	namespace := registry.RegistryNamespaceForAccount(a)
+	if namespace == "" {
+		return "", "", cranerror.NewError(CodeRegistryNamespaceNotFound, "no registry namespace for account "+a.Email)
 	}
 
-	if modelImage.Publicity == 1 { // for public repository
-		return "R"
+	password, err := registry.IssueCredential(namespace, []*token.ResourceActions{
+		{Type: "repository", Name: namespace + "/" + image, Actions: []string{"push", "pull"}},
+	})
+	if err != nil {
+		log.Errorf("issue registry credential of %s got error: %v", namespace, err)
+		return "", "", cranerror.NewError(CodeRegistryMakeTokenFailed, err.Error())
 	}
 
-	return "RWM"
+	return namespace, password, nil
 }
 
-func (registry *Registry) GenTokenForUI(username, service, scope string) (string, error) {
-	access := registry.ParseResourceActions(scope)
-	for _, a := range access {
-		registry.FilterAccess(username, true, a)
+// IssueCredential makes a credential of the principal,
+// the token requests authenticated with it are granted the actions of access at most
+func (registry *Registry) IssueCredential(principal string, access []*token.ResourceActions) (string, error) {
+	pk, err := libtrust.LoadKeyFile(registry.PrivateKeyPath)
+	if err != nil {
+		return "", err
+	}
+	tk, err := makeTokenCore(issuer, principal, credentialAudience, credentialExpiration, access, pk)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s.%s", tk.Raw, base64UrlEncode(tk.Signature)), nil
+}
+
+// the actions granted by the credential of the principal, false if password is not a valid one
+func (registry *Registry) verifyCredential(principal, password string) ([]*token.ResourceActions, bool) {
+	if strings.Count(password, ".") != 2 {
+		return nil, false
+	}
+
+	tk, err := token.NewToken(password)
+	if err != nil || tk.Claims.Audience != credentialAudience || tk.Claims.Subject != principal {
+		return nil, false
+	}
+
+	now := time.Now().Unix()
+	if tk.Claims.Issuer != issuer || now < tk.Claims.NotBefore || now > tk.Claims.Expiration {
+		return nil, false
+	}
+
+	pk, err := libtrust.LoadKeyFile(registry.PrivateKeyPath)
+	if err != nil {
+		log.Errorf("load registry private key got error: %v", err)
+		return nil, false
+	}
+	if tk.Header.KeyID != pk.KeyID() || pk.PublicKey().Verify(strings.NewReader(tk.Raw), tk.Header.SigningAlg, tk.Signature) != nil {
+		return nil, false
+	}
+
+	return tk.Claims.Access, true
+}
+
+// keep the actions of a granted to the same resource
+func grantAccess(a *token.ResourceActions, granted []*token.ResourceActions) {
+	requested := a.Actions
+	a.Actions = []string{}
+	for _, g := range granted {
+		if g.Type != a.Type || g.Name != a.Name {
+			continue
+		}
+		for _, action := range requested {
+			if utils.StringInSlice(action, g.Actions) {
+				a.Actions = append(a.Actions, action)
+			}
+		}
 	}
-	return registry.MakeToken(registry.PrivateKeyPath, username, service, access)
 }
 
 func (registry *Registry) RegistryNamespaceForAccount(a auth.Account) string {
@@ -173,3 +173,48 @@ func TestRegistryNamespaceForEmailError(t *testing.T) {
 	pre := regi.registryNamespaceForEmail("nobody@nobody.com")
 	assert.Equal(t, "", pre)
 }
+
+func TestPushCredential(t *testing.T) {
+	dbClient, _ := db.NewDB("testdb", "")
+	regi := &Registry{
+		DbClient:       dbClient,
+		PrivateKeyPath: "private_key.test",
+	}
+	testdb.SetQueryWithArgsFunc(func(query string, args []driver.Value) (result driver.Rows, err error) {
+		columns := []string{"namespace", "account_email"}
+
+		rows := ""
+		if args[0] == "test@test.com" {
+			rows = "test_namespace,test@test.com"
+		}
+		return testdb.RowsFromCSVString(columns, rows), nil
+	})
+
+	_, _, err := regi.PushCredential(auth.Account{Email: "nobody@nobody.com"}, "nginx")
+	assert.NotNil(t, err)
+
+	namespace, password, err := regi.PushCredential(auth.Account{Email: "test@test.com"}, "nginx")
+	assert.Nil(t, err)
+	assert.Equal(t, "test_namespace", namespace)
+
+	granted, ok := regi.verifyCredential("test_namespace", password)
+	assert.True(t, ok)
+	_, ok = regi.verifyCredential("other_namespace", password)
+	assert.False(t, ok)
+	_, ok = regi.verifyCredential("test_namespace", password+"x")
+	assert.False(t, ok)
+	_, ok = regi.verifyCredential("test_namespace", "password")
+	assert.False(t, ok)
+
+	ui, err := regi.MakeToken(regi.PrivateKeyPath, "test_namespace", "registry", granted)
+	assert.Nil(t, err)
+	_, ok = regi.verifyCredential("test_namespace", ui)
+	assert.False(t, ok)
+
+	a := &token.ResourceActions{Type: "repository", Name: "test_namespace/nginx", Actions: []string{"pull", "push", "*"}}
+	grantAccess(a, granted)
+	assert.Equal(t, []string{"pull", "push"}, a.Actions)
+	a = &token.ResourceActions{Type: "repository", Name: "test_namespace/redis", Actions: []string{"pull", "push"}}
+	grantAccess(a, granted)
+	assert.Empty(t, a.Actions)
+}
@@ -0,0 +1,560 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Dataman-Cloud/crane/src/plugins/auth"
+	"github.com/Dataman-Cloud/crane/src/utils"
+	"github.com/Dataman-Cloud/crane/src/utils/cranerror"
+	"github.com/Dataman-Cloud/crane/src/utils/httpresponse"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	WebhookEventPush   = "push"
+	WebhookEventPull   = "pull"
+	WebhookEventDelete = "delete"
+
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+
+	// the hex hmac-sha256 of the body keyed by the secret of the webhook
+	webhookSignatureHeader = "X-Crane-Signature"
+	webhookEventHeader     = "X-Crane-Event"
+	webhookDeliveryHeader  = "X-Crane-Delivery"
+
+	webhookRequestTimeout = 10 * time.Second
+	// deliveries listed at most
+	webhookDeliveryListLimit = 100
+	// the bytes of the response body kept in the delivery history
+	webhookResponseLimit = 1024
+)
+
+var webhookEvents = []string{WebhookEventPush, WebhookEventPull, WebhookEventDelete}
+
+// the waits before the retries of a failed delivery, its attempts are one more
+var webhookRetryBackoff = []time.Duration{10 * time.Second, 30 * time.Second, time.Minute, 5 * time.Minute}
+
+// the webhooks post to public addresses only, not to crane, the nodes or the metadata of the cloud
+var webhookBlockedNetworks = parseNetworks(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12", "192.168.0.0/16",
+	"::/128", "::1/128", "fc00::/7", "fe80::/10",
+)
+
+// replaced by the tests posting to local servers
+var webhookAddressAllowed = publicAddress
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func publicAddress(ip net.IP) bool {
+	for _, network := range webhookBlockedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// dial the address once all the ips of its host are public, the check on each dial covers the redirects
+// and the names resolved to private ips after the webhook was saved
+func dialWebhook(network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no address of %s", host)
+	}
+	for _, ip := range ips {
+		if !webhookAddressAllowed(ip) {
+			return nil, fmt.Errorf("address %s of %s not allowed", ip, host)
+		}
+	}
+
+	return net.DialTimeout(network, net.JoinHostPort(ips[0].String(), port), webhookRequestTimeout)
+}
+
+// Webhook posts the registry events of a namespace, or of one repository of it, to an url
+type Webhook struct {
+	ID        uint64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	Namespace string `json:"Namespace" gorm:"not null"`
+	// empty for every repository of the namespace
+	Image string `json:"Image"`
+	URL   string `json:"Url" gorm:"not null"`
+	// the events posted separated by commas
+	Events    string `json:"Events" gorm:"not null"`
+	Secret    string `json:"-" gorm:"not null"`
+	Enabled   bool   `json:"Enabled"`
+	CreaterId uint64 `json:"CreaterId"`
+}
+
+// WebhookDelivery is the history of posting one event to a webhook
+type WebhookDelivery struct {
+	ID        uint64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	WebhookID    uint64     `json:"WebhookID" gorm:"not null"`
+	Event        string     `json:"Event"`
+	Repository   string     `json:"Repository"`
+	Payload      string     `json:"Payload" sql:"type:text"`
+	Status       string     `json:"Status"`
+	Attempts     int        `json:"Attempts"`
+	ResponseCode int        `json:"ResponseCode"`
+	Response     string     `json:"Response" sql:"type:text"`
+	Error        string     `json:"Error"`
+	DeliveredAt  *time.Time `json:"DeliveredAt"`
+}
+
+// WebhookPayload is the body posted to the webhooks
+type WebhookPayload struct {
+	Event      string    `json:"Event"`
+	Namespace  string    `json:"Namespace"`
+	Image      string    `json:"Image"`
+	Repository string    `json:"Repository"`
+	Tag        string    `json:"Tag"`
+	Digest     string    `json:"Digest"`
+	Actor      string    `json:"Actor"`
+	Timestamp  time.Time `json:"Timestamp"`
+}
+
+type webhookParam struct {
+	Namespace string   `json:"Namespace"`
+	Image     string   `json:"Image"`
+	URL       string   `json:"Url"`
+	Events    []string `json:"Events"`
+	// generated if empty on creation, kept if empty on update
+	Secret  string `json:"Secret"`
+	Enabled *bool  `json:"Enabled"`
+}
+
+func (param *webhookParam) validate() error {
+	if strings.Contains(param.Image, "/") {
+		return cranerror.NewError(CodeWebhookParamError, "invalid image "+param.Image)
+	}
+
+	u, err := url.Parse(param.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return cranerror.NewError(CodeWebhookParamError, "Url must be an http or https url")
+	}
+	// the names are checked on each delivery, they may resolve differently later
+	host := u.Hostname()
+	if ip := net.ParseIP(host); (ip != nil && !webhookAddressAllowed(ip)) || strings.EqualFold(host, "localhost") {
+		return cranerror.NewError(CodeWebhookParamError, "Url must be a public address")
+	}
+
+	if len(param.Events) == 0 {
+		return cranerror.NewError(CodeWebhookParamError, "Events required")
+	}
+	for _, event := range param.Events {
+		if !utils.StringInSlice(event, webhookEvents) {
+			return cranerror.NewError(CodeWebhookParamError, "Events must be some of push, pull and delete")
+		}
+	}
+	return nil
+}
+
+func (webhook *Webhook) subscribes(event string) bool {
+	return utils.StringInSlice(event, strings.Split(webhook.Events, ","))
+}
+
+func generateWebhookSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+func signWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// the payload of the registry event, the tag is the one of the event or of the digest
+func (registry *Registry) webhookPayload(n Event) WebhookPayload {
+	namespace := strings.Split(n.Target.Repository, "/")[0]
+	image := strings.Split(n.Target.Repository, "/")[1]
+	payload := WebhookPayload{
+		Event:      n.Action,
+		Namespace:  namespace,
+		Image:      image,
+		Repository: n.Target.Repository,
+		Tag:        n.Target.Tag,
+		Digest:     n.Target.Digest,
+		Timestamp:  n.TimeStamp,
+	}
+	if n.Actor != nil {
+		payload.Actor = n.Actor.Name
+	}
+
+	if payload.Tag == "" && payload.Digest != "" && n.Action != WebhookEventDelete {
+		var tag Tag
+		if err := registry.DbClient.Where("namespace = ? AND image = ? AND digest = ?", namespace, image, payload.Digest).Find(&tag).Error; err == nil {
+			payload.Tag = tag.Tag
+		}
+	}
+	return payload
+}
+
+// queue a delivery of the event to each enabled webhook of its repository subscribing to it
+func (registry *Registry) dispatchWebhooks(n Event) {
+	if n.Target == nil || len(strings.Split(n.Target.Repository, "/")) != 2 {
+		return
+	}
+	namespace := strings.Split(n.Target.Repository, "/")[0]
+	image := strings.Split(n.Target.Repository, "/")[1]
+
+	var webhooks []Webhook
+	if err := registry.DbClient.Where("namespace = ? AND (image = '' OR image = ?) AND enabled = ?", namespace, image, true).Find(&webhooks).Error; err != nil {
+		return
+	}
+
+	var body []byte
+	for i := range webhooks {
+		webhook := webhooks[i]
+		if !webhook.subscribes(n.Action) {
+			continue
+		}
+
+		if body == nil {
+			var err error
+			if body, err = json.Marshal(registry.webhookPayload(n)); err != nil {
+				log.Errorf("marshal webhook payload of %s got error: %v", n.Target.Repository, err)
+				return
+			}
+		}
+
+		delivery := &WebhookDelivery{
+			WebhookID:  webhook.ID,
+			Event:      n.Action,
+			Repository: n.Target.Repository,
+			Payload:    string(body),
+			Status:     WebhookDeliveryPending,
+		}
+		if err := registry.DbClient.Save(delivery).Error; err != nil {
+			log.Errorf("save delivery of webhook %d got error: %v", webhook.ID, err)
+			continue
+		}
+		go registry.deliverWebhook(&webhook, delivery)
+	}
+}
+
+// post the delivery until the webhook accepts it or the retries run out
+func (registry *Registry) deliverWebhook(webhook *Webhook, delivery *WebhookDelivery) {
+	client := &http.Client{
+		Timeout:   webhookRequestTimeout,
+		Transport: &http.Transport{Dial: dialWebhook},
+	}
+	for {
+		// a resumed delivery goes on with the retries it has left
+		attempt := delivery.Attempts
+		code, response, err := postWebhook(client, webhook, delivery)
+
+		now := time.Now()
+		delivery.Attempts++
+		delivery.ResponseCode = code
+		delivery.Response = response
+		delivery.DeliveredAt = &now
+		delivery.Error = ""
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+
+		succeeded := err == nil && code >= 200 && code < 300
+		if succeeded {
+			delivery.Status = WebhookDeliverySucceeded
+		} else if attempt >= len(webhookRetryBackoff) {
+			delivery.Status = WebhookDeliveryFailed
+		}
+		registry.DbClient.Model(delivery).Updates(map[string]interface{}{
+			"status":        delivery.Status,
+			"attempts":      delivery.Attempts,
+			"response_code": delivery.ResponseCode,
+			"response":      delivery.Response,
+			"error":         delivery.Error,
+			"delivered_at":  now,
+		})
+
+		if delivery.Status != WebhookDeliveryPending {
+			log.Infof("delivery %d of webhook %d %s after %d attempts", delivery.ID, webhook.ID, delivery.Status, delivery.Attempts)
+			return
+		}
+		time.Sleep(webhookRetryBackoff[attempt])
+	}
+}
+
+// go on with the deliveries left pending by a restart, the ones of webhooks removed or disabled since fail
+func (registry *Registry) resumeWebhookDeliveries() {
+	var deliveries []WebhookDelivery
+	if err := registry.DbClient.Where("status = ?", WebhookDeliveryPending).Find(&deliveries).Error; err != nil {
+		return
+	}
+
+	for i := range deliveries {
+		delivery := &deliveries[i]
+		var webhook Webhook
+		if err := registry.DbClient.Where("id = ?", delivery.WebhookID).Find(&webhook).Error; err != nil || !webhook.Enabled {
+			registry.DbClient.Model(delivery).Updates(map[string]interface{}{
+				"status": WebhookDeliveryFailed,
+				"error":  "webhook removed or disabled before the delivery",
+			})
+			continue
+		}
+		log.Infof("resume delivery %d of webhook %d after %d attempts", delivery.ID, webhook.ID, delivery.Attempts)
+		go registry.deliverWebhook(&webhook, delivery)
+	}
+}
+
+// the status code and the head of the body of the response of the webhook
+func postWebhook(client *http.Client, webhook *Webhook, delivery *WebhookDelivery) (int, string, error) {
+	request, err := http.NewRequest("POST", webhook.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, "", err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(webhookEventHeader, delivery.Event)
+	request.Header.Set(webhookDeliveryHeader, strconv.FormatUint(delivery.ID, 10))
+	request.Header.Set(webhookSignatureHeader, signWebhookPayload(webhook.Secret, []byte(delivery.Payload)))
+
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, "", err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, webhookResponseLimit))
+	if err != nil {
+		return response.StatusCode, "", err
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return response.StatusCode, string(body), fmt.Errorf("webhook responded %s", response.Status)
+	}
+	return response.StatusCode, string(body), nil
+}
+
+// the webhook of the path administered by the account of ctx, false if the response is written already
+func (registry *Registry) administeredWebhook(ctx *gin.Context) (*Webhook, bool) {
+	id, err := strconv.ParseUint(ctx.Param("webhook_id"), 10, 64)
+	if err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeWebhookNotFound, "invalid webhook id"))
+		return nil, false
+	}
+
+	var webhook Webhook
+	if err := registry.DbClient.Where("id = ?", id).Find(&webhook).Error; err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeWebhookNotFound, err.Error()))
+		return nil, false
+	}
+
+	if _, ok := registry.grantNamespace(ctx, webhook.Namespace); !ok {
+		return nil, false
+	}
+	return &webhook, true
+}
+
+// the webhooks of a namespace, query namespace defaults to the one of the account
+func (registry *Registry) ListWebhooks(ctx *gin.Context) {
+	namespace, ok := registry.grantNamespace(ctx, ctx.Query("namespace"))
+	if !ok {
+		return
+	}
+
+	webhooks := make([]Webhook, 0)
+	if err := registry.DbClient.Where("namespace = ?", namespace).Order("id").Find(&webhooks).Error; err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeWebhookSaveError, err.Error()))
+		return
+	}
+
+	httpresponse.Ok(ctx, webhooks)
+}
+
+func (registry *Registry) InspectWebhook(ctx *gin.Context) {
+	webhook, ok := registry.administeredWebhook(ctx)
+	if !ok {
+		return
+	}
+
+	httpresponse.Ok(ctx, webhook)
+}
+
+// create a webhook, its secret is only returned once
+func (registry *Registry) CreateWebhook(ctx *gin.Context) {
+	var param webhookParam
+	if err := ctx.BindJSON(&param); err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeWebhookParamError, err.Error()))
+		return
+	}
+	if err := param.validate(); err != nil {
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	namespace, ok := registry.grantNamespace(ctx, param.Namespace)
+	if !ok {
+		return
+	}
+
+	if param.Secret == "" {
+		secret, err := generateWebhookSecret()
+		if err != nil {
+			httpresponse.Error(ctx, cranerror.NewError(CodeWebhookSaveError, err.Error()))
+			return
+		}
+		param.Secret = secret
+	}
+
+	webhook := Webhook{
+		Namespace: namespace,
+		Image:     param.Image,
+		URL:       param.URL,
+		Events:    strings.Join(param.Events, ","),
+		Secret:    param.Secret,
+		Enabled:   param.Enabled == nil || *param.Enabled,
+	}
+	if account, found := ctx.Get("account"); found {
+		webhook.CreaterId = account.(auth.Account).ID
+	}
+	if err := registry.DbClient.Save(&webhook).Error; err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeWebhookSaveError, err.Error()))
+		return
+	}
+
+	httpresponse.Ok(ctx, gin.H{"Webhook": webhook, "Secret": webhook.Secret})
+}
+
+// update the url, the events, the secret or the state of a webhook, its namespace stays
+func (registry *Registry) UpdateWebhook(ctx *gin.Context) {
+	webhook, ok := registry.administeredWebhook(ctx)
+	if !ok {
+		return
+	}
+
+	var param webhookParam
+	if err := ctx.BindJSON(&param); err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeWebhookParamError, err.Error()))
+		return
+	}
+	if err := param.validate(); err != nil {
+		httpresponse.Error(ctx, err)
+		return
+	}
+
+	webhook.Image = param.Image
+	webhook.URL = param.URL
+	webhook.Events = strings.Join(param.Events, ",")
+	if param.Secret != "" {
+		webhook.Secret = param.Secret
+	}
+	if param.Enabled != nil {
+		webhook.Enabled = *param.Enabled
+	}
+	if err := registry.DbClient.Save(webhook).Error; err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeWebhookSaveError, err.Error()))
+		return
+	}
+
+	httpresponse.Ok(ctx, webhook)
+}
+
+// delete a webhook and its delivery history
+func (registry *Registry) DeleteWebhook(ctx *gin.Context) {
+	webhook, ok := registry.administeredWebhook(ctx)
+	if !ok {
+		return
+	}
+
+	registry.DbClient.Where("webhook_id = ?", webhook.ID).Delete(&WebhookDelivery{})
+	if err := registry.DbClient.Delete(webhook).Error; err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeWebhookSaveError, err.Error()))
+		return
+	}
+
+	httpresponse.Ok(ctx, "success")
+}
+
+// the latest deliveries of a webhook, query status filters them
+func (registry *Registry) ListWebhookDeliveries(ctx *gin.Context) {
+	webhook, ok := registry.administeredWebhook(ctx)
+	if !ok {
+		return
+	}
+
+	query := registry.DbClient.Where("webhook_id = ?", webhook.ID)
+	if status := ctx.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	deliveries := make([]WebhookDelivery, 0)
+	if err := query.Order("id DESC").Limit(webhookDeliveryListLimit).Find(&deliveries).Error; err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeWebhookSaveError, err.Error()))
+		return
+	}
+
+	httpresponse.Ok(ctx, deliveries)
+}
+
+// deliver the payload of a past delivery again as a new delivery
+func (registry *Registry) RedeliverWebhook(ctx *gin.Context) {
+	webhook, ok := registry.administeredWebhook(ctx)
+	if !ok {
+		return
+	}
+
+	var past WebhookDelivery
+	if err := registry.DbClient.Where("id = ? AND webhook_id = ?", ctx.Param("delivery_id"), webhook.ID).Find(&past).Error; err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeWebhookNotFound, err.Error()))
+		return
+	}
+
+	delivery := &WebhookDelivery{
+		WebhookID:  webhook.ID,
+		Event:      past.Event,
+		Repository: past.Repository,
+		Payload:    past.Payload,
+		Status:     WebhookDeliveryPending,
+	}
+	if err := registry.DbClient.Save(delivery).Error; err != nil {
+		httpresponse.Error(ctx, cranerror.NewError(CodeWebhookSaveError, err.Error()))
+		return
+	}
+	go registry.deliverWebhook(webhook, delivery)
+
+	httpresponse.Ok(ctx, delivery)
+}
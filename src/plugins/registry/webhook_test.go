@@ -0,0 +1,183 @@
+package registry
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dataman-Cloud/crane/src/utils/db"
+
+	"github.com/erikstmartin/go-testdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookParamValidate(t *testing.T) {
+	param := &webhookParam{URL: "https://hooks.example.com/crane", Events: []string{"push", "delete"}}
+	assert.Nil(t, param.validate())
+
+	for _, invalid := range []*webhookParam{
+		{URL: "ftp://hooks.example.com", Events: []string{"push"}},
+		{URL: "https://", Events: []string{"push"}},
+		{URL: "https://hooks.example.com"},
+		{URL: "https://hooks.example.com", Events: []string{"tag"}},
+		{URL: "https://hooks.example.com", Events: []string{"push"}, Image: "shop/web"},
+		{URL: "http://127.0.0.1:2375/containers/json", Events: []string{"push"}},
+		{URL: "http://169.254.169.254/latest/meta-data", Events: []string{"push"}},
+		{URL: "http://10.0.0.5/hook", Events: []string{"push"}},
+		{URL: "http://[::1]/hook", Events: []string{"push"}},
+		{URL: "http://localhost/hook", Events: []string{"push"}},
+	} {
+		assert.NotNil(t, invalid.validate(), invalid.URL)
+	}
+}
+
+func TestWebhookSubscribes(t *testing.T) {
+	webhook := &Webhook{Events: "push,delete"}
+	assert.True(t, webhook.subscribes("push"))
+	assert.True(t, webhook.subscribes("delete"))
+	assert.False(t, webhook.subscribes("pull"))
+}
+
+func TestSignWebhookPayload(t *testing.T) {
+	// echo -n 'hello' | openssl dgst -sha256 -hmac secret
+	assert.Equal(t, "sha256=88aab3ede8d3adf94d26ab90d3bafd4a2083070c3bcce9c014ee04a443847c0b",
+		signWebhookPayload("secret", []byte("hello")))
+}
+
+func TestDeliverWebhookRetries(t *testing.T) {
+	defer allowLocalWebhooks()()
+	backoff := webhookRetryBackoff
+	webhookRetryBackoff = []time.Duration{time.Millisecond, time.Millisecond}
+	defer func() { webhookRetryBackoff = backoff }()
+
+	attempts := 0
+	var signature, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		signature = r.Header.Get(webhookSignatureHeader)
+		content, _ := ioutil.ReadAll(r.Body)
+		body = string(content)
+	}))
+	defer server.Close()
+
+	dbClient, _ := db.NewDB("testdb", "")
+	registry := &Registry{DbClient: dbClient}
+	webhook := &Webhook{URL: server.URL, Secret: "secret"}
+	delivery := &WebhookDelivery{Event: "push", Payload: "hello", Status: WebhookDeliveryPending}
+
+	registry.deliverWebhook(webhook, delivery)
+	assert.Equal(t, WebhookDeliverySucceeded, delivery.Status)
+	assert.Equal(t, 2, delivery.Attempts)
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, signWebhookPayload("secret", []byte("hello")), signature)
+}
+
+func TestDeliverWebhookFails(t *testing.T) {
+	defer allowLocalWebhooks()()
+	backoff := webhookRetryBackoff
+	webhookRetryBackoff = []time.Duration{time.Millisecond}
+	defer func() { webhookRetryBackoff = backoff }()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	dbClient, _ := db.NewDB("testdb", "")
+	registry := &Registry{DbClient: dbClient}
+	delivery := &WebhookDelivery{Event: "push", Payload: "{}", Status: WebhookDeliveryPending}
+
+	registry.deliverWebhook(&Webhook{URL: server.URL}, delivery)
+	assert.Equal(t, WebhookDeliveryFailed, delivery.Status)
+	assert.Equal(t, 2, delivery.Attempts)
+	assert.Equal(t, http.StatusNotFound, delivery.ResponseCode)
+}
+
+func TestDeliverWebhookResumed(t *testing.T) {
+	defer allowLocalWebhooks()()
+	backoff := webhookRetryBackoff
+	webhookRetryBackoff = []time.Duration{time.Millisecond, time.Millisecond}
+	defer func() { webhookRetryBackoff = backoff }()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	dbClient, _ := db.NewDB("testdb", "")
+	registry := &Registry{DbClient: dbClient}
+	// two attempts were made before the restart
+	delivery := &WebhookDelivery{Event: "push", Payload: "{}", Status: WebhookDeliveryPending, Attempts: 2}
+
+	registry.deliverWebhook(&Webhook{URL: server.URL}, delivery)
+	assert.Equal(t, WebhookDeliveryFailed, delivery.Status)
+	assert.Equal(t, 3, delivery.Attempts)
+}
+
+func TestResumeWebhookDeliveriesOfRemovedWebhook(t *testing.T) {
+	testdb.SetQueryWithArgsFunc(func(query string, args []driver.Value) (driver.Rows, error) {
+		if strings.Contains(query, "webhook_deliveries") {
+			return testdb.RowsFromCSVString([]string{"id", "webhook_id", "event", "status", "attempts"}, "7,3,push,pending,1"), nil
+		}
+		return testdb.RowsFromCSVString([]string{"id"}, ""), nil
+	})
+	var updates []string
+	testdb.SetExecWithArgsFunc(func(query string, args []driver.Value) (driver.Result, error) {
+		updates = append(updates, fmt.Sprint(query, args))
+		return testdb.NewResult(0, nil, 1, nil), nil
+	})
+	defer testdb.SetExecWithArgsFunc(nil)
+
+	dbClient, _ := db.NewDB("testdb", "")
+	registry := &Registry{DbClient: dbClient}
+	registry.resumeWebhookDeliveries()
+
+	assert.Len(t, updates, 1)
+	assert.Contains(t, updates[0], "webhook_deliveries")
+	assert.Contains(t, updates[0], WebhookDeliveryFailed)
+}
+
+// let the webhooks post to the local test servers, the returned func restores the check
+func allowLocalWebhooks() func() {
+	webhookAddressAllowed = func(ip net.IP) bool { return true }
+	return func() { webhookAddressAllowed = publicAddress }
+}
+
+func TestDeliverWebhookToPrivateAddress(t *testing.T) {
+	backoff := webhookRetryBackoff
+	webhookRetryBackoff = nil
+	defer func() { webhookRetryBackoff = backoff }()
+
+	posted := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted = true
+	}))
+	defer server.Close()
+
+	dbClient, _ := db.NewDB("testdb", "")
+	registry := &Registry{DbClient: dbClient}
+	delivery := &WebhookDelivery{Event: "push", Payload: "{}", Status: WebhookDeliveryPending}
+
+	registry.deliverWebhook(&Webhook{URL: server.URL}, delivery)
+	assert.False(t, posted)
+	assert.Equal(t, WebhookDeliveryFailed, delivery.Status)
+	assert.Contains(t, delivery.Error, "not allowed")
+}
+
+func TestPublicAddress(t *testing.T) {
+	assert.True(t, publicAddress(net.ParseIP("8.8.8.8")))
+	assert.True(t, publicAddress(net.ParseIP("2001:4860:4860::8888")))
+	assert.False(t, publicAddress(net.ParseIP("172.17.0.1")))
+	assert.False(t, publicAddress(net.ParseIP("192.168.1.10")))
+	assert.False(t, publicAddress(net.ParseIP("fe80::1")))
+}
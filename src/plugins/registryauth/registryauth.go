@@ -42,3 +42,10 @@ func Get(name string) (*RegistryAuth, error) {
 	err := DbClient.Where("name = ?", name).First(&registryAuth).Error
 	return &registryAuth, err
 }
+
+// the names are unique per account only
+func GetOfAccount(name string, accountId uint64) (*RegistryAuth, error) {
+	var registryAuth RegistryAuth
+	err := DbClient.Where("name = ? AND account_id = ?", name, accountId).First(&registryAuth).Error
+	return &registryAuth, err
+}
@@ -5,37 +5,40 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
 	"os"
-	"os/exec"
 	"strings"
 	"sync"
-	"syscall"
 	"text/template"
-	"unsafe"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/fatih/structs"
 	"github.com/gorilla/websocket"
-	"github.com/kr/pty"
 )
 
 const (
 	SubProtocol = "shurenyun"
 )
 
+// Terminal is the tty of the process a websocket client is attached to
+type Terminal interface {
+	io.ReadWriteCloser
+	Resize(rows, columns uint16) error
+	// the command line of the process
+	Command() string
+}
+
 type ClientContext struct {
 	Request       *http.Request
 	Conn          *websocket.Conn
-	Command       *exec.Cmd
-	Pty           *os.File
+	Terminal      Terminal
 	WriteMutex    *sync.Mutex
 	TitleTemplate *template.Template
 	Options       *Options
 }
 
 type Options struct {
-	CloseSignal       int                    `hcl:"close_signal"`
 	Preferences       HtermPrefernces        `hcl:"preferences"`
 	RawPreferences    map[string]interface{} `hcl:"preferences"`
 	EnableReconnect   bool                   `hcl:"enable_reconnect"`
@@ -69,7 +72,6 @@ type ArgResizeTerminal struct {
 
 type ContextVars struct {
 	Command    string
-	Pid        int
 	Hostname   string
 	RemoteAddr string
 }
@@ -91,17 +93,11 @@ var (
 		EnableReconnect:   false,
 		ReconnectTime:     10,
 		Once:              false,
-		CloseSignal:       1, // syscall.SIGHUP
 		Preferences:       HtermPrefernces{},
 	}
 )
 
-func New(cmd *exec.Cmd, conn *websocket.Conn, request *http.Request, opts *Options) (*ClientContext, error) {
-	ptyIo, err := pty.Start(cmd)
-	if err != nil {
-		return nil, err
-	}
-
+func New(terminal Terminal, conn *websocket.Conn, request *http.Request, opts *Options) (*ClientContext, error) {
 	titleTemplate, err := template.New("title").Parse(opts.TitleFormat)
 	if err != nil {
 		return nil, errors.New("Title format string syntax error")
@@ -110,8 +106,7 @@ func New(cmd *exec.Cmd, conn *websocket.Conn, request *http.Request, opts *Optio
 	return &ClientContext{
 		Request:       request,
 		Conn:          conn,
-		Command:       cmd,
-		Pty:           ptyIo,
+		Terminal:      terminal,
 		TitleTemplate: titleTemplate,
 		WriteMutex:    &sync.Mutex{},
 		Options:       opts,
@@ -119,8 +114,7 @@ func New(cmd *exec.Cmd, conn *websocket.Conn, request *http.Request, opts *Optio
 }
 
 func (ctx *ClientContext) HandleClient() {
-	log.Infof("Command is running fot client %s with PID %d (args=%q)",
-		ctx.Request.RemoteAddr, ctx.Command.Process.Pid, ctx.Command.Args)
+	log.Infof("Command is running for client %s (command=%q)", ctx.Request.RemoteAddr, ctx.Terminal.Command())
 	exit := make(chan bool, 2)
 
 	go func() {
@@ -138,12 +132,8 @@ func (ctx *ClientContext) HandleClient() {
 	go func() {
 		<-exit
 
-		// Even if the Pty has been closed
-		// Read(0 processSend() keeps blocking and the process doen't exit
-		ctx.Command.Process.Signal(syscall.Signal(ctx.Options.CloseSignal))
-		ctx.Command.Wait()
-
-		ctx.Pty.Close()
+		// ends the process and unblocks the Read of processSend()
+		ctx.Terminal.Close()
 
 		ctx.Conn.Close()
 		log.Infof("Connection closed: %s", ctx.Request.RemoteAddr)
@@ -159,7 +149,7 @@ func (ctx *ClientContext) processSend() {
 	buf := make([]byte, 1024)
 
 	for {
-		size, err := ctx.Pty.Read(buf)
+		size, err := ctx.Terminal.Read(buf)
 		if err != nil {
 			log.Errorf("Command exited of %s. Error: %s", ctx.Request.RemoteAddr, err.Error())
 			return
@@ -182,8 +172,7 @@ func (ctx *ClientContext) write(data []byte) error {
 func (ctx ClientContext) sendCustomTitle() error {
 	hostname, _ := os.Hostname()
 	titleVars := ContextVars{
-		Command:    strings.Join(ctx.Command.Args, " "),
-		Pid:        ctx.Command.Process.Pid,
+		Command:    ctx.Terminal.Command(),
 		Hostname:   hostname,
 		RemoteAddr: ctx.Request.RemoteAddr,
 	}
@@ -260,7 +249,7 @@ func (ctx *ClientContext) processReceive() {
 				break
 			}
 
-			_, err := ctx.Pty.Write(data[1:])
+			_, err := ctx.Terminal.Write(data[1:])
 			if err != nil {
 				log.Error("Write received message got error: ", err)
 				return
@@ -279,25 +268,10 @@ func (ctx *ClientContext) processReceive() {
 				return
 			}
 
-			window := struct {
-				row uint16
-				col uint16
-				x   uint16
-				y   uint16
-			}{
-				uint16(args.Rows),
-				uint16(args.Columns),
-				0,
-				0,
+			if err := ctx.Terminal.Resize(uint16(args.Rows), uint16(args.Columns)); err != nil {
+				log.Error("Resize terminal got error: ", err)
 			}
 
-			syscall.Syscall(
-				syscall.SYS_IOCTL,
-				ctx.Pty.Fd(),
-				syscall.TIOCSWINSZ,
-				uintptr(unsafe.Pointer(&window)),
-			)
-
 		default:
 			log.Error("Unknown message type")
 			return
@@ -1,20 +1,64 @@
 package tty
 
 import (
+	"encoding/base64"
 	"io"
 	"net/http"
 	"net/http/httptest"
-	"os/exec"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 )
 
+type fakeTerminal struct {
+	output  chan []byte
+	input   chan []byte
+	resized chan [2]uint16
+	closed  chan struct{}
+}
+
+func newFakeTerminal() *fakeTerminal {
+	return &fakeTerminal{
+		output:  make(chan []byte, 1),
+		input:   make(chan []byte, 1),
+		resized: make(chan [2]uint16, 1),
+		closed:  make(chan struct{}),
+	}
+}
+
+func (f *fakeTerminal) Read(p []byte) (int, error) {
+	select {
+	case data := <-f.output:
+		return copy(p, data), nil
+	case <-f.closed:
+		return 0, io.EOF
+	}
+}
+
+func (f *fakeTerminal) Write(p []byte) (int, error) {
+	f.input <- append([]byte{}, p...)
+	return len(p), nil
+}
+
+func (f *fakeTerminal) Resize(rows, columns uint16) error {
+	f.resized <- [2]uint16{rows, columns}
+	return nil
+}
+
+func (f *fakeTerminal) Command() string {
+	return "sh"
+}
+
+func (f *fakeTerminal) Close() error {
+	close(f.closed)
+	return nil
+}
+
 func TestNew(t *testing.T) {
-	cmd := exec.Command("echo", "test")
-	client, err := New(cmd, nil, nil, DefaultOptions)
+	client, err := New(newFakeTerminal(), nil, nil, DefaultOptions)
 	assert.Nil(t, err)
 
 	assert.True(t, client.Options.PermitWrite)
@@ -25,7 +69,46 @@ func TestNew(t *testing.T) {
 	assert.False(t, client.Options.EnableReconnect)
 	assert.Equal(t, client.Options.ReconnectTime, 10)
 	assert.False(t, client.Options.Once)
-	assert.Equal(t, client.Options.CloseSignal, 1)
+}
+
+func TestHandleClient(t *testing.T) {
+	terminal := newFakeTerminal()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := Upgrader.Upgrade(w, r, nil)
+		assert.Nil(t, err)
+
+		client, err := New(terminal, ws, r, DefaultOptions)
+		assert.Nil(t, err)
+		client.HandleClient()
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	c, _, err := websocket.DefaultDialer.Dial(parseURL(server.URL), nil)
+	assert.Nil(t, err)
+
+	// preferences are sent first
+	_, message, err := c.ReadMessage()
+	assert.Nil(t, err)
+	assert.Equal(t, byte(SetPreferences), message[0])
+
+	terminal.output <- []byte("$ ")
+	_, message, err = c.ReadMessage()
+	assert.Nil(t, err)
+	assert.Equal(t, string(Output)+base64.StdEncoding.EncodeToString([]byte("$ ")), string(message))
+
+	assert.Nil(t, c.WriteMessage(websocket.TextMessage, []byte(string(Input)+"ls\n")))
+	assert.Equal(t, "ls\n", string(<-terminal.input))
+
+	assert.Nil(t, c.WriteMessage(websocket.TextMessage, []byte(string(ResizeTerminal)+`{"Columns":80,"Rows":24}`)))
+	assert.Equal(t, [2]uint16{24, 80}, <-terminal.resized)
+
+	c.Close()
+	select {
+	case <-terminal.closed:
+	case <-time.After(time.Second):
+		t.Fatal("terminal not closed")
+	}
 }
 
 func startMockAcsServer(t *testing.T, closeWS <-chan bool) (*httptest.Server, <-chan error, error) {
@@ -47,8 +130,7 @@ func startMockAcsServer(t *testing.T, closeWS <-chan bool) (*httptest.Server, <-
 		req := &http.Request{
 			RemoteAddr: "test.test",
 		}
-		cmd := exec.Command("ping", "www.baidu.com")
-		client, err := New(cmd, ws, req, DefaultOptions)
+		client, err := New(newFakeTerminal(), ws, req, DefaultOptions)
 		assert.Nil(t, err)
 		client.HandleClient()
 	})
@@ -69,70 +151,3 @@ func parseURL(s string) string {
 	}
 	return wssString
 }
-
-//TODO: upccup make it right as soon
-//func TestHandleClient(t *testing.T) {
-//	closeWS := make(chan bool)
-//	interrupt := make(chan os.Signal, 1)
-//	signal.Notify(interrupt, os.Interrupt)
-//	server, errs, err := startMockAcsServer(t, closeWS)
-//	assert.Nil(t, err)
-//
-//	addr := parseURL(server.URL)
-//	t.Log("server url: ", addr)
-//
-//	u, err := url.Parse(addr)
-//	assert.Nil(t, err)
-//
-//	c, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
-//	assert.Nil(t, err)
-//	defer c.Close()
-//
-//	done := make(chan struct{})
-//
-//	go func() {
-//		defer c.Close()
-//		defer close(done)
-//		for {
-//			_, message, err := c.ReadMessage()
-//			if err != nil {
-//				log.Println("read:", err)
-//				return
-//			}
-//			log.Printf("recv: %s", message)
-//		}
-//	}()
-//
-//	ticker := time.NewTicker(time.Second)
-//	defer ticker.Stop()
-//
-//	for {
-//		select {
-//		case t := <-ticker.C:
-//			err := c.WriteMessage(websocket.TextMessage, []byte(t.String()))
-//			if err != nil {
-//				log.Println("write:", err)
-//				return
-//			}
-//		case <-interrupt:
-//			log.Println("interrupt")
-//			// To cleanly close a connection, a client should send a close
-//			// frame and wait for the server to close the connection.
-//			closeWS <- true
-//			err := c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
-//			if err != nil {
-//				log.Println("write close:", err)
-//				return
-//			}
-//			select {
-//			case <-done:
-//			case <-time.After(time.Second):
-//			}
-//			c.Close()
-//			return
-//		case err := <-errs:
-//			t.Error(err)
-//			return
-//		}
-//	}
-//}
@@ -0,0 +1,252 @@
+package tty
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// output chunks buffered for each viewer, a slower viewer misses output
+	viewerBuffer = 256
+)
+
+var (
+	ErrNoLiveSession     = errors.New("no live terminal session to follow")
+	ErrReadOnlyTerminal  = errors.New("terminal is read only")
+	ErrLiveSessionExists = errors.New("terminal session already live")
+)
+
+// LiveSessions keeps the interactive terminals being used,
+// their output is shared with the read only viewers following them
+type LiveSessions struct {
+	mu        sync.Mutex
+	terminals map[string]*LiveTerminal
+}
+
+func NewLiveSessions() *LiveSessions {
+	return &LiveSessions{terminals: make(map[string]*LiveTerminal)}
+}
+
+// Start makes the terminal live until it is closed, an ID is given to the session if it has none
+func (sessions *LiveSessions) Start(terminal Terminal, session Session) (*LiveTerminal, error) {
+	if session.ID == "" {
+		session.ID = NewSessionID()
+	}
+	session.Command = terminal.Command()
+	if session.StartedAt.IsZero() {
+		session.StartedAt = time.Now()
+	}
+
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+
+	if _, ok := sessions.terminals[session.ID]; ok {
+		return nil, ErrLiveSessionExists
+	}
+
+	live := &LiveTerminal{
+		Terminal: terminal,
+		session:  session,
+		sessions: sessions,
+		viewers:  make(map[*TerminalViewer]bool),
+	}
+	sessions.terminals[session.ID] = live
+	return live, nil
+}
+
+func (sessions *LiveSessions) remove(id string) {
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+
+	delete(sessions.terminals, id)
+}
+
+// the live sessions matching the set fields of filter, the latest first
+func (sessions *LiveSessions) List(filter Session) []Session {
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+
+	list := make([]Session, 0)
+	for _, live := range sessions.terminals {
+		session := live.session
+		if (filter.Account == "" || filter.Account == session.Account) &&
+			(filter.NodeID == "" || filter.NodeID == session.NodeID) &&
+			(filter.ContainerID == "" || filter.ContainerID == session.ContainerID) {
+			list = append(list, session)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].StartedAt.After(list[j].StartedAt)
+	})
+
+	return list
+}
+
+// Follow returns a read only view of the live session id,
+// or of the latest live session of the container if id is empty
+func (sessions *LiveSessions) Follow(id, containerId string) (*TerminalViewer, error) {
+	sessions.mu.Lock()
+	live, ok := sessions.terminals[id]
+	if id == "" {
+		for _, l := range sessions.terminals {
+			if l.session.ContainerID == containerId && (live == nil || l.session.StartedAt.After(live.session.StartedAt)) {
+				live, ok = l, true
+			}
+		}
+	}
+	sessions.mu.Unlock()
+
+	if !ok || live.session.ContainerID != containerId {
+		return nil, ErrNoLiveSession
+	}
+
+	return live.follow()
+}
+
+// End closes the live session id, its viewers are closed too
+func (sessions *LiveSessions) End(id string) (Session, error) {
+	sessions.mu.Lock()
+	live, ok := sessions.terminals[id]
+	sessions.mu.Unlock()
+
+	if !ok {
+		return Session{}, ErrSessionNotFound
+	}
+
+	log.Infof("ending live terminal session %s of %s", id, live.session.Account)
+	return live.session, live.Close()
+}
+
+// LiveTerminal is an interactive terminal whose output is copied to its viewers
+type LiveTerminal struct {
+	Terminal
+
+	session  Session
+	sessions *LiveSessions
+
+	mu      sync.Mutex
+	viewers map[*TerminalViewer]bool
+	closed  bool
+}
+
+func (live *LiveTerminal) Session() Session {
+	return live.session
+}
+
+func (live *LiveTerminal) Read(p []byte) (int, error) {
+	n, err := live.Terminal.Read(p)
+	if n > 0 {
+		live.broadcast(p[:n])
+	}
+
+	return n, err
+}
+
+func (live *LiveTerminal) broadcast(data []byte) {
+	live.mu.Lock()
+	defer live.mu.Unlock()
+
+	for viewer := range live.viewers {
+		select {
+		case viewer.output <- append([]byte(nil), data...):
+		default:
+			log.Warnf("viewer of terminal session %s is too slow, output dropped", live.session.ID)
+		}
+	}
+}
+
+func (live *LiveTerminal) follow() (*TerminalViewer, error) {
+	live.mu.Lock()
+	defer live.mu.Unlock()
+
+	if live.closed {
+		return nil, ErrNoLiveSession
+	}
+
+	viewer := &TerminalViewer{
+		live:   live,
+		output: make(chan []byte, viewerBuffer),
+		closed: make(chan struct{}),
+	}
+	live.viewers[viewer] = true
+	return viewer, nil
+}
+
+func (live *LiveTerminal) unfollow(viewer *TerminalViewer) {
+	live.mu.Lock()
+	defer live.mu.Unlock()
+
+	delete(live.viewers, viewer)
+}
+
+// close the terminal and its viewers, the session is not live anymore
+func (live *LiveTerminal) Close() error {
+	live.sessions.remove(live.session.ID)
+
+	live.mu.Lock()
+	if !live.closed {
+		live.closed = true
+		for viewer := range live.viewers {
+			viewer.closeOnce.Do(func() { close(viewer.closed) })
+		}
+		live.viewers = make(map[*TerminalViewer]bool)
+	}
+	live.mu.Unlock()
+
+	return live.Terminal.Close()
+}
+
+// TerminalViewer follows the output of a live terminal, it can neither write nor resize
+type TerminalViewer struct {
+	live      *LiveTerminal
+	output    chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+	pending   []byte
+}
+
+// the session followed
+func (viewer *TerminalViewer) Session() Session {
+	return viewer.live.session
+}
+
+func (viewer *TerminalViewer) Read(p []byte) (int, error) {
+	if len(viewer.pending) == 0 {
+		select {
+		case data := <-viewer.output:
+			viewer.pending = data
+		case <-viewer.closed:
+			return 0, io.EOF
+		}
+	}
+
+	n := copy(p, viewer.pending)
+	viewer.pending = viewer.pending[n:]
+	return n, nil
+}
+
+func (viewer *TerminalViewer) Write(p []byte) (int, error) {
+	return 0, ErrReadOnlyTerminal
+}
+
+// the size of the terminal is the one of its interactive session
+func (viewer *TerminalViewer) Resize(rows, columns uint16) error {
+	return nil
+}
+
+func (viewer *TerminalViewer) Command() string {
+	return viewer.live.session.Command
+}
+
+// stop following, the live terminal is not closed
+func (viewer *TerminalViewer) Close() error {
+	viewer.live.unfollow(viewer)
+	viewer.closeOnce.Do(func() { close(viewer.closed) })
+	return nil
+}
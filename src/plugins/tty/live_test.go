@@ -0,0 +1,76 @@
+package tty
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLiveSessionsFollow(t *testing.T) {
+	sessions := NewLiveSessions()
+
+	_, err := sessions.Follow("", "container1")
+	assert.Equal(t, ErrNoLiveSession, err)
+
+	terminal := newFakeTerminal()
+	live, err := sessions.Start(terminal, Session{ContainerID: "container1", Account: "foo@bar.com"})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, live.Session().ID)
+	_, err = sessions.Start(newFakeTerminal(), live.Session())
+	assert.Equal(t, ErrLiveSessionExists, err)
+
+	_, err = sessions.Follow("", "container2")
+	assert.Equal(t, ErrNoLiveSession, err)
+	_, err = sessions.Follow(live.Session().ID, "container2")
+	assert.Equal(t, ErrNoLiveSession, err)
+
+	viewer, err := sessions.Follow("", "container1")
+	assert.Nil(t, err)
+	assert.Equal(t, live.Session().ID, viewer.Session().ID)
+	assert.Equal(t, "sh", viewer.Command())
+
+	terminal.output <- []byte("hello")
+	buf := make([]byte, 3)
+	n, _ := live.Read(buf)
+	assert.Equal(t, "hel", string(buf[:n]))
+
+	n, err = viewer.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "hel", string(buf[:n]))
+	_, err = viewer.Write([]byte("ls"))
+	assert.Equal(t, ErrReadOnlyTerminal, err)
+
+	assert.Len(t, sessions.List(Session{ContainerID: "container1"}), 1)
+	assert.Len(t, sessions.List(Session{Account: "bar@foo.com"}), 0)
+
+	session, err := sessions.End(live.Session().ID)
+	assert.Nil(t, err)
+	assert.Equal(t, live.Session().ID, session.ID)
+	_, err = viewer.Read(buf)
+	assert.Equal(t, io.EOF, err)
+	assert.Len(t, sessions.List(Session{}), 0)
+
+	_, err = sessions.End(live.Session().ID)
+	assert.Equal(t, ErrSessionNotFound, err)
+}
+
+func TestViewerClose(t *testing.T) {
+	sessions := NewLiveSessions()
+	terminal := newFakeTerminal()
+	live, err := sessions.Start(terminal, Session{ContainerID: "container1"})
+	assert.Nil(t, err)
+
+	viewer, err := sessions.Follow(live.Session().ID, "container1")
+	assert.Nil(t, err)
+	viewer.Close()
+	assert.Len(t, live.viewers, 0)
+
+	// the live terminal is still running
+	select {
+	case <-terminal.closed:
+		t.Error("terminal should not be closed by a viewer")
+	default:
+	}
+	live.Close()
+}
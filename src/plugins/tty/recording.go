@@ -0,0 +1,502 @@
+package tty
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// asciicast v2, https://github.com/asciinema/asciinema/blob/develop/doc/asciicast-v2.md
+	CastVersion = 2
+
+	CastEventOutput = "o"
+	CastEventInput  = "i"
+	CastEventResize = "r"
+
+	// the size of the terminal until the client resizes it
+	DefaultCastWidth  = 80
+	DefaultCastHeight = 24
+
+	recordingCastExt    = ".cast"
+	recordingSessionExt = ".json"
+	// how often the recordings past retention are removed
+	recordingCleanupInterval = time.Hour
+)
+
+var (
+	ErrSessionNotFound = errors.New("terminal session not found")
+
+	sessionIdPattern = regexp.MustCompile(`^[0-9a-z]+$`)
+)
+
+// Session is the metadata of a recorded terminal session
+type Session struct {
+	ID          string    `json:"ID"`
+	Account     string    `json:"Account"`
+	NodeID      string    `json:"NodeID"`
+	ContainerID string    `json:"ContainerID"`
+	Command     string    `json:"Command"`
+	RemoteAddr  string    `json:"RemoteAddr"`
+	StartedAt   time.Time `json:"StartedAt"`
+	EndedAt     time.Time `json:"EndedAt"`
+	// the size of the asciicast file in bytes
+	Size int64 `json:"Size"`
+}
+
+func (session Session) Ended() bool {
+	return !session.EndedAt.IsZero()
+}
+
+type CastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// CastEvent is a line of the asciicast after the header: [time, type, data]
+type CastEvent struct {
+	Time float64
+	Type string
+	Data string
+}
+
+func (event CastEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{event.Time, event.Type, event.Data})
+}
+
+func (event *CastEvent) UnmarshalJSON(data []byte) error {
+	var fields []interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	if len(fields) != 3 {
+		return fmt.Errorf("invalid asciicast event %s", string(data))
+	}
+
+	var ok bool
+	if event.Time, ok = fields[0].(float64); !ok {
+		return fmt.Errorf("invalid asciicast event time %v", fields[0])
+	}
+	if event.Type, ok = fields[1].(string); !ok {
+		return fmt.Errorf("invalid asciicast event type %v", fields[1])
+	}
+	if event.Data, ok = fields[2].(string); !ok {
+		return fmt.Errorf("invalid asciicast event data %v", fields[2])
+	}
+
+	return nil
+}
+
+// RecordingStore keeps the asciicast of the terminal sessions and their metadata in a directory,
+// sessions older than the retention are removed
+type RecordingStore struct {
+	dir       string
+	retention time.Duration
+	mu        sync.Mutex
+}
+
+// a zero retention keeps the recordings forever
+func NewRecordingStore(dir string, retention time.Duration) (*RecordingStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &RecordingStore{dir: dir, retention: retention}, nil
+}
+
+func (store *RecordingStore) castPath(id string) string {
+	return filepath.Join(store.dir, id+recordingCastExt)
+}
+
+func (store *RecordingStore) sessionPath(id string) string {
+	return filepath.Join(store.dir, id+recordingSessionExt)
+}
+
+func (store *RecordingStore) saveSession(session Session) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	content, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	// written aside and renamed, a reader never sees a partial file
+	tmp := store.sessionPath(session.ID) + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, store.sessionPath(session.ID))
+}
+
+func (store *RecordingStore) Get(id string) (Session, error) {
+	var session Session
+	if !sessionIdPattern.MatchString(id) {
+		return session, ErrSessionNotFound
+	}
+
+	content, err := ioutil.ReadFile(store.sessionPath(id))
+	if os.IsNotExist(err) {
+		return session, ErrSessionNotFound
+	} else if err != nil {
+		return session, err
+	}
+
+	if err := json.Unmarshal(content, &session); err != nil {
+		return session, err
+	}
+
+	if !session.Ended() {
+		// the size of a session being recorded grows
+		if info, err := os.Stat(store.castPath(id)); err == nil {
+			session.Size = info.Size()
+		}
+	}
+
+	return session, nil
+}
+
+// the sessions matching the set fields of filter, the latest first
+func (store *RecordingStore) List(filter Session) ([]Session, error) {
+	files, err := ioutil.ReadDir(store.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]Session, 0)
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != recordingSessionExt {
+			continue
+		}
+
+		session, err := store.Get(strings.TrimSuffix(file.Name(), recordingSessionExt))
+		if err != nil {
+			log.Warnf("read terminal session %s got error: %s", file.Name(), err.Error())
+			continue
+		}
+
+		if (filter.Account == "" || filter.Account == session.Account) &&
+			(filter.NodeID == "" || filter.NodeID == session.NodeID) &&
+			(filter.ContainerID == "" || filter.ContainerID == session.ContainerID) {
+			sessions = append(sessions, session)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].StartedAt.After(sessions[j].StartedAt)
+	})
+
+	return sessions, nil
+}
+
+// the asciicast file of the session
+func (store *RecordingStore) Open(id string) (*os.File, error) {
+	if _, err := store.Get(id); err != nil {
+		return nil, err
+	}
+
+	return os.Open(store.castPath(id))
+}
+
+func (store *RecordingStore) Remove(id string) error {
+	if _, err := store.Get(id); err != nil {
+		return err
+	}
+
+	if err := os.Remove(store.castPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return os.Remove(store.sessionPath(id))
+}
+
+// remove the ended sessions past retention, the number of removed sessions is returned
+func (store *RecordingStore) Cleanup() (int, error) {
+	if store.retention <= 0 {
+		return 0, nil
+	}
+
+	sessions, err := store.List(Session{})
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	deadline := time.Now().Add(-store.retention)
+	for _, session := range sessions {
+		if !session.Ended() || session.EndedAt.After(deadline) {
+			continue
+		}
+
+		if err := store.Remove(session.ID); err != nil {
+			log.Warnf("remove terminal session %s got error: %s", session.ID, err.Error())
+			continue
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+// clean up the recordings periodically until stop closed
+func (store *RecordingStore) Watch(stop <-chan struct{}) {
+	ticker := time.NewTicker(recordingCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		if removed, err := store.Cleanup(); err != nil {
+			log.Warnf("clean up terminal sessions got error: %s", err.Error())
+		} else if removed > 0 {
+			log.Infof("removed %d terminal sessions past retention", removed)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// a new id of terminal session
+func NewSessionID() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// Record starts recording the terminal, the session is saved with its StartedAt set
+// and ended when the returned terminal is closed, an ID is given to the session if it has none
+func (store *RecordingStore) Record(terminal Terminal, session Session) (*RecordedTerminal, error) {
+	now := time.Now()
+	if session.ID == "" {
+		session.ID = NewSessionID()
+	}
+	session.Command = terminal.Command()
+	session.StartedAt = now
+
+	file, err := os.OpenFile(store.castPath(session.ID), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	recorded := &RecordedTerminal{
+		Terminal: terminal,
+		session:  session,
+		store:    store,
+		file:     file,
+		writer:   bufio.NewWriter(file),
+		pending:  make(map[string][]byte),
+	}
+
+	header := CastHeader{
+		Version:   CastVersion,
+		Width:     DefaultCastWidth,
+		Height:    DefaultCastHeight,
+		Timestamp: now.Unix(),
+		Title:     session.Command,
+	}
+	if err := recorded.writeLine(header); err != nil {
+		file.Close()
+		os.Remove(store.castPath(session.ID))
+		return nil, err
+	}
+
+	if err := store.saveSession(session); err != nil {
+		file.Close()
+		os.Remove(store.castPath(session.ID))
+		return nil, err
+	}
+
+	return recorded, nil
+}
+
+// RecordedTerminal records the output, the input and the resizes of a terminal as asciicast events
+type RecordedTerminal struct {
+	Terminal
+
+	session Session
+	store   *RecordingStore
+
+	mu     sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+	// bytes of a rune split between two reads or writes, by event type
+	pending map[string][]byte
+	closed  bool
+}
+
+func (recorded *RecordedTerminal) Session() Session {
+	recorded.mu.Lock()
+	defer recorded.mu.Unlock()
+
+	return recorded.session
+}
+
+func (recorded *RecordedTerminal) Read(p []byte) (int, error) {
+	n, err := recorded.Terminal.Read(p)
+	if n > 0 {
+		recorded.record(CastEventOutput, p[:n])
+	}
+
+	return n, err
+}
+
+func (recorded *RecordedTerminal) Write(p []byte) (int, error) {
+	n, err := recorded.Terminal.Write(p)
+	if n > 0 {
+		recorded.record(CastEventInput, p[:n])
+	}
+
+	return n, err
+}
+
+func (recorded *RecordedTerminal) Resize(rows, columns uint16) error {
+	if err := recorded.Terminal.Resize(rows, columns); err != nil {
+		return err
+	}
+
+	recorded.record(CastEventResize, []byte(fmt.Sprintf("%dx%d", columns, rows)))
+	return nil
+}
+
+// close the terminal and end the recording
+func (recorded *RecordedTerminal) Close() error {
+	err := recorded.Terminal.Close()
+
+	recorded.mu.Lock()
+	defer recorded.mu.Unlock()
+
+	if recorded.closed {
+		return err
+	}
+	recorded.closed = true
+
+	if flushErr := recorded.writer.Flush(); flushErr != nil {
+		log.Warnf("flush terminal session %s got error: %s", recorded.session.ID, flushErr.Error())
+	}
+	recorded.file.Close()
+
+	recorded.session.EndedAt = time.Now()
+	if info, statErr := os.Stat(recorded.store.castPath(recorded.session.ID)); statErr == nil {
+		recorded.session.Size = info.Size()
+	}
+	if saveErr := recorded.store.saveSession(recorded.session); saveErr != nil {
+		log.Warnf("save terminal session %s got error: %s", recorded.session.ID, saveErr.Error())
+	}
+
+	return err
+}
+
+func (recorded *RecordedTerminal) record(eventType string, data []byte) {
+	recorded.mu.Lock()
+	defer recorded.mu.Unlock()
+
+	if recorded.closed {
+		return
+	}
+
+	// json encodes invalid utf8 as U+FFFD, a rune split by the stream waits for its end
+	data = append(recorded.pending[eventType], data...)
+	end := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				end = i
+			}
+			break
+		}
+	}
+	recorded.pending[eventType] = append([]byte(nil), data[end:]...)
+	if end == 0 {
+		return
+	}
+
+	event := CastEvent{
+		Time: time.Since(recorded.session.StartedAt).Seconds(),
+		Type: eventType,
+		Data: string(data[:end]),
+	}
+	if err := recorded.writeLine(event); err != nil {
+		log.Warnf("record terminal session %s got error: %s", recorded.session.ID, err.Error())
+	}
+}
+
+func (recorded *RecordedTerminal) writeLine(v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if _, err := recorded.writer.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	// the session can be downloaded while it is being recorded
+	return recorded.writer.Flush()
+}
+
+// CastReader parses an asciicast v2 stream
+type CastReader struct {
+	Header  CastHeader
+	scanner *bufio.Scanner
+}
+
+// read the header of the asciicast
+func NewCastReader(r io.Reader) (*CastReader, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	reader := &CastReader{scanner: scanner}
+	if err := json.Unmarshal(scanner.Bytes(), &reader.Header); err != nil {
+		return nil, err
+	}
+	if reader.Header.Version != CastVersion {
+		return nil, fmt.Errorf("unsupported asciicast version %d", reader.Header.Version)
+	}
+
+	return reader, nil
+}
+
+// the next event of the asciicast, io.EOF after the last one
+func (reader *CastReader) Next() (CastEvent, error) {
+	var event CastEvent
+	for reader.scanner.Scan() {
+		if len(reader.scanner.Bytes()) == 0 {
+			continue
+		}
+
+		err := json.Unmarshal(reader.scanner.Bytes(), &event)
+		return event, err
+	}
+
+	if err := reader.scanner.Err(); err != nil {
+		return event, err
+	}
+
+	return event, io.EOF
+}
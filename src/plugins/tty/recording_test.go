@@ -0,0 +1,107 @@
+package tty
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordTerminal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recordings")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := NewRecordingStore(dir, time.Hour)
+	assert.Nil(t, err)
+
+	terminal := newFakeTerminal()
+	recorded, err := store.Record(terminal, Session{Account: "admin@admin.com", NodeID: "node1", ContainerID: "container1"})
+	assert.Nil(t, err)
+
+	session := recorded.Session()
+	sessions, err := store.List(Session{NodeID: "node1"})
+	assert.Nil(t, err)
+	assert.Len(t, sessions, 1)
+	assert.False(t, sessions[0].Ended())
+	assert.Equal(t, "sh", sessions[0].Command)
+
+	recorded.Write([]byte("ls\r"))
+	<-terminal.input
+	recorded.Resize(40, 100)
+	<-terminal.resized
+	// the rune é is split between two reads
+	terminal.output <- []byte("caf\xc3")
+	buf := make([]byte, 16)
+	n, _ := recorded.Read(buf)
+	assert.Equal(t, 4, n)
+	terminal.output <- []byte("\xa9\r\n")
+	recorded.Read(buf)
+	recorded.Close()
+
+	session, err = store.Get(session.ID)
+	assert.Nil(t, err)
+	assert.True(t, session.Ended())
+	assert.True(t, session.Size > 0)
+
+	cast, err := store.Open(session.ID)
+	assert.Nil(t, err)
+	defer cast.Close()
+	reader, err := NewCastReader(cast)
+	assert.Nil(t, err)
+	assert.Equal(t, CastVersion, reader.Header.Version)
+	assert.Equal(t, DefaultCastWidth, reader.Header.Width)
+
+	var events []CastEvent
+	for {
+		event, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		events = append(events, event)
+	}
+	assert.Len(t, events, 4)
+	assert.Equal(t, CastEvent{Time: events[0].Time, Type: CastEventInput, Data: "ls\r"}, events[0])
+	assert.Equal(t, "100x40", events[1].Data)
+	assert.Equal(t, "caf", events[2].Data)
+	assert.Equal(t, "é\r\n", events[3].Data)
+
+	_, err = store.Get("../" + session.ID)
+	assert.Equal(t, ErrSessionNotFound, err)
+	sessions, err = store.List(Session{Account: "foo@bar.com"})
+	assert.Nil(t, err)
+	assert.Len(t, sessions, 0)
+}
+
+func TestRecordingCleanup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recordings")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := NewRecordingStore(dir, time.Hour)
+	assert.Nil(t, err)
+
+	old := Session{ID: "old", StartedAt: time.Now().Add(-3 * time.Hour), EndedAt: time.Now().Add(-2 * time.Hour)}
+	recent := Session{ID: "recent", StartedAt: time.Now().Add(-3 * time.Hour), EndedAt: time.Now()}
+	running := Session{ID: "running", StartedAt: time.Now().Add(-3 * time.Hour)}
+	for _, session := range []Session{old, recent, running} {
+		assert.Nil(t, store.saveSession(session))
+		assert.Nil(t, ioutil.WriteFile(store.castPath(session.ID), []byte("{}\n"), 0600))
+	}
+
+	removed, err := store.Cleanup()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, removed)
+	_, err = store.Get("old")
+	assert.Equal(t, ErrSessionNotFound, err)
+	_, err = os.Stat(store.castPath("old"))
+	assert.True(t, os.IsNotExist(err))
+
+	sessions, err := store.List(Session{})
+	assert.Nil(t, err)
+	assert.Len(t, sessions, 2)
+}
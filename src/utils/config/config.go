@@ -1,6 +1,9 @@
 package config
 
 import (
+	"net/url"
+	"strings"
+
 	"github.com/Dataman-Cloud/crane/src/utils"
 	log "github.com/Sirupsen/logrus"
 )
@@ -17,15 +20,64 @@ type Config struct {
 	FeatureFlags      []string `env:"CRANE_FEATURE_FLAGS,required"`
 	DockerTlsVerify   bool     `env:"CRANE_DOCKER_TLS_VERIFY" envDefault:"false"`
 
+	// swarm manager failover: other manager endpoints crane could fail over to,
+	// managers discovered from the node list are added at runtime
+	SwarmManagerEndpoints     []string `env:"CRANE_SWARM_MANAGER_ENDPOINTS"`
+	SwarmManagerCheckInterval int      `env:"CRANE_SWARM_MANAGER_CHECK_INTERVAL" envDefault:"10"`
+
+	// seconds a verified node endpoint is trusted by the node client pool
+	NodeClientTTL int `env:"CRANE_NODE_CLIENT_TTL" envDefault:"60"`
+
+	// seconds between two syncs of the in memory swarm state, 0 disables the cache
+	SwarmCacheInterval int `env:"CRANE_SWARM_CACHE_INTERVAL" envDefault:"5"`
+
+	// events kept in memory for event stream clients resuming with Last-Event-ID
+	EventHistorySize int `env:"CRANE_EVENT_HISTORY_SIZE" envDefault:"1000"`
+
+	// MB of the files downloaded from or uploaded to a container
+	ContainerFileMaxSize int `env:"CRANE_CONTAINER_FILE_MAX_SIZE" envDefault:"100"`
+
+	// image of the helper containers backing up and restoring volumes, pulled on the nodes missing it
+	VolumeHelperImage string `env:"CRANE_VOLUME_HELPER_IMAGE" envDefault:"busybox:latest"`
+
+	// MB of the build context of an image built by crane
+	ImageBuildContextMaxSize int `env:"CRANE_IMAGE_BUILD_CONTEXT_MAX_SIZE" envDefault:"500"`
+
+	// directory of the terminal session recordings, empty disables the recording,
+	// recordings are removed after the retention days, 0 keeps them forever
+	TerminalRecordingDir       string `env:"CRANE_TERMINAL_RECORDING_DIR"`
+	TerminalRecordingRetention int    `env:"CRANE_TERMINAL_RECORDING_RETENTION" envDefault:"30"`
+
+	// seconds between two prunes of every ready node, 0 disables the scheduled prunes,
+	// the kinds pruned are among containers, images, volumes and networks
+	PruneInterval int      `env:"CRANE_PRUNE_INTERVAL" envDefault:"0"`
+	PruneKinds    []string `env:"CRANE_PRUNE_KINDS" envDefault:"containers,images"`
+
+	// CIDRs the subnets of the networks created by crane are allocated from, empty lets docker choose them,
+	// every network gets a subnet of the prefix size
+	NetworkPoolCIDRs      []string `env:"CRANE_NETWORK_POOL_CIDRS"`
+	NetworkPoolPrefixSize int      `env:"CRANE_NETWORK_POOL_PREFIX_SIZE" envDefault:"24"`
+
 	// registry
 	RegistryPrivateKeyPath string `env:"CRANE_REGISTRY_PRIVATE_KEY_PATH,required"`
 	RegistryAddr           string `env:"CRANE_REGISTRY_ADDR,required"`
+	// host[:port] the nodes reach the registry at, the host of RegistryAddr if not set
+	RegistryHost string `env:"CRANE_REGISTRY_HOST"`
+	// seconds between two runs of the enabled retention policies, 0 disables the scheduled runs
+	RegistryRetentionInterval int `env:"CRANE_REGISTRY_RETENTION_INTERVAL" envDefault:"0"`
+	// the node and the container of the registry the garbage collections run in, empty if crane does not run them
+	RegistryGCNodeID    string `env:"CRANE_REGISTRY_GC_NODE_ID"`
+	RegistryGCContainer string `env:"CRANE_REGISTRY_GC_CONTAINER"`
+	// seconds a garbage collection runs before it is reported timed out, the registry stays read only until it ends
+	RegistryGCTimeout int `env:"CRANE_REGISTRY_GC_TIMEOUT" envDefault:"3600"`
 
 	// account
 	AccountAuthenticator   string `env:"CRANE_ACCOUNT_AUTHENTICATOR,required"`
 	AccountTokenStore      string `env:"CRANE_ACCOUNT_TOKEN_STORE"`
 	AccountEmailDefault    string `env:"CRANE_ACCOUNT_EMAIL_DEFAULT"`
 	AccountPasswordDefault string `env:"CRANE_ACCOUNT_PASSWORD_DEFAULT"`
+	// emails of the accounts allowed to the admin api, the default account is always admin
+	AccountAdmins []string `env:"CRANE_ACCOUNT_ADMINS"`
 
 	CatalogPath            string `env:"CRANE_CATALOG_PATH"`
 	SearchLoadDataInterval int    `env:"CRANE_SEARCH_LOAD_DATA_INTERVAL"`
@@ -37,6 +89,28 @@ func (c *Config) FeatureEnabled(feature string) bool {
 	return utils.StringInSlice(feature, c.FeatureFlags)
 }
 
+// the default account and the accounts of AccountAdmins are admins
+func (c *Config) AccountIsAdmin(email string) bool {
+	if email == "" {
+		return false
+	}
+
+	return email == c.AccountEmailDefault || utils.StringInSlice(email, c.AccountAdmins)
+}
+
+// the registry host the images pushed by the nodes are named with
+func (c *Config) RegistryImageHost() string {
+	if c.RegistryHost != "" {
+		return c.RegistryHost
+	}
+
+	if u, err := url.Parse(c.RegistryAddr); err == nil && u.Host != "" {
+		return u.Host
+	}
+
+	return strings.TrimSuffix(c.RegistryAddr, "/")
+}
+
 func InitConfig() *Config {
 	cfg := Config{}
 	if err := Parse(&cfg); err != nil {
@@ -15,6 +15,28 @@ func TestConfigFeatureEnabled(t *testing.T) {
 	assert.True(t, config.FeatureEnabled("foo"), "feature foo should be enabled")
 }
 
+func TestConfigAccountIsAdmin(t *testing.T) {
+	config := &Config{
+		AccountEmailDefault: "admin@admin.com",
+		AccountAdmins:       []string{""},
+	}
+
+	assert.True(t, config.AccountIsAdmin("admin@admin.com"))
+	assert.False(t, config.AccountIsAdmin(""))
+	assert.False(t, config.AccountIsAdmin("foo@bar.com"))
+	config.AccountAdmins = []string{"foo@bar.com"}
+	assert.True(t, config.AccountIsAdmin("foo@bar.com"))
+}
+
+func TestConfigRegistryImageHost(t *testing.T) {
+	config := &Config{RegistryAddr: "http://crane_registry:5000"}
+	assert.Equal(t, "crane_registry:5000", config.RegistryImageHost())
+	config.RegistryAddr = "crane_registry:5000/"
+	assert.Equal(t, "crane_registry:5000", config.RegistryImageHost())
+	config.RegistryHost = "registry.crane.io"
+	assert.Equal(t, "registry.crane.io", config.RegistryImageHost())
+}
+
 func TestConfigStruct(t *testing.T) {
 	config := new(Config)
 	config.CraneAddr = "foobar"